@@ -0,0 +1,33 @@
+//go:build !noallocbudget && !race
+
+package main
+
+import "testing"
+
+// convertAllocBudget is the most allocations converting a line of each of
+// convertCases may take. A change that needs more must raise the budget
+// here, on purpose. Where another standard library allocates differently,
+// build with -tags noallocbudget to leave this test out.
+var convertAllocBudget = map[string]float64{
+	"mvc4":          6,
+	"mvc4 username": 8,
+	"identity v3":   11,
+}
+
+func TestConvertAllocBudget(t *testing.T) {
+	for _, tt := range convertCases {
+		budget, ok := convertAllocBudget[tt.name]
+		if !ok {
+			t.Errorf("%s has no allocation budget", tt.name)
+			continue
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			if _, err := convertOnce(tt.line, tt.username); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs > budget {
+			t.Errorf("converting %s takes %v allocations, over its budget of %v", tt.name, allocs, budget)
+		}
+	}
+}
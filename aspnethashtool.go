@@ -1,181 +1,825 @@
 package main
 
 import (
-	"bufio"
-	"crypto/rand"
-	"crypto/sha1"
-	"crypto/sha256"
+	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
-	"unicode"
 
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
 	"github.com/spf13/pflag"
-	"go.uber.org/ratelimit"
-	"golang.org/x/crypto/pbkdf2"
 )
 
-// Generate a hash and salt from plaintext
-func generateHash(plain string, hashMode string, PBKDF2IterCount int, PBKDF2SubkeyLength int, SaltSize int) (string, error) {
-	if hashMode == "mvc4" {
-		SaltSize = 16
+// hashModes lists the accepted values of --mode.
+var hashModes = []string{"mvc4", "webforms", "dnn", "umbraco-legacy", "formsauth-sha1", "formsauth-md5", "auto"}
+
+// hashAlgorithms lists the accepted values of --hash-algorithm.
+var hashAlgorithms = []string{"sha256", "hmacsha256", "hmacsha512"}
+
+// Generate a hash and salt from plaintext. keyed, if set, replaces SHA256 for
+// WebForms hashes.
+func generateHash(plain string, hashMode string, PBKDF2IterCount int, PBKDF2SubkeyLength int, SaltSize int, salt []byte, keyed *aspnethash.KeyedHasher) (string, error) {
+	opts := aspnethash.Options{Iterations: PBKDF2IterCount, SubkeyLength: PBKDF2SubkeyLength, SaltSize: SaltSize, Salt: salt}
+
+	switch hashMode {
+	case "mvc4":
+		opts.SaltSize = aspnethash.DefaultSaltSize
+		return aspnethash.HashMVC4([]byte(plain), opts)
+	case "webforms":
+		hashWebForms := aspnethash.HashWebForms
+		if keyed != nil {
+			hashWebForms = keyed.Hash
+		}
+		hash, salt, err := hashWebForms([]byte(plain), opts)
+		if err != nil {
+			return "", err
+		}
+		return hash + "," + salt, nil
+	case "dnn":
+		hash, salt, err := aspnethash.HashDNN([]byte(plain), opts)
+		if err != nil {
+			return "", err
+		}
+		return hash + "," + salt, nil
+	case "umbraco-legacy":
+		return aspnethash.HashUmbracoLegacy([]byte(plain)), nil
+	case "formsauth-sha1", "formsauth-md5":
+		return aspnethash.HashFormsAuth([]byte(plain), formsAuthAlgorithm(hashMode))
+	}
+	return "", fmt.Errorf("%w: %s", aspnethash.ErrUnsupportedFormat, hashMode)
+}
+
+// hashParser parses the hash of a convert line. iterations is used for hashes
+// that don't store their own.
+type hashParser func(encoded string, iterations int) (aspnethash.Hash, error)
+
+// newHashParser returns the parser convert uses. MVC4 blobs must have the
+// 0x00 version byte and exactly the length the salt and subkey sizes add up
+// to; a 0x01 version byte is taken for an Identity v3 hash. With
+// allowLengthMismatch, any blob of at least 17 bytes passes, its first byte
+// skipped and the 16 bytes after it taken for the salt.
+func newHashParser(saltSize, subkeyLength int, allowLengthMismatch bool) hashParser {
+	return func(encoded string, iterations int) (aspnethash.Hash, error) {
+		if allowLengthMismatch {
+			hash, err := aspnethash.ParseMVC4(encoded)
+			hash.Iterations = iterations
+			return hash, err
+		}
+		if 1+saltSize+subkeyLength != 32 && aspnethash.IsUmbracoLegacy(encoded) {
+			return aspnethash.Hash{}, fmt.Errorf("%w: unsalted 32-byte hash, likely Umbraco legacy HMAC-SHA256, which hashcat can't crack", aspnethash.ErrUnsupportedFormat)
+		}
+		hash, err := aspnethash.ParseSized(encoded, saltSize, subkeyLength)
+		if hash.Version == 0 {
+			hash.Iterations = iterations
+		}
+		return hash, err
 	}
-	var encoded string
-	salt := make([]byte, SaltSize)
-	_, err := rand.Read(salt)
+}
+
+func convertHash(line string, usernamePresent bool, delimiter string, outputDelimiter string, trim bool, PBKDF2IterCount int, normalize usernameNormalizer, parse hashParser, dialect *hashcatDialect) (string, error) {
+	username, encoded, err := splitUsername(line, usernamePresent, delimiter, trim)
 	if err != nil {
 		return "", err
 	}
 
-	encoded_salt := base64.StdEncoding.EncodeToString(salt)
+	hash, err := parse(encoded, PBKDF2IterCount)
+	if err != nil {
+		return "", err
+	}
 
-	if hashMode == "mvc4" {
-		// MVC4 Logic
-		subkey := pbkdf2.Key([]byte(plain), salt, PBKDF2IterCount, PBKDF2SubkeyLength, sha1.New)
-		outputBytes := append([]byte{0}, salt...)
-		outputBytes = append(outputBytes, subkey...)
-		encoded = base64.StdEncoding.EncodeToString(outputBytes)
-	} else if hashMode == "webforms" {
-		// WebForms Logic
-		hash := sha256.Sum256([]byte(plain))
-		combined := append(salt, hash[:]...)
-		encoded = base64.StdEncoding.EncodeToString(combined)
-		encoded = fmt.Sprintf("%s,%s", encoded, encoded_salt)
+	processedLine, err := hashcatLine(pbkdf2Digest(hash), dialect)
+	if err != nil {
+		return "", err
+	}
+	if usernamePresent {
+		processedLine = normalize.apply(username) + outputDelimiter + processedLine
 	}
 
-	return encoded, nil
+	return processedLine, nil
 }
 
-func convertHash(line string, usernamePresent bool, delimiter string, PBKDF2IterCount int) (string, error) {
-	var username, encoded string
-
+// splitUsername splits a <username><delimiter><hash> line, or returns the
+// whole line as the hash if usernamePresent is false.
+func splitUsername(line string, usernamePresent bool, delimiter string, trim bool) (username, encoded string, err error) {
+	encoded = line
 	if usernamePresent {
 		parts := strings.SplitN(line, delimiter, 2)
 		if len(parts) < 2 {
-			return "", fmt.Errorf("invalid line format: missing delimiter")
+			return "", "", fmt.Errorf("invalid line format: %w", errMissingDelimiter)
 		}
-		username = parts[0]
-		encoded = strings.TrimSpace(parts[1])
-	} else {
-		username = ""
-		encoded = strings.TrimSpace(line)
+		username, encoded = parts[0], parts[1]
 	}
-
-	// Decode from Base64
-	decoded, err := base64.StdEncoding.DecodeString(encoded)
-	if err != nil {
-		return "", fmt.Errorf("error decoding Base64: %w", err)
+	if trim {
+		encoded = strings.TrimSpace(encoded)
 	}
+	return username, encoded, nil
+}
 
-	// Drop first byte
-	if len(decoded) < 17 {
-		return "", fmt.Errorf("decoded bytes too short")
+// takeIterField removes the col-th (1-based) --delimiter separated field from
+// line and parses it as the PBKDF2 iteration count of the row. ok is false if
+// the row has no such field or it isn't a positive number; the field is
+// still removed in the latter case.
+func takeIterField(line, delimiter string, col int) (rest string, iter int, ok bool) {
+	fields := strings.Split(line, delimiter)
+	if col > len(fields) {
+		return line, 0, false
 	}
-	droppedBytes := decoded[1:]
+	value := strings.TrimSpace(fields[col-1])
+	rest = strings.Join(append(fields[:col-1:col-1], fields[col:]...), delimiter)
+	iter, err := strconv.Atoi(value)
+	if err != nil || iter < 1 {
+		return rest, 0, false
+	}
+	return rest, iter, true
+}
 
-	// Split the byte slice
-	salt, hashDigest := droppedBytes[:16], droppedBytes[16:]
+// parseDelimiter interprets the escape sequences \t, \0 and \\ in a delimiter
+// given on the command line. Delimiters may be longer than one character but
+// can't be empty or contain a newline, since input is split into lines first.
+func parseDelimiter(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return "", fmt.Errorf("trailing backslash in %q", s)
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case '0':
+			b.WriteByte(0)
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			return "", fmt.Errorf("newline is not allowed in %q", s)
+		default:
+			return "", fmt.Errorf("unknown escape sequence \\%c in %q", s[i], s)
+		}
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("delimiter must not be empty")
+	}
+	if strings.Contains(b.String(), "\n") {
+		return "", fmt.Errorf("newline is not allowed in %q", s)
+	}
+	return b.String(), nil
+}
 
-	// Convert each part from bytes to Base64
-	saltBase64 := base64.StdEncoding.EncodeToString(salt)
-	hashBase64 := base64.StdEncoding.EncodeToString(hashDigest)
+// maxLoggedLineLength caps how much of an input line is echoed to the log.
+const maxLoggedLineLength = 80
 
-	// Merge and add prefix
-	var processedLine string
-	if usernamePresent {
-		processedLine = fmt.Sprintf("%s:sha1:%s:%s:%s", username, PBKDF2IterCount, saltBase64, hashBase64)
-	} else {
-		processedLine = fmt.Sprintf("sha1:%s:%s:%s", fmt.Sprint(PBKDF2IterCount), saltBase64, hashBase64)
+// truncateLine shortens line to at most n bytes for logging.
+func truncateLine(line string, n int) string {
+	if len(line) <= n {
+		return line
 	}
-
-	return processedLine, nil
+	return line[:n] + "..."
 }
 
 func main() {
+	useUTF8Console()
+
 	var generateMode bool
 	var hashMode string
 	var work_type string
 	var usernamePresent bool
-	var delimiter string
-	var wg sync.WaitGroup
+	var delimiter, delimiterArg string
+	var outputDelimiter, outputDelimiterArg string
+	var hexEscape bool
 	var processedLines int64
 	var erroredLines int64
-	var rateLimit int
+	var abandonedLines int64
+	var rateLimit float64
+	var rateLimitBytes string
+	var rateBurst int
 	var maxWorkers int
+	var maxWorkersArg string
+	var autoWorkers bool
+	var maxWorkersCap int
+	var threads int
+	var batchSize int
+	var writeBuffer int
+	var scaler *workerScaler
 	var quiet bool
+	var requireInput bool
 
 	var PBKDF2IterCount int
 	var PBKDF2SubkeyLength int
 	var SaltSize int
 	var advancedHelp bool
+	var statsJSON string
+	var errorFilePath string
+	var errorFileAlways bool
+	var errFile *errorFile
+	var profileName string
+	var webConfigPath string
+	var saveProfileName string
+	var profileDescription string
+	var profilesDir string
+	var listProfilesFlag bool
+	var configPath string
+	var printConfigFlag bool
+	var cleanStale bool
+	var staleAge time.Duration
+	var verbose int
+	var noColor bool
+	var logFormat string
+	var strict bool
+	var strictOnce sync.Once
+	var strictStopped atomic.Bool
+	var strictAt string
+	var strictErr error
+	var maxLineBytes int
+	var nullDelimited bool
+	var outputLineEnding string
+	var trimFlag, noTrimFlag, trim bool
+	var keepCR bool
+	var inputCharset string
+	var inputPath string
+	var filesFrom string
+	var validate bool
+	var allowLengthMismatch bool
+	var identifySummaryFlag bool
+	var frequencyFlag, frequencyExact bool
+	var analyzeSalts, analyzeSaltsExact bool
+	var auditFlag bool
+	var auditPolicyArg string
+	var frequencyTop int
+	var inputCompression string
+	var outputPath string
+	var outputCompression string
+	var force bool
+	var out recordWriter
+	var split int
+	var noAtomic bool
+	var outputAppend, lockOutput bool
+	var tee bool
+	var preFilter, postFilter string
+	var teeHead int64
+	var outputAddr string
+	var outputRetries int
+	var outputBacklog int64
+	var outputChecksum, checksumSidecar bool
+	var checksumOf string
+	var splitBy string
+	var shards *shardedWriter
+	var single *outputWriter
+	var skip, limit int64
+	var skippedLines int64
+	var duplicateLines int64
+	var ordered bool
+	var checkpointPath string
+	var checkpointInterval time.Duration
+	var countFirst bool
+	var sampleFraction float64
+	var sampleN, sampleSeed int64
+	var shardArg, shardStrategy string
+	var readerMode string
+	var sortKey, sortMemArg, tmpDir string
+	var progressInterval time.Duration
+	var timeout time.Duration
+	var resume bool
+	var resumeAt int64 = -1
+	var resumeLines int64
+	errorKinds := newErrorCounter()
 
+	var password, hashArg string
+	var prompt bool
+	var hashesPath, wordlistPath string
+	var showMode, showUncracked bool
+	var removeMode bool
+	var removedLines, keptLines int64
+	var rehashMode bool
+	var rehashTarget, unrecoveredPath string
+	var potfilePath, mapFilePath string
+	var stripUsernames bool
+	var fix, fixOnly bool
+	var alsoHashcat string
+	var hashcatModeArg string
+	var generateFormat, schemaArg, sqlTable, sqlUsersTable, sqlUsernameColumn string
+	var usernameValue string
+	var usernamesFile, blankUsernames string
+	var policyArg string
+	var randomCount int64
+	var randomCharset, randomMask string
+	var randomLength int
+	var noPlain bool
+	var policyWarn, policyEnforce bool
+	var outputFormat string
+	var repeatedHashes int64
+	var showCracked, showLooked int64
+	var decryptionKey, decryptionAlgo string
+	var hashAlgorithm, validationKey string
+	var insecureSecretPerms bool
+	var iterCol int
+	var unique bool
+	var normalizeUsernameArg string
+	var reportCollisions bool
+	var duplicateUsernamesArg string
+	var uniqueApprox int
+	var uniqueOutput bool
+	var uniqueOutputApprox int
+	var uniqueOutputScope string
+	var iterFallbacks int64
+	var benchDuration time.Duration
+	var benchIters []int
+	var saltArg string
+	var fixedSalt []byte
+	var uniqueSaltsFlag bool
+	var reuseSaltPerPlaintext bool
+	var cacheSize int
+	var saltSequenceSeed string
+	var cpuProfile, memProfile, pprofHTTP string
+	var timingsFlag bool
+	var statusAddr string
+	var jsonOutput, withSource bool
+	var invalidUTF8 string
+	var invalidUTF8Lines int64
 	var help bool
+	var showVersion bool
 	var sem chan struct{}
 
 	startTime := time.Now()
 
-	pflag.BoolVarP(&generateMode, "generate", "g", false, "generate hashes from plaintext input instead of converting")
-	pflag.StringVarP(&hashMode, "mode", "M", "default", "Choose between MVC4 (SimpleMembershipProvider) and WebForms (DefaultMembershipProvider) when generating hashes. Defaults to MVC4")
-	pflag.BoolVarP(&usernamePresent, "username", "u", false, "indicates if the input is prefixed with a username")
-	pflag.StringVarP(&delimiter, "delimiter", "d", ",", "delimiter to split username and salt+hash if --username is used (default: \",\")")
-	pflag.IntVarP(&rateLimit, "rate-limit", "r", 0, "number of lines per second to process. 0 = no limit")
-	pflag.IntVarP(&maxWorkers, "max-workers", "m", 0, "maximum number of workers (goroutines) to use. 0 = no limit (default))")
+	// The first argument may select a subcommand. Each subcommand only gets
+	// its own flags plus the global ones; the bare invocation keeps accepting
+	// every flag, as it always did.
+	command := ""
+	args := os.Args[1:]
+	if len(args) > 0 && isCommand(args[0]) {
+		command, args = args[0], args[1:]
+	}
+	legacy := command == ""
+	// The completion script covers every flag of every subcommand.
+	allFlags := legacy || command == "completion"
+
+	flags := pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	global := pflag.NewFlagSet("global", pflag.ExitOnError)
+	unused := pflag.NewFlagSet("unused", pflag.ContinueOnError)
+	flags.SetNormalizeFunc(normalizeFlagName)
+	unused.SetNormalizeFunc(normalizeFlagName)
+	// flagsFor returns the flag set to register a flag listed in
+	// flagCommands on. Flags of other subcommands still set their default,
+	// but can't be used.
+	flagsFor := func(name string) *pflag.FlagSet {
+		if allFlags || slices.Contains(flagCommands[name], command) {
+			return flags
+		}
+		return unused
+	}
+	defaultDelimiter := ","
+	if command == "verify" || command == "remove" {
+		// Converted lines separate the username with the output delimiter.
+		defaultDelimiter = ":"
+	}
+
+	flagsFor("generate").BoolVarP(&generateMode, "generate", "g", false, "generate hashes from plaintext input instead of converting")
+	flagsFor("mode").StringVarP(&hashMode, "mode", "M", "default", "hash format: MVC4 (SimpleMembershipProvider), WebForms (DefaultMembershipProvider, generate only), DNN (DotNetNuke's SqlMembershipProvider, <hash>,<salt>) umbraco-legacy (unsalted HMAC-SHA256, generate and verify only), formsauth-sha1 and formsauth-md5 (FormsAuthentication's unsalted uppercase hex) or auto (convert each hash by its detected format, as identify labels it). Defaults to MVC4")
+	flagsFor("username").BoolVarP(&usernamePresent, "username", "u", false, "indicates if the input is prefixed with a username")
+	flagsFor("delimiter").StringVarP(&delimiterArg, "delimiter", "d", defaultDelimiter, fmt.Sprintf("delimiter to split username and salt+hash (generate: plaintext) if --username is used; accepts \\t, \\0 and \\\\ escapes (default: %q)", defaultDelimiter))
+	flagsFor("password").StringVarP(&password, "password", "p", "", "hash this one password instead of reading input, and print only the result")
+	flagsFor("prompt").BoolVar(&prompt, "prompt", false, "read one password from the terminal without echoing it, and print only its hash")
+	flagsFor("hash").StringVarP(&hashArg, "hash", "H", "", "convert this one hash instead of reading input, and print only the result")
+	flagsFor("salt").StringVar(&saltArg, "salt", "", "base64 salt to use for every hash instead of a random one, for reproducible output (MVC4: 16 bytes)")
+	flagsFor("username-value").StringVar(&usernameValue, "username-value", "", "username of the --password or --prompt password, written before its hash as with --username")
+	flagsFor("random").Int64Var(&randomCount, "random", 0, "generate this many random plaintexts instead of reading input, and write <username>:<plaintext>:<hash> lines; the usernames are user000001 and on, or from --usernames-file")
+	flagsFor("random-charset").StringVar(&randomCharset, "random-charset", defaultRandomCharset, "characters of the --random plaintexts: ?l, ?u, ?d, ?s (specials), ?a (all four) and any literal characters")
+	flagsFor("random-length").IntVar(&randomLength, "random-length", defaultRandomLength, "length of the --random plaintexts, in characters")
+	flagsFor("random-mask").StringVar(&randomMask, "random-mask", "", "shape of the --random plaintexts, one placeholder per character, as in hashcat: ?u?l?l?l?d?d?s; ?? is a question mark")
+	flagsFor("no-plain").BoolVar(&noPlain, "no-plain", false, "leave the plaintexts of --random out of the output, writing <username>:<hash>")
+	flagsFor("usernames-file").StringVar(&usernamesFile, "usernames-file", "", "file of usernames, one per line, paired line by line with the plaintexts of the input; the output is <username>:<hash> as with --username")
+	flagsFor("blank-usernames").StringVar(&blankUsernames, "blank-usernames", "error", "what to do with input lines whose --usernames-file line is blank: error or skip")
+	flagsFor("policy").StringVar(&policyArg, "policy", "", "password rules to check each plaintext against before hashing, as \"minlen=8,minnonalnum=1,maxlen=128\"; lengths are counted in UTF-16 characters, as .NET does")
+	flagsFor("json").BoolVar(&jsonOutput, "json", false, "testvectors: print the test vectors as a JSON array; diff: print the counts and every account that differs as JSON; convert: write each result as a JSON object on a line of its own")
+	flagsFor("with-source").BoolVar(&withSource, "with-source", false, "with --json, add the file, line and byte offset of the input line to each object")
+	flagsFor("policy-warn").BoolVar(&policyWarn, "policy-warn", false, "count the plaintexts breaking --policy and hash them anyway (default)")
+	flagsFor("policy-enforce").BoolVar(&policyEnforce, "policy-enforce", false, "count the plaintexts breaking --policy as errored lines instead of hashing them")
+	flagsFor("output-format").StringVar(&generateFormat, "output-format", "lines", "output format: lines, or sql for a T-SQL script that resets the passwords of the --username input in the tables of --schema")
+	flagsFor("schema").StringVar(&schemaArg, "schema", "", "tables the --output-format sql script updates: simplemembership (webpages_Membership), membership (aspnet_Membership) or identity (AspNetUsers)")
+	flagsFor("sql-table").StringVar(&sqlTable, "sql-table", "", "table the --output-format sql script updates (default: the --schema one)")
+	flagsFor("sql-users-table").StringVar(&sqlUsersTable, "sql-users-table", "", "table the --output-format sql script looks the usernames up in, for --schema simplemembership and membership (default: UserProfile or aspnet_Users)")
+	flagsFor("sql-username-column").StringVar(&sqlUsernameColumn, "sql-username-column", "", "username column of the --output-format sql script (default: UserName)")
+	flagsFor("also-hashcat").StringVar(&alsoHashcat, "also-hashcat", "", "also write the hashcat line of every generated hash, with the same salt, to this file, in the same order and with the same --username prefix as the output")
+	flagsFor("hashcat-mode").StringVar(&hashcatModeArg, "hashcat-mode", "", "write the hashcat lines for this hashcat mode: "+strings.Join(hashcatModes(), ", ")+"; hashes it can't hold are errors (default: the usual mode of each hash)")
+	flagsFor("format").StringVar(&outputFormat, "format", "hashcat", "output format: hashcat, or phc for passlib's $pbkdf2$ and $pbkdf2-sha256$ strings (PBKDF2 hashes only)")
+	flagsFor("reuse-salt-per-plaintext").BoolVar(&reuseSaltPerPlaintext, "reuse-salt-per-plaintext", false, "hash each distinct plaintext once and write the same salt and hash for its repeats, served from a cache of the last --cache-size plaintexts; identical plaintexts then share a hash")
+	flagsFor("cache-size").IntVar(&cacheSize, "cache-size", defaultCacheSize, "plaintexts generate remembers, least recently used first out, to count repeated plaintexts or, with --reuse-salt-per-plaintext, reuse their hashes. 0 = none")
+	flagsFor("unique-salts").BoolVar(&uniqueSaltsFlag, "unique-salts", false, "never use the same random salt twice in a run; salts already used are drawn again")
+	flagsFor("salt-sequence").StringVar(&saltSequenceSeed, "salt-sequence", "", "derive each salt from this seed and the line number instead of drawing it at random, for reproducible fixtures with unique salts (needs 16-byte salts; also --salt-seed). Test data only: the seed gives the salts away")
+	benchFlags := flagsFor("bench")
+	benchFlags.DurationVar(&benchDuration, "bench", 0, "instead of reading input, measure hashing throughput for this long (default 5s) and exit; use --bench=<duration>")
+	benchFlags.Lookup("bench").NoOptDefVal = "5s"
+	flagsFor("bench-iters").IntSliceVar(&benchIters, "bench-iters", nil, "comma-separated PBKDF2 iteration counts to compare with --bench (default: --iter)")
+	flagsFor("hashes").StringVar(&hashesPath, "hashes", "", "file of MVC4 or Identity v3 hashes (or converted hashcat lines) to crack")
+	flagsFor("wordlist").StringVar(&wordlistPath, "wordlist", "", "read candidate passwords from this file instead of stdin (same as --input)")
+	flagsFor("show").BoolVar(&showMode, "show", false, "print username:plaintext for the accounts of the input dump cracked in --potfile")
+	flagsFor("remove").BoolVar(&removeMode, "remove", false, "print the lines of the converted input whose hashes aren't cracked in --potfile yet")
+	flagsFor("rehash").BoolVar(&rehashMode, "rehash", false, "print username:new hash for the accounts of the input dump cracked in --potfile, re-hashed as --target-mode")
+	flagsFor("potfile").StringVar(&potfilePath, "potfile", "", "hashcat potfile with the cracked hashes")
+	flagsFor("target-mode").StringVar(&rehashTarget, "target-mode", "identityv3", "what rehash re-hashes the cracked plaintexts as: identityv3 (HMAC-SHA256, 10000 iterations) or identityv3-sha512 (HMAC-SHA512, 100000 iterations, as .NET 7 and later); --iter, --salt-size and --subkey-length override them")
+	flagsFor("unrecovered-file").StringVar(&unrecoveredPath, "unrecovered-file", "", "write the dump lines of the accounts rehash found no plaintext for to this file")
+	flagsFor("map-file").StringVar(&mapFilePath, "map-file", "", "convert: with --username, also write <hash>:<username> lines to this file; show: read those lines instead of the dump")
+	flagsFor("fix").BoolVar(&fix, "fix", false, "repair base64 before converting: add missing = padding, translate the URL-safe alphabet, drop whitespace inside hashes and join hashes wrapped over several lines at 64 or 76 columns; the stats count the lines each repair was needed for")
+	flagsFor("fix-only").BoolVar(&fixOnly, "fix-only", false, "like --fix, but write the repaired input lines instead of converting them")
+	flagsFor("strip-usernames").BoolVar(&stripUsernames, "strip-usernames", false, "with --username and --map-file, leave the usernames out of the output and write <hashcat line>\t<username> rows to the --map-file; --unique then writes repeated hashes once, but maps every account")
+	flagsFor("allow-length-mismatch").BoolVar(&allowLengthMismatch, "allow-length-mismatch", false, "[ADVANCED] convert any blob of at least 17 bytes, skipping the first byte and taking the next 16 as the salt, instead of requiring the 0x00 version byte and exactly 1 + --salt-size + --subkey-length bytes")
+	flagsFor("summary").BoolVar(&identifySummaryFlag, "summary", false, "print how many hashes of each format were found, as <count>\t<format>\t<hashcat mode>, instead of labeling each line")
+	flagsFor("frequency").BoolVar(&frequencyFlag, "frequency", false, "instead of the normal output, count how often each distinct hash (convert) or plaintext (generate) occurs and print the most frequent as <count>\t<percent>\t<value>")
+	flagsFor("top").IntVar(&frequencyTop, "top", 50, "number of values --frequency prints")
+	flagsFor("frequency-exact").BoolVar(&frequencyExact, "frequency-exact", false, "keep every distinct value for --frequency, so values seen once can be listed too; uses much more memory on large inputs")
+	flagsFor("analyze-salts").BoolVar(&analyzeSalts, "analyze-salts", false, "while converting, count the distinct salts and report the ones used by more than one hash and the all-zero or ASCII-looking ones; -v logs each reuse")
+	flagsFor("audit").BoolVar(&auditFlag, "audit", false, "report how many hashes meet the --audit-policy, by algorithm and iteration range, and exit 1 if too few do")
+	flagsFor("audit-policy").StringVar(&auditPolicyArg, "audit-policy", defaultAuditPolicy, "what --audit checks the hashes against: comma-separated min-iter=<n>, algos=<algorithm>[,<algorithm>...] and min-share=<percent of the hashes, default 100>")
+	flagsFor("analyze-salts-exact").BoolVar(&analyzeSaltsExact, "analyze-salts-exact", false, "like --analyze-salts, but keep every salt with the accounts using it, to list them for the most reused salts; uses much more memory on large inputs")
+	flagsFor("validate").BoolVar(&validate, "validate", false, "check the input without writing output: report the formats, lengths and salt sizes found and the lines that would fail, and exit 1 if any would; generate skips the hashing")
+	flagsFor("hash-algorithm").StringVar(&hashAlgorithm, "hash-algorithm", "sha256", "WebForms hashAlgorithmType: sha256, or hmacsha256 or hmacsha512 keyed with --validation-key")
+	validationKeyFlags := flagsFor("validation-key")
+	validationKeyFlags.StringVar(&validationKey, "validation-key", "", "machineKey validationKey (hex) the HMAC --hash-algorithm is keyed with, or where to read it: @file (@- for stdin), fd:N for an inherited file descriptor or env:NAME for an environment variable")
+	validationKeyFlags.SetAnnotation("validation-key", secretAnnotation, []string{"true"})
+	keyFlags := flagsFor("decryption-key")
+	keyFlags.StringVar(&decryptionKey, "decryption-key", "", "machineKey decryptionKey (hex) the passwords were encrypted with, or where to read it: @file (@- for stdin), fd:N or env:NAME, as for --validation-key")
+	keyFlags.SetAnnotation("decryption-key", secretAnnotation, []string{"true"})
+	flagsFor("insecure-secret-perms").BoolVar(&insecureSecretPerms, "insecure-secret-perms", false, "read --validation-key and --decryption-key from files anyone may read, which are refused otherwise")
+	flagsFor("decryption-algo").StringVar(&decryptionAlgo, "decryption-algo", "aes", "machineKey decryption algorithm: aes or 3des")
+	flagsFor("uncracked").BoolVar(&showUncracked, "uncracked", false, "also print accounts that weren't cracked, with "+uncrackedMarker+" as the plaintext")
+	flagsFor("normalize-username").StringVar(&normalizeUsernameArg, "normalize-username", "", "comma-separated transforms applied to usernames in the output, in order: lower, strip-domain (drop DOMAIN\\ and @domain), trim")
+	flagsFor("duplicate-usernames").StringVar(&duplicateUsernamesArg, "duplicate-usernames", "keep-all", "what to do with rows whose username, after --normalize-username, an earlier row had: keep-all, first (drop the later rows), last (drop the earlier rows; holds the output back until the input is done) or error (write nothing and exit 1); the stats count the repeats whatever the policy")
+	flagsFor("username-collisions").BoolVar(&reportCollisions, "username-collisions", false, "log rows whose username --normalize-username turns into one already seen for a different username")
+	flagsFor("iter-col").IntVar(&iterCol, "iter-col", 0, "take each row's PBKDF2 iteration count from this --delimiter separated field (1 = first, counting the username); rows without a valid count use --iter")
+	flagsFor("output-delimiter").StringVar(&outputDelimiterArg, "output-delimiter", ":", "separator between username and hash in converted output; accepts the same escapes as --delimiter (default: \":\")")
+	flagsFor("hex-escape").BoolVar(&hexEscape, "hex-escape", true, "decode $HEX[...] plaintexts in the input, and write usernames and plaintexts that contain the output delimiter, a colon or bytes outside printable ASCII as $HEX[<hex>] like hashcat; --hex-escape=false reads and writes them as they are")
+	global.Float64VarP(&rateLimit, "rate-limit", "r", 0, "number of lines per second to process; may be fractional (0.5 = one line every 2 seconds). 0 = no limit")
+	global.StringVar(&rateLimitBytes, "rate-limit-bytes", "", "input bytes to read per second, such as 10M (K, M and G are powers of 1024), counted before decompression; applies on top of --rate-limit")
+	global.IntVar(&rateBurst, "rate-burst", defaultRateBurst, "lines --rate-limit lets through at once to catch up after a stall. 0 = strict spacing")
+	global.StringVarP(&maxWorkersArg, "max-workers", "m", "0", "maximum number of workers (goroutines) to use, or auto to adjust it to the measured throughput. 0 = no limit (default))")
+	global.IntVar(&batchSize, "batch-size", 0, "lines handed to a worker at once (default: 256 for convert and identify, 1 for generate, verify, crack and --rate-limit)")
+	global.IntVar(&threads, "threads", 0, "CPU threads the Go runtime runs the work on (GOMAXPROCS), which caps the CPUs used whatever the number of workers (default: $GOMAXPROCS or the number of CPUs)")
+	global.IntVar(&maxWorkersCap, "max-workers-cap", 0, "most workers --max-workers auto may use (default: 8 per --threads)")
 
-	pflag.IntVarP(&PBKDF2IterCount, "iter", "i", 1000, "[ADVANCED] number of PBKDF2 iterations (default: 1000)")
-	pflag.IntVarP(&PBKDF2SubkeyLength, "subkey-length", "l", 32, "[ADVANCED] PBKDF2 subkey length in bytes (default: 32 = 256 bits)")
-	pflag.IntVarP(&SaltSize, "salt-size", "s", 16, "[ADVANCED] salt size in bytes (default: 16 = 128 bits)")
+	flagsFor("iter").IntVarP(&PBKDF2IterCount, "iter", "i", 1000, "[ADVANCED] number of PBKDF2 iterations (default: 1000)")
+	flagsFor("subkey-length").IntVarP(&PBKDF2SubkeyLength, "subkey-length", "l", 32, "[ADVANCED] PBKDF2 subkey length in bytes (default: 32 = 256 bits)")
+	flagsFor("salt-size").IntVarP(&SaltSize, "salt-size", "s", 16, "[ADVANCED] salt size in bytes (default: 16 = 128 bits)")
 
-	pflag.BoolVarP(&help, "help", "h", false, "print this help message")
-	pflag.BoolVarP(&advancedHelp, "advanced-help", "a", false, "print help message for advanced hashing options")
-	pflag.BoolVarP(&quiet, "quiet", "q", false, "suppress output")
+	global.StringVar(&profileName, "profile", "", "load options from a saved profile; flags given on the command line still take precedence")
+	global.StringVar(&saveProfileName, "save-profile", "", "save all non-default options to a named profile and exit")
+	global.StringVar(&profileDescription, "profile-description", "", "description stored with --save-profile")
+	global.StringVar(&profilesDir, "profiles-dir", defaultProfilesDir(), "directory profiles are stored in")
+	global.BoolVar(&listProfilesFlag, "list-profiles", false, "list saved profiles and exit")
+	global.StringVar(&configPath, "config", "", "read default options from this YAML or TOML file, keyed by long flag name (default: aspnethashtool/config.yaml or config.toml in the user config directory, if present; \"\" for none)")
+	global.BoolVar(&cleanStale, "clean-stale", false, "remove the temporary directories left in --tmp-dir by runs that were killed before cleaning up, and exit")
+	global.DurationVar(&staleAge, "stale-age", 24*time.Hour, "with --clean-stale, only remove the directories unchanged for this long")
+	global.BoolVar(&printConfigFlag, "print-config", false, "print the options in effect, after the config file, profile and --web-config, as a config file and exit")
 
-	pflag.Usage = func() {
-		if !advancedHelp {
+	global.StringVar(&webConfigPath, "web-config", "", "read the machineKey, membership hashAlgorithmType, password rules and iteration settings from a web.config; flags given on the command line or by --profile still take precedence")
+
+	global.BoolVarP(&help, "help", "h", false, "print this help message")
+	global.BoolVarP(&showVersion, "version", "V", false, "print version and build information and exit")
+	global.BoolVarP(&advancedHelp, "advanced-help", "a", false, "print help message for advanced hashing options")
+	global.BoolVarP(&quiet, "quiet", "q", false, "log nothing but errors")
+	global.StringVar(&logFormat, "log-format", "text", "format of the log on stderr: text, or json for one JSON object per entry with its fields")
+	global.BoolVar(&noColor, "no-color", false, "don't color errors and the summary, even if stderr is a terminal")
+	global.StringVar(&readerMode, "reader", "auto", "how to read a single uncompressed input file: lines (one by one), chunked (large blocks split into lines by the workers), mmap (like chunked, but memory-mapped) or auto (mmap for files over 1 GiB)")
+	global.BoolVar(&countFirst, "count-first", false, "count the input lines before processing, so progress and the stats can tell the share done (skipped for stdin, pipes, compressed and UTF-16 input)")
+	global.DurationVar(&progressInterval, "progress", 0, "log the progress every this long (e.g. 30s): lines done out of the --count-first total, or the share of the input file bytes read, and an ETA. 0 = off")
+	global.BoolVar(&requireInput, "require-input", false, "print the usage and exit instead of reading lines typed on the terminal when there is no --input")
+	global.BoolVar(&unique, "unique", false, "skip lines seen before (only the hash is compared with --username); duplicates are counted in the stats")
+	global.IntVar(&uniqueApprox, "unique-approx", 0, "like --unique, but remember lines in a bloom filter sized for this many distinct lines (about 4 bytes each), at the cost of dropping about one distinct line in a million")
+	global.BoolVar(&uniqueOutput, "unique-output", false, "don't write output lines already written in this run; the lines left out are counted in the stats")
+	global.IntVar(&uniqueOutputApprox, "unique-output-approx", 0, "like --unique-output, but remember lines in a bloom filter sized for this many distinct lines, as --unique-approx does")
+	global.StringVar(&uniqueOutputScope, "unique-output-scope", "global", "with --split, leave out lines written to any file (global) or only to the same file (shard)")
+	global.IntVar(&maxLineBytes, "max-line-bytes", defaultMaxLineBytes, "longest input line accepted; longer lines are counted as errors and skipped")
+	global.BoolVarP(&nullDelimited, "null", "0", false, "read and write NUL-terminated records instead of lines")
+	global.StringVar(&inputPath, "input", "", "read input from this file instead of stdin")
+	global.StringVar(&preFilter, "pre-filter", "", "shell command every input file is passed through before parsing; it must print one line for each line it reads, in order")
+	global.StringVar(&postFilter, "post-filter", "", "shell command the output is passed through before it is written; it must print one line for each line it reads, in order")
+	global.StringVar(&filesFrom, "files-from", "", "also read the input files listed in this file (- for stdin), one path or glob pattern per line, after --input and the file arguments")
+	global.StringVar(&inputCompression, "input-compression", "auto", "compression of the input: none, gzip, zstd or auto (detect from magic bytes)")
+	global.StringVarP(&outputPath, "output", "o", "", "write results to this file instead of stdout")
+	global.StringVar(&outputAddr, "output-addr", "", "send the output to this Unix socket (unix:///path/to.sock) or TCP endpoint (tcp://host:port) instead of stdout")
+	global.IntVar(&outputRetries, "output-retries", 5, "times --output-addr tries to reconnect after losing the connection before the run fails")
+	global.Int64Var(&outputBacklog, "output-backlog", 10000, "lines --output-addr holds while reconnecting before the workers have to wait")
+	global.IntVar(&writeBuffer, "write-buffer", defaultWriteBuffer, "size of the output buffer in bytes")
+	global.BoolVar(&outputAppend, "output-append", false, "add to an existing --output instead of replacing it")
+	global.BoolVar(&lockOutput, "lock-output", false, "lock --output while writing, so another run pointed at the same file fails at once instead of interleaving")
+	global.BoolVar(&tee, "tee", false, "also print the lines written to --output on stdout, e.g. to watch them while the file is written")
+	global.Int64Var(&teeHead, "head", 0, "like --tee, but only print the first this many lines. 0 = off")
+	global.BoolVar(&noAtomic, "no-atomic", false, "write --output in place instead of to <output>.tmp renamed at the end, e.g. for FIFOs or to read the file while it grows")
+	global.IntVar(&split, "split", 0, "spread the output over this many files next to --output (hashes.txt becomes hashes_000.txt, ...); --ordered only keeps the order within each file")
+	global.StringVar(&splitBy, "split-by", "round-robin", "how --split picks the file for a line: round-robin, or hash to keep identical hashes together")
+	global.StringVar(&outputLineEnding, "output-line-ending", "lf", "end output lines with lf, crlf for Windows tools that insist on it, or native (crlf on Windows, lf elsewhere)")
+	global.StringVar(&outputCompression, "output-compression", "", "compress the output: none, gzip or zstd (default: from the --output extension, .gz or .zst)")
+	global.BoolVar(&outputChecksum, "output-checksum", false, "compute a SHA-256 checksum of the output and report it with its byte and line counts in the stats")
+	global.StringVar(&checksumOf, "checksum-of", "written", "what --output-checksum hashes: the bytes written, or the raw records before --output-compression")
+	global.BoolVar(&checksumSidecar, "checksum-sidecar", false, "also write the --output-checksum to <output>.sha256 in sha256sum format (implies --output-checksum)")
+	global.BoolVar(&force, "force", false, "allow writing compressed output, or output held back by --sort, to stdout")
+	flagsFor("sort").StringVar(&sortKey, "sort", "", "hold the output back and write it sorted by username or hash at the end, spilling to temporary files beyond --sort-mem")
+	flagsFor("sort-mem").StringVar(&sortMemArg, "sort-mem", defaultSortMem, "memory --sort holds records in before spilling them to a file, e.g. 512M or 2G")
+	flagsFor("tmp-dir").StringVar(&tmpDir, "tmp-dir", os.TempDir(), "directory the temporary files of a run, like the --sort spill files, are kept in, in a directory of the run's own")
+	global.StringVar(&invalidUTF8, "invalid-utf8", "pass", "what to do with input lines that aren't valid UTF-8 after --input-charset: pass them on as they are, replace the invalid bytes with U+FFFD, or error")
+	global.StringVar(&inputCharset, "input-charset", "utf8", "character set of the input: utf8, utf16le, utf16be, windows-1252 or auto (detect from byte order mark)")
+	global.BoolVar(&keepCR, "keep-cr", false, "keep the \\r of CRLF line endings as part of the line")
+	global.BoolVar(&trimFlag, "trim", false, "trim leading/trailing whitespace from each line (default in convert mode)")
+	global.BoolVar(&noTrimFlag, "no-trim", false, "keep leading/trailing whitespace (default in generate mode, where plaintexts are hashed exactly as read)")
+	global.Int64Var(&skip, "skip", 0, "skip this many input lines before processing")
+	global.Int64Var(&limit, "limit", 0, "stop after processing this many lines (after --skip). 0 = no limit")
+	global.Float64Var(&sampleFraction, "sample", 0, "process only a random sample of this share of the input lines, e.g. 0.01, and extrapolate the stats to the whole input")
+	global.Int64Var(&sampleN, "sample-n", 0, "process only this many input lines, spread evenly over the input (needs an input that can be counted first)")
+	global.Int64Var(&sampleSeed, "sample-seed", 0, "seed of --sample and --sample-n, to draw the same sample again (default: random, shown in the stats)")
+	global.StringVar(&shardArg, "shard", "", "process only shard i of n of the input, given as i/n counting from 0, so n runs with i from 0 to n-1 process each line once between them")
+	global.StringVar(&shardStrategy, "shard-strategy", "modulo", "how --shard splits the input: modulo (the lines whose number modulo n is i, keeping their numbers) or bytes (the i-th of n contiguous byte ranges of a single uncompressed file, cut at line boundaries and numbered from the start of the range)")
+	global.BoolVar(&ordered, "ordered", false, "write results in input order")
+	global.StringVar(&checkpointPath, "checkpoint", "", "periodically record progress in this file so an interrupted run can be resumed (implies --ordered, needs --output)")
+	global.DurationVar(&timeout, "timeout", 0, "stop reading input after this long, let the lines in progress finish, and exit with code 124")
+	global.DurationVar(&checkpointInterval, "checkpoint-interval", 30*time.Second, "how often to update the --checkpoint file")
+	global.BoolVar(&resume, "resume", false, "continue from the --checkpoint file, appending to --output")
+	global.BoolVar(&strict, "strict", false, "stop at the first line that fails and exit non-zero")
+	global.CountVarP(&verbose, "verbose", "v", "log each failed line and the progress every 30s; repeat (-vv) to include the line content and startup and worker diagnostics")
+	global.StringVar(&cpuProfile, "cpu-profile", "", "write a CPU profile of the processing to this file")
+	global.StringVar(&memProfile, "mem-profile", "", "write a heap profile to this file after processing")
+	global.BoolVar(&timingsFlag, "timings", false, "break the run time down in the stats into reading, waiting for a worker, processing and writing")
+	global.StringVar(&statusAddr, "status-addr", "", "serve the progress of the run as JSON on this address (e.g. :8899), with /healthz answering 200 while it runs")
+	global.StringVar(&pprofHTTP, "pprof-http", "", "serve net/http/pprof on this address (e.g. localhost:6060) while running")
+	global.StringVar(&statsJSON, "stats-json", "", "write the final statistics as JSON to this file")
+	global.StringVar(&errorFilePath, "error-file", "", "write failed input lines to this file as <line number>\\t<error>\\t<line>, with <file>:<line number> when reading several inputs")
+	global.BoolVar(&errorFileAlways, "error-file-always", false, "create the --error-file even if no lines fail")
+
+	flags.AddFlagSet(global)
+	// Profiles and checkpoints look flags up through the package-level
+	// functions, so make the active flag set the default one.
+	pflag.CommandLine = flags
+
+	flags.Usage = func() {
+		printFlag := func(flag *pflag.Flag, usage string) {
+			if flag.Shorthand != "" {
+				fmt.Printf(" -%s, --%-20s %s\n", flag.Shorthand, flag.Name, usage)
+			} else {
+				fmt.Printf("     --%-20s %s\n", flag.Name, usage)
+			}
+		}
+		// printFlags prints the flags for which include returns true.
+		printFlags := func(include func(flag *pflag.Flag) bool) {
+			flags.VisitAll(func(flag *pflag.Flag) {
+				if !include(flag) {
+					return
+				}
+				if advancedHelp {
+					if strings.HasPrefix(flag.Usage, "[ADVANCED]") {
+						printFlag(flag, strings.TrimPrefix(flag.Usage, "[ADVANCED] "))
+					}
+				} else if !strings.HasPrefix(flag.Usage, "[ADVANCED]") {
+					printFlag(flag, flag.Usage)
+				}
+			})
+		}
+
+		if advancedHelp {
+			fmt.Printf("Advanced options:\n")
+			printFlags(func(*pflag.Flag) bool { return true })
+			fmt.Println("\nWARNING: Changing these parameters will result in hashes that are incompatible with ASP.NET.")
+			return
+		}
+
+		if legacy {
 			fmt.Printf("Usage of %s:\n", os.Args[0])
 			fmt.Println("This application either generates or converts ASP.NET MVC4/Web Forms password hashes.")
 			fmt.Println("Convert mode (default) reads hashes from stdin and writes hashcat mode 12000 compatible hashes to stdout.")
 			fmt.Println("Generate mode (-g) reads plaintext from stdin and writes hashes to stdout.")
+			fmt.Println("Commands:")
+			for _, c := range commands {
+				fmt.Printf("  %-24s %s\n", c.name, c.summary)
+			}
 			fmt.Println("Flags:")
-		} else {
-			fmt.Printf("Advanced options:\n")
+			printFlags(func(*pflag.Flag) bool { return true })
+			return
 		}
 
-		pflag.VisitAll(func(flag *pflag.Flag) {
-			if advancedHelp {
-				if strings.HasPrefix(flag.Usage, "[ADVANCED]") {
-					fmt.Printf(" -%s, --%-20s %s\n", flag.Shorthand, flag.Name, strings.TrimPrefix(flag.Usage, "[ADVANCED] "))
-				}
-			} else if !strings.HasPrefix(flag.Usage, "[ADVANCED]") {
-				fmt.Printf(" -%s, --%-20s %s\n", flag.Shorthand, flag.Name, flag.Usage)
+		fmt.Printf("Usage: %s %s [flags] [file...]\n", os.Args[0], command)
+		for _, c := range commands {
+			if c.name == command {
+				fmt.Println(c.summary)
 			}
-		})
-		if advancedHelp {
-			fmt.Println("\nWARNING: Changing these parameters will result in hashes that are incompatible with ASP.NET.")
 		}
+		fmt.Println("Flags:")
+		printFlags(func(flag *pflag.Flag) bool { return global.Lookup(flag.Name) == nil })
+		fmt.Println("Global flags:")
+		printFlags(func(flag *pflag.Flag) bool { return global.Lookup(flag.Name) != nil })
 	}
+	// With flags installed as pflag.CommandLine, parse errors go through
+	// the package-level Usage.
+	pflag.Usage = flags.Usage
 
-	pflag.Parse()
+	flags.Parse(args)
+
+	// flagSources tells where the flags not at their default got their
+	// value, for --print-config.
+	flagSources := map[string]string{}
+	recordSources := func(set map[string]bool, source string) {
+		for name := range set {
+			flagSources[name] = source
+		}
+	}
+	flags.Visit(func(flag *pflag.Flag) { flagSources[flag.Name] = sourceCommandLine })
+	fromEnv, err := applyEnv(flags)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	recordSources(fromEnv, sourceEnv)
 
 	if advancedHelp {
-		pflag.Usage()
+		flags.Usage()
 		os.Exit(0)
 	}
 
 	if help {
-		pflag.Usage()
+		flags.Usage()
+		os.Exit(0)
+	}
+
+	if showVersion {
+		printVersion(filepath.Base(os.Args[0]))
+		os.Exit(0)
+	}
+
+	if legacy {
+		command = "convert"
+		if generateMode || flags.Changed("password") || prompt || flags.Changed("bench") {
+			command = "generate"
+		} else if showMode {
+			command = "show"
+		} else if removeMode {
+			command = "remove"
+		} else if rehashMode {
+			command = "rehash"
+		}
+	}
+
+	if command == "completion" {
+		if flags.NArg() != 1 {
+			log.Fatalf("Usage: %s completion <%s>", os.Args[0], strings.Join(completionShells, "|"))
+		}
+		if err := writeCompletion(os.Stdout, flags.Arg(0), filepath.Base(os.Args[0]), flags); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "testvectors" {
+		if err := writeTestVectors(os.Stdout, jsonOutput); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if listProfilesFlag {
+		if err := listProfiles(profilesDir); err != nil {
+			log.Fatalf("Error listing profiles: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	// The profile may set --quiet or --log-format, so its warnings wait
+	// for setupLogging.
+	var profileWarnings []string
+	if profileName != "" {
+		var fromProfile map[string]bool
+		var err error
+		fromProfile, profileWarnings, err = loadProfile(profilesDir, profileName)
+		if err != nil {
+			log.Fatalf("Error loading profile: %v", err)
+		}
+		recordSources(fromProfile, sourceProfile)
+	}
+
+	if !flags.Changed("config") {
+		configPath = findDefaultConfig()
+	}
+	var fromConfig map[string]bool
+	if configPath != "" {
+		// Flags of other commands may be in the config file too.
+		known := func(name string) bool { return flags.Lookup(name) != nil || unused.Lookup(name) != nil }
+		var err error
+		if fromConfig, err = applyConfig(configPath, known); err != nil {
+			log.Fatalf("Error reading --config: %v", err)
+		}
+		recordSources(fromConfig, sourceConfig)
+	}
+
+	if !slices.Contains(logFormats, logFormat) {
+		log.Fatalf("Error: invalid --log-format %q (valid: %v).", logFormat, logFormats)
+	}
+	setupLogging(quiet, verbose, noColor, logFormat)
+	for _, w := range profileWarnings {
+		log.Printf("Warning: %s", w)
+	}
+
+	var fromWebConfig map[string]bool
+	if webConfigPath != "" {
+		var err error
+		if fromWebConfig, err = applyWebConfig(webConfigPath, validate || logLevel >= levelVerbose, fromConfig); err != nil {
+			log.Fatalf("Error reading --web-config: %v", err)
+		}
+		recordSources(fromWebConfig, sourceWebConfig)
+	}
+
+	if printConfigFlag {
+		printConfig(os.Stdout, flagSources)
+		os.Exit(0)
+	}
+
+	if cleanStale {
+		if staleAge < 0 {
+			log.Fatalf("Error: --stale-age must not be negative.")
+		}
+		removed, err := aspnethash.CleanStale(tmpDir, staleAge)
+		for _, dir := range removed {
+			logAt(levelVerbose, "Removed %s", dir)
+		}
+		log.Printf("Removed %d temporary directories of runs that are gone from %s", len(removed), tmpDir)
+		if err != nil {
+			log.Fatalf("Error cleaning up %s: %v", tmpDir, err)
+		}
 		os.Exit(0)
 	}
 
+	if skip < 0 || limit < 0 {
+		log.Fatalf("Error: --skip and --limit must not be negative.")
+	}
+
+	if maxLineBytes < 1 {
+		log.Fatalf("Error: --max-line-bytes must be at least 1.")
+	}
+
+	normalize, err := parseUsernameNormalizer(normalizeUsernameArg)
+	if err != nil {
+		log.Fatalf("Error: invalid --normalize-username: %v", err)
+	}
+	if SaltSize < 1 || PBKDF2SubkeyLength < 1 {
+		log.Fatalf("Error: --salt-size and --subkey-length must be positive.")
+	}
+	parseHash := newHashParser(SaltSize, PBKDF2SubkeyLength, allowLengthMismatch)
+	var collisions *usernameCollisions
+	if reportCollisions {
+		if normalize == nil {
+			log.Fatalf("Error: --username-collisions needs --normalize-username.")
+		}
+		collisions = newUsernameCollisions()
+	}
+	if normalize != nil && !usernamePresent && command != "diff" {
+		log.Fatalf("Error: --normalize-username needs --username.")
+	}
+
+	if iterCol < 0 {
+		log.Fatalf("Error: --iter-col must not be negative.")
+	}
+
+	var dedup seenSet
+	switch {
+	case uniqueApprox < 0:
+		log.Fatalf("Error: --unique-approx must not be negative.")
+	case uniqueApprox > 0:
+		dedup = newBloomSet(uniqueApprox)
+	case unique:
+		dedup = exactSet{}
+	}
+
 	// Validate the mode flag
 	hashMode = strings.ToLower(hashMode)
-	if hashMode != "mvc4" && hashMode != "webforms" && hashMode != "default" {
-		log.Fatalf("Invalid mode. Choose between MVC4 and WebForms.")
+	if hashMode != "default" && !slices.Contains(hashModes, hashMode) {
+		log.Fatalf("Invalid mode. Choose between MVC4, WebForms and DNN.")
+	}
+
+	if flags.Changed("threads") {
+		if threads < 1 {
+			log.Fatalf("Error: --threads must be at least 1.")
+		}
+		runtime.GOMAXPROCS(threads)
+	}
+	// cpus is how many CPUs the work can run on at once.
+	cpus := runtime.GOMAXPROCS(0)
+
+	if maxWorkers, autoWorkers, err = parseMaxWorkers(maxWorkersArg); err != nil {
+		log.Fatalf("Error: invalid --max-workers: %v", err)
+	}
+	if autoWorkers {
+		maxWorkers = maxWorkersCap
+		if maxWorkers <= 0 {
+			maxWorkers = 8 * cpus
+		}
 	}
 
 	// Create max worker semaphore if maxWorkers is set
@@ -183,98 +827,2222 @@ func main() {
 		sem = make(chan struct{}, maxWorkers)
 	}
 
-	if generateMode {
+	if trimFlag && noTrimFlag {
+		log.Fatalf("Error: --trim and --no-trim are mutually exclusive.")
+	}
+	// Plaintexts are hashed exactly as read unless asked otherwise, while
+	// stray whitespace around a base64 hash is never meaningful. The \r of a
+	// CRLF line ending is removed by the reader before either applies.
+	trim = command != "generate" && command != "crack"
+	if trimFlag {
+		trim = true
+	} else if noTrimFlag {
+		trim = false
+	}
+
+	var schema *sqlSchema
+	// randomPositions are the characters of each position of the --random
+	// plaintexts.
+	var randomPositions [][]rune
+	if !slices.Contains(outputFormatsGenerate, generateFormat) {
+		log.Fatalf("Error: invalid --output-format %q (valid: %v).", generateFormat, outputFormatsGenerate)
+	}
+	if generateFormat == "sql" {
+		if schemaArg == "" {
+			log.Fatalf("Error: --output-format sql needs the --schema to update.")
+		}
+		s, err := lookupSQLSchema(schemaArg)
+		if err != nil {
+			log.Fatalf("Error: invalid --schema: %v", err)
+		}
+		if hashMode == "default" {
+			hashMode = s.hashModes[0]
+		} else if !slices.Contains(s.hashModes, hashMode) {
+			log.Fatalf("Error: --schema %s stores %s hashes, not %s ones.", s.name, strings.Join(s.hashModes, " or "), hashMode)
+		}
+		if sqlUsersTable != "" && s.usersTable == "" {
+			log.Fatalf("Error: %s has the usernames itself; --sql-users-table doesn't apply.", s.table)
+		}
+		if sqlTable != "" {
+			s.table = sqlTable
+		}
+		if sqlUsersTable != "" {
+			s.usersTable = sqlUsersTable
+		}
+		if sqlUsernameColumn != "" {
+			s.usernameColumn = sqlUsernameColumn
+		}
+		schema = &s
+	} else if schemaArg != "" || sqlTable != "" || sqlUsersTable != "" || sqlUsernameColumn != "" {
+		log.Fatalf("Error: --schema and the --sql-* flags only apply to --output-format sql.")
+	}
+
+	switch command {
+	case "generate", "verify":
 		work_type = "lines"
 		if hashMode == "default" {
 			hashMode = "mvc4"
 		}
-		if usernamePresent {
-			log.Fatalf("Error: --generate and --username flags are mutually exclusive.")
+		if command == "generate" && hashMode == "auto" {
+			log.Fatalf("Error: --mode auto only applies to convert.")
 		}
-	} else {
+		if command == "verify" && hashMode != "mvc4" && hashMode != "umbraco-legacy" && formsAuthAlgorithm(hashMode) == "" {
+			log.Fatalf("Error: verify supports --mode mvc4 (which also recognizes WebForms pairs), umbraco-legacy, formsauth-sha1 and formsauth-md5.")
+		}
+		if command == "verify" && usernamePresent {
+			log.Fatalf("Error: verify doesn't take --username.")
+		}
+		if randomCount < 0 {
+			log.Fatalf("Error: --random must not be negative.")
+		}
+		if randomCount > 0 {
+			if usernamePresent {
+				log.Fatalf("Error: --random makes up the usernames, or takes them from --usernames-file; don't give --username.")
+			}
+			if inputPath != "" || flags.NArg() > 0 || filesFrom != "" || flags.Changed("password") || prompt {
+				log.Fatalf("Error: --random generates its own plaintexts; it can't be combined with input files, --password or --prompt.")
+			}
+			if dedup != nil {
+				log.Fatalf("Error: --unique can't be combined with --random.")
+			}
+			if randomMask != "" {
+				if flags.Changed("random-charset") || flags.Changed("random-length") {
+					log.Fatalf("Error: --random-mask sets the charset and length of every position; don't give --random-charset or --random-length.")
+				}
+				if randomPositions, err = parseMask(randomMask); err != nil {
+					log.Fatalf("Error: invalid --random-mask: %v", err)
+				}
+			} else {
+				if randomLength < 1 {
+					log.Fatalf("Error: --random-length must be at least 1.")
+				}
+				charset, err := parseRandomCharset(randomCharset)
+				if err != nil {
+					log.Fatalf("Error: invalid --random-charset: %v", err)
+				}
+				for i := 0; i < randomLength; i++ {
+					randomPositions = append(randomPositions, charset)
+				}
+			}
+			// The usernames are written as those of --username lines.
+			usernamePresent = usernamesFile == ""
+		} else if noPlain || flags.Changed("random-charset") || flags.Changed("random-length") || randomMask != "" {
+			log.Fatalf("Error: --random-charset, --random-length, --random-mask and --no-plain only apply to --random.")
+		}
+		if !slices.Contains(blankUsernamePolicies, blankUsernames) {
+			log.Fatalf("Error: invalid --blank-usernames %q (valid: %v).", blankUsernames, blankUsernamePolicies)
+		}
+		if usernamesFile != "" {
+			if usernamePresent {
+				log.Fatalf("Error: --usernames-file gives the usernames; input lines with --username can't have them too.")
+			}
+			if dedup != nil {
+				log.Fatalf("Error: --unique can't be combined with --usernames-file.")
+			}
+			// The usernames are written as those of --username lines.
+			usernamePresent = true
+		}
+	case "crack":
+		work_type = "candidates"
+	case "decrypt":
+		work_type = "passwords"
+	default:
 		work_type = "hashes"
-		if hashMode != "default" {
-			log.Fatalf("Error: hash type selection is not supported in convert mode.")
+		if hashMode == "default" {
+			hashMode = "mvc4"
+		}
+		if hashMode != "mvc4" && hashMode != "dnn" && hashMode != "auto" && formsAuthAlgorithm(hashMode) == "" {
+			log.Fatalf("Error: convert supports --mode mvc4, dnn, formsauth-sha1, formsauth-md5 and auto.")
+		}
+		if (hashMode == "dnn" || formsAuthAlgorithm(hashMode) != "") && (iterCol > 0 || mapFilePath != "") {
+			log.Fatalf("Error: --iter-col and --map-file only apply to MVC4 hashes.")
+		}
+		if hashMode == "auto" && (iterCol > 0 || allowLengthMismatch || flags.Changed("salt-size") || flags.Changed("subkey-length")) {
+			log.Fatalf("Error: --mode auto only converts hashes of the standard sizes; --iter-col, --salt-size, --subkey-length and --allow-length-mismatch don't apply.")
 		}
 	}
 
-	if delimiter != "," && !usernamePresent {
-		log.Fatalf("Error: --delimiter can only be used when --username is also used.")
+	if delimiter, err = parseDelimiter(delimiterArg); err != nil {
+		log.Fatalf("Error: invalid --delimiter: %v", err)
 	}
-
-	// Disable logging if quiet
-	if quiet {
-		log.SetOutput(io.Discard)
-	} else {
-		log.SetOutput(os.Stderr)
+	if outputDelimiter, err = parseDelimiter(outputDelimiterArg); err != nil {
+		log.Fatalf("Error: invalid --output-delimiter: %v", err)
 	}
 
-	// Rate limiting
-	var limiter ratelimit.Limiter
-	if rateLimit > 0 {
-		limiter = ratelimit.New(rateLimit)
-	} else {
-		limiter = ratelimit.NewUnlimited()
+	if command == "diff" {
+		if flags.NArg() != 2 {
+			log.Fatalf("Usage: %s diff [flags] <old dump> <new dump>", os.Args[0])
+		}
+		d := &dumpDiff{delimiter: delimiter, trim: trim, normalize: normalize, iterations: PBKDF2IterCount, charset: inputCharset}
+		if err := writeDumpDiff(os.Stdout, flags.Arg(0), flags.Arg(1), d, jsonOutput); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		os.Exit(0)
+	}
+	if !slices.Contains(invalidUTF8Policies, invalidUTF8) {
+		log.Fatalf("Error: invalid --invalid-utf8 %q (valid: %v).", invalidUTF8, invalidUTF8Policies)
+	}
+	esc := hexEscaper{on: hexEscape, delimiter: outputDelimiter}
+	if usernamePresent {
+		normalize = append(normalize, esc.escape)
 	}
 
-	log.Printf("Processing %s from stdin...\n\n", work_type)
-
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		if maxWorkers > 0 {
-			sem <- struct{}{} // Acquire a token if maxWorkers is set
+	var crk *cracker
+	if command == "crack" {
+		if hashesPath == "" {
+			log.Fatalf("Error: crack needs the --hashes to test.")
 		}
-
-		wg.Add(1)
-		if rateLimit > 0 {
-			limiter.Take()
+		if wordlistPath != "" {
+			if inputPath != "" {
+				log.Fatalf("Error: --wordlist and --input are the same thing; give only one.")
+			}
+			inputPath = wordlistPath
 		}
+		if crk, err = loadCracker(hashesPath, usernamePresent, delimiter, PBKDF2IterCount); err != nil {
+			log.Fatalf("Error loading --hashes: %v", err)
+		}
+		if crk.targets == 0 {
+			log.Fatalf("Error: no hashes in %s.", hashesPath)
+		}
+	}
 
-		go func(line string) {
-			defer wg.Done()
-			var result string
-			var err error
-
-			if generateMode {
-				// Generate hash
-				result, err = generateHash(line, hashMode, int(PBKDF2IterCount), int(PBKDF2SubkeyLength), int(SaltSize))
-			} else {
-				// Convert hash
-				result, err = convertHash(line, usernamePresent, delimiter, PBKDF2IterCount)
+	var pot *potfile
+	if command == "show" || command == "remove" || command == "rehash" {
+		if potfilePath == "" {
+			log.Fatalf("Error: %s needs the --potfile to look the hashes up in.", command)
+		}
+		if mapFilePath != "" {
+			if inputPath != "" || flags.NArg() > 0 || filesFrom != "" || usernamePresent {
+				log.Fatalf("Error: --map-file replaces the dump; don't give --input or --username with it.")
 			}
-
-			if err != nil {
-				atomic.AddInt64(&erroredLines, 1)
-			} else {
-				fmt.Println(result)
-				atomic.AddInt64(&processedLines, 1)
+			inputPath = mapFilePath
+		}
+		if pot, err = loadPotfile(potfilePath); err != nil {
+			log.Fatalf("Error loading --potfile: %v", err)
+		}
+		if pot.malformed > 0 {
+			log.Printf("Skipped %d malformed lines in %s\n", pot.malformed, potfilePath)
+		}
+	}
+	var rh *rehasher
+	if command == "rehash" {
+		if !slices.Contains(rehashTargets, rehashTarget) {
+			log.Fatalf("Error: invalid --target-mode %q (valid: %v).", rehashTarget, rehashTargets)
+		}
+		prf, iterations := rehashParams(rehashTarget)
+		if flags.Changed("iter") {
+			if PBKDF2IterCount < 1 {
+				log.Fatalf("Error: --iter must be at least 1.")
 			}
-			if maxWorkers > 0 {
-				<-sem // Release the token if maxWorkers is set
+			iterations = PBKDF2IterCount
+		}
+		rh = newRehasher(pot, prf, aspnethash.Options{Iterations: iterations, SaltSize: SaltSize, SubkeyLength: PBKDF2SubkeyLength})
+	}
+	var keyed *aspnethash.KeyedHasher
+	hashAlgorithm = strings.ToLower(hashAlgorithm)
+	if fromWebConfig["hash-algorithm"] && command == "generate" && hashMode != "webforms" {
+		// The membership hash algorithm says nothing about MVC4 hashes.
+		hashAlgorithm = "sha256"
+	}
+	if fromWebConfig["validation-key"] && hashAlgorithm == "sha256" {
+		validationKey = ""
+	}
+	if !slices.Contains(hashAlgorithms, hashAlgorithm) {
+		log.Fatalf("Error: invalid --hash-algorithm %q (valid: %v).", hashAlgorithm, hashAlgorithms)
+	}
+	singleValue := flags.Changed("password") || prompt || flags.Changed("hash") || flags.Changed("bench")
+	if !slices.Contains(lineEndings, outputLineEnding) {
+		log.Fatalf("Error: invalid --output-line-ending %q (valid: %v).", outputLineEnding, lineEndings)
+	}
+	newline := lineEnding(outputLineEnding)
+	if newline != "\n" && nullDelimited {
+		log.Fatalf("Error: --null writes NUL-terminated records; --output-line-ending doesn't apply.")
+	}
+	var listed []string
+	if filesFrom != "" && !singleValue && randomCount == 0 {
+		var unmatched []string
+		var err error
+		if listed, unmatched, err = readFilesFrom(filesFrom); err != nil {
+			log.Fatalf("Error reading --files-from: %v", err)
+		}
+		for _, pattern := range unmatched {
+			if strict {
+				log.Fatalf("Error: --files-from pattern %q matches no files.", pattern)
 			}
-		}(scanner.Text())
+			log.Printf("Warning: --files-from pattern %q matches no files.", pattern)
+		}
+	}
+	// A key read from stdin leaves nothing of it for the input.
+	if (secretFromStdin(validationKey) || secretFromStdin(decryptionKey)) && !singleValue && randomCount == 0 && readsStdin(inputPath, flags.Args(), filesFrom, listed) {
+		log.Fatalf("Error: the key is read from stdin (@- or fd:0), so the input has to be given as a file.")
+	}
+	if hashAlgorithm != "sha256" {
+		if command == "generate" && hashMode != "webforms" {
+			log.Fatalf("Error: --hash-algorithm only applies to --mode webforms.")
+		}
+		if validationKey == "" {
+			log.Fatalf("Error: --hash-algorithm %s needs the machineKey --validation-key.", hashAlgorithm)
+		}
+		key, err := readKeyArg("validation-key", validationKey, insecureSecretPerms)
+		if err != nil {
+			log.Fatalf("Error: invalid --validation-key: %v", err)
+		}
+		if keyed, err = aspnethash.NewKeyedHasher(hashAlgorithm, key); err != nil {
+			log.Fatalf("Error: invalid --validation-key: %v", err)
+		}
+	} else if validationKey != "" {
+		log.Fatalf("Error: --validation-key needs an HMAC --hash-algorithm.")
+	}
+
+	var passwordCipher *aspnethash.PasswordCipher
+	if command == "decrypt" {
+		if decryptionKey == "" {
+			log.Fatalf("Error: decrypt needs the machineKey --decryption-key.")
+		}
+		key, err := readKeyArg("decryption-key", decryptionKey, insecureSecretPerms)
+		if err != nil {
+			log.Fatalf("Error: invalid --decryption-key: %v", err)
+		}
+		if passwordCipher, err = aspnethash.NewPasswordCipher(strings.ToLower(decryptionAlgo), key); err != nil {
+			log.Fatalf("Error: invalid --decryption-key: %v", err)
+		}
+	}
+	if stripUsernames && mapFilePath == "" {
+		log.Fatalf("Error: --strip-usernames needs --map-file to keep the usernames.")
+	}
+	var policy *passwordPolicy
+	var violations *policyViolations
+	if policyWarn && policyEnforce {
+		log.Fatalf("Error: --policy-warn and --policy-enforce are mutually exclusive.")
+	}
+	if policyArg != "" {
+		p, err := parsePasswordPolicy(policyArg)
+		if err != nil {
+			log.Fatalf("Error: invalid --policy: %v", err)
+		}
+		policy, violations = &p, &policyViolations{}
+	} else if policyWarn || policyEnforce {
+		log.Fatalf("Error: --policy-warn and --policy-enforce need a --policy.")
+	}
+	// checkPolicy counts the rules plain breaks; with --policy-enforce,
+	// breaking any is an error.
+	checkPolicy := func(lineNo int64, plain string) error {
+		if policy == nil {
+			return nil
+		}
+		broken := policy.check(plain)
+		if broken == 0 {
+			return nil
+		}
+		violations.add(broken, lineNo)
+		if policyEnforce {
+			return fmt.Errorf("%w: %s", errPolicy, strings.Join(brokenRules(broken), ", "))
+		}
+		return nil
+	}
+
+	var fixes *base64Fixes
+	if fixOnly {
+		if mapFilePath != "" || frequencyFlag || validate {
+			log.Fatalf("Error: --fix-only writes the repaired input, so it can't be combined with --map-file, --frequency or --validate.")
+		}
+		fix = true
+	}
+	if fix {
+		fixes = &base64Fixes{}
+	}
+	// outputDedup is --unique with --strip-usernames: every account needs its
+	// map row, so only repeated hashes are left out of the output.
+	var outputDedup *lockedSet
+	// sidePath is a second output written next to the main one, in the same
+	// order: the --map-file of convert or the --also-hashcat file of
+	// generate. sideFlag names it in messages.
+	var sidePath, sideFlag string
+	if command == "convert" && mapFilePath != "" {
+		sidePath, sideFlag = mapFilePath, "--map-file"
+		if !usernamePresent {
+			log.Fatalf("Error: --map-file needs --username.")
+		}
+		if checkpointPath != "" {
+			log.Fatalf("Error: --map-file can't be resumed from a --checkpoint.")
+		}
+		if stripUsernames && dedup != nil {
+			outputDedup = &lockedSet{set: dedup}
+			dedup = nil
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatalf("Stdin scanner encountered an error: %v", err)
+	if unrecoveredPath != "" {
+		if checkpointPath != "" {
+			log.Fatalf("Error: --unrecovered-file can't be resumed from a --checkpoint.")
+		}
+		sidePath, sideFlag = unrecoveredPath, "--unrecovered-file"
 	}
 
-	wg.Wait()
+	if alsoHashcat != "" {
+		if checkpointPath != "" {
+			log.Fatalf("Error: --also-hashcat can't be resumed from a --checkpoint.")
+		}
+		if validate || frequencyFlag {
+			log.Fatalf("Error: --validate and --frequency write no hashes; don't give --also-hashcat.")
+		}
+		if hashMode == "umbraco-legacy" || keyed != nil {
+			log.Fatalf("Error: hashcat has no mode for umbraco-legacy or HMAC keyed hashes; --also-hashcat can't write them.")
+		}
+		sidePath, sideFlag = alsoHashcat, "--also-hashcat"
+	}
 
-	endTime := time.Now()
-	totalTime := endTime.Sub(startTime).Seconds()
+	var dialect *hashcatDialect
+	if hashcatModeArg != "" {
+		if dialect, err = lookupDialect(hashcatModeArg); err != nil {
+			log.Fatalf("Error: invalid --hashcat-mode: %v", err)
+		}
+		if command == "generate" && alsoHashcat == "" {
+			log.Fatalf("Error: generate writes hashcat lines only with --also-hashcat; --hashcat-mode needs it.")
+		}
+		algorithms := modeAlgorithms[hashMode]
+		if command == "generate" && hashMode == "mvc4" {
+			// Only convert takes Identity v3 hashes for MVC4 ones.
+			algorithms = []string{algoPBKDF2SHA1}
+		}
+		if algorithms != nil && !slices.ContainsFunc(algorithms, dialect.holds) {
+			log.Fatalf("Error: hashcat mode %s (%s) can't hold %s hashes (%s); valid combinations: %s", dialect.mode, dialect.name, hashMode, strings.Join(algorithms, ", "), dialectCombinations())
+		}
+	}
 
-	// Stats
-	if !quiet {
-		fmt.Fprintln(os.Stderr)
-	}
-	log.Printf("Done! Total Run Time: %f seconds", totalTime)
-	log.Printf("Processed %d %s", processedLines, work_type)
-	log.Printf("Errored %s: %d", work_type, erroredLines)
-	if totalTime > 0 {
-		r := []rune(work_type)
-		r[0] = unicode.ToUpper(r[0])
-		work_type = string(r)
-		log.Printf("%s per second: %f", work_type, float64(processedLines)/totalTime)
+	if !slices.Contains(outputFormats, outputFormat) {
+		log.Fatalf("Error: invalid --format %q (valid: %v).", outputFormat, outputFormats)
+	}
+	if outputFormat == "phc" {
+		if dialect != nil {
+			log.Fatalf("Error: --hashcat-mode picks a hashcat output; it can't be combined with --format phc.")
+		}
+		if hashMode == "webforms" || hashMode == "dnn" || formsAuthAlgorithm(hashMode) != "" {
+			log.Fatalf("Error: --format phc only holds PBKDF2 hashes; %s hashes aren't.", hashMode)
+		}
+		dialect = &phcDialect
+	}
+
+	if legacy && jsonOutput && command != "convert" {
+		// Without a command, every flag parses, so --json would go unused.
+		log.Fatalf("Error: --json only applies to convert mode, not %s.", command)
+	}
+	if command == "convert" && jsonOutput {
+		switch {
+		case flags.Changed("hash"):
+			log.Fatalf("Error: --hash writes the hashcat line of one hash; it can't be combined with --json.")
+		case sortKey != "":
+			log.Fatalf("Error: --sort reads the records as lines; it can't be combined with --json.")
+		case stripUsernames:
+			log.Fatalf("Error: --strip-usernames can't be combined with --json; leave the username out of the objects with jq instead.")
+		case fixOnly:
+			log.Fatalf("Error: --fix-only writes the input lines, not JSON; it can't be combined with --json.")
+		case splitBy == "hash":
+			log.Fatalf("Error: --split-by hash reads the records as lines; it can't be combined with --json.")
+		}
+	}
+	if withSource {
+		if !jsonOutput {
+			log.Fatalf("Error: --with-source adds to the objects of --json; give --json too.")
+		}
+		if preFilter != "" {
+			log.Fatalf("Error: --with-source can't trace lines through --pre-filter back to the input.")
+		}
+	}
+
+	if (hashMode == "umbraco-legacy" || formsAuthAlgorithm(hashMode) != "") && (saltArg != "" || uniqueSaltsFlag || flags.Changed("salt-sequence")) {
+		log.Fatalf("Error: %s hashes aren't salted.", hashMode)
+	}
+	if saltArg != "" {
+		if fixedSalt, err = base64.StdEncoding.DecodeString(saltArg); err != nil {
+			log.Fatalf("Error: invalid --salt: %v", err)
+		}
+		if hashMode == "mvc4" && len(fixedSalt) != aspnethash.DefaultSaltSize {
+			log.Fatalf("Error: --salt must be %d bytes in MVC4 mode.", aspnethash.DefaultSaltSize)
+		}
+	}
+
+	var saltDraws *uniqueSalts
+	var saltSeq *saltSequence
+	if uniqueSaltsFlag || flags.Changed("salt-sequence") {
+		if saltArg != "" {
+			log.Fatalf("Error: --salt uses one salt for every hash; it can't be combined with --unique-salts or --salt-sequence.")
+		}
+		if uniqueSaltsFlag && flags.Changed("salt-sequence") {
+			log.Fatalf("Error: --salt-sequence salts are unique already; don't add --unique-salts.")
+		}
+		saltLen := SaltSize
+		if hashMode == "mvc4" {
+			saltLen = aspnethash.DefaultSaltSize
+		}
+		if uniqueSaltsFlag {
+			if resume {
+				log.Fatalf("Error: --unique-salts can't know the salts of the run being resumed; use --salt-sequence instead.")
+			}
+			saltDraws = newUniqueSalts(saltLen)
+		} else {
+			if saltLen < 16 {
+				log.Fatalf("Error: --salt-sequence needs salts of at least 16 bytes to keep them unique.")
+			}
+			if saltSeq, err = newSaltSequence(saltSequenceSeed, saltLen); err != nil {
+				log.Fatalf("Error: --salt-sequence: %v", err)
+			}
+			log.Printf("Warning: --salt-sequence salts can be worked out by anyone who knows the seed; the output is for test fixtures only, never for production accounts.")
+		}
+	}
+
+	var plainCache *plaintextCache
+	if cacheSize < 0 {
+		log.Fatalf("Error: --cache-size must not be negative.")
+	}
+	if reuseSaltPerPlaintext {
+		if saltDraws != nil || saltSeq != nil {
+			log.Fatalf("Error: --reuse-salt-per-plaintext gives repeated plaintexts the same salt; it can't be combined with --unique-salts or --salt-sequence.")
+		}
+		if cacheSize == 0 {
+			log.Fatalf("Error: --reuse-salt-per-plaintext needs a --cache-size.")
+		}
+		log.Printf("Warning: --reuse-salt-per-plaintext gives every repeat of a plaintext the same salt and hash; don't use the output where salts must be unique.")
+	}
+	if command == "generate" && !validate && !frequencyFlag && cacheSize > 0 {
+		plainCache = newPlaintextCache(cacheSize, reuseSaltPerPlaintext)
+	}
+
+	var checks *validation
+	if validate {
+		if command != "convert" && command != "generate" {
+			log.Fatalf("Error: --validate only applies to convert and generate.")
+		}
+		if flags.Changed("bench") || flags.Changed("password") || prompt || flags.Changed("hash") {
+			log.Fatalf("Error: --validate checks input; it can't be combined with --bench, --password, --prompt or --hash.")
+		}
+		if outputPath != "" || checkpointPath != "" || mapFilePath != "" || split > 0 {
+			log.Fatalf("Error: --validate writes no output; don't give --output, --checkpoint, --map-file or --split.")
+		}
+		checks = newValidation()
+	}
+	var freq *frequency
+	if frequencyFlag {
+		if flags.Changed("bench") || flags.Changed("password") || prompt || flags.Changed("hash") {
+			log.Fatalf("Error: --frequency counts input; it can't be combined with --bench, --password, --prompt or --hash.")
+		}
+		if validate || checkpointPath != "" || mapFilePath != "" || split > 0 || unique || uniqueApprox > 0 {
+			log.Fatalf("Error: --frequency can't be combined with --validate, --checkpoint, --map-file, --split, --unique or --unique-approx.")
+		}
+		if frequencyTop < 1 {
+			log.Fatalf("Error: --top must be at least 1.")
+		}
+		freq = newFrequency(frequencyExact)
+	} else if flags.Changed("top") || frequencyExact {
+		log.Fatalf("Error: --top and --frequency-exact need --frequency.")
+	}
+	var salts *saltAnalysis
+	if analyzeSalts || analyzeSaltsExact {
+		if singleValue {
+			log.Fatalf("Error: --analyze-salts looks at the input; it can't be combined with --hash.")
+		}
+		if frequencyFlag || fixOnly {
+			log.Fatalf("Error: --frequency and --fix-only don't convert the hashes; --analyze-salts can't be combined with them.")
+		}
+		if formsAuthAlgorithm(hashMode) != "" {
+			log.Fatalf("Error: FormsAuthentication hashes are unsalted; --analyze-salts doesn't apply.")
+		}
+		salts = newSaltAnalysis(analyzeSaltsExact)
+	}
+	var audited *audit
+	if auditFlag || flags.Changed("audit-policy") {
+		if !auditFlag {
+			log.Fatalf("Error: --audit-policy needs --audit.")
+		}
+		if singleValue {
+			log.Fatalf("Error: --audit looks at the input; it can't be combined with --hash.")
+		}
+		if frequencyFlag || fixOnly || validate {
+			log.Fatalf("Error: --frequency, --fix-only and --validate don't convert the hashes; --audit can't be combined with them.")
+		}
+		policy, err := parseAuditPolicy(auditPolicyArg)
+		if err != nil {
+			log.Fatalf("Error: invalid --audit-policy: %v", err)
+		}
+		audited = newAudit(policy)
+	}
+	var dupUsernames *duplicateUsernames
+	if flags.Changed("duplicate-usernames") {
+		if !slices.Contains(duplicateUsernamePolicies, duplicateUsernamesArg) {
+			log.Fatalf("Error: invalid --duplicate-usernames %q (valid: %v).", duplicateUsernamesArg, duplicateUsernamePolicies)
+		}
+		if !usernamePresent {
+			log.Fatalf("Error: --duplicate-usernames needs --username.")
+		}
+		if singleValue {
+			log.Fatalf("Error: --duplicate-usernames looks at the input; it can't be combined with --hash.")
+		}
+		if frequencyFlag || fixOnly || validate {
+			log.Fatalf("Error: --frequency, --fix-only and --validate don't convert the hashes; --duplicate-usernames can't be combined with them.")
+		}
+		if duplicateUsernamesArg == "last" {
+			if checkpointPath != "" {
+				log.Fatalf("Error: --duplicate-usernames last holds the output back until the input is done; it can't be combined with --checkpoint.")
+			}
+			if outputDedup != nil {
+				log.Fatalf("Error: --duplicate-usernames last can't be combined with --strip-usernames and --unique, which drop repeated hashes as they are written.")
+			}
+		}
+		dupUsernames = newDuplicateUsernames(duplicateUsernamesArg, delimiter, trim, normalize)
+	}
+	var identified *identifySummary
+	if identifySummaryFlag {
+		if checkpointPath != "" {
+			log.Fatalf("Error: --summary counts the whole input in memory; it can't be combined with --checkpoint.")
+		}
+		identified = newIdentifySummary()
+	}
+
+	if flags.Changed("bench") {
+		if benchDuration <= 0 {
+			log.Fatalf("Error: --bench duration must be positive.")
+		}
+		if len(benchIters) == 0 {
+			benchIters = []int{PBKDF2IterCount}
+		}
+		workers := maxWorkers
+		if workers <= 0 || autoWorkers {
+			workers = cpus
+		}
+		log.Printf("Benchmarking %s hashes on %d workers for %v per iteration count...\n", hashMode, workers, benchDuration)
+		var results []benchResult
+		for _, iter := range benchIters {
+			if iter < 1 {
+				log.Fatalf("Error: --bench-iters must be positive.")
+			}
+			result, err := runBench(hashMode, iter, PBKDF2SubkeyLength, SaltSize, workers, benchDuration)
+			if err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			results = append(results, result)
+		}
+		if err := writeBenchTable(os.Stdout, results); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if usernameValue != "" && !flags.Changed("password") && !prompt {
+		log.Fatalf("Error: --username-value only applies to --password and --prompt; input lines take --username.")
+	}
+	if schema != nil && !flags.Changed("password") && !prompt {
+		if !usernamePresent {
+			log.Fatalf("Error: --output-format sql needs --username input lines of <username><delimiter><password>.")
+		}
+		if nullDelimited || split > 0 || checkpointPath != "" || outputAppend || sortKey != "" || validate || frequencyFlag {
+			log.Fatalf("Error: --output-format sql writes one script; it can't be combined with --null, --split, --checkpoint, --output-append, --sort, --validate or --frequency.")
+		}
+	}
+
+	// -p, --prompt and -H process one value instead of reading input.
+	if flags.Changed("password") || prompt || flags.Changed("hash") {
+		if flags.Changed("password") && flags.Changed("hash") {
+			log.Fatalf("Error: --password and --hash are mutually exclusive.")
+		}
+		if prompt && (flags.Changed("password") || flags.Changed("hash")) {
+			log.Fatalf("Error: --prompt can't be combined with --password or --hash.")
+		}
+		if flags.Changed("hash") && command != "convert" {
+			log.Fatalf("Error: --hash only applies to convert mode.")
+		}
+		if inputPath != "" || flags.NArg() > 0 || filesFrom != "" {
+			log.Fatalf("Error: --password and --hash don't read input and can't be combined with --input or input files.")
+		}
+		if schema != nil && usernameValue == "" {
+			log.Fatalf("Error: --output-format sql needs the --username-value whose password to reset.")
+		}
+		if prompt {
+			if password, err = promptPassword(); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		}
+		if fix {
+			hashArg, _ = fixLine(hashArg, usernamePresent, delimiter, trim)
+		}
+		var result string
+		if command == "generate" {
+			plain := password
+			if trim {
+				plain = strings.TrimSpace(plain)
+			}
+			plain = esc.decode(plain)
+			if err := checkPolicy(1, plain); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			if policy != nil {
+				if broken := brokenRules(policy.check(plain)); len(broken) > 0 {
+					log.Printf("Warning: the password breaks the --policy rules %s", strings.Join(broken, ", "))
+				}
+			}
+			salt := fixedSalt
+			if saltSeq != nil {
+				salt = saltSeq.salt(1)
+			}
+			result, err = generateHash(plain, hashMode, int(PBKDF2IterCount), int(PBKDF2SubkeyLength), int(SaltSize), salt, keyed)
+			switch {
+			case err != nil:
+			case schema != nil:
+				if result, err = schema.statement(normalize.apply(usernameValue), result); err == nil {
+					result = sqlHeader + result + "\n" + sqlFooter(1)
+				}
+			case usernameValue != "":
+				result = esc.escape(normalize.apply(usernameValue)) + outputDelimiter + result
+			}
+		} else if fixOnly {
+			result = hashArg
+		} else if hashMode == "auto" {
+			result, err = convertAuto(hashArg, usernamePresent, delimiter, outputDelimiter, trim, PBKDF2IterCount, normalize, dialect)
+		} else if hashMode == "dnn" {
+			result, err = convertDNN(hashArg, usernamePresent, delimiter, outputDelimiter, trim, normalize, dialect)
+		} else if algorithm := formsAuthAlgorithm(hashMode); algorithm != "" {
+			result, err = convertFormsAuth(hashArg, usernamePresent, delimiter, outputDelimiter, trim, algorithm, normalize, dialect)
+		} else {
+			result, err = convertHash(hashArg, usernamePresent, delimiter, outputDelimiter, trim, PBKDF2IterCount, normalize, parseHash, dialect)
+		}
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Print(result + newline)
+		os.Exit(0)
+	}
+
+	if writeBuffer < 1 {
+		log.Fatalf("Error: --write-buffer must be at least 1.")
+	}
+
+	if outputAddr != "" {
+		if outputPath != "" {
+			log.Fatalf("Error: --output-addr can't be combined with --output.")
+		}
+		if flags.Changed("output-compression") {
+			log.Fatalf("Error: --output-addr can't be combined with --output-compression; a compressed stream can't carry on over a new connection.")
+		}
+		if _, _, err := parseOutputAddr(outputAddr); err != nil {
+			log.Fatalf("Error: %v.", err)
+		}
+	}
+	if outputRetries < 0 {
+		log.Fatalf("Error: --output-retries must not be negative.")
+	}
+	if outputBacklog < 1 {
+		log.Fatalf("Error: --output-backlog must be at least 1.")
+	}
+	if outputCompression == "" {
+		outputCompression = outputCompressionFor(outputPath)
+	}
+	outputCompression = strings.ToLower(outputCompression)
+	if outputCompression != "none" && outputPath == "" && !force {
+		log.Fatalf("Error: --output-compression needs --output, or --force to write compressed data to stdout.")
+	}
+
+	splitBy = strings.ToLower(splitBy)
+	if split < 0 {
+		log.Fatalf("Error: --split must not be negative.")
+	}
+	if !slices.Contains(shardModes, splitBy) {
+		log.Fatalf("Error: invalid --split-by %q (valid: %v).", splitBy, shardModes)
+	}
+	if split > 0 {
+		if outputPath == "" {
+			log.Fatalf("Error: --split needs --output to name the files after.")
+		}
+		if checkpointPath != "" {
+			log.Fatalf("Error: --split can't be combined with --checkpoint.")
+		}
+		if outputAppend {
+			log.Fatalf("Error: --split can't be combined with --output-append.")
+		}
+	}
+	if (outputAppend || lockOutput) && outputPath == "" {
+		log.Fatalf("Error: --output-append and --lock-output need --output.")
+	}
+	if teeHead < 0 {
+		log.Fatalf("Error: --head must not be negative.")
+	}
+	if (tee || teeHead > 0) && outputPath == "" && outputAddr == "" {
+		log.Fatalf("Error: --tee and --head need --output or --output-addr; the output already goes to stdout.")
+	}
+	checksumOf = strings.ToLower(checksumOf)
+	if !slices.Contains(checksumTargets, checksumOf) {
+		log.Fatalf("Error: invalid --checksum-of %q (valid: %v).", checksumOf, checksumTargets)
+	}
+	if checksumSidecar && outputPath == "" {
+		log.Fatalf("Error: --checksum-sidecar needs --output.")
+	}
+	var checksum string
+	if outputChecksum || checksumSidecar || flags.Changed("checksum-of") {
+		checksum = checksumOf
+	}
+
+	// newOutputSet makes the --unique-output set of an output.
+	var newOutputSet func() seenSet
+	uniqueOutputScope = strings.ToLower(uniqueOutputScope)
+	switch {
+	case uniqueOutputApprox < 0:
+		log.Fatalf("Error: --unique-output-approx must not be negative.")
+	case !slices.Contains(uniqueOutputScopes, uniqueOutputScope):
+		log.Fatalf("Error: invalid --unique-output-scope %q (valid: %v).", uniqueOutputScope, uniqueOutputScopes)
+	case uniqueOutputApprox > 0:
+		newOutputSet = func() seenSet { return newBloomSet(uniqueOutputApprox) }
+	case uniqueOutput:
+		newOutputSet = func() seenSet { return exactSet{} }
+	}
+	if newOutputSet != nil && alsoHashcat != "" {
+		log.Fatalf("Error: --also-hashcat pairs its lines with the output's; it can't be combined with --unique-output.")
+	}
+
+	var sortMem int64
+	if sortKey != "" {
+		sortKey = strings.ToLower(sortKey)
+		if !slices.Contains(sortKeys, sortKey) {
+			log.Fatalf("Error: invalid --sort %q (valid: %v).", sortKey, sortKeys)
+		}
+		if sortKey == "username" && (!usernamePresent || stripUsernames) {
+			log.Fatalf("Error: --sort username needs --username, without --strip-usernames.")
+		}
+		if checkpointPath != "" || frequencyFlag {
+			log.Fatalf("Error: --sort can't be combined with --checkpoint or --frequency.")
+		}
+		if outputPath == "" && !force {
+			log.Fatalf("Error: --sort holds all output back until the end; give --output, or --force to write it to stdout anyway.")
+		}
+		if sortMem, err = parseByteSize(sortMemArg); err != nil || sortMem < 1 {
+			log.Fatalf("Error: invalid --sort-mem %q: give a positive size such as 512M.", sortMemArg)
+		}
+	} else if flags.Changed("sort-mem") || flags.Changed("tmp-dir") {
+		log.Fatalf("Error: --sort-mem and --tmp-dir need --sort.")
+	}
+
+	if timeout < 0 {
+		log.Fatalf("Error: --timeout must not be negative.")
+	}
+
+	if resume && checkpointPath == "" {
+		log.Fatalf("Error: --resume needs --checkpoint.")
+	}
+	if checkpointPath != "" {
+		if outputPath == "" || outputCompression != "none" {
+			log.Fatalf("Error: --checkpoint needs an uncompressed --output file.")
+		}
+		if postFilter != "" {
+			log.Fatalf("Error: --checkpoint can't be combined with --post-filter, which holds back lines the checkpoint would count as written.")
+		}
+		if checkpointInterval <= 0 {
+			log.Fatalf("Error: --checkpoint-interval must be positive.")
+		}
+		ordered = true
+	}
+
+	switch {
+	case sampleFraction != 0 && sampleN != 0:
+		log.Fatalf("Error: --sample and --sample-n are mutually exclusive.")
+	case sampleFraction < 0 || sampleFraction > 1:
+		log.Fatalf("Error: --sample must be a share between 0 and 1.")
+	case sampleN < 0:
+		log.Fatalf("Error: --sample-n must not be negative.")
+	case (sampleFraction != 0 || sampleN != 0) && checkpointPath != "":
+		log.Fatalf("Error: --sample and --sample-n can't be resumed from a --checkpoint.")
+	case flags.Changed("sample-seed") && sampleFraction == 0 && sampleN == 0:
+		log.Fatalf("Error: --sample-seed needs --sample or --sample-n.")
+	}
+
+	var inShard *inputShard
+	if shardArg != "" {
+		shardStrategy = strings.ToLower(shardStrategy)
+		if !slices.Contains(inputShardStrategies, shardStrategy) {
+			log.Fatalf("Error: invalid --shard-strategy %q (valid: %v).", shardStrategy, inputShardStrategies)
+		}
+		if inShard, err = parseInputShard(shardArg, shardStrategy); err != nil {
+			log.Fatalf("Error: invalid --shard: %v", err)
+		}
+		switch {
+		case sampleFraction != 0 || sampleN != 0:
+			log.Fatalf("Error: --shard and --sample each take a part of the input; use one of them.")
+		case shardStrategy == "bytes" && usernamesFile != "":
+			log.Fatalf("Error: --shard-strategy bytes doesn't read the lines before the shard, so it can't pair them with --usernames-file; use --shard-strategy modulo.")
+		}
+	} else if flags.Changed("shard-strategy") {
+		log.Fatalf("Error: --shard-strategy needs --shard.")
+	}
+
+	if errorFileAlways && errorFilePath == "" {
+		log.Fatalf("Error: --error-file-always can only be used together with --error-file.")
+	}
+
+	if saveProfileName != "" {
+		path, err := saveProfile(profilesDir, saveProfileName, profileDescription)
+		if err != nil {
+			log.Fatalf("Error saving profile: %v", err)
+		}
+		log.Printf("Saved profile %q to %s", saveProfileName, path)
+		os.Exit(0)
+	}
+
+	// Rate limiting
+	if rateLimit < 0 || rateBurst < 0 {
+		log.Fatalf("Error: --rate-limit and --rate-burst must not be negative.")
+	}
+	limiter := newRateLimiter(rateLimit, rateBurst)
+	var byteLimit *byteLimiter
+	if rateLimitBytes != "" {
+		n, err := parseByteSize(rateLimitBytes)
+		if err != nil || n == 0 {
+			log.Fatalf("Error: invalid --rate-limit-bytes %q: want a size of at least 1 byte, such as 10M.", rateLimitBytes)
+		}
+		byteLimit = newByteLimiter(n)
+	}
+
+	// Batching pays off for cheap lines; hashing is slow enough on its own,
+	// and a rate limited run shouldn't hold lines back to fill a batch.
+	if batchSize < 0 {
+		log.Fatalf("Error: --batch-size must not be negative.")
+	}
+	if batchSize == 0 {
+		batchSize = 256
+		if (command == "generate" && !validate && !frequencyFlag) || command == "verify" || command == "crack" || rateLimit > 0 {
+			batchSize = 1
+		}
+	}
+
+	if errorFilePath != "" {
+		var err error
+		errFile, err = newErrorFile(errorFilePath, errorFileAlways)
+		if err != nil {
+			log.Fatalf("Error creating error file: %v", err)
+		}
+	}
+
+	sources := inputSources(inputPath, flags.Args(), filesFrom, listed)
+	if filesFrom == "-" && slices.ContainsFunc(sources, func(src inputSource) bool { return src.path == "" }) {
+		log.Fatalf("Error: stdin holds the --files-from list, so it can't be an input too.")
+	}
+	if len(sources) == 0 {
+		log.Fatalf("Error: --files-from %s lists no input files.", filesFrom)
+	}
+	if randomCount > 0 {
+		end := byte('\n')
+		if nullDelimited {
+			end = 0
+		}
+		sources = []inputSource{{random: newRandomPlaintexts(randomPositions, randomCount, end)}}
+	}
+	multi := len(sources) > 1
+	inputCompression, inputCharset = strings.ToLower(inputCompression), strings.ToLower(inputCharset)
+	if !slices.Contains(inputCompressions, inputCompression) {
+		log.Fatalf("Error: invalid --input-compression %q (valid: %v)", inputCompression, inputCompressions)
+	}
+	if !slices.Contains(inputCharsets, inputCharset) {
+		log.Fatalf("Error: invalid --input-charset %q (valid: %v)", inputCharset, inputCharsets)
+	}
+	// The --with-source offsets must point into the file as it is on disk,
+	// but for its compression.
+	if withSource && inputCharset != "utf8" {
+		log.Fatalf("Error: --with-source offsets count the bytes of UTF-8 input; it can't be combined with --input-charset %s.", inputCharset)
+	}
+	recordEnd := byte('\n')
+	if nullDelimited {
+		recordEnd = 0
+	}
+	if inShard != nil && inShard.strategy == "bytes" {
+		if multi {
+			log.Fatalf("Error: --shard-strategy bytes splits a single input file; give one, or use --shard-strategy modulo.")
+		}
+		if err := inShard.cut(sources[0], inputCompression, inputCharset, recordEnd); err != nil {
+			log.Fatalf("Error: --shard-strategy bytes: %v; use --shard-strategy modulo.", err)
+		}
+		sources[0].section = inShard.section
+	}
+	if inShard != nil {
+		log.Printf("Shard %s: %s", inShard.label(), inShard.describe())
+	}
+	if len(sources) == 1 && sources[0].path == "" && sources[0].random == nil && stdinIsTerminal() {
+		// Most likely the tool was started without arguments to see what
+		// it does; don't leave the user looking at what seems to be a hang.
+		if requireInput {
+			fmt.Fprintln(os.Stderr, "Error: no input given and stdin is a terminal. Use --input or pipe lines in.")
+			flags.Usage()
+			os.Exit(2)
+		}
+		log.Print("Reading from the terminal; paste lines and press Ctrl-D when done, or see --help.")
+	}
+	// A single input is opened up front, so a missing file fails the run
+	// before the output is touched. Several are opened one after the other
+	// while reading, and one that can't be read is reported and skipped.
+	var firstInput io.Reader
+	var firstCloser io.Closer
+	// inputBytesRead counts the bytes read from the input files, before
+	// decompression, for --progress.
+	var inputBytesRead int64
+	inputName := fmt.Sprintf("%d files", len(sources))
+	if !multi {
+		if firstInput, inputName, firstCloser, err = openInput(sources[0], inputCompression, inputCharset, &inputBytesRead, byteLimit); err != nil {
+			log.Fatalf("Error opening input: %v", err)
+		}
+	}
+	if preFilter != "" && !multi {
+		if firstInput, firstCloser, err = filterInput(preFilter, firstInput, firstCloser, recordEnd); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	var pairs *pairedUsernames
+	if usernamesFile != "" {
+		if pairs, err = openPairedUsernames(usernamesFile, inputCharset, recordEnd, maxLineBytes); err != nil {
+			log.Fatalf("Error opening --usernames-file: %v", err)
+		}
+	}
+
+	// From here on the run may leave temporary files, which errors remove
+	// with fatalf.
+	runTemp = aspnethash.NewRunDir(tmpDir)
+	var sorter *outputSorter
+	if sortKey != "" {
+		recordDelimiter := ""
+		if usernamePresent && !stripUsernames {
+			recordDelimiter = outputDelimiter
+		}
+		sorter = newOutputSorter(sortKey, recordDelimiter, recordEnd, sortMem, runTemp)
+	}
+
+	settings := currentSettings()
+	settings["command"] = command
+	if flags.NArg() > 0 || filesFrom != "" {
+		// The input files given as arguments resume only with the same list.
+		names := make([]string, len(sources))
+		for i, src := range sources {
+			names[i] = src.name()
+		}
+		settings["files"] = strings.Join(names, ", ")
+	}
+	if resume {
+		cp, err := readCheckpoint(checkpointPath)
+		if err == nil {
+			if err := cp.mismatch(settings); err != nil {
+				fatalf("Error: cannot resume: %v", err)
+			}
+			resumeAt, resumeLines = cp.OutputBytes, cp.Lines
+			log.Printf("Resuming after line %d", resumeLines)
+		} else if os.IsNotExist(err) {
+			log.Printf("No checkpoint at %s, starting from the beginning", checkpointPath)
+		} else {
+			fatalf("Error reading checkpoint: %v", err)
+		}
+	}
+
+	var timings *pipelineTimings
+	if timingsFlag {
+		timings = &pipelineTimings{}
+	}
+	opts := outputOptions{
+		resumeAt:    resumeAt,
+		compression: outputCompression,
+		bufferSize:  writeBuffer,
+		// Appending goes to the file itself; a failed run leaves what was
+		// there before intact anyway.
+		viaTemp:    !noAtomic && !outputAppend && outputPath != "" && writeAtomically(outputPath),
+		appendTo:   outputAppend,
+		terminator: recordEnd,
+		lock:       lockOutput,
+		checksum:   checksum,
+		crlf:       newline == "\r\n",
+		postFilter: postFilter,
+	}
+	if timings != nil {
+		opts.ioTime = &timings.outputIO
+	}
+	// The .tmp of an unfinished output is kept for --checkpoint to resume.
+	if checkpointPath == "" {
+		opts.temp = runTemp
+	}
+	// A closed pipe is reported as a write error instead of killing the
+	// process, so the run can stop reading, report its stats and exit 141
+	// as the signal would have.
+	ignoreBrokenPipe()
+	outputFailure := newWriteFailure()
+	opts.failure = outputFailure
+	var sink *netSink
+	if outputAddr != "" {
+		if sink, err = newNetSink(outputAddr, outputRetries, outputBacklog, recordEnd); err != nil {
+			fatalf("Error connecting to --output-addr: %v", err)
+		}
+		opts.sink = sink
+	}
+	if tee || teeHead > 0 {
+		opts.tee = newTeeWriter(os.Stdout, recordEnd, teeHead)
+	}
+	if split > 0 {
+		opts.viaTemp = !noAtomic
+		shards, err = newShardedWriter(outputPath, split, splitBy == "hash", opts, newOutputSet, uniqueOutputScope == "global")
+		if shards != nil {
+			shards.stripUsername, shards.outputDelimiter = usernamePresent, outputDelimiter
+			out = shards
+		}
+	} else {
+		if newOutputSet != nil {
+			opts.unique = newOutputSet()
+		}
+		single, err = newOutputWriter(outputPath, opts)
+		if single != nil {
+			out = single
+		}
+	}
+	if err != nil {
+		fatalf("Error opening output: %v", err)
+	}
+	if schema != nil {
+		out.write(sqlHeader)
+	}
+	var sideOut *outputWriter
+	if sidePath != "" {
+		if sideOut, err = newOutputWriter(sidePath, outputOptions{resumeAt: -1, compression: "none", bufferSize: writeBuffer, viaTemp: !noAtomic && writeAtomically(sidePath), terminator: recordEnd, crlf: newline == "\r\n", failure: outputFailure, temp: runTemp}); err != nil {
+			fatalf("Error opening %s: %v", sideFlag, err)
+		}
+	}
+
+	if progressInterval < 0 {
+		fatalf("Error: --progress must not be negative.")
+	}
+	if logLevel >= levelVerbose && !flags.Changed("progress") {
+		progressInterval = verboseProgressInterval
+	}
+	var totalLines int64
+	if countFirst {
+		total, skipReason, err := countInputLines(sources, inputCompression, inputCharset, recordEnd)
+		switch {
+		case err != nil:
+			log.Printf("Not counting the input lines first: %v", err)
+		case skipReason != "":
+			log.Printf("Not counting the input lines first: %s", skipReason)
+		default:
+			totalLines = total
+			if limit > 0 {
+				totalLines = min(totalLines, skip+limit)
+			}
+			log.Printf("Counted %s input lines", groupThousands(totalLines))
+		}
+	}
+	var sample *sampler
+	if sampleFraction != 0 || sampleN != 0 {
+		if !flags.Changed("sample-seed") {
+			sampleSeed = newSampleSeed()
+		}
+		if sampleFraction != 0 {
+			sample = newFractionSampler(sampleFraction, sampleSeed)
+			log.Printf("Sampling %g%% of the input lines (seed %d)", 100*sampleFraction, sampleSeed)
+		} else {
+			population := totalLines
+			if population == 0 {
+				total, skipReason, err := countInputLines(sources, inputCompression, inputCharset, recordEnd)
+				switch {
+				case err != nil:
+					fatalf("Error counting the input lines for --sample-n: %v", err)
+				case skipReason != "":
+					fatalf("Error: --sample-n needs to count the input lines first, but can't: %s. Use --sample with a share instead.", skipReason)
+				}
+				population = total
+			}
+			population = max(0, population-skip)
+			sample = newCountSampler(sampleN, population, sampleSeed)
+			log.Printf("Sampling %s of %s input lines, one in every %s (seed %d)", groupThousands(min(sampleN, population)), groupThousands(population), groupThousands(sample.every), sampleSeed)
+		}
+	}
+	readerMode = strings.ToLower(readerMode)
+	if !slices.Contains(readerModes, readerMode) {
+		fatalf("Error: invalid --reader %q (valid: %v)", readerMode, readerModes)
+	}
+	// The line by line reader does the per-line bookkeeping of these, and
+	// one-line batches mean work expensive enough not to need chunks.
+	var blocker string
+	switch {
+	case skip > 0:
+		blocker = "--skip"
+	case limit > 0:
+		blocker = "--limit"
+	case checkpointPath != "":
+		blocker = "--checkpoint"
+	case dedup != nil:
+		blocker = "--unique"
+	case rateLimit > 0:
+		blocker = "--rate-limit"
+	case byteLimit != nil:
+		blocker = "--rate-limit-bytes"
+	case batchSize == 1:
+		blocker = "handing out lines one at a time"
+	case fix:
+		blocker = "--fix"
+	case usernamesFile != "":
+		blocker = "--usernames-file"
+	case preFilter != "":
+		blocker = "--pre-filter"
+	case inShard != nil:
+		blocker = "--shard"
+	case withSource:
+		blocker = "--with-source"
+	}
+	var chunks chunkSource
+	inputReader, readerNote := chooseReader(readerMode, sources, inputCompression, inputCharset, blocker)
+	if readerNote != "" && readerMode != "auto" {
+		how := "line by line"
+		if inputReader == "chunked" {
+			how = "in chunks"
+		}
+		log.Printf("--reader %s: %s; reading the input %s", readerMode, readerNote, how)
+	}
+	if inputReader != "lines" {
+		if chunks, err = openChunks(sources[0].path, inputReader, recordEnd); err != nil {
+			fatalf("Error opening input: %v", err)
+		}
+		firstCloser.Close()
+	}
+	log.Printf("%s %s: Processing %s from %s...\n\n", filepath.Base(os.Args[0]), versionString(), work_type, inputName)
+	if flags.Changed("threads") {
+		log.Printf("Running on %d of %d CPUs (--threads)", cpus, runtime.NumCPU())
+	}
+	if crk != nil {
+		log.Printf("Cracking %d hashes with %d distinct salts\n", crk.targets, len(crk.groups))
+	}
+	if logLevel >= levelDebug {
+		workerLimit := "unlimited"
+		switch {
+		case autoWorkers:
+			workerLimit = fmt.Sprintf("auto, %d to %d", min(cpus, maxWorkers), maxWorkers)
+		case maxWorkers > 0:
+			workerLimit = strconv.Itoa(maxWorkers)
+		}
+		log.Printf("Workers: %s on %d threads (%d CPUs), batches of %d lines", workerLimit, cpus, runtime.NumCPU(), batchSize)
+		log.Printf("Input: %d source(s), --reader %s, charset %s, compression %s", len(sources), inputReader, inputCharset, inputCompression)
+		log.Printf("Buffers: %d bytes of output, lines up to %d bytes", writeBuffer, maxLineBytes)
+	}
+
+	// ctx is cancelled to stop the producer early, by --strict, by SIGINT/
+	// SIGTERM or by --timeout (see shutdownContext). Workers already
+	// dispatched are allowed to finish so the output can be closed properly.
+	runCtx, stopRun := shutdownContext(timeout)
+	defer stopRun()
+	ctx, cancel := context.WithCancel(runCtx)
+	if byteLimit != nil {
+		context.AfterFunc(ctx, byteLimit.stop)
+	}
+	defer cancel()
+	// An output that fails to be written stops the producer as well.
+	go func() {
+		select {
+		case <-outputFailure.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// fileStats counts the outcomes per input file when there are several.
+	files := make([]fileStats, len(sources))
+	for i, src := range sources {
+		files[i].Name = src.name()
+	}
+
+	// reportError accounts for a failed line and reports it according to
+	// --error-file, --verbose and --strict. With several inputs, the line is
+	// reported as file:line.
+	// position returns where a line is, for the --error-file and for the
+	// log.
+	position := func(l batchLine) (at, where string) {
+		if multi {
+			return fmt.Sprintf("%s:%d", files[l.file].Name, l.fileLine), fmt.Sprintf("%s line %d", files[l.file].Name, l.fileLine)
+		}
+		return strconv.FormatInt(l.lineNo, 10), fmt.Sprintf("line %d", l.lineNo)
+	}
+	reportError := func(l batchLine, err error) {
+		atomic.AddInt64(&erroredLines, 1)
+		atomic.AddInt64(&files[l.file].Errored, 1)
+		errorKinds.add(l.lineNo, err)
+		at, where := position(l)
+		if strict {
+			strictOnce.Do(func() {
+				strictAt, strictErr = where, err
+				strictStopped.Store(true)
+				cancel()
+			})
+		}
+		if errFile != nil {
+			errFile.record(at, l.text, err)
+			return
+		}
+		if logLevel < levelVerbose {
+			return
+		}
+		fields := []logField{{"line_no", l.lineNo}, {"error_kind", errorKindName(err)}}
+		if multi {
+			fields = append(fields, logField{"file", files[l.file].Name}, logField{"file_line", l.fileLine})
+		}
+		if logLevel >= levelDebug {
+			logWith(levelDebug, fields, "Error at %s: %v: %q", where, err, truncateLine(l.text, maxLoggedLineLength))
+		} else {
+			logWith(levelVerbose, fields, "Error at %s: %v", where, err)
+		}
+	}
+
+	// write takes the records of the finished lines, for --sort to hold
+	// back if it is given.
+	write := out.write
+	if sorter != nil {
+		write = sorter.write
+	}
+	if timings != nil {
+		write = timings.timedWrite(write)
+	}
+	// pipe runs the batches on the workers. Ordered, it writes their
+	// records from the first line neither skipped nor done by the run
+	// being resumed.
+	slots := sem
+	if chunks != nil && slots == nil {
+		// Bound the chunks held in memory at once.
+		slots = make(chan struct{}, cpus)
+	}
+	pipe := aspnethash.NewPipeline(slots, ordered, max(skip, resumeLines)+1)
+
+	// writeCheckpoint records the lines released so far together with the
+	// output size they correspond to, after making that output durable.
+	writeCheckpoint := func() error {
+		return pipe.Released(func(lastLine int64) error {
+			offset, err := out.sync()
+			if err != nil {
+				return err
+			}
+			cp := checkpoint{Lines: lastLine, OutputBytes: offset, Settings: settings}
+			return cp.write(checkpointPath)
+		})
+	}
+	checkpointDone := make(chan struct{})
+	if checkpointPath != "" {
+		go func() {
+			ticker := time.NewTicker(checkpointInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := writeCheckpoint(); err != nil {
+						log.Printf("Error writing checkpoint: %v", err)
+					}
+				case <-checkpointDone:
+					return
+				}
+			}
+		}()
+	}
+
+	// lineNo is the position in the original input, including skipped lines,
+	// so error reports always point at the right line.
+	var lineNo int64
+	var taken int64
+	// pairMismatch tells why the input and --usernames-file don't pair up.
+	var pairMismatch string
+
+	if pprofHTTP != "" {
+		servePprof(pprofHTTP)
+	}
+	var status *statusServer
+	if statusAddr != "" {
+		changed := map[string]string{}
+		flags.Visit(func(f *pflag.Flag) { changed[f.Name] = redactedFlagValue(f) })
+		counters := statusCounters{processed: &processedLines, errored: &erroredLines, skipped: &skippedLines, duplicates: &duplicateLines}
+		statusMode := ""
+		if command == "generate" {
+			statusMode = hashMode
+		}
+		if status, err = startStatusServer(statusAddr, counters, command, statusMode, changed); err != nil {
+			fatalf("Error starting --status-addr: %v", err)
+		}
+	}
+	progressCtx, stopProgress := context.WithCancel(runCtx)
+	defer stopProgress()
+	prog := &progress{
+		started: time.Now(),
+		done: func() int64 {
+			return atomic.LoadInt64(&processedLines) + atomic.LoadInt64(&erroredLines) + atomic.LoadInt64(&skippedLines) + atomic.LoadInt64(&duplicateLines)
+		},
+		totalLines: totalLines,
+		bytesRead:  &inputBytesRead,
+	}
+	if byteLimit != nil {
+		prog.throttled = &byteLimit.consumed
+	}
+	if totalLines == 0 {
+		prog.totalBytes, _ = inputBytes(sources)
+	}
+	if progressInterval > 0 {
+		go prog.run(progressCtx, progressInterval)
+	}
+	reportOnSignal(progressCtx, prog, statusCounters{processed: &processedLines, errored: &erroredLines, skipped: &skippedLines, duplicates: &duplicateLines})
+	if autoWorkers {
+		finished := func() int64 { return atomic.LoadInt64(&processedLines) + atomic.LoadInt64(&erroredLines) }
+		scaler = newWorkerScaler(sem, cpus, finished, logLevel >= levelDebug)
+		go scaler.run(ctx)
+	}
+	stopCPUProfile := func() error { return nil }
+	if cpuProfile != "" {
+		if stopCPUProfile, err = startCPUProfile(cpuProfile); err != nil {
+			fatalf("Error starting CPU profile: %v", err)
+		}
+	}
+	// process turns one input line into its output record. Records for the
+	// side output go to sideRecords, so they are written like the output
+	// records.
+	process := func(l batchLine, sideRecords *strings.Builder) (string, error) {
+		lineNo, line, pairedUsername := l.lineNo, l.text, l.username
+		switch command {
+		case "generate":
+			username, plain := "", line
+			if pairs != nil {
+				if strings.TrimSpace(pairedUsername) == "" {
+					return "", errBlankUsername
+				}
+				username = pairedUsername
+			} else if randomCount > 0 {
+				username = syntheticUsername(lineNo)
+			} else if usernamePresent {
+				var err error
+				if username, plain, err = splitUsername(line, true, delimiter, false); err != nil {
+					return "", err
+				}
+			}
+			if trim {
+				plain = strings.TrimSpace(plain)
+			}
+			if randomCount == 0 {
+				plain = esc.decode(plain)
+			}
+			if err := checkPolicy(lineNo, plain); err != nil {
+				return "", err
+			}
+			if checks != nil {
+				checks.observe("plaintext", len(plain), -1)
+				return "", nil
+			}
+			if freq != nil {
+				freq.observe(plain)
+				return "", nil
+			}
+			hash := func() (string, error) {
+				salt := fixedSalt
+				switch {
+				case saltSeq != nil:
+					salt = saltSeq.salt(lineNo)
+				case saltDraws != nil:
+					var err error
+					if salt, err = saltDraws.next(); err != nil {
+						return "", err
+					}
+				}
+				return generateHash(plain, hashMode, int(PBKDF2IterCount), int(PBKDF2SubkeyLength), int(SaltSize), salt, keyed)
+			}
+			var result string
+			var err error
+			if plainCache != nil {
+				result, err = plainCache.hash(plain, hash)
+			} else {
+				result, err = hash()
+			}
+			if err != nil {
+				return "", err
+			}
+			var prefix string
+			if usernamePresent {
+				prefix = normalize.apply(username) + outputDelimiter
+			}
+			if sideOut != nil {
+				sideLine, err := generatedHashcat(result, hashMode, int(PBKDF2IterCount), int(PBKDF2SubkeyLength), dialect)
+				if err != nil {
+					return "", err
+				}
+				sideRecords.WriteString(prefix + sideLine + string(recordEnd))
+			}
+			if schema != nil {
+				return schema.statement(esc.decode(normalize.apply(username)), result)
+			}
+			if randomCount > 0 && !noPlain {
+				prefix += esc.escape(plain) + outputDelimiter
+			}
+			return prefix + result, nil
+		case "verify":
+			return verifyLine(line, delimiter, trim, PBKDF2IterCount, hashMode, keyed, esc)
+		case "identify":
+			return identifyLine(lineNo, line, usernamePresent, delimiter, trim, identified, audited)
+		case "show":
+			result, cracked, err := showLine(line, mapFilePath != "", usernamePresent, delimiter, outputDelimiter, trim, showUncracked, pot, esc)
+			if err == nil {
+				atomic.AddInt64(&showLooked, 1)
+				if cracked {
+					atomic.AddInt64(&showCracked, 1)
+				}
+			}
+			return result, err
+		case "rehash":
+			result, unrecovered, err := rh.line(lineNo, line, usernamePresent, delimiter, outputDelimiter, trim, esc)
+			if unrecovered != "" && sideOut != nil {
+				sideRecords.WriteString(unrecovered + string(recordEnd))
+			}
+			return result, err
+		case "remove":
+			result, cracked, err := removeLine(line, usernamePresent, delimiter, trim, pot)
+			if err == nil {
+				if cracked {
+					atomic.AddInt64(&removedLines, 1)
+				} else {
+					atomic.AddInt64(&keptLines, 1)
+				}
+			}
+			return result, err
+		case "decrypt":
+			return decryptLine(line, usernamePresent, delimiter, outputDelimiter, trim, passwordCipher, esc)
+		case "crack":
+			plain := line
+			if trim {
+				plain = strings.TrimSpace(plain)
+			}
+			found := crk.try(esc.decode(plain), string(recordEnd), esc)
+			if found != "" && crk.done() {
+				cancel()
+			}
+			return found, nil
+		}
+		if fixes != nil {
+			var repairs int
+			if line, repairs = fixLine(line, usernamePresent, delimiter, trim); repairs != 0 {
+				fixes.add(repairs, lineNo)
+			}
+			if fixOnly {
+				return line, nil
+			}
+		}
+		if freq != nil {
+			_, encoded, err := splitUsername(line, usernamePresent, delimiter, trim)
+			if err == nil {
+				freq.observe(encoded)
+			}
+			return "", err
+		}
+		var result string
+		var err error
+		iter := PBKDF2IterCount
+		if hashMode == "auto" {
+			result, err = convertAuto(line, usernamePresent, delimiter, outputDelimiter, trim, PBKDF2IterCount, normalize, dialect)
+		} else if hashMode == "dnn" {
+			result, err = convertDNN(line, usernamePresent, delimiter, outputDelimiter, trim, normalize, dialect)
+		} else if algorithm := formsAuthAlgorithm(hashMode); algorithm != "" {
+			result, err = convertFormsAuth(line, usernamePresent, delimiter, outputDelimiter, trim, algorithm, normalize, dialect)
+		} else {
+			if iterCol > 0 {
+				var ok bool
+				if line, iter, ok = takeIterField(line, delimiter, iterCol); !ok {
+					iter = PBKDF2IterCount
+					atomic.AddInt64(&iterFallbacks, 1)
+				}
+			}
+			result, err = convertHash(line, usernamePresent, delimiter, outputDelimiter, trim, iter, normalize, parseHash, dialect)
+		}
+		if err == nil && audited != nil {
+			algorithm, iterations := lineScheme(line, hashMode, usernamePresent, delimiter, trim, iter)
+			audited.observe(lineNo, algorithm, iterations)
+		}
+		if err == nil && salts != nil {
+			username, salt := lineSalt(line, hashMode, usernamePresent, delimiter, trim, PBKDF2IterCount, parseHash)
+			account := "line " + strconv.FormatInt(lineNo, 10)
+			if usernamePresent {
+				account = normalize.apply(username)
+			}
+			salts.observe(lineNo, account, salt)
+		}
+		if err == nil && jsonOutput {
+			var username *string
+			hash := result
+			if usernamePresent {
+				u, _, _ := splitUsername(line, usernamePresent, delimiter, trim)
+				escaped := normalize.apply(u)
+				// JSON strings hold any username, so it goes in without
+				// the $HEX[...] escaping of the text output.
+				u = esc.decode(escaped)
+				username, hash = &u, strings.TrimPrefix(result, escaped+outputDelimiter)
+			}
+			var source *recordSource
+			if withSource {
+				source = &recordSource{File: files[l.file].Name, Line: l.fileLine, ByteOffset: l.offset}
+			}
+			if result, err = jsonRecord(username, hash, source); err != nil {
+				return "", err
+			}
+		}
+		holdLast := dupUsernames != nil && dupUsernames.policy == "last"
+		if err == nil && (sideOut != nil || collisions != nil || holdLast) {
+			username, encoded, _ := splitUsername(line, usernamePresent, delimiter, trim)
+			normalized := normalize.apply(username)
+			if collisions != nil {
+				collisions.check(lineNo, username, normalized)
+			}
+			var side string
+			switch {
+			case sideOut != nil && stripUsernames:
+				hashLine := strings.TrimPrefix(result, normalized+outputDelimiter)
+				side = hashLine + "\t" + normalized + string(recordEnd)
+				result = hashLine
+				if outputDedup != nil && outputDedup.seen(hashLine) {
+					atomic.AddInt64(&repeatedHashes, 1)
+					result = ""
+				}
+			case sideOut != nil:
+				side = encoded + ":" + normalized + string(recordEnd)
+			}
+			if holdLast {
+				dupUsernames.hold(lineNo, normalized, result, side)
+				result = ""
+			} else {
+				sideRecords.WriteString(side)
+			}
+		}
+		if err == nil && checks != nil {
+			if hashMode == "dnn" {
+				_, hash, _ := parseDNNLine(line, usernamePresent, delimiter, trim)
+				checks.observe("dnn", len(hash.Digest), len(hash.Salt))
+			} else {
+				_, encoded, _ := splitUsername(line, usernamePresent, delimiter, trim)
+				checks.observeHash(encoded, int(PBKDF2SubkeyLength))
+			}
+			return "", nil
+		}
+		return result, err
+	}
+
+	// processBatch processes a batch of consecutive lines and returns the
+	// function that writes its records.
+	processBatch := func(batch []batchLine) func() {
+		var started time.Time
+		if timings != nil {
+			started = time.Now()
+			atomic.AddInt64(&timings.batchLines, int64(len(batch)))
+			defer addSince(&timings.busy, started)
+		}
+		var records, sideRecords strings.Builder
+		var processed int64
+		for i, l := range batch {
+			// Once --strict has stopped the run, the rest of the batch
+			// is left out rather than converted past the bad line.
+			if strictStopped.Load() {
+				atomic.AddInt64(&abandonedLines, int64(len(batch)-i))
+				break
+			}
+			if l.tooLong {
+				reportError(l, errLineTooLong)
+				continue
+			}
+			if invalidUTF8 != "pass" && !validUTF8(l.text, l.username) {
+				atomic.AddInt64(&invalidUTF8Lines, 1)
+				if invalidUTF8 == "error" {
+					reportError(l, errInvalidUTF8)
+					continue
+				}
+				l.text, l.username = replaceInvalidUTF8(l.text), replaceInvalidUTF8(l.username)
+				_, where := position(l)
+				logAt(levelVerbose, "Replaced invalid UTF-8 at %s", where)
+			}
+			result, err := process(l, &sideRecords)
+			if err != nil {
+				reportError(l, err)
+				continue
+			}
+			processed++
+			if result != "" {
+				records.WriteString(result)
+				records.WriteByte(recordEnd)
+			}
+		}
+		if timings != nil {
+			addSince(&timings.processing, started)
+		}
+		atomic.AddInt64(&processedLines, processed)
+		atomic.AddInt64(&files[batch[0].file].Processed, processed)
+		// The pipeline writes one batch at a time, so the batches reach
+		// both outputs in the same order.
+		return func() {
+			if records.Len() > 0 {
+				write(records.String())
+			}
+			if sideRecords.Len() > 0 {
+				sideOut.write(sideRecords.String())
+			}
+		}
+	}
+	// submit waits for a worker for the batch of first to first+n-1, and
+	// reports whether it got one before the run was cancelled.
+	submit := func(first, n int64, batch func() []batchLine) bool {
+		if timings != nil {
+			defer addSince(&timings.dispatchWait, time.Now())
+		}
+		return pipe.Submit(ctx, first, n, func() func() { return processBatch(batch()) })
+	}
+
+	// Lines are handed to workers in batches of consecutive lines, so cheap
+	// work isn't dominated by goroutine and synchronization overhead.
+	pending := lineBatch{lines: make([]batchLine, 0, batchSize)}
+	// sampledOut counts the lines --sample left out, and outOfShard those
+	// --shard left to the other shards.
+	var sampledOut, outOfShard int64
+	// flush submits the pending batch and counts the lines it accounts for.
+	// If the run is cancelled while it waits for a worker, the lines are
+	// left unread instead, so they aren't missing from the stats.
+	flush := func() bool {
+		b := pending
+		pending = lineBatch{lines: make([]batchLine, 0, batchSize)}
+		if b.first == 0 {
+			return true
+		}
+		n := b.last - b.first + 1
+		if len(b.lines) == 0 {
+			pipe.Skip(b.first, n)
+		} else if !submit(b.first, n, func() []batchLine { return b.lines }) {
+			lineNo = b.first - 1
+			return false
+		}
+		files[b.file].Read += n
+		atomic.AddInt64(&duplicateLines, b.duplicates)
+		atomic.AddInt64(&skippedLines, b.skipped)
+		sampledOut += b.sampledOut
+		outOfShard += b.outOfShard
+		return true
+	}
+
+	// stopped ends the run early; stopInput is what the producer does when an
+	// input can't be read. Alone, it is fatal like any read error. With
+	// several, the input is skipped, unless --strict.
+	stopped := false
+	stopInput := func(i int, err error) {
+		if !multi {
+			if checkpointPath == "" {
+				out.discard()
+			}
+			fatalf("Error reading %s: %v", files[i].Name, err)
+		}
+		log.Printf("Error reading %s, skipping it: %v", files[i].Name, err)
+		files[i].Error = err.Error()
+		if strict {
+			strictOnce.Do(func() {
+				strictAt, strictErr = files[i].Name, err
+				strictStopped.Store(true)
+				cancel()
+			})
+			stopped = true
+		}
+	}
+
+	if timings != nil {
+		timings.start()
+	}
+	if chunks != nil {
+		// Workers split the chunks into lines, so the producer only cuts
+		// the input at record boundaries and counts the records.
+		splitter := chunkSplitter{delim: recordEnd, limit: maxLineBytes, keepCR: keepCR}
+		for first := true; ctx.Err() == nil; first = false {
+			chunk, err := chunks.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				stopInput(0, err)
+				break
+			}
+			atomic.AddInt64(&inputBytesRead, int64(len(chunk)))
+			if first {
+				chunk = bytes.TrimPrefix(chunk, utf8BOM)
+			}
+			n := countRecords(chunk, recordEnd)
+			if n == 0 {
+				continue
+			}
+			firstLine := lineNo + 1
+			if !submit(firstLine, n, func() []batchLine { return splitter.split(chunk, firstLine) }) {
+				break
+			}
+			lineNo += n
+			files[0].Read += n
+		}
+	} else {
+		for i, src := range sources {
+			if stopped || ctx.Err() != nil || (limit > 0 && taken >= limit) {
+				break
+			}
+			input, closer := firstInput, firstCloser
+			if multi {
+				var name string
+				if input, name, closer, err = openInput(src, inputCompression, inputCharset, &inputBytesRead, byteLimit); err != nil {
+					stopInput(i, err)
+					continue
+				}
+				if preFilter != "" {
+					if input, closer, err = filterInput(preFilter, input, closer, recordEnd); err != nil {
+						fatalf("Error: %v", err)
+					}
+				}
+				log.Printf("Reading %s", name)
+			}
+			reader := newLineReader(input, recordEnd, maxLineBytes)
+			reader.keepCR = keepCR
+			if src.section != nil {
+				// A --shard byte range is read on its own, but the offsets
+				// are in the whole file.
+				reader.pos = src.section.start
+			}
+			if fix {
+				reader.joinWrapped = wrappedContinuation(delimiter)
+			}
+			var fileLine int64
+			for ctx.Err() == nil && (limit == 0 || taken < limit) && reader.next() {
+				lineNo++
+				fileLine++
+				var username string
+				var usernameTooLong bool
+				if pairs != nil {
+					if !pairs.next() {
+						if err := pairs.readErr(); err != nil {
+							pairMismatch = fmt.Sprintf("reading --usernames-file: %v", err)
+						} else {
+							pairMismatch = fmt.Sprintf("--usernames-file has %d lines, but the input has %s", pairs.lines, inputLinesFrom(lineNo, reader, sources[i+1:], inputCompression, inputCharset, recordEnd))
+						}
+						stopped = true
+						break
+					}
+					username, usernameTooLong = pairs.text(), pairs.lineTooLong()
+				}
+				if lineNo <= skip || lineNo <= resumeLines {
+					// Lines done by the run being resumed still count towards --limit.
+					files[i].Read++
+					atomic.AddInt64(&skippedLines, 1)
+					if lineNo > skip {
+						taken++
+						if dedup != nil && !reader.lineTooLong() {
+							// Remember them, so their duplicates are still dropped.
+							dedup.seen(uniqueKey(reader.text(), usernamePresent, delimiter, trim))
+						}
+						if dupUsernames != nil && !reader.lineTooLong() {
+							dupUsernames.observe(lineNo, reader.text())
+						}
+					}
+					continue
+				}
+				if inShard != nil && inShard.section == nil && !inShard.owns(lineNo) {
+					pending.account(lineNo, i)
+					pending.skipped++
+					pending.outOfShard++
+					continue
+				}
+				if sample != nil && !sample.keep(lineNo-skip) {
+					pending.account(lineNo, i)
+					pending.skipped++
+					pending.sampledOut++
+					continue
+				}
+				taken++
+				if pairs != nil && blankUsernames == "skip" && strings.TrimSpace(username) == "" && !usernameTooLong {
+					pending.account(lineNo, i)
+					pending.skipped++
+					continue
+				}
+				if reader.lineTooLong() || usernameTooLong {
+					pending.account(lineNo, i)
+					pending.lines = append(pending.lines, batchLine{lineNo: lineNo, file: i, fileLine: fileLine, offset: reader.offset(), tooLong: true})
+				} else {
+					text := reader.text()
+					// --duplicate-usernames first drops the later rows of an
+					// account like --unique drops repeated lines.
+					if (dedup != nil && dedup.seen(uniqueKey(text, usernamePresent, delimiter, trim))) ||
+						(dupUsernames != nil && dupUsernames.observe(lineNo, text) && dupUsernames.policy == "first") {
+						pending.account(lineNo, i)
+						pending.duplicates++
+						continue
+					}
+					if rateLimit > 0 && !takeRate(ctx, limiter) {
+						lineNo--
+						stopped = true
+						break
+					}
+					if reader.wrapped {
+						fixes.add(repairWrapped, lineNo)
+					}
+					pending.account(lineNo, i)
+					pending.lines = append(pending.lines, batchLine{lineNo: lineNo, file: i, fileLine: fileLine, offset: reader.offset(), text: text, username: username})
+				}
+				if len(pending.lines) == batchSize && !flush() {
+					stopped = true
+					break
+				}
+			}
+			// Batches don't span inputs, so their lines count towards one
+			// file. Once the run is cancelled, the pending batch is left
+			// unread.
+			if ctx.Err() == nil {
+				flush()
+			} else if pending.first != 0 {
+				lineNo = pending.first - 1
+				pending = lineBatch{lines: make([]batchLine, 0, batchSize)}
+			}
+			closer.Close()
+			if err := reader.readErr(); err != nil {
+				stopInput(i, err)
+			}
+		}
+	}
+
+	if timings != nil {
+		timings.doneReading()
+	}
+	workersFinished := waitWorkers(runCtx, pipe.Wait)
+	if timings != nil {
+		timings.stop()
+	}
+	if pairs != nil {
+		// Usernames left over only matter if the whole input was read, and
+		// --random reads as many as it needs.
+		if pairMismatch == "" && randomCount == 0 && !stopped && ctx.Err() == nil && (limit == 0 || taken < limit) {
+			if usernames, err := pairs.count(); err != nil {
+				pairMismatch = fmt.Sprintf("reading --usernames-file: %v", err)
+			} else if usernames != lineNo {
+				pairMismatch = fmt.Sprintf("--usernames-file has %d lines, but the input has %d", usernames, lineNo)
+			}
+		}
+		pairs.Close()
+		if pairMismatch != "" {
+			if checkpointPath == "" {
+				out.discard()
+			}
+			if sideOut != nil {
+				sideOut.discard()
+			}
+			fatalf("Error: %s; usernames and plaintexts pair up line by line.", pairMismatch)
+		}
+	}
+	stopProgress()
+	// A mapped input can only be unmapped once no worker is splitting it.
+	if chunks != nil && workersFinished {
+		if err := chunks.Close(); err != nil {
+			log.Printf("Error closing input: %v", err)
+		}
+	}
+
+	var workersUsed *workerRange
+	if scaler != nil {
+		r := scaler.close()
+		workersUsed = &r
+	} else if maxWorkers > 0 {
+		workersUsed = &workerRange{Min: maxWorkers, Max: maxWorkers}
+	}
+
+	if err := stopCPUProfile(); err != nil {
+		log.Printf("Error writing CPU profile: %v", err)
+	}
+	if memProfile != "" {
+		if err := writeHeapProfile(memProfile); err != nil {
+			log.Printf("Error writing heap profile: %v", err)
+		}
+	}
+
+	if strictErr != nil {
+		log.Printf("Aborted (--strict): %s: %v", strictAt, strictErr)
+	}
+	interrupted := context.Cause(runCtx) == errInterrupted
+	if interrupted {
+		log.Printf("Interrupted, stopped reading input after line %d", lineNo)
+	}
+	timedOut := context.Cause(runCtx) == errTimedOut
+	if timedOut {
+		log.Printf("Timed out after %v, stopped reading input after line %d", timeout, lineNo)
+	}
+	brokenPipe := sink == nil && isBrokenPipe(outputFailure.error())
+	if brokenPipe {
+		log.Printf("Output closed early (broken pipe), stopped reading input after line %d", lineNo)
+	}
+	if !workersFinished {
+		log.Printf("Some workers were still busy %v after the timeout; their lines are missing from the output", timeoutGrace)
+	}
+
+	close(checkpointDone)
+	if checkpointPath != "" {
+		if err := writeCheckpoint(); err != nil {
+			log.Printf("Error writing checkpoint: %v", err)
+		}
+	}
+
+	// An atomic output is only moved into place after a complete run. An
+	// incomplete one is removed, unless a checkpoint will resume it.
+	dupFailed := dupUsernames != nil && dupUsernames.failed()
+	if dupFailed {
+		log.Printf("Error: %d rows repeat the username of an earlier row (--duplicate-usernames error), e.g. %s", dupUsernames.rows, dupUsernames.exampleList())
+	}
+	complete := strictErr == nil && !interrupted && !timedOut && outputFailure.error() == nil && !dupFailed
+	if dupUsernames != nil && dupUsernames.held != nil && complete {
+		var side func(string)
+		if sideOut != nil {
+			side = sideOut.write
+		}
+		dupUsernames.release(write, side, recordEnd)
+	}
+	if sideOut != nil {
+		if err := sideOut.close(); err != nil {
+			sideOut.discard()
+			fatalf("Error writing %s: %v", sideFlag, err)
+		}
+		if complete {
+			err = sideOut.commit()
+		} else {
+			err = sideOut.discard()
+		}
+		if err != nil {
+			log.Printf("Error finishing %s: %v", sideFlag, err)
+		}
+	}
+	if sorter != nil {
+		if complete {
+			err = sorter.finish(out.write)
+		} else {
+			// Nothing is written for an incomplete run; just drop the spill files.
+			err = sorter.finish(func(string) {})
+		}
+		if err != nil {
+			out.discard()
+			fatalf("Error sorting output: %v", err)
+		}
+	}
+	if schema != nil && complete {
+		out.write(sqlFooter(atomic.LoadInt64(&processedLines)) + string(recordEnd))
+	}
+	if identified != nil && complete {
+		out.write(identified.records(string(recordEnd)))
+	}
+	var frequencies *frequencyReport
+	var saltsReport *saltReport
+	if salts != nil {
+		saltsReport = salts.report()
+	}
+	if freq != nil {
+		frequencies = freq.report(frequencyTop)
+		if complete {
+			out.write(frequencies.records(string(recordEnd)))
+		}
+	}
+	// A broken pipe was already reported; any other write error is reported
+	// here, once. An --output-addr that gave up still gets the stats, with
+	// the lines it couldn't send.
+	outErr := out.close()
+	sinkFailed := sink != nil && outErr != nil
+	if outErr != nil && !brokenPipe && !sinkFailed {
+		if checkpointPath == "" {
+			out.discard()
+		}
+		fatalf("Error writing output: %v", outErr)
+	}
+	if sinkFailed {
+		log.Printf("Error writing output: %v; %d lines not delivered", outErr, single.sinkReport().Undelivered)
+	}
+	if opts.tee != nil {
+		if err := opts.tee.error(); err != nil {
+			log.Printf("Warning: --tee stopped printing the output: %v", err)
+		}
+	}
+	var finishErr error
+	switch {
+	case complete:
+		finishErr = out.commit()
+	case checkpointPath == "":
+		finishErr = out.discard()
+	}
+	if finishErr != nil {
+		fatalf("Error finishing output: %v", finishErr)
+	}
+	removeRunTemp()
+	if checksumSidecar {
+		if err := out.writeChecksumFile(); err != nil {
+			fatalf("Error writing the checksum file: %v", err)
+		}
+	}
+	if status != nil {
+		if err := status.close(); err != nil {
+			log.Printf("Error stopping --status-addr: %v", err)
+		}
+	}
+
+	if errFile != nil {
+		if err := errFile.close(); err != nil {
+			fatalf("Error writing error file: %v", err)
+		}
+	}
+
+	stats := runStats{
+		WorkType:   work_type,
+		Read:       lineNo,
+		Processed:  atomic.LoadInt64(&processedLines),
+		Errored:    atomic.LoadInt64(&erroredLines),
+		Skipped:    atomic.LoadInt64(&skippedLines),
+		Duplicates: atomic.LoadInt64(&duplicateLines),
+		ErrorKinds: errorKinds.breakdown(),
+		Workers:    workersUsed,
+		Threads:    cpus,
+		Timing:     runTiming{StartedAt: startTime},
+		TotalLines: totalLines,
+	}
+	stats.Abandoned = atomic.LoadInt64(&abandonedLines)
+	if !workersFinished {
+		stats.Abandoned = stats.Read - stats.Processed - stats.Errored - stats.Skipped - stats.Duplicates
+	}
+	stats.Version = versionString()
+	stats.Command = command
+	if command == "generate" {
+		stats.HashMode = hashMode
+	}
+	if strictErr != nil {
+		stats.StopReason = "strict"
+	} else if interrupted {
+		stats.StopReason = "interrupted"
+	} else if timedOut {
+		stats.StopReason = "timeout"
+	} else if brokenPipe {
+		stats.StopReason = "broken_pipe"
+	} else if sinkFailed {
+		stats.StopReason = "output_failed"
+	} else if crk != nil && crk.done() && ctx.Err() != nil {
+		stats.StopReason = "cracked"
+	}
+	if crk != nil {
+		stats.Targets, stats.Cracked = int64(crk.targets), crk.cracked()
+	}
+	if command == "show" {
+		stats.Targets, stats.Cracked = showLooked, showCracked
+	}
+	if rh != nil {
+		stats.Targets, stats.Cracked = rh.looked, rh.rehashed
+	}
+	if command == "remove" {
+		stats.Removed, stats.Kept = &removedLines, &keptLines
+	}
+	stats.IterFallbacks = iterFallbacks
+	if invalidUTF8 != "pass" {
+		stats.InvalidUTF8 = &invalidUTF8Lines
+	}
+	if plainCache != nil {
+		stats.PlaintextCache = plainCache.report()
+	}
+	if fixes != nil {
+		stats.Repairs = fixes.entries()
+	}
+	if violations != nil {
+		stats.Policy = &policyReport{Violations: violations.lines.Load(), Enforced: policyEnforce, Rules: violations.entries()}
+	}
+	stats.RepeatedHashes = repeatedHashes
+	if collisions != nil {
+		stats.UsernameCollisions = &collisions.count
+	}
+	if dupUsernames != nil {
+		stats.DuplicateUsernames = dupUsernames.report()
+	}
+	if saltDraws != nil {
+		stats.SaltRedraws = &saltDraws.redraws
+	}
+	if inShard != nil {
+		stats.Shard = inShard.report(outOfShard)
+	}
+	if sample != nil {
+		population := max(0, lineNo-skip)
+		stats.Sample = sample.report(population, population-sampledOut, stats.Processed, stats.Errored, stats.Duplicates)
+	}
+	stats.Output = outputPath
+	if sink != nil {
+		stats.Sink = single.sinkReport()
+	}
+	if outputCompression != "none" {
+		stats.OutputCompression = outputCompression
+	}
+	if shards != nil {
+		stats.Shards = shards.breakdown()
+	}
+	if checks != nil {
+		stats.Validation = checks.report()
+	}
+	stats.Frequency = frequencies
+	stats.Salts = saltsReport
+	if audited != nil {
+		stats.Audit = audited.report()
+	}
+	if sorter != nil {
+		stats.Sort = sorter.stats(sortKey)
+	}
+	if multi {
+		stats.Files = make([]fileStats, len(files))
+		for i := range files {
+			stats.Files[i] = fileStats{
+				Name:      files[i].Name,
+				Read:      files[i].Read,
+				Processed: atomic.LoadInt64(&files[i].Processed),
+				Errored:   atomic.LoadInt64(&files[i].Errored),
+				Error:     files[i].Error,
+			}
+		}
+	}
+	if outputAppend {
+		stats.LinesWritten, stats.OutputPreexistingBytes = single.linesWritten()
+	}
+	stats.BytesWritten, stats.CompressedBytes = out.bytesWritten()
+	if outputCompression == "none" {
+		stats.CompressedBytes = 0
+	}
+	stats.OutputChecksums = out.checksums()
+	if newOutputSet != nil {
+		outputDuplicates := out.duplicatesDropped()
+		stats.OutputDuplicates = &outputDuplicates
+	}
+	if errFile != nil && errFile.created() {
+		stats.ErrorFile = errorFilePath
+	}
+	if timings != nil {
+		// Only a fixed worker limit has a capacity to be idle against.
+		limit := 0
+		if scaler == nil {
+			limit = maxWorkers
+			if limit == 0 && chunks != nil {
+				limit = cpus
+			}
+		}
+		stats.Timing.Pipeline = timings.report(lineNo, stats.Processed, limit)
+	}
+	consistent := stats.reconcile()
+	stats.finish(time.Now())
+
+	// Stats
+	fmt.Fprintln(log.Writer())
+	stats.logSummary()
+	if statsJSON != "" {
+		if err := stats.writeJSON(statsJSON); err != nil {
+			fatalf("Error writing stats JSON: %v", err)
+		}
+	}
+	if strictErr != nil || (strict && !consistent) {
+		os.Exit(1)
+	}
+	if interrupted {
+		os.Exit(130)
+	}
+	if timedOut {
+		os.Exit(124)
+	}
+	if brokenPipe {
+		os.Exit(141)
+	}
+	if sinkFailed {
+		os.Exit(1)
+	}
+	if stats.Audit != nil && !stats.Audit.Passed || dupFailed {
+		os.Exit(1)
+	}
+	// Like grep, verify fails when not every line matched.
+	if (command == "verify" || validate) && erroredLines > 0 {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// runMainEnv makes the test binary run the tool instead of the tests, for
+// runTool.
+const runMainEnv = "ASPNETHASHTOOL_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// toolRun is the result of a run of the tool.
+type toolRun struct {
+	stdout, stderr string
+	exitCode       int
+}
+
+// runTool runs the tool with args and stdin in a process of its own, away
+// from the user's config files and ASPNETHASHTOOL_ variables.
+func runTool(t testing.TB, stdin string, args ...string) toolRun {
+	t.Helper()
+	cmd := toolCommand(t, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	run := toolRun{}
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) {
+			t.Fatal(err)
+		}
+		run.exitCode = exit.ExitCode()
+	}
+	run.stdout, run.stderr = stdout.String(), stderr.String()
+	return run
+}
+
+// toolCommand returns the command runTool runs, for tests that need to
+// start it themselves.
+func toolCommand(t testing.TB, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	home := t.TempDir()
+	cmd.Env = []string{runMainEnv + "=1", "HOME=" + home, "XDG_CONFIG_HOME=" + home, "NO_COLOR=1"}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) && !strings.HasPrefix(kv, "HOME=") && !strings.HasPrefix(kv, "XDG_CONFIG_HOME=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	return cmd
+}
+
+// mustRunTool is runTool for runs that must succeed.
+func mustRunTool(t testing.TB, stdin string, args ...string) string {
+	t.Helper()
+	run := runTool(t, stdin, args...)
+	if run.exitCode != 0 {
+		t.Fatalf("%s exited with %d: %s", strings.Join(args, " "), run.exitCode, run.stderr)
+	}
+	return run.stdout
+}
+
+// syntheticHashes returns n username,hash lines of random MVC4 blobs, which
+// convert takes without verifying them.
+func syntheticHashes(n int) string {
+	r := rand.New(rand.NewSource(1))
+	blob := make([]byte, 1+aspnethash.DefaultSaltSize+aspnethash.DefaultSubkeyLength)
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		r.Read(blob[1:])
+		fmt.Fprintf(&b, "user%d,%s\n", i, base64.StdEncoding.EncodeToString(blob))
+	}
+	return b.String()
+}
+
+// BenchmarkConvertBatchSize converts a synthetic dump one line at a time and
+// in the default batches of 256. Raise benchLines for a run closer to a real
+// dump.
+func BenchmarkConvertBatchSize(b *testing.B) {
+	const benchLines = 200000
+	input := syntheticHashes(benchLines)
+	for _, size := range []int{1, 256} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				mustRunTool(b, input, "convert", "-u", "-q", "--batch-size", fmt.Sprint(size))
+			}
+			b.ReportMetric(float64(benchLines*b.N)/b.Elapsed().Seconds(), "lines/s")
+		})
+	}
+}
+
+// TestThreads checks --threads sets GOMAXPROCS, which the log, the worker
+// diagnostics and the threads field of the stats report, and that without
+// it the run takes $GOMAXPROCS.
+func TestThreads(t *testing.T) {
+	for _, tt := range []struct {
+		threads, env string
+		want         int
+	}{
+		{"1", "", 1},
+		{"3", "", 3},
+		// More threads than CPUs is allowed, and --threads wins over the
+		// environment.
+		{"64", "2", 64},
+		{"", "2", 2},
+	} {
+		stats := filepath.Join(t.TempDir(), "stats.json")
+		args := []string{"generate", "-vv", "--max-workers", "auto", "--stats-json", stats}
+		if tt.threads != "" {
+			args = append(args, "--threads", tt.threads)
+		}
+		cmd := toolCommand(t, args...)
+		if tt.env != "" {
+			cmd.Env = append(cmd.Env, "GOMAXPROCS="+tt.env)
+		}
+		cmd.Stdin = strings.NewReader("password\n")
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("--threads %q: %v: %s", tt.threads, err, stderr.String())
+		}
+		b, err := os.ReadFile(stats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s runStats
+		if err := json.Unmarshal(b, &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Threads != tt.want {
+			t.Errorf("--threads %q, GOMAXPROCS %q: the stats say %d threads, want %d", tt.threads, tt.env, s.Threads, tt.want)
+		}
+		want := []string{fmt.Sprintf("auto, %d to %d on %d threads", tt.want, 8*tt.want, tt.want)}
+		if tt.threads != "" {
+			want = append(want, fmt.Sprintf("Running on %d of %d CPUs (--threads)", tt.want, runtime.NumCPU()))
+		}
+		for _, w := range want {
+			if !strings.Contains(stderr.String(), w) {
+				t.Errorf("--threads %q, GOMAXPROCS %q: no %q in the log: %s", tt.threads, tt.env, w, stderr.String())
+			}
+		}
+	}
+	run := runTool(t, "password\n", "generate", "--threads", "0")
+	if run.exitCode == 0 || !strings.Contains(run.stderr, "--threads must be at least 1") {
+		t.Errorf("--threads 0 exited with %d: %s", run.exitCode, run.stderr)
+	}
+}
+
+// BenchmarkGenerateThreads hashes a batch of passwords with PBKDF2 at
+// several --threads, to see how generate scales with the CPUs it may use.
+// It can't scale past the CPUs of the machine.
+func BenchmarkGenerateThreads(b *testing.B) {
+	const benchLines = 2000
+	var input strings.Builder
+	for i := 0; i < benchLines; i++ {
+		fmt.Fprintf(&input, "password%d\n", i)
+	}
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				mustRunTool(b, input.String(), "generate", "-q", "--threads", fmt.Sprint(threads), "--max-workers", "auto")
+			}
+			b.ReportMetric(float64(benchLines*b.N)/b.Elapsed().Seconds(), "lines/s")
+		})
+	}
+}
+
+// convertCases are the convert lines the hot-path benchmarks and the
+// allocation budget run on.
+var convertCases = []struct {
+	name, line string
+	username   bool
+}{
+	{"mvc4", "AAABAgMEBQYHCAkKCwwNDg8TEAcfPTbAR4SiWAURv/76tjH7y7DB7E7ZLpDmTdwP1g==", false},
+	{"mvc4 username", "alice,AAABAgMEBQYHCAkKCwwNDg8TEAcfPTbAR4SiWAURv/76tjH7y7DB7E7ZLpDmTdwP1g==", true},
+	{"identity v3", "alice,AQAAAAEAACcQAAAAEAABAgMEBQYHCAkKCwwNDg+C+4kIIpPWEkm+WRQWPvycsw5up6fUf784Y2BOE8Kz3w==", true},
+}
+
+var convertParser = newHashParser(aspnethash.DefaultSaltSize, aspnethash.DefaultSubkeyLength, false)
+
+// convertOnce converts line as convert does with the default flags.
+func convertOnce(line string, username bool) (string, error) {
+	return convertHash(line, username, ",", ":", false, aspnethash.DefaultIterations, nil, convertParser, nil)
+}
+
+func BenchmarkConvertHash(b *testing.B) {
+	for _, tt := range convertCases {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := convertOnce(tt.line, tt.username); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultAuditPolicy is current guidance for stored passwords: PBKDF2 with
+// HMAC-SHA256 and at least 600,000 iterations.
+const defaultAuditPolicy = "min-iter=600000,algos=" + algoPBKDF2SHA256
+
+// auditAlgorithms are the algorithms --audit-policy can allow.
+var auditAlgorithms = []string{algoPBKDF2SHA1, algoPBKDF2SHA256, algoPBKDF2SHA512, algoSaltedSHA1, algoSaltedSHA256, algoMD5, algoSHA1, algoSHA256, algoSHA512}
+
+// auditPolicy is what --audit checks the hashes against: an allowed
+// algorithm and enough iterations. Algorithms without iterations count as
+// one. The run passes if at least minShare percent of the hashes comply.
+type auditPolicy struct {
+	text       string
+	minIter    int
+	algorithms []string
+	minShare   float64
+}
+
+// parseAuditPolicy parses a comma-separated list of rule=value pairs, such
+// as "min-iter=600000,algos=pbkdf2-sha256,pbkdf2-sha512,min-share=95".
+// Further algorithms of algos follow it as items of their own.
+func parseAuditPolicy(s string) (auditPolicy, error) {
+	p := auditPolicy{text: s, minShare: 100}
+	var last string
+	for _, rule := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok && last == "algos" {
+			name, value = last, name
+		} else if !ok {
+			return p, fmt.Errorf("%q isn't a rule=value pair", rule)
+		}
+		name = strings.ToLower(name)
+		switch name {
+		case "min-iter":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return p, fmt.Errorf("min-iter takes a positive number of iterations, not %q", value)
+			}
+			p.minIter = n
+		case "algos":
+			value = strings.ToLower(value)
+			if !slices.Contains(auditAlgorithms, value) {
+				return p, fmt.Errorf("unknown algorithm %q (valid: %v)", value, auditAlgorithms)
+			}
+			p.algorithms = append(p.algorithms, value)
+		case "min-share":
+			n, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+			if err != nil || n < 0 || n > 100 {
+				return p, fmt.Errorf("min-share takes a percentage from 0 to 100, not %q", value)
+			}
+			p.minShare = n
+		default:
+			return p, fmt.Errorf("unknown rule %q (want min-iter, algos, min-share)", name)
+		}
+		last = name
+	}
+	return p, nil
+}
+
+// complies reports whether hashes of algorithm with iterations meet p. With
+// no algos rule, any algorithm does.
+func (p auditPolicy) complies(algorithm string, iterations int) bool {
+	if len(p.algorithms) > 0 && !slices.Contains(p.algorithms, algorithm) {
+		return false
+	}
+	return iterations >= p.minIter
+}
+
+// auditBucket counts the hashes of one algorithm in one iteration range.
+type auditBucket struct {
+	Algorithm string `json:"algorithm"`
+	// MinIterations and MaxIterations are the fewest and most iterations
+	// seen in the bucket.
+	MinIterations int     `json:"min_iterations"`
+	MaxIterations int     `json:"max_iterations"`
+	Count         int64   `json:"count"`
+	Share         float64 `json:"share"`
+	Compliant     bool    `json:"compliant"`
+	// Example is the first line with a hash of the bucket.
+	Example int64 `json:"example_line"`
+}
+
+// label names the bucket as the summary shows it.
+func (b auditBucket) label() string {
+	iterations := groupThousands(int64(b.MinIterations))
+	if b.MaxIterations != b.MinIterations {
+		iterations += "-" + groupThousands(int64(b.MaxIterations))
+	}
+	if b.MaxIterations == 1 {
+		return b.Algorithm + ", 1 iteration"
+	}
+	return fmt.Sprintf("%s, %s iterations", b.Algorithm, iterations)
+}
+
+// audit sorts the hashes of a run into buckets by algorithm and iteration
+// range for --audit. The ranges start at the powers of ten and at the
+// policy's min-iter, so a bucket either complies or doesn't. It is safe for
+// concurrent use.
+type audit struct {
+	policy  auditPolicy
+	mu      sync.Mutex
+	buckets map[string]*auditBucket
+}
+
+func newAudit(policy auditPolicy) *audit {
+	return &audit{policy: policy, buckets: map[string]*auditBucket{}}
+}
+
+// rangeStart returns the start of the iteration range of iterations.
+func (a *audit) rangeStart(iterations int) int {
+	start := 1
+	for start <= iterations/10 {
+		start *= 10
+	}
+	if a.policy.minIter > start && a.policy.minIter <= iterations {
+		start = a.policy.minIter
+	}
+	return start
+}
+
+// observe counts a hash of algorithm with iterations, found on line lineNo.
+func (a *audit) observe(lineNo int64, algorithm string, iterations int) {
+	iterations = max(iterations, 1)
+	key := algorithm + ":" + strconv.Itoa(a.rangeStart(iterations))
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b := a.buckets[key]
+	if b == nil {
+		b = &auditBucket{Algorithm: algorithm, MinIterations: iterations, MaxIterations: iterations, Compliant: a.policy.complies(algorithm, iterations), Example: lineNo}
+		a.buckets[key] = b
+	}
+	b.Count++
+	b.MinIterations = min(b.MinIterations, iterations)
+	b.MaxIterations = max(b.MaxIterations, iterations)
+	// Lines are handed to the workers out of order.
+	b.Example = min(b.Example, lineNo)
+}
+
+// auditReport is the --audit part of the stats.
+type auditReport struct {
+	Policy    string  `json:"policy"`
+	Audited   int64   `json:"audited"`
+	Compliant int64   `json:"compliant"`
+	Share     float64 `json:"compliant_share"`
+	MinShare  float64 `json:"min_share"`
+	Passed    bool    `json:"passed"`
+	// Buckets are sorted weakest first: the ones that don't comply, by
+	// iterations, then the ones that do.
+	Buckets []auditBucket `json:"buckets"`
+}
+
+// report sums up the hashes seen and checks them against the policy. A run
+// without hashes passes.
+func (a *audit) report() *auditReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := &auditReport{Policy: a.policy.text, MinShare: a.policy.minShare, Buckets: []auditBucket{}}
+	for _, b := range a.buckets {
+		r.Audited += b.Count
+		if b.Compliant {
+			r.Compliant += b.Count
+		}
+		r.Buckets = append(r.Buckets, *b)
+	}
+	r.Share = 100
+	if r.Audited > 0 {
+		r.Share = 100 * float64(r.Compliant) / float64(r.Audited)
+		for i := range r.Buckets {
+			r.Buckets[i].Share = 100 * float64(r.Buckets[i].Count) / float64(r.Audited)
+		}
+	}
+	r.Passed = r.Share >= r.MinShare
+	sort.Slice(r.Buckets, func(i, j int) bool {
+		a, b := r.Buckets[i], r.Buckets[j]
+		if a.Compliant != b.Compliant {
+			return !a.Compliant
+		}
+		if a.MinIterations != b.MinIterations {
+			return a.MinIterations < b.MinIterations
+		}
+		return a.Algorithm < b.Algorithm
+	})
+	return r
+}
+
+// logSummary logs the report after the run summary.
+func (r *auditReport) logSummary() {
+	verdict := "PASS"
+	if !r.Passed {
+		verdict = "FAIL"
+	}
+	log.Printf("Audit (%s): %s", r.Policy, verdict)
+	log.Printf("  Compliant: %s of %s hashes (%.1f%%, %.1f%% needed)", groupThousands(r.Compliant), groupThousands(r.Audited), r.Share, r.MinShare)
+	for _, b := range r.Buckets {
+		mark := "weak"
+		if b.Compliant {
+			mark = "ok"
+		}
+		log.Printf("  %s: %s (%.1f%%) %s, e.g. line %d", b.label(), groupThousands(b.Count), b.Share, mark, b.Example)
+	}
+}
+
+// lineScheme returns the algorithm and iteration count of a line convert
+// converted in mode, for --audit. iterations is what MVC4 hashes, which
+// don't store it, were converted with; the other formats without
+// iterations count one.
+func lineScheme(line, mode string, usernamePresent bool, delimiter string, trim bool, iterations int) (algorithm string, iter int) {
+	switch {
+	case mode == "dnn":
+		return algoSaltedSHA1, 1
+	case formsAuthAlgorithm(mode) != "":
+		return formsAuthAlgorithm(mode), 1
+	case mode == "auto":
+		_, encoded, _ := splitUsername(line, usernamePresent, delimiter, trim)
+		return detectionScheme(detectHash(encoded, delimiter, iterations))
+	}
+	return algoPBKDF2SHA1, iterations
+}
+
+// detectionScheme returns the algorithm and iteration count of a detected
+// hash, for --audit. Hashes hashcat has no mode for go by their format.
+func detectionScheme(d detection) (algorithm string, iterations int) {
+	switch h := d.digest; {
+	case len(h.pbkdf2.Subkey) > 0:
+		return h.algorithm, h.pbkdf2.Iterations
+	case h.algorithm != "":
+		return h.algorithm, 1
+	}
+	return d.format, 1
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// benchPassword is hashed over and over by --bench.
+const benchPassword = "correct horse battery staple"
+
+// benchSink receives the length of every benchmark hash, so the compiler
+// can't drop the hashing as dead code.
+var benchSink int64
+
+// benchResult is the throughput measured for one iteration count.
+type benchResult struct {
+	iterations int
+	workers    int
+	hashes     int64
+	elapsed    time.Duration
+}
+
+// perSecond is the total number of hashes per second.
+func (r benchResult) perSecond() float64 {
+	return float64(r.hashes) / r.elapsed.Seconds()
+}
+
+// latency is how long a single hash takes on one core, which is what a
+// login request has to wait for.
+func (r benchResult) latency() time.Duration {
+	if r.hashes == 0 {
+		return 0
+	}
+	return time.Duration(int64(r.elapsed) * int64(r.workers) / r.hashes)
+}
+
+// runBench hashes benchPassword on workers goroutines for d.
+func runBench(hashMode string, iterations, subkeyLength, saltSize, workers int, d time.Duration) (benchResult, error) {
+	var (
+		wg       sync.WaitGroup
+		hashes   int64
+		errOnce  sync.Once
+		benchErr error
+	)
+	start := time.Now()
+	deadline := start.Add(d)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n int64
+			for time.Now().Before(deadline) {
+				hash, err := generateHash(benchPassword, hashMode, iterations, subkeyLength, saltSize, nil, nil)
+				if err != nil {
+					errOnce.Do(func() { benchErr = err })
+					return
+				}
+				n += int64(len(hash))
+				atomic.AddInt64(&hashes, 1)
+			}
+			atomic.AddInt64(&benchSink, n)
+		}()
+	}
+	wg.Wait()
+	return benchResult{iterations: iterations, workers: workers, hashes: hashes, elapsed: time.Since(start)}, benchErr
+}
+
+// writeBenchTable prints one row per iteration count.
+func writeBenchTable(w io.Writer, results []benchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "iterations\thashes/s\tper core\tlatency/hash\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%d\t%.1f\t%.1f\t%v\t\n", r.iterations, r.perSecond(), r.perSecond()/float64(r.workers), r.latency().Round(time.Microsecond))
+	}
+	return tw.Flush()
+}
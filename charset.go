@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// inputCharsets lists the accepted values of --input-charset.
+var inputCharsets = []string{"utf8", "utf16le", "utf16be", "windows-1252", "auto"}
+
+// decodeInput wraps r so that it yields UTF-8 for the named charset. The
+// conversion happens before the input is split into lines, so delimiters and
+// non-ASCII usernames are seen as UTF-8 throughout.
+func decodeInput(r io.Reader, charset string) (io.Reader, error) {
+	var dec *encoding.Decoder
+	switch charset {
+	case "utf8":
+		return r, nil
+	case "utf16le":
+		dec = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()
+	case "utf16be":
+		dec = unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder()
+	case "windows-1252":
+		dec = charmap.Windows1252.NewDecoder()
+	case "auto":
+		// Sniff a UTF-8 or UTF-16 byte order mark, falling back to UTF-8.
+		return transform.NewReader(r, unicode.BOMOverride(encoding.Nop.NewDecoder())), nil
+	default:
+		return nil, fmt.Errorf("unknown charset %q (valid: %v)", charset, inputCharsets)
+	}
+	return transform.NewReader(r, dec), nil
+}
+
+// invalidUTF8Policies are the accepted values of --invalid-utf8.
+var invalidUTF8Policies = []string{"pass", "replace", "error"}
+
+// errInvalidUTF8 is returned for lines that aren't valid UTF-8, with
+// --invalid-utf8 error.
+var errInvalidUTF8 = errors.New("invalid UTF-8")
+
+// validUTF8 reports whether a line and its --usernames-file username are
+// valid UTF-8. Hashes are base64 or hex, so only plaintexts and usernames
+// can fail it.
+func validUTF8(line, username string) bool {
+	return utf8.ValidString(line) && utf8.ValidString(username)
+}
+
+// replaceInvalidUTF8 substitutes U+FFFD for every byte of s that isn't part
+// of a valid UTF-8 sequence, as converting it to runes does.
+func replaceInvalidUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return string([]rune(s))
+}
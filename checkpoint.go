@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// checkpointSettings are the flags that must match between the run that
+// wrote a checkpoint and the run resuming from it.
+var checkpointSettings = []string{
+	"generate", "mode", "iter", "iter-col", "subkey-length", "salt-size", "salt", "salt-sequence",
+	"username", "normalize-username", "delimiter", "output-delimiter", "null", "trim", "no-trim", "keep-cr",
+	"input", "input-charset", "input-compression", "output", "skip", "limit",
+	"unique", "unique-approx", "allow-length-mismatch", "shard", "shard-strategy",
+}
+
+// checkpoint records how far a run got. Lines counts input lines (including
+// skipped ones) whose results have been written and flushed; OutputBytes is
+// the size of the output file at that point.
+type checkpoint struct {
+	Lines       int64             `json:"lines"`
+	OutputBytes int64             `json:"output_bytes"`
+	Settings    map[string]string `json:"settings"`
+}
+
+// currentSettings collects the values of checkpointSettings for this run.
+func currentSettings() map[string]string {
+	settings := make(map[string]string, len(checkpointSettings))
+	for _, name := range checkpointSettings {
+		if flag := pflag.Lookup(name); flag != nil {
+			settings[name] = flagValue(flag)
+		}
+	}
+	return settings
+}
+
+// readCheckpoint loads the checkpoint at path.
+func readCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("parsing checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// mismatch returns an error naming every setting that differs from settings.
+func (cp *checkpoint) mismatch(settings map[string]string) error {
+	var diffs []string
+	for name, value := range settings {
+		if old, ok := cp.Settings[name]; !ok || old != value {
+			diffs = append(diffs, fmt.Sprintf("--%s (was %q, now %q)", name, cp.Settings[name], value))
+		}
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	sort.Strings(diffs)
+	return fmt.Errorf("settings differ from the checkpointed run: %s", strings.Join(diffs, ", "))
+}
+
+// write replaces the checkpoint at path. The file is written next to its
+// final location and renamed, so a crash never leaves a torn checkpoint.
+func (cp *checkpoint) write(path string) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
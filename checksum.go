@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksumTargets lists the accepted values of --checksum-of: the bytes
+// that reach the output, or the records before --output-compression.
+var checksumTargets = []string{"written", "raw"}
+
+// checksumWriter hashes the bytes written through it for --output-checksum.
+type checksumWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+	// preexisting is the number of records already in the output before
+	// this run, counted by prehash.
+	preexisting int64
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{w: w, h: sha256.New()}
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+// prehash starts the checksum with the first size bytes of the output file
+// at path, which this run resumes or appends to, so the digest covers the
+// whole file. raw hashes them decompressed, as --checksum-of raw hashes the
+// records of this run.
+func (c *checksumWriter) prehash(path string, size int64, compression string, raw bool, terminator byte) error {
+	f, err := os.Open(longPath(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stored := io.LimitReader(f, size)
+	if !raw {
+		stored = io.TeeReader(stored, c.h)
+		c.n += size
+	}
+	records := stored
+	if compression != "none" {
+		if records, _, err = decompressInput(stored, compression); err != nil {
+			return err
+		}
+	}
+	counter := &recordCounter{terminator: terminator}
+	var w io.Writer = counter
+	if raw {
+		w = io.MultiWriter(c.h, counter)
+	}
+	n, err := io.Copy(w, records)
+	if err != nil {
+		return err
+	}
+	if raw {
+		c.n += n
+	}
+	c.preexisting = counter.n
+	// Hash whatever the decompressor left unread after its last frame.
+	_, err = io.Copy(io.Discard, stored)
+	return err
+}
+
+// recordCounter counts the record terminators written to it.
+type recordCounter struct {
+	terminator byte
+	n          int64
+}
+
+func (r *recordCounter) Write(p []byte) (int, error) {
+	r.n += int64(bytes.Count(p, []byte{r.terminator}))
+	return len(p), nil
+}
+
+// checksumReport is the --output-checksum of one output.
+type checksumReport struct {
+	File   string `json:"file"` // "-" for stdout
+	Of     string `json:"of"`
+	SHA256 string `json:"sha256"`
+	Bytes  int64  `json:"bytes"`
+	Lines  int64  `json:"lines"`
+}
+
+// sidecar is the content of a sha256sum file checking the report, named
+// after the output it sits next to. A raw checksum of a compressed output
+// names the file without its compression extension, for
+// "zcat hashes.txt.gz | sha256sum" to compare against.
+func (r *checksumReport) sidecar(compressed bool) string {
+	name := filepath.Base(r.File)
+	if r.Of == "raw" && compressed {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return fmt.Sprintf("%s  %s\n", r.SHA256, name)
+}
+
+// checksumFilePath is the sidecar --checksum-sidecar writes for an output.
+func checksumFilePath(path string) string {
+	return path + ".sha256"
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// readerModes lists the accepted values of --reader.
+var readerModes = []string{"auto", "lines", "chunked", "mmap"}
+
+// chunkReaderThreshold is the input size from which --reader auto reads in
+// chunks.
+const chunkReaderThreshold = 1 << 30
+
+// chunkSize is the size chunks are cut at. A chunk always ends after a
+// record terminator, so it grows by the rest of the record cut through.
+const chunkSize = 8 << 20
+
+// chunkSource hands out a single input file in chunks of whole records, for
+// workers to split into lines themselves. next returns io.EOF after the last
+// chunk.
+type chunkSource interface {
+	next() ([]byte, error)
+	Close() error
+}
+
+// readChunks cuts chunks from a file read sequentially.
+type readChunks struct {
+	f     *os.File
+	r     *bufio.Reader
+	delim byte
+}
+
+func newReadChunks(f *os.File, delim byte) *readChunks {
+	return &readChunks{f: f, r: bufio.NewReaderSize(f, 64*1024), delim: delim}
+}
+
+func (c *readChunks) next() ([]byte, error) {
+	buf := make([]byte, chunkSize)
+	n, err := io.ReadFull(c.r, buf)
+	switch {
+	case n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF):
+		return nil, io.EOF
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return buf[:n], nil
+	case err != nil:
+		return nil, err
+	}
+	if buf[n-1] != c.delim {
+		rest, err := c.r.ReadBytes(c.delim)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = append(buf, rest...)
+	}
+	return buf, nil
+}
+
+func (c *readChunks) Close() error { return c.f.Close() }
+
+// mappedChunks cuts chunks from a memory-mapped file.
+type mappedChunks struct {
+	f      *os.File
+	data   []byte
+	off    int
+	delim  byte
+	unmap  func() error
+	closed bool
+}
+
+func (c *mappedChunks) next() ([]byte, error) {
+	if c.off >= len(c.data) {
+		return nil, io.EOF
+	}
+	end := c.off + chunkSize
+	if end >= len(c.data) {
+		end = len(c.data)
+	} else if i := bytes.IndexByte(c.data[end-1:], c.delim); i < 0 {
+		end = len(c.data)
+	} else {
+		end += i
+	}
+	chunk := c.data[c.off:end]
+	c.off = end
+	return chunk, nil
+}
+
+func (c *mappedChunks) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return errors.Join(c.unmap(), c.f.Close())
+}
+
+// openChunks opens path for --reader chunked or mmap.
+func openChunks(path, mode string, delim byte) (chunkSource, error) {
+	f, err := os.Open(longPath(path))
+	if err != nil {
+		return nil, err
+	}
+	if mode == "chunked" {
+		return newReadChunks(f, delim), nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	data, unmap, err := mapFile(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &mappedChunks{f: f, data: data, delim: delim, unmap: unmap}, nil
+}
+
+// countRecords counts the records in a chunk, including a last one without
+// a terminator.
+func countRecords(chunk []byte, delim byte) int64 {
+	n := int64(bytes.Count(chunk, []byte{delim}))
+	if len(chunk) > 0 && chunk[len(chunk)-1] != delim {
+		n++
+	}
+	return n
+}
+
+// chunkSplitter splits chunks into lines like lineReader does.
+type chunkSplitter struct {
+	delim  byte
+	limit  int
+	keepCR bool
+}
+
+// split returns the lines of chunk, the first of which is line firstLine
+// of the input.
+func (s chunkSplitter) split(chunk []byte, firstLine int64) []batchLine {
+	lines := make([]batchLine, 0, countRecords(chunk, s.delim))
+	lineNo := firstLine
+	for len(chunk) > 0 {
+		line := chunk
+		if i := bytes.IndexByte(chunk, s.delim); i >= 0 {
+			line, chunk = chunk[:i], chunk[i+1:]
+		} else {
+			chunk = nil
+		}
+		if n := len(line); n > 0 && s.delim == '\n' && !s.keepCR && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+		l := batchLine{lineNo: lineNo, fileLine: lineNo}
+		if len(line) > s.limit {
+			l.tooLong = true
+		} else {
+			l.text = string(line)
+		}
+		lines = append(lines, l)
+		lineNo++
+	}
+	return lines
+}
+
+// chooseReader picks the reader for --reader mode: "lines", or "chunked" or
+// "mmap" if the input allows reading it in chunks. blocker names what
+// needs the input read line by line, if anything. reason tells why a
+// chunked reader that was asked for isn't used.
+func chooseReader(mode string, sources []inputSource, compression, charset string, blocker string) (reader, reason string) {
+	if mode == "lines" {
+		return "lines", ""
+	}
+	switch {
+	case len(sources) != 1:
+		return "lines", "it reads a single input file"
+	case sources[0].path == "":
+		return "lines", "stdin can't be read in chunks"
+	case blocker != "":
+		return "lines", blocker + " needs the input read line by line"
+	case charset != "utf8" && charset != "auto":
+		return "lines", "the input needs decoding from " + charset
+	}
+	f, err := os.Open(longPath(sources[0].path))
+	if err != nil {
+		// Left to opening the input to report.
+		return "lines", ""
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return "lines", sources[0].name() + " is not a regular file"
+	}
+	switch compressed, utf16, err := sniffInput(f, compression, charset); {
+	case err != nil:
+		return "lines", ""
+	case compressed:
+		return "lines", sources[0].name() + " is compressed"
+	case utf16:
+		return "lines", sources[0].name() + " is UTF-16"
+	}
+	if mode == "auto" {
+		if info.Size() < chunkReaderThreshold {
+			return "lines", ""
+		}
+		mode = "mmap"
+	}
+	if mode == "mmap" && !mmapSupported {
+		return "chunked", "memory mapping isn't supported on this platform"
+	}
+	return mode, ""
+}
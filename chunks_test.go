@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChunkSplitter(t *testing.T) {
+	tests := []struct {
+		name, chunk string
+		keepCR      bool
+		want        []string
+	}{
+		{"crlf", "a\r\nb\r\n", false, []string{"a", "b"}},
+		{"keep cr", "a\r\nb\r\n", true, []string{"a\r", "b\r"}},
+		{"no final newline", "a\nb", false, []string{"a", "b"}},
+		{"empty lines", "\n\r\n\n", false, []string{"", "", ""}},
+		{"lone cr", "a\rb\n", false, []string{"a\rb"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := chunkSplitter{delim: '\n', limit: 16, keepCR: tt.keepCR}
+			lines := s.split([]byte(tt.chunk), 7)
+			if int64(len(lines)) != countRecords([]byte(tt.chunk), '\n') {
+				t.Errorf("%d lines, countRecords says %d", len(lines), countRecords([]byte(tt.chunk), '\n'))
+			}
+			var got []string
+			for i, l := range lines {
+				if l.lineNo != int64(7+i) || l.fileLine != l.lineNo {
+					t.Errorf("line %d numbered %d (file line %d)", 7+i, l.lineNo, l.fileLine)
+				}
+				got = append(got, l.text)
+			}
+			equalLines(t, got, tt.want)
+		})
+	}
+}
+
+func TestChunkSplitterLimit(t *testing.T) {
+	s := chunkSplitter{delim: '\n', limit: 4}
+	// The limit is on the line without its CRLF ending, as with lineReader.
+	lines := s.split([]byte("abcd\r\nabcde\nab"), 1)
+	if len(lines) != 3 || lines[0].tooLong || lines[0].text != "abcd" || !lines[1].tooLong || lines[1].text != "" || lines[2].text != "ab" {
+		t.Errorf("got %+v", lines)
+	}
+}
+
+// TestChunkSourcesEdges cuts files at every kind of chunk boundary, with
+// both chunk readers, and checks the lines are those lineReader reads:
+// a record cut through by the boundary, a CRLF split between two chunks,
+// a chunk ending right after a newline, and no newline at the end.
+func TestChunkSourcesEdges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes files of more than a chunk")
+	}
+	dir := t.TempDir()
+	for _, cut := range []struct {
+		name string
+		// first is the length of the first line, before its CRLF.
+		first int
+	}{
+		{"record cut", chunkSize + 3},
+		{"crlf split", chunkSize - 1},
+		{"after newline", chunkSize - 2},
+		{"before cr", chunkSize - 5},
+	} {
+		var b strings.Builder
+		b.WriteString(strings.Repeat("a", cut.first) + "\r\n")
+		for i := 0; i < 1000; i++ {
+			b.WriteString(strings.Repeat("b", i%50) + "\r\n")
+		}
+		b.WriteString("last")
+		input := b.String()
+		path := filepath.Join(dir, strings.ReplaceAll(cut.name, " ", "-"))
+		if err := os.WriteFile(path, []byte(input), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		want := readLines(t, []byte(input), 2*chunkSize, false)
+		for _, mode := range []string{"chunked", "mmap"} {
+			if mode == "mmap" && !mmapSupported {
+				continue
+			}
+			t.Run(cut.name+"/"+mode, func(t *testing.T) {
+				src, err := openChunks(path, mode, '\n')
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer src.Close()
+				s := chunkSplitter{delim: '\n', limit: 2 * chunkSize}
+				var got []string
+				var last []byte
+				chunks := 0
+				next := int64(1)
+				for {
+					chunk, err := src.next()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					if last != nil && last[len(last)-1] != '\n' {
+						t.Fatalf("the chunk before line %d doesn't end with a newline", next)
+					}
+					last = chunk
+					chunks++
+					for _, l := range s.split(chunk, next) {
+						if l.lineNo != next {
+							t.Fatalf("line %d numbered %d", next, l.lineNo)
+						}
+						got = append(got, l.text)
+						next++
+					}
+				}
+				if chunks < 2 {
+					t.Fatalf("read in %d chunk", chunks)
+				}
+				equalLines(t, got, want)
+			})
+		}
+	}
+}
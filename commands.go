@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+	"github.com/spf13/pflag"
+)
+
+// commands lists the subcommands. Running without one is the same as
+// "convert", except that the legacy -g flag switches to generating.
+var commands = []struct {
+	name    string
+	summary string
+}{
+	{"convert", "convert ASP.NET MVC4 hashes to hashcat mode 12000 (default)"},
+	{"generate", "generate ASP.NET MVC4/Web Forms hashes from plaintexts"},
+	{"verify", "check <plaintext>:<hash> lines and print the hashes that match"},
+	{"identify", "label each hash with its format and hashcat mode"},
+	{"crack", "test a wordlist against MVC4 and Identity v3 hashes and print the ones found"},
+	{"decrypt", "decrypt passwords stored encrypted (PasswordFormat 2) with the machineKey"},
+	{"show", "print <username>:<plaintext> for the accounts of a dump cracked in a hashcat --potfile"},
+	{"remove", "print the lines of a converted hash file not cracked in a hashcat --potfile yet"},
+	{"rehash", "re-hash the accounts of a dump cracked in a hashcat --potfile as Identity v3 hashes"},
+	{"diff", "compare two dumps by account: added, removed, unchanged and changed, and how"},
+	{"testvectors", "print known-good hashes of a fixed plaintext and salt for every mode"},
+	{"completion", "print a bash, zsh or fish completion script"},
+}
+
+// flagCommands lists the subcommands each command-specific flag applies to.
+// Flags not listed here are global. An empty list means the flag is only
+// accepted without a subcommand.
+var flagCommands = map[string][]string{
+	"generate":                 {},
+	"mode":                     {"convert", "generate", "verify"},
+	"username":                 {"convert", "generate", "identify", "crack", "decrypt", "show", "remove", "rehash"},
+	"delimiter":                {"convert", "generate", "verify", "identify", "crack", "decrypt", "show", "remove", "rehash", "diff"},
+	"output-delimiter":         {"convert", "generate", "decrypt", "show", "rehash"},
+	"hex-escape":               {"convert", "generate", "verify", "crack", "decrypt", "show", "rehash"},
+	"normalize-username":       {"convert", "diff"},
+	"audit":                    {"convert", "identify"},
+	"duplicate-usernames":      {"convert"},
+	"audit-policy":             {"convert", "identify"},
+	"username-collisions":      {"convert"},
+	"password":                 {"generate"},
+	"prompt":                   {"generate"},
+	"bench":                    {"generate"},
+	"bench-iters":              {"generate"},
+	"hash":                     {"convert"},
+	"salt":                     {"generate"},
+	"unique-salts":             {"generate"},
+	"reuse-salt-per-plaintext": {"generate"},
+	"also-hashcat":             {"generate"},
+	"hashcat-mode":             {"convert", "generate"},
+	"format":                   {"convert"},
+	"output-format":            {"generate"},
+	"schema":                   {"generate"},
+	"sql-table":                {"generate"},
+	"sql-users-table":          {"generate"},
+	"sql-username-column":      {"generate"},
+	"username-value":           {"generate"},
+	"usernames-file":           {"generate"},
+	"blank-usernames":          {"generate"},
+	"policy":                   {"generate"},
+	"json":                     {"testvectors", "diff", "convert"},
+	"with-source":              {"convert"},
+	"policy-warn":              {"generate"},
+	"policy-enforce":           {"generate"},
+	"random":                   {"generate"},
+	"random-charset":           {"generate"},
+	"random-length":            {"generate"},
+	"random-mask":              {"generate"},
+	"no-plain":                 {"generate"},
+	"cache-size":               {"generate"},
+	"salt-sequence":            {"generate"},
+	"iter":                     {"convert", "generate", "verify", "crack", "rehash", "diff"},
+	"iter-col":                 {"convert"},
+	"subkey-length":            {"convert", "generate", "rehash"},
+	"salt-size":                {"convert", "generate", "rehash"},
+	"allow-length-mismatch":    {"convert"},
+	"hashes":                   {"crack"},
+	"wordlist":                 {"crack"},
+	"show":                     {},
+	"remove":                   {},
+	"rehash":                   {},
+	"potfile":                  {"show", "remove", "rehash"},
+	"target-mode":              {"rehash"},
+	"unrecovered-file":         {"rehash"},
+	"map-file":                 {"convert", "show"},
+	"strip-usernames":          {"convert"},
+	"fix":                      {"convert"},
+	"fix-only":                 {"convert"},
+	"uncracked":                {"show"},
+	"validate":                 {"convert", "generate"},
+	"analyze-salts":            {"convert"},
+	"analyze-salts-exact":      {"convert"},
+	"summary":                  {"identify"},
+	"frequency":                {"convert", "generate"},
+	"top":                      {"convert", "generate"},
+	"frequency-exact":          {"convert", "generate"},
+	"sort":                     {"convert", "decrypt", "show"},
+	"sort-mem":                 {"convert", "decrypt", "show"},
+	"tmp-dir":                  {"convert", "decrypt", "show"},
+	"decryption-key":           {"decrypt"},
+	"insecure-secret-perms":    {"generate", "verify", "decrypt"},
+	"hash-algorithm":           {"generate", "verify"},
+	"validation-key":           {"generate", "verify"},
+	"decryption-algo":          {"decrypt"},
+}
+
+// flagAliases maps other names a flag is accepted by, on the command line
+// and in config files, to its own.
+var flagAliases = map[string]string{
+	"salt-seed": "salt-sequence",
+}
+
+// normalizeFlagName resolves flagAliases for pflag.
+func normalizeFlagName(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+	if alias, ok := flagAliases[name]; ok {
+		name = alias
+	}
+	return pflag.NormalizedName(name)
+}
+
+func isCommand(name string) bool {
+	for _, c := range commands {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// errMismatch is returned by verify for a plaintext that doesn't match.
+var errMismatch = errors.New("password does not match")
+
+// verifyLine checks a <plaintext><delimiter><hash> line. The hash is split
+// off at the last delimiter, so plaintexts may contain the delimiter, and
+// a $HEX[...] plaintext is decoded first.
+func verifyLine(line, delimiter string, trim bool, iterations int, mode string, keyed *aspnethash.KeyedHasher, esc hexEscaper) (string, error) {
+	i := strings.LastIndex(line, delimiter)
+	if i < 0 {
+		return "", errMissingDelimiter
+	}
+	plain, encoded := line[:i], line[i+len(delimiter):]
+	if trim {
+		encoded = strings.TrimSpace(encoded)
+	}
+	plain = esc.decode(plain)
+
+	var ok bool
+	if mode == "umbraco-legacy" {
+		var err error
+		if ok, err = aspnethash.VerifyUmbracoLegacy(plain, encoded); err != nil {
+			return "", err
+		}
+	} else if algorithm := formsAuthAlgorithm(mode); algorithm != "" {
+		hash, err := parseFormsAuth(encoded, algorithm)
+		if err != nil {
+			return "", err
+		}
+		ok = hash.Verify([]byte(plain))
+	} else if strings.Contains(encoded, ",") {
+		verify := aspnethash.Verify
+		if keyed != nil {
+			verify = keyed.Verify
+		}
+		var err error
+		if ok, err = verify(plain, encoded); err != nil {
+			return "", err
+		}
+	} else {
+		hash, err := aspnethash.ParseMVC4(encoded)
+		if err != nil {
+			return "", err
+		}
+		hash.Iterations = iterations
+		ok = hash.Verify([]byte(plain))
+	}
+	if !ok {
+		return "", errMismatch
+	}
+	return encoded + ": OK", nil
+}
+
+// identifyLine labels a line as <line>\t<format>\t<hashcat mode>\t<detail>,
+// or only counts its format if summary isn't nil.
+func identifyLine(lineNo int64, line string, usernamePresent bool, delimiter string, trim bool, summary *identifySummary, audited *audit) (string, error) {
+	_, encoded, err := splitUsername(line, usernamePresent, delimiter, trim)
+	if err != nil {
+		return "", err
+	}
+	d := detectHash(encoded, delimiter, aspnethash.DefaultIterations)
+	if audited != nil {
+		algorithm, iterations := detectionScheme(d)
+		audited.observe(lineNo, algorithm, iterations)
+	}
+	if summary != nil {
+		summary.add(d)
+		return "", nil
+	}
+	detail := d.detail
+	if alternatives := alternativeDialects(d.digest.algorithm); len(alternatives) > 0 {
+		detail += "; also -m " + strings.Join(alternatives, ", ")
+	}
+	return line + "\t" + d.format + "\t" + d.hashcatMode + "\t" + detail, nil
+}
+
+// identifySummary counts the formats identify --summary found. It is safe
+// for concurrent use.
+type identifySummary struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func newIdentifySummary() *identifySummary {
+	return &identifySummary{counts: map[string]int64{}}
+}
+
+func (s *identifySummary) add(d detection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[d.format+"\t"+d.hashcatMode]++
+}
+
+// records returns a <count>\t<format>\t<hashcat mode> record per format,
+// most common first.
+func (s *identifySummary) records(recordEnd string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]countEntry, 0, len(s.counts))
+	for name, n := range s.counts {
+		entries = append(entries, countEntry{Name: name, Count: n})
+	}
+	sortByCount(entries)
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(strconv.FormatInt(e.Count, 10) + "\t" + e.Name + recordEnd)
+	}
+	return b.String()
+}
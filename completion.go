@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+	"github.com/spf13/pflag"
+)
+
+// completionShells lists the shells the completion command supports.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// flagChoices returns the accepted values of flags that take one of a fixed
+// set, from the same tables the values are validated against.
+func flagChoices(name string) []string {
+	switch name {
+	case "mode":
+		return hashModes
+	case "input-charset":
+		return inputCharsets
+	case "input-compression":
+		return inputCompressions
+	case "output-compression":
+		return outputCompressions
+	case "output-line-ending":
+		return lineEndings
+	case "split-by":
+		return shardModes
+	case "shard-strategy":
+		return inputShardStrategies
+	case "checksum-of":
+		return checksumTargets
+	case "unique-output-scope":
+		return uniqueOutputScopes
+	case "hash-algorithm":
+		return hashAlgorithms
+	case "reader":
+		return readerModes
+	case "sort":
+		return sortKeys
+	case "hashcat-mode":
+		return hashcatModes()
+	case "format":
+		return outputFormats
+	case "output-format":
+		return outputFormatsGenerate
+	case "schema":
+		return sqlSchemaNames()
+	case "log-format":
+		return logFormats
+	case "invalid-utf8":
+		return invalidUTF8Policies
+	case "blank-usernames":
+		return blankUsernamePolicies
+	case "duplicate-usernames":
+		return duplicateUsernamePolicies
+	case "decryption-algo":
+		return aspnethash.DecryptionAlgorithms
+	case "target-mode":
+		return rehashTargets
+	}
+	return nil
+}
+
+// fileFlags and dirFlags take a path to complete.
+var (
+	fileFlags = []string{"config", "input", "files-from", "web-config", "output", "error-file", "stats-json", "checkpoint", "cpu-profile", "mem-profile", "hashes", "wordlist", "potfile", "map-file", "also-hashcat", "usernames-file", "unrecovered-file"}
+	dirFlags  = []string{"profiles-dir", "tmp-dir"}
+)
+
+// completionFlag is what the completion scripts need to know about a flag.
+type completionFlag struct {
+	name, shorthand string
+	usage           string
+	takesValue      bool
+	repeatable      bool
+	commands        []string // nil if global
+	global          bool
+}
+
+// appliesTo reports whether the flag is accepted by command ("" meaning no
+// subcommand).
+func (f completionFlag) appliesTo(command string) bool {
+	return f.global || command == "" || slices.Contains(f.commands, command)
+}
+
+// completionFlags collects the flags of flags in name order.
+func completionFlags(flags *pflag.FlagSet) []completionFlag {
+	var out []completionFlag
+	flags.VisitAll(func(flag *pflag.Flag) {
+		cmds, scoped := flagCommands[flag.Name]
+		out = append(out, completionFlag{
+			name:       flag.Name,
+			shorthand:  flag.Shorthand,
+			usage:      strings.TrimPrefix(flag.Usage, "[ADVANCED] "),
+			takesValue: flag.NoOptDefVal == "",
+			repeatable: flag.Value.Type() == "count",
+			commands:   cmds,
+			global:     !scoped,
+		})
+	})
+	return out
+}
+
+// writeCompletion writes the completion script for shell, completing the
+// subcommands and the flags of flags for the program prog.
+func writeCompletion(w io.Writer, shell, prog string, flags *pflag.FlagSet) error {
+	all := completionFlags(flags)
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, prog, all)
+	case "zsh":
+		writeZshCompletion(w, prog, all)
+	case "fish":
+		writeFishCompletion(w, prog, all)
+	default:
+		return fmt.Errorf("unknown shell %q (valid: %v)", shell, completionShells)
+	}
+	return nil
+}
+
+// commandNames returns the subcommand names.
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, c := range commands {
+		names[i] = c.name
+	}
+	return names
+}
+
+// shellFunction turns prog into a name usable as a shell function.
+func shellFunction(prog string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, prog)
+}
+
+func writeBashCompletion(w io.Writer, prog string, all []completionFlag) {
+	fn := shellFunction(prog)
+	fmt.Fprintf(w, "# bash completion for %s\n", prog)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd=\n")
+	fmt.Fprintf(w, "    if (( COMP_CWORD > 1 )); then\n")
+	fmt.Fprintf(w, "        case ${COMP_WORDS[1]} in\n")
+	fmt.Fprintf(w, "            %s) cmd=${COMP_WORDS[1]} ;;\n", strings.Join(commandNames(), "|"))
+	fmt.Fprintf(w, "        esac\n")
+	fmt.Fprintf(w, "    fi\n")
+	fmt.Fprintf(w, "    if [[ $cmd == completion ]]; then\n")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(completionShells, " "))
+	fmt.Fprintf(w, "        return\n")
+	fmt.Fprintf(w, "    fi\n")
+
+	fmt.Fprintf(w, "    case $prev in\n")
+	var noCompletion []string
+	for _, f := range all {
+		if !f.takesValue {
+			continue
+		}
+		pattern := "--" + f.name
+		if f.shorthand != "" {
+			pattern += "|-" + f.shorthand
+		}
+		switch {
+		case flagChoices(f.name) != nil:
+			fmt.Fprintf(w, "        %s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", pattern, strings.Join(flagChoices(f.name), " "))
+		case slices.Contains(fileFlags, f.name):
+			fmt.Fprintf(w, "        %s) compopt -o filenames; COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", pattern)
+		case slices.Contains(dirFlags, f.name):
+			fmt.Fprintf(w, "        %s) compopt -o filenames; COMPREPLY=($(compgen -d -- \"$cur\")); return ;;\n", pattern)
+		default:
+			noCompletion = append(noCompletion, pattern)
+		}
+	}
+	if len(noCompletion) > 0 {
+		fmt.Fprintf(w, "        %s) return ;;\n", strings.Join(noCompletion, "|"))
+	}
+	fmt.Fprintf(w, "    esac\n")
+
+	fmt.Fprintf(w, "    if (( COMP_CWORD == 1 )) && [[ $cur != -* ]]; then\n")
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(commandNames(), " "))
+	fmt.Fprintf(w, "        return\n")
+	fmt.Fprintf(w, "    fi\n")
+	fmt.Fprintf(w, "    local flags\n")
+	fmt.Fprintf(w, "    case $cmd in\n")
+	for _, c := range commands {
+		if c.name == "completion" {
+			continue
+		}
+		fmt.Fprintf(w, "        %s) flags=%q ;;\n", c.name, bashFlagWords(all, c.name))
+	}
+	fmt.Fprintf(w, "        *) flags=%q ;;\n", bashFlagWords(all, ""))
+	fmt.Fprintf(w, "    esac\n")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -o default -F %s %s\n", fn, prog)
+}
+
+// bashFlagWords returns the long flags accepted by command.
+func bashFlagWords(all []completionFlag, command string) string {
+	var words []string
+	for _, f := range all {
+		if f.appliesTo(command) {
+			words = append(words, "--"+f.name)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func writeZshCompletion(w io.Writer, prog string, all []completionFlag) {
+	fn := shellFunction(prog)
+	fmt.Fprintf(w, "#compdef %s\n\n", prog)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "  local -a commands args\n")
+	fmt.Fprintf(w, "  commands=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "    %s\n", zshQuote(c.name+":"+c.summary))
+	}
+	fmt.Fprintf(w, "  )\n")
+	fmt.Fprintf(w, "  if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then\n")
+	fmt.Fprintf(w, "    _describe command commands\n")
+	fmt.Fprintf(w, "    return\n")
+	fmt.Fprintf(w, "  fi\n")
+	fmt.Fprintf(w, "  local cmd=\n")
+	fmt.Fprintf(w, "  case $words[2] in\n")
+	fmt.Fprintf(w, "    %s)\n", strings.Join(commandNames(), "|"))
+	fmt.Fprintf(w, "      cmd=$words[2]\n")
+	fmt.Fprintf(w, "      shift words\n")
+	fmt.Fprintf(w, "      (( CURRENT-- )) ;;\n")
+	fmt.Fprintf(w, "  esac\n")
+	fmt.Fprintf(w, "  case $cmd in\n")
+	fmt.Fprintf(w, "    completion) _values shell %s; return ;;\n", strings.Join(completionShells, " "))
+	for _, c := range commands {
+		if c.name == "completion" {
+			continue
+		}
+		fmt.Fprintf(w, "    %s) args=(\n", c.name)
+		writeZshSpecs(w, all, c.name)
+		fmt.Fprintf(w, "    ) ;;\n")
+	}
+	fmt.Fprintf(w, "    *) args=(\n")
+	writeZshSpecs(w, all, "")
+	fmt.Fprintf(w, "    ) ;;\n")
+	fmt.Fprintf(w, "  esac\n")
+	fmt.Fprintf(w, "  _arguments -s $args\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "compdef %s %s\n", fn, prog)
+}
+
+// writeZshSpecs writes the _arguments specs of the flags accepted by command.
+func writeZshSpecs(w io.Writer, all []completionFlag, command string) {
+	for _, f := range all {
+		if !f.appliesTo(command) {
+			continue
+		}
+		desc := "[" + zshEscape(f.usage) + "]"
+		action := ""
+		if f.takesValue {
+			switch {
+			case flagChoices(f.name) != nil:
+				action = ":" + f.name + ":(" + strings.Join(flagChoices(f.name), " ") + ")"
+			case slices.Contains(fileFlags, f.name):
+				action = ":file:_files"
+			case slices.Contains(dirFlags, f.name):
+				action = ":directory:_files -/"
+			default:
+				action = ":" + f.name + ":"
+			}
+		}
+		long, short := "--"+f.name, "-"+f.shorthand
+		if f.takesValue {
+			long, short = long+"=", short+"+"
+		}
+		prefix := ""
+		if f.repeatable {
+			prefix = "*"
+		}
+		if f.shorthand == "" {
+			fmt.Fprintf(w, "      %s\n", zshQuote(prefix+long+desc+action))
+			continue
+		}
+		exclusive := ""
+		if !f.repeatable {
+			exclusive = "(-" + f.shorthand + " --" + f.name + ")"
+		}
+		fmt.Fprintf(w, "      %s{%s,%s}%s\n", zshQuote(exclusive+prefix), short, long, zshQuote(desc+action))
+	}
+}
+
+// zshEscape escapes the characters _arguments treats specially in a
+// description.
+func zshEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+// zshQuote single-quotes s for zsh.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func writeFishCompletion(w io.Writer, prog string, all []completionFlag) {
+	names := strings.Join(commandNames(), " ")
+	noCommand := "not __fish_seen_subcommand_from " + names
+	fmt.Fprintf(w, "# fish completion for %s\n", prog)
+	fmt.Fprintf(w, "complete -c %s -f\n", prog)
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c %s -n %s -a %s -d %s\n", prog, fishQuote("__fish_use_subcommand"), c.name, fishQuote(c.summary))
+	}
+	fmt.Fprintf(w, "complete -c %s -n %s -a %s\n", prog, fishQuote("__fish_seen_subcommand_from completion"), fishQuote(strings.Join(completionShells, " ")))
+	for _, f := range all {
+		line := "complete -c " + prog
+		if !f.global {
+			cond := noCommand
+			if len(f.commands) > 0 {
+				cond = "__fish_seen_subcommand_from " + strings.Join(f.commands, " ") + "; or " + noCommand
+			}
+			line += " -n " + fishQuote(cond)
+		}
+		if f.shorthand != "" {
+			line += " -s " + f.shorthand
+		}
+		line += " -l " + f.name
+		if f.takesValue {
+			switch {
+			case flagChoices(f.name) != nil:
+				line += " -x -a " + fishQuote(strings.Join(flagChoices(f.name), " "))
+			case slices.Contains(fileFlags, f.name):
+				line += " -r -F"
+			case slices.Contains(dirFlags, f.name):
+				line += " -x -a " + fishQuote("(__fish_complete_directories)")
+			default:
+				line += " -x"
+			}
+		}
+		fmt.Fprintf(w, "%s -d %s\n", line, fishQuote(f.usage))
+	}
+}
+
+// fishQuote single-quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// inputCompressions lists the accepted values of --input-compression.
+var inputCompressions = []string{"auto", "none", "gzip", "zstd"}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressInput wraps r in a decompressor for the given compression. With
+// "auto" the compression is detected from the magic bytes at the start of the
+// stream. The returned name is the compression actually used.
+func decompressInput(r io.Reader, compression string) (io.Reader, string, error) {
+	if compression == "auto" {
+		br := bufio.NewReader(r)
+		magic, _ := br.Peek(len(zstdMagic))
+		switch {
+		case bytes.HasPrefix(magic, gzipMagic):
+			compression = "gzip"
+		case bytes.HasPrefix(magic, zstdMagic):
+			compression = "zstd"
+		default:
+			compression = "none"
+		}
+		r = br
+	}
+
+	switch compression {
+	case "none":
+		return r, compression, nil
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, compression, fmt.Errorf("opening gzip stream: %w", err)
+		}
+		return zr, compression, nil
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, compression, fmt.Errorf("opening zstd stream: %w", err)
+		}
+		return zr.IOReadCloser(), compression, nil
+	default:
+		return nil, compression, fmt.Errorf("unknown compression %q (valid: %v)", compression, inputCompressions)
+	}
+}
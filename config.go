@@ -0,0 +1,405 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// envPrefix starts the names of the environment variables that set flags.
+const envPrefix = "ASPNETHASHTOOL_"
+
+// envExcluded lists flags that can't be set from the environment, as they
+// would turn every run into a help or version run.
+var envExcluded = map[string]bool{
+	"help":          true,
+	"advanced-help": true,
+	"version":       true,
+}
+
+// Sources of flag values, for --print-config.
+const (
+	sourceDefault     = "default"
+	sourceCommandLine = "command line"
+	sourceEnv         = "environment"
+	sourceProfile     = "profile"
+	sourceWebConfig   = "web.config"
+	sourceConfig      = "config file"
+)
+
+// envName is the environment variable of a flag: --max-workers is set by
+// ASPNETHASHTOOL_MAX_WORKERS.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// applyEnv sets every flag of fs that wasn't given on the command line from
+// its environment variable, if that is set. It returns the names of the
+// flags it set.
+func applyEnv(fs *pflag.FlagSet) (map[string]bool, error) {
+	set := map[string]bool{}
+	var err error
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || envExcluded[flag.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envName(flag.Name))
+		if !ok {
+			return
+		}
+		if setErr := setFlagValue(flag, value); setErr != nil {
+			err = fmt.Errorf("%s: invalid value for --%s: %w", envName(flag.Name), flag.Name, setErr)
+			return
+		}
+		set[flag.Name] = true
+	})
+	return set, err
+}
+
+// configEntry is one key of a config file.
+type configEntry struct {
+	key   string
+	value string
+	line  int
+}
+
+// defaultConfigPaths are the config files loaded when --config isn't
+// given, the first one that exists.
+func defaultConfigPaths() []string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil
+	}
+	dir = filepath.Join(dir, "aspnethashtool")
+	return []string{filepath.Join(dir, "config.yaml"), filepath.Join(dir, "config.yml"), filepath.Join(dir, "config.toml")}
+}
+
+// findDefaultConfig returns the default config file, or "" if there is
+// none.
+func findDefaultConfig() string {
+	for _, path := range defaultConfigPaths() {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// readConfig reads a config file of flat key/value pairs, keyed by long
+// flag name, as TOML if its name ends in .toml and as YAML otherwise.
+func readConfig(path string) ([]configEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	var entries []configEntry
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		entries, err = parseTOMLConfig(text)
+	} else {
+		entries, err = parseYAMLConfig(text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := map[string]int{}
+	for _, e := range entries {
+		if first, ok := seen[e.key]; ok {
+			return nil, fmt.Errorf("%s: line %d: %q is already set on line %d", path, e.line, e.key, first)
+		}
+		seen[e.key] = e.line
+	}
+	return entries, nil
+}
+
+// applyConfig sets the flags of the config file at path that weren't given
+// on the command line or by a profile. Keys that aren't flags of any
+// command are an error; keys of other commands are ignored. It returns the
+// names of the flags it set.
+func applyConfig(path string, known func(name string) bool) (map[string]bool, error) {
+	entries, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	set := map[string]bool{}
+	for _, e := range entries {
+		if !known(e.key) || profileExcluded[e.key] {
+			return nil, fmt.Errorf("%s: line %d: unknown option %q", path, e.line, e.key)
+		}
+		flag := pflag.Lookup(e.key)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		if err := setFlagValue(flag, e.value); err != nil {
+			return nil, fmt.Errorf("%s: line %d: invalid value for --%s: %w", path, e.line, e.key, err)
+		}
+		set[e.key] = true
+	}
+	return set, nil
+}
+
+// printConfig writes the value of every flag of the command as a YAML
+// config file, secrets redacted, each with a comment naming its source from
+// sources (the default if it isn't there).
+func printConfig(w io.Writer, sources map[string]string) {
+	var names []string
+	pflag.VisitAll(func(flag *pflag.Flag) {
+		if !profileExcluded[flag.Name] {
+			names = append(names, flag.Name)
+		}
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		source := sources[name]
+		if source == "" {
+			source = sourceDefault
+		}
+		fmt.Fprintf(w, "%s: %s # %s\n", name, yamlScalar(redactedFlagValue(pflag.Lookup(name))), source)
+	}
+}
+
+// yamlScalar quotes s if it wouldn't read back as the same plain scalar.
+func yamlScalar(s string) string {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s, "#:\"'\\\t\n[]{},&*!|>%@`") || s == "~" || s == "null" {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+var errNestedConfig = errors.New("nested values aren't supported; keys are long flag names")
+
+// parseYAMLConfig parses the flat subset of YAML a config file needs:
+// "key: value" lines with plain, quoted or [flow] list values, block lists
+// of "- item" lines, and comments.
+func parseYAMLConfig(text string) ([]configEntry, error) {
+	var entries []configEntry
+	// list is the entry whose value is an indented block list, if any.
+	var list *configEntry
+	var items []string
+	endList := func() {
+		// A key without a value or items is null, and left at its default.
+		if list != nil && items != nil {
+			list.value = strings.Join(items, ",")
+			entries = append(entries, *list)
+		}
+		list, items = nil, nil
+	}
+	for i, raw := range strings.Split(text, "\n") {
+		lineNo := i + 1
+		line := strings.TrimRight(stripConfigComment(raw), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(line, "- ") {
+			item, ok := strings.CutPrefix(trimmed, "- ")
+			if !ok && trimmed == "-" {
+				item, ok = "", true
+			}
+			if list == nil || !ok {
+				return nil, fmt.Errorf("line %d: %w", lineNo, errNestedConfig)
+			}
+			value, err := yamlValue(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			items = append(items, value)
+			continue
+		}
+		endList()
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNo)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if unquoted, err := yamlValue(key); err == nil {
+			key = unquoted
+		}
+		if value == "" {
+			list = &configEntry{key: key, line: lineNo}
+			continue
+		}
+		if value == "~" || value == "null" {
+			// Left at its default.
+			continue
+		}
+		var err error
+		if strings.HasPrefix(value, "[") {
+			value, err = flowList(value, yamlValue)
+		} else if strings.HasPrefix(value, "{") || strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			err = errNestedConfig
+		} else {
+			value, err = yamlValue(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		entries = append(entries, configEntry{key: key, value: value, line: lineNo})
+	}
+	endList()
+	return entries, nil
+}
+
+// yamlValue unquotes a single or double quoted YAML scalar, or returns a
+// plain one as is.
+func yamlValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid quoted value %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
+
+// parseTOMLConfig parses the flat subset of TOML a config file needs:
+// "key = value" lines with string, number, boolean or single-line array
+// values, and comments. Tables are rejected.
+func parseTOMLConfig(text string) ([]configEntry, error) {
+	var entries []configEntry
+	for i, raw := range strings.Split(text, "\n") {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripConfigComment(raw))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables aren't supported; keys are long flag names", lineNo)
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if strings.HasPrefix(key, `"`) || strings.HasPrefix(key, "'") {
+			var err error
+			if key, err = tomlValue(key); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		} else if strings.Contains(key, ".") {
+			return nil, fmt.Errorf("line %d: dotted keys aren't supported; keys are long flag names", lineNo)
+		}
+		var err error
+		if strings.HasPrefix(value, "[") {
+			value, err = flowList(value, tomlValue)
+		} else {
+			value, err = tomlValue(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		entries = append(entries, configEntry{key: key, value: value, line: lineNo})
+	}
+	return entries, nil
+}
+
+// tomlValue unquotes a TOML basic or literal string. Numbers and booleans
+// are returned as written, for the flag to parse.
+func tomlValue(s string) (string, error) {
+	switch {
+	case s == "":
+		return "", errors.New("missing value")
+	case strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''"):
+		return "", errors.New("multi-line strings aren't supported")
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case strings.HasPrefix(s, "{"):
+		return "", errors.New("inline tables aren't supported; keys are long flag names")
+	}
+	return s, nil
+}
+
+// flowList parses a single-line [a, b, "c"] list into the comma separated
+// form list flags take.
+func flowList(s string, unquote func(string) (string, error)) (string, error) {
+	if !strings.HasSuffix(s, "]") {
+		return "", errors.New("lists must close on the same line")
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	var items []string
+	for inner != "" {
+		var item string
+		if inner[0] == '"' || inner[0] == '\'' {
+			end := closingQuote(inner)
+			if end < 0 {
+				return "", fmt.Errorf("invalid list %s", s)
+			}
+			item, inner = inner[:end+1], inner[end+1:]
+		} else {
+			item, inner, _ = strings.Cut(inner, ",")
+			inner = "," + inner
+		}
+		value, err := unquote(strings.TrimSpace(item))
+		if err != nil {
+			return "", err
+		}
+		items = append(items, value)
+		inner = strings.TrimSpace(inner)
+		if inner != "" && inner[0] != ',' {
+			return "", fmt.Errorf("invalid list %s", s)
+		}
+		inner = strings.TrimSpace(strings.TrimPrefix(inner, ","))
+	}
+	return strings.Join(items, ","), nil
+}
+
+// closingQuote returns the index of the quote closing the string s starts
+// with, or -1.
+func closingQuote(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripConfigComment drops a # comment that isn't inside quotes. A quote
+// only starts a quoted value at the start of a token, so apostrophes in
+// plain values are left alone.
+func stripConfigComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t:=[,", line[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+// useUTF8Console does nothing: terminals elsewhere take the UTF-8 the tool
+// writes as it is.
+func useUTF8Console() {}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+// cpUTF8 is the UTF-8 code page.
+const cpUTF8 = 65001
+
+var (
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleOutputCP = kernel32.NewProc("GetConsoleOutputCP")
+	procSetConsoleOutputCP = kernel32.NewProc("SetConsoleOutputCP")
+)
+
+// useUTF8Console switches the console the process is attached to, if any,
+// to the UTF-8 output code page. Writes to the console itself go through
+// the Unicode console API already, but output redirected to a program on
+// the same console, such as more, findstr or a PowerShell pipeline, is
+// read back in the console's code page, which garbles non-ASCII usernames
+// and plaintexts in a legacy one. The code page stays set after the run,
+// as with chcp 65001.
+func useUTF8Console() {
+	// GetConsoleOutputCP returns 0 without a console.
+	if cp, _, _ := procGetConsoleOutputCP.Call(); cp != 0 && cp != cpUTF8 {
+		procSetConsoleOutputCP.Call(cpUTF8)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// crackTarget is one hash of the --hashes file.
+type crackTarget struct {
+	label  string // printed for a match: the username, or the hash as given
+	subkey []byte
+	found  int32
+}
+
+// crackGroup holds the targets that share salt and parameters, so every
+// candidate is derived once per group rather than once per target.
+type crackGroup struct {
+	params    aspnethash.Hash // PRF, salt, iterations and subkey length
+	targets   []*crackTarget
+	remaining int64
+}
+
+// cracker tests candidates against the hashes that haven't been found yet.
+// It is safe for concurrent use.
+type cracker struct {
+	groups    []*crackGroup
+	targets   int
+	remaining int64
+}
+
+// loadCracker reads the hashes to crack from path, one per line: MVC4 or
+// Identity v3 hashes, or hashcat lines as written by convert, optionally
+// prefixed with a username. MVC4 hashes are assumed to use iterations.
+func loadCracker(path string, usernamePresent bool, delimiter string, iterations int) (*cracker, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &cracker{}
+	groups := map[string]*crackGroup{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), defaultMaxLineBytes)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		label, encoded := line, line
+		if usernamePresent {
+			var ok bool
+			if label, encoded, ok = strings.Cut(line, delimiter); !ok {
+				return nil, fmt.Errorf("%s line %d: %w", path, lineNo, errMissingDelimiter)
+			}
+		}
+		hash, err := aspnethash.Parse(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, lineNo, err)
+		}
+		if hash.Version != 0x01 && !strings.Contains(encoded, ":") {
+			hash.Iterations = iterations
+		}
+		if len(hash.Subkey) == 0 {
+			return nil, fmt.Errorf("%s line %d: %w", path, lineNo, aspnethash.ErrTooShort)
+		}
+
+		key := hash.PRF.String() + ":" + strconv.Itoa(hash.Iterations) + ":" +
+			base64.StdEncoding.EncodeToString(hash.Salt) + ":" + strconv.Itoa(len(hash.Subkey))
+		g := groups[key]
+		if g == nil {
+			g = &crackGroup{params: hash}
+			groups[key] = g
+			c.groups = append(c.groups, g)
+		}
+		g.targets = append(g.targets, &crackTarget{label: label, subkey: hash.Subkey})
+		g.remaining++
+		c.targets++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	c.remaining = int64(c.targets)
+	return c, nil
+}
+
+// try derives candidate for every group with hashes left and returns a
+// <label>:<candidate> record per hash it matches, joined by sep. Found hashes
+// aren't tested again.
+func (c *cracker) try(candidate, sep string, esc hexEscaper) string {
+	var matches []string
+	for _, g := range c.groups {
+		if atomic.LoadInt64(&g.remaining) == 0 {
+			continue
+		}
+		subkey := g.params.Derive([]byte(candidate))
+		for _, t := range g.targets {
+			if atomic.LoadInt32(&t.found) == 0 && subtle.ConstantTimeCompare(subkey, t.subkey) == 1 &&
+				atomic.CompareAndSwapInt32(&t.found, 0, 1) {
+				atomic.AddInt64(&g.remaining, -1)
+				atomic.AddInt64(&c.remaining, -1)
+				matches = append(matches, t.label+":"+esc.escape(candidate))
+			}
+		}
+	}
+	return strings.Join(matches, sep)
+}
+
+// cracked returns the number of hashes found so far.
+func (c *cracker) cracked() int64 {
+	return int64(c.targets) - atomic.LoadInt64(&c.remaining)
+}
+
+// done reports whether every hash has been found.
+func (c *cracker) done() bool {
+	return atomic.LoadInt64(&c.remaining) == 0
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// readKeyArg decodes the hex key of flag, given on the command line or read
+// from the file, descriptor or environment variable it names, as by
+// readSecret.
+func readKeyArg(flag, arg string, insecurePerms bool) ([]byte, error) {
+	arg, err := readSecret(flag, arg, insecurePerms)
+	if err != nil {
+		return nil, err
+	}
+	arg = strings.TrimSpace(arg)
+	if strings.HasPrefix(strings.ToLower(arg), "autogenerate") {
+		return nil, aspnethash.ErrAutoGenerateKey
+	}
+	return hex.DecodeString(arg)
+}
+
+// decryptLine decrypts the password of a <username><delimiter><password>
+// line, or of a line that is only the encrypted password, and returns
+// <username><outputDelimiter><plaintext> or just the plaintext.
+func decryptLine(line string, usernamePresent bool, delimiter, outputDelimiter string, trim bool, c *aspnethash.PasswordCipher, esc hexEscaper) (string, error) {
+	username, encoded, err := splitUsername(line, usernamePresent, delimiter, trim)
+	if err != nil {
+		return "", err
+	}
+	plain, err := c.Decrypt(encoded)
+	if err != nil {
+		return "", err
+	}
+	plain = esc.escape(plain)
+	if usernamePresent {
+		return esc.escape(username) + outputDelimiter + plain, nil
+	}
+	return plain, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// detection is what detectHash makes of a hash. identify prints it and
+// convert --mode auto writes its hashcat field, so the two always agree.
+type detection struct {
+	format string
+	// hashcatMode is "-" if hashcat has no mode for the hash.
+	hashcatMode string
+	// detail holds what else is known, like the iteration count of an
+	// Identity v3 hash, or "-".
+	detail string
+	// hashcat is the hash as hashcatMode takes it, or empty.
+	hashcat string
+	// digest is the hash for other hashcat dialects; its algorithm is
+	// empty if hashcat has no mode for the hash.
+	digest hashDigest
+}
+
+// detected describes a hash hashcat can take, in the default dialect of
+// its algorithm.
+func detected(format, detail string, h hashDigest) detection {
+	d := detection{format: format, hashcatMode: "-", detail: detail, digest: h}
+	if dialect := defaultDialect(h.algorithm); dialect != nil {
+		if line, err := dialect.format(h); err == nil {
+			d.hashcatMode, d.hashcat = dialect.mode, line
+		}
+	}
+	return d
+}
+
+// undetected describes a hash hashcat has no mode for.
+func undetected(format, detail string) detection {
+	return detection{format: format, hashcatMode: "-", detail: detail}
+}
+
+// hexAlgorithms maps the length of unsalted hex digests to their likely
+// algorithm.
+var hexAlgorithms = map[int]string{
+	32:  algoMD5,
+	40:  algoSHA1,
+	64:  algoSHA256,
+	128: algoSHA512,
+}
+
+// digestAlgorithms maps the length of unsalted binary digests to their
+// likely algorithm.
+var digestAlgorithms = map[int]string{
+	16: algoMD5,
+	20: algoSHA1,
+}
+
+// detectHash names the format of an encoded hash, a "hash,salt" pair or a
+// hash and salt in separate --delimiter columns. MVC4 hashes are given
+// iterations, which they don't store.
+func detectHash(encoded, delimiter string, iterations int) detection {
+	if strings.Count(encoded, ":") == 3 {
+		if hash, err := aspnethash.ParseHashcat(encoded); err == nil {
+			return detected("hashcat", fmt.Sprintf("prf=%s iterations=%d", hash.PRF, hash.Iterations), pbkdf2Digest(hash))
+		}
+	}
+	if hash, salt, ok := strings.Cut(encoded, ","); ok {
+		return detectPair("webforms", hash, salt)
+	}
+	if hash, salt, ok := strings.Cut(encoded, delimiter); ok && delimiter != "" {
+		return detectPair("webforms-columns", hash, salt)
+	}
+
+	// FormsAuthentication wrote uppercase hex; other tools mostly write
+	// lowercase.
+	if isUpperHex(encoded) {
+		if hash, err := aspnethash.ParseFormsAuth(encoded); err == nil {
+			return detected("formsauth-"+hash.Algorithm, "unsalted, FormsAuthentication", hashDigest{algorithm: hash.Algorithm, digest: hash.Digest})
+		}
+	}
+	if algorithm, ok := hexAlgorithms[len(encoded)]; ok && isHex(encoded) {
+		digest, _ := hex.DecodeString(encoded)
+		return detected(algorithm, "unsalted", hashDigest{algorithm: algorithm, digest: digest})
+	}
+	if len(encoded)%2 == 0 && isHex(encoded) {
+		return undetected("hex", fmt.Sprintf("%d bytes", len(encoded)/2))
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(decoded) == 0 {
+		return undetected("unknown", "-")
+	}
+	switch {
+	case decoded[0] == 0x00 && len(decoded) == 1+aspnethash.DefaultSaltSize+aspnethash.DefaultSubkeyLength:
+		hash, _ := aspnethash.ParseMVC4Sized(encoded, aspnethash.DefaultSaltSize, aspnethash.DefaultSubkeyLength)
+		hash.Iterations = iterations
+		return detected("mvc4", "identity v2", pbkdf2Digest(hash))
+	case decoded[0] == 0x01:
+		if hash, err := aspnethash.ParseIdentityV3(encoded); err == nil {
+			return detected("identity-v3", fmt.Sprintf("prf=%s iterations=%d", hash.PRF, hash.Iterations), pbkdf2Digest(hash))
+		}
+	}
+	if algorithm, ok := digestAlgorithms[len(decoded)]; ok {
+		return detected(algorithm, "unsalted, base64", hashDigest{algorithm: algorithm, digest: decoded})
+	}
+	if len(decoded) == 32 && aspnethash.IsUmbracoLegacy(encoded) {
+		return undetected("umbraco-legacy", "unsalted HMAC-SHA256")
+	}
+	return undetected("unknown", fmt.Sprintf("%d bytes", len(decoded)))
+}
+
+// detectPair tells the Web Forms and DNN hashes stored as a base64 hash and
+// salt apart by the length of the hash.
+func detectPair(format, encodedHash, encodedSalt string) detection {
+	digest, hashErr := base64.StdEncoding.DecodeString(encodedHash)
+	salt, saltErr := base64.StdEncoding.DecodeString(encodedSalt)
+	if hashErr != nil || saltErr != nil || len(digest) == 0 {
+		return undetected("unknown", "-")
+	}
+	switch {
+	case len(digest) == 20:
+		// SqlMembershipProvider's SHA1, which DNN uses.
+		return detected(format, "sha1, --hex-salt (DNN)", hashDigest{algorithm: algoSaltedSHA1, digest: digest, salt: salt})
+	case len(digest) == 32:
+		return detected(format, "sha256, --hex-salt (or HMACSHA256 keyed with the validationKey)", hashDigest{algorithm: algoSaltedSHA256, digest: digest, salt: salt})
+	case len(digest) == len(salt)+32 && string(digest[:len(salt)]) == string(salt):
+		// The salt followed by the unsalted SHA256 of the password, as
+		// generate --mode webforms writes them.
+		return detected(format, "salt+sha256", hashDigest{algorithm: algoSHA256, digest: digest[len(salt):]})
+	case len(digest) == 64:
+		return undetected(format, "HMACSHA512 keyed with the validationKey")
+	}
+	return undetected(format, strconv.Itoa(len(digest))+"-byte hash")
+}
+
+// generatedHashcat returns the hashcat line of a hash generate wrote in
+// mode, in dialect or the default one. MVC4 hashes don't store their
+// iteration count and may have a non-default subkey length, so they are
+// parsed with the ones used.
+func generatedHashcat(encoded, mode string, iterations, subkeyLength int, dialect *hashcatDialect) (string, error) {
+	if mode == "mvc4" {
+		hash, err := aspnethash.ParseMVC4Sized(encoded, aspnethash.DefaultSaltSize, subkeyLength)
+		if err != nil {
+			return "", err
+		}
+		hash.Iterations = iterations
+		return hashcatLine(pbkdf2Digest(hash), dialect)
+	}
+	d := detectHash(encoded, ",", iterations)
+	if d.hashcat == "" {
+		return "", fmt.Errorf("%w: hashcat has no mode for %s (%s)", aspnethash.ErrUnsupportedFormat, d.format, d.detail)
+	}
+	return hashcatLine(d.digest, dialect)
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// convertAuto converts a hash of any format detectHash knows a hashcat mode
+// for, in dialect if it isn't nil.
+func convertAuto(line string, usernamePresent bool, delimiter, outputDelimiter string, trim bool, iterations int, normalize usernameNormalizer, dialect *hashcatDialect) (string, error) {
+	username, encoded, err := splitUsername(line, usernamePresent, delimiter, trim)
+	if err != nil {
+		return "", err
+	}
+	d := detectHash(encoded, delimiter, iterations)
+	if d.hashcat == "" {
+		if d.format == "unknown" {
+			return "", fmt.Errorf("%w: unrecognized hash", aspnethash.ErrUnsupportedFormat)
+		}
+		return "", fmt.Errorf("%w: hashcat has no mode for %s (%s)", aspnethash.ErrUnsupportedFormat, d.format, d.detail)
+	}
+	processedLine := d.hashcat
+	if dialect != nil {
+		if processedLine, err = dialect.write(d.digest); err != nil {
+			return "", err
+		}
+	}
+	if usernamePresent {
+		return normalize.apply(username) + outputDelimiter + processedLine, nil
+	}
+	return processedLine, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"text/tabwriter"
+)
+
+// The ways diff tells an account of the new dump changed from the old one.
+const (
+	// changeSalt is a new salt with the same scheme: the password was set
+	// again, but the hash wasn't upgraded.
+	changeSalt = "salt"
+	// changeHash is a new hash with the same salt and scheme, which only
+	// unsalted schemes should show.
+	changeHash = "hash"
+	// changeScheme is a new algorithm or iteration count.
+	changeScheme = "scheme"
+)
+
+// dumpHash is a hash of a dump as diff compares it.
+type dumpHash struct {
+	// scheme is how it is hashed: the algorithm, with the iteration count
+	// for PBKDF2, so the same hash converted to a hashcat line compares
+	// equal to its base64 form.
+	scheme       string
+	salt, digest []byte
+}
+
+// parseDumpHash makes a dumpHash of encoded, in any format identify knows.
+// MVC4 hashes, which don't store it, are taken to use iterations. Hashes of
+// unknown formats are compared as they are written.
+func parseDumpHash(encoded, delimiter string, iterations int) dumpHash {
+	d := detectHash(encoded, delimiter, iterations)
+	switch h := d.digest; {
+	case len(h.pbkdf2.Subkey) > 0:
+		return dumpHash{scheme: fmt.Sprintf("%s, %d iterations", h.algorithm, h.pbkdf2.Iterations), salt: h.pbkdf2.Salt, digest: h.pbkdf2.Subkey}
+	case h.algorithm != "":
+		return dumpHash{scheme: h.algorithm, salt: h.salt, digest: h.digest}
+	}
+	return dumpHash{scheme: d.format + " (" + d.detail + ")", digest: []byte(encoded)}
+}
+
+// dumpDiff compares two dumps of <username><delimiter><hash> lines by
+// account. Both are held in memory by normalized username, with the hashes
+// as written; only the hashes of accounts that differ are parsed.
+type dumpDiff struct {
+	delimiter  string
+	trim       bool
+	normalize  usernameNormalizer
+	iterations int
+	charset    string
+	// detail keeps every account that differs, for --json.
+	detail bool
+
+	Old       dumpSummary      `json:"old"`
+	New       dumpSummary      `json:"new"`
+	Added     int64            `json:"added"`
+	Removed   int64            `json:"removed"`
+	Unchanged int64            `json:"unchanged"`
+	Changed   map[string]int64 `json:"changed"`
+	Schemes   []schemeChange   `json:"schemes"`
+	Accounts  []accountChange  `json:"accounts,omitempty"`
+	schemes   map[[2]string]int64
+}
+
+// dumpSummary describes one of the dumps.
+type dumpSummary struct {
+	Path     string `json:"path"`
+	Accounts int64  `json:"accounts"`
+	// Duplicates counts the lines of an account already listed; the last
+	// line of an account is the one compared.
+	Duplicates int64 `json:"duplicates"`
+	// Malformed counts the lines without a delimiter, which are skipped.
+	Malformed int64 `json:"malformed"`
+}
+
+// schemeChange counts the changed accounts hashed with From in the old dump
+// and To in the new one; From and To are the same for a new salt or hash.
+type schemeChange struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Accounts int64  `json:"accounts"`
+}
+
+// accountChange is an account that differs between the dumps.
+type accountChange struct {
+	Username string `json:"username"`
+	// Status is added, removed or changed.
+	Status    string `json:"status"`
+	Change    string `json:"change,omitempty"`
+	OldScheme string `json:"old_scheme,omitempty"`
+	NewScheme string `json:"new_scheme,omitempty"`
+}
+
+// readDump calls fn with the normalized username and hash of every account
+// line of path ("-" for stdin), counting into s.
+func (d *dumpDiff) readDump(path string, s *dumpSummary, fn func(username, encoded string)) error {
+	src := inputSource{path: path}
+	if path == "-" {
+		src.path = ""
+	}
+	var read int64
+	r, _, closer, err := openInput(src, "auto", d.charset, &read, nil)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	s.Path = src.name()
+	lines := newLineReader(r, '\n', defaultMaxLineBytes)
+	for lines.next() {
+		if lines.lineTooLong() {
+			s.Malformed++
+			continue
+		}
+		line := lines.text()
+		if line == "" {
+			continue
+		}
+		username, encoded, err := splitUsername(line, true, d.delimiter, d.trim)
+		if err != nil {
+			s.Malformed++
+			continue
+		}
+		fn(d.normalize.apply(username), encoded)
+	}
+	if err := lines.readErr(); err != nil {
+		return fmt.Errorf("%s: %w", s.Path, err)
+	}
+	if s.Malformed > 0 {
+		log.Printf("Skipped %d malformed lines in %s\n", s.Malformed, s.Path)
+	}
+	if s.Duplicates > 0 {
+		log.Printf("Warning: %s lists %d accounts more than once; the last line of each is compared.", s.Path, s.Duplicates)
+	}
+	return nil
+}
+
+// run compares the dumps at oldPath and newPath.
+func (d *dumpDiff) run(oldPath, newPath string) error {
+	d.Changed = map[string]int64{changeSalt: 0, changeHash: 0, changeScheme: 0}
+	d.schemes = map[[2]string]int64{}
+	old := map[string]string{}
+	err := d.readDump(oldPath, &d.Old, func(username, encoded string) {
+		if _, dup := old[username]; dup {
+			d.Old.Duplicates++
+		} else {
+			d.Old.Accounts++
+		}
+		old[username] = encoded
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]string{}
+	err = d.readDump(newPath, &d.New, func(username, encoded string) {
+		if _, dup := seen[username]; dup {
+			d.New.Duplicates++
+		} else {
+			d.New.Accounts++
+		}
+		seen[username] = encoded
+	})
+	if err != nil {
+		return err
+	}
+
+	for username, encoded := range seen {
+		before, ok := old[username]
+		delete(old, username)
+		if !ok {
+			d.Added++
+			if d.detail {
+				d.Accounts = append(d.Accounts, accountChange{Username: username, Status: "added", NewScheme: parseDumpHash(encoded, d.delimiter, d.iterations).scheme})
+			}
+			continue
+		}
+		d.compare(username, before, encoded)
+	}
+	for username, encoded := range old {
+		d.Removed++
+		if d.detail {
+			d.Accounts = append(d.Accounts, accountChange{Username: username, Status: "removed", OldScheme: parseDumpHash(encoded, d.delimiter, d.iterations).scheme})
+		}
+	}
+
+	for k, n := range d.schemes {
+		d.Schemes = append(d.Schemes, schemeChange{From: k[0], To: k[1], Accounts: n})
+	}
+	sort.Slice(d.Schemes, func(i, j int) bool {
+		a, b := d.Schemes[i], d.Schemes[j]
+		if a.Accounts != b.Accounts {
+			return a.Accounts > b.Accounts
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	sort.Slice(d.Accounts, func(i, j int) bool { return d.Accounts[i].Username < d.Accounts[j].Username })
+	return nil
+}
+
+// compare counts an account listed in both dumps. Hashes written
+// differently, like a base64 hash and its hashcat line, are unchanged if
+// they hold the same scheme, salt and hash.
+func (d *dumpDiff) compare(username, before, after string) {
+	if before == after {
+		d.Unchanged++
+		return
+	}
+	o := parseDumpHash(before, d.delimiter, d.iterations)
+	n := parseDumpHash(after, d.delimiter, d.iterations)
+	var change string
+	switch {
+	case o.scheme != n.scheme:
+		change = changeScheme
+	case !bytes.Equal(o.salt, n.salt):
+		change = changeSalt
+	case !bytes.Equal(o.digest, n.digest):
+		change = changeHash
+	default:
+		d.Unchanged++
+		return
+	}
+	d.Changed[change]++
+	d.schemes[[2]string{o.scheme, n.scheme}]++
+	if d.detail {
+		d.Accounts = append(d.Accounts, accountChange{Username: username, Status: "changed", Change: change, OldScheme: o.scheme, NewScheme: n.scheme})
+	}
+}
+
+// writeDumpDiff compares the dumps at oldPath and newPath and writes a
+// summary, or with asJSON the counts and the accounts that differ as JSON.
+func writeDumpDiff(w io.Writer, oldPath, newPath string, d *dumpDiff, asJSON bool) error {
+	d.detail = asJSON
+	if err := d.run(oldPath, newPath); err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "old:\t%s\t%d accounts\n", d.Old.Path, d.Old.Accounts)
+	fmt.Fprintf(tw, "new:\t%s\t%d accounts\n", d.New.Path, d.New.Accounts)
+	fmt.Fprintf(tw, "added:\t%d\n", d.Added)
+	fmt.Fprintf(tw, "removed:\t%d\n", d.Removed)
+	fmt.Fprintf(tw, "unchanged:\t%d\n", d.Unchanged)
+	fmt.Fprintf(tw, "changed:\t%d\n", d.Changed[changeSalt]+d.Changed[changeHash]+d.Changed[changeScheme])
+	fmt.Fprintf(tw, "  new salt, same scheme:\t%d\n", d.Changed[changeSalt])
+	fmt.Fprintf(tw, "  new hash, same salt:\t%d\n", d.Changed[changeHash])
+	fmt.Fprintf(tw, "  new scheme:\t%d\n", d.Changed[changeScheme])
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(d.Schemes) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nchanged accounts by scheme:")
+	for _, s := range d.Schemes {
+		if s.From == s.To {
+			fmt.Fprintf(tw, "  %s\t(not upgraded)\t%d\n", s.From, s.Accounts)
+		} else {
+			fmt.Fprintf(tw, "  %s\t-> %s\t%d\n", s.From, s.To, s.Accounts)
+		}
+	}
+	return tw.Flush()
+}
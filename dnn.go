@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// parseDNNLine splits a convert --mode dnn line, <hash><delimiter><salt>
+// with an optional username in front, and parses the hash.
+func parseDNNLine(line string, usernamePresent bool, delimiter string, trim bool) (username string, hash aspnethash.DNNHash, err error) {
+	username, columns, err := splitUsername(line, usernamePresent, delimiter, trim)
+	if err != nil {
+		return "", hash, err
+	}
+	encoded, salt, ok := strings.Cut(columns, delimiter)
+	if !ok {
+		return "", hash, fmt.Errorf("expected <hash>%s<salt>: %w", delimiter, errMissingDelimiter)
+	}
+	if trim {
+		encoded, salt = strings.TrimSpace(encoded), strings.TrimSpace(salt)
+	}
+	hash, err = aspnethash.ParseDNN(encoded, salt)
+	return username, hash, err
+}
+
+// convertDNN turns a DNN line into a hashcat line, for mode 140 unless
+// dialect says otherwise.
+func convertDNN(line string, usernamePresent bool, delimiter, outputDelimiter string, trim bool, normalize usernameNormalizer, dialect *hashcatDialect) (string, error) {
+	username, hash, err := parseDNNLine(line, usernamePresent, delimiter, trim)
+	if err != nil {
+		return "", err
+	}
+	processedLine, err := hashcatLine(hashDigest{algorithm: algoSaltedSHA1, digest: hash.Digest, salt: hash.Salt}, dialect)
+	if err != nil {
+		return "", err
+	}
+	if usernamePresent {
+		return normalize.apply(username) + outputDelimiter + processedLine, nil
+	}
+	return processedLine, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// errorFile collects failed input lines for --error-file. Each entry is
+// written as "<line number>\t<error>\t<input line>", or with several inputs
+// "<file>:<line number>\t...", so the failures can be inspected and re-run.
+// Workers call record concurrently; writes are serialized by the mutex.
+type errorFile struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	w     *bufio.Writer
+	count int64
+	err   error
+}
+
+// newErrorFile prepares an error file at path. Unless always is set, the file
+// is only created once the first error is recorded.
+func newErrorFile(path string, always bool) (*errorFile, error) {
+	e := &errorFile{path: path}
+	if always {
+		if err := e.open(); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *errorFile) open() error {
+	f, err := os.Create(e.path)
+	if err != nil {
+		return err
+	}
+	e.file = f
+	e.w = bufio.NewWriter(f)
+	return nil
+}
+
+// record writes one failed line, at the position at. Write errors are kept and reported by close.
+func (e *errorFile) record(at string, line string, lineErr error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.err != nil {
+		return
+	}
+	if e.file == nil {
+		if e.err = e.open(); e.err != nil {
+			return
+		}
+	}
+	e.count++
+	e.w.WriteString(at)
+	e.w.WriteByte('\t')
+	e.w.WriteString(lineErr.Error())
+	e.w.WriteByte('\t')
+	e.w.WriteString(line)
+	_, e.err = e.w.WriteString("\n")
+}
+
+// created reports whether the file exists on disk.
+func (e *errorFile) created() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file != nil
+}
+
+// close flushes and closes the file, returning the first error encountered.
+func (e *errorFile) close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.file == nil {
+		return e.err
+	}
+	if err := e.w.Flush(); err != nil && e.err == nil {
+		e.err = err
+	}
+	if err := e.file.Close(); err != nil && e.err == nil {
+		e.err = err
+	}
+	return e.err
+}
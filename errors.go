@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// errMissingDelimiter is returned for lines without the --delimiter when
+// usernames are expected.
+var errMissingDelimiter = errors.New("missing delimiter")
+
+// errorKinds maps each kind of failure to its name in the stats. Failed
+// lines are counted under the first kind their error wraps.
+var errorKinds = []struct {
+	err  error
+	name string
+}{
+	{errMissingDelimiter, "missing_delimiter"},
+	{aspnethash.ErrInvalidBase64, "invalid_base64"},
+	{aspnethash.ErrTooShort, "too_short"},
+	{aspnethash.ErrVersionByte, "version_byte"},
+	{aspnethash.ErrLengthMismatch, "length_mismatch"},
+	{aspnethash.ErrUnsupportedFormat, "unsupported_format"},
+	{aspnethash.ErrDecrypt, "decrypt_failed"},
+	{errLineTooLong, "line_too_long"},
+	{errMismatch, "mismatch"},
+	{errDuplicateAccount, "duplicate_account"},
+	{errBlankUsername, "blank_username"},
+	{errPolicy, "policy_violation"},
+	{errInvalidUTF8, "invalid_utf8"},
+}
+
+// otherErrorKind is used for errors that don't wrap a known kind.
+const otherErrorKind = "other"
+
+// errorKindName returns the stats name of the kind err belongs to.
+func errorKindName(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.name
+		}
+	}
+	return otherErrorKind
+}
+
+// errorCounter counts failed lines per error kind and remembers the lowest
+// line number of each, whichever worker got to it first. It is safe for concurrent use.
+type errorCounter struct {
+	counts   map[string]*int64
+	examples map[string]*int64
+}
+
+func newErrorCounter() *errorCounter {
+	c := &errorCounter{counts: map[string]*int64{}, examples: map[string]*int64{}}
+	for _, k := range errorKinds {
+		c.counts[k.name], c.examples[k.name] = new(int64), new(int64)
+	}
+	c.counts[otherErrorKind], c.examples[otherErrorKind] = new(int64), new(int64)
+	return c
+}
+
+// add counts err, which happened on lineNo.
+func (c *errorCounter) add(lineNo int64, err error) {
+	name := errorKindName(err)
+	atomic.AddInt64(c.counts[name], 1)
+	for {
+		first := atomic.LoadInt64(c.examples[name])
+		if first != 0 && first <= lineNo || atomic.CompareAndSwapInt64(c.examples[name], first, lineNo) {
+			break
+		}
+	}
+}
+
+// breakdown returns the non-zero counts ordered by descending count, then
+// by name.
+func (c *errorCounter) breakdown() []countEntry {
+	entries := []countEntry{}
+	for name, count := range c.counts {
+		if n := atomic.LoadInt64(count); n > 0 {
+			entries = append(entries, countEntry{Name: name, Count: n, Example: atomic.LoadInt64(c.examples[name])})
+		}
+	}
+	sortByCount(entries)
+	return entries
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+func TestErrorCounterKeepsLowestLine(t *testing.T) {
+	c := newErrorCounter()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Each worker reports its lines from the last to the first,
+			// so the lowest line is never the first one seen.
+			for line := int64(1000); line > 0; line-- {
+				if line%8 == int64(w) {
+					c.add(line+1, fmt.Errorf("line %d: %w", line+1, aspnethash.ErrTooShort))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	c.add(3, errMissingDelimiter)
+	c.add(2, errMissingDelimiter)
+
+	got := c.breakdown()
+	want := []countEntry{
+		{Name: "too_short", Count: 1000, Example: 2},
+		{Name: "missing_delimiter", Count: 2, Example: 2},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("breakdown() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("breakdown()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+)
+
+// filterProcess runs a --pre-filter or --post-filter command, started once
+// and fed through its stdin, so a quirk of the input or output can be fixed
+// without a pipeline stage of its own. The filter must print exactly one
+// record for each it is given, in the same order, so the line numbers still
+// refer to the input: one printing more records than it was given so far
+// fails at once, one printing fewer by the time it exits fails at the end.
+type filterProcess struct {
+	flag       string
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	stdout     io.ReadCloser
+	terminator byte
+	// given and printed count the records written to the filter and read
+	// back.
+	given, printed atomic.Int64
+	// last and lastRead are the last bytes written and read, to count a
+	// final record without a terminator.
+	last, lastRead byte
+
+	waitOnce sync.Once
+	waitErr  error
+	exited   atomic.Bool
+}
+
+// startFilter starts command for flag. Its stderr is the tool's.
+func startFilter(flag, command string, terminator byte) (*filterProcess, error) {
+	cmd := filterCommand(command)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %w", flag, err)
+	}
+	return &filterProcess{flag: flag, cmd: cmd, stdin: stdin, stdout: stdout, terminator: terminator, last: terminator, lastRead: terminator}, nil
+}
+
+// Write gives the filter records. They are counted before the filter can
+// see them, so its output never gets ahead of the count.
+func (f *filterProcess) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	f.given.Add(int64(bytes.Count(p, []byte{f.terminator})))
+	f.last = p[len(p)-1]
+	n, err := f.stdin.Write(p)
+	if err != nil {
+		// Not wrapped: the filter exiting is no broken pipe of the output.
+		return n, fmt.Errorf("%s: %v", f.flag, err)
+	}
+	return n, nil
+}
+
+// closeInput tells the filter there are no more records.
+func (f *filterProcess) closeInput() error {
+	if f.last != f.terminator {
+		f.given.Add(1)
+	}
+	return f.stdin.Close()
+}
+
+// Read reads what the filter printed; finish checks the end of it.
+func (f *filterProcess) Read(p []byte) (int, error) {
+	n, err := f.stdout.Read(p)
+	if n > 0 {
+		f.lastRead = p[n-1]
+		if f.printed.Add(int64(bytes.Count(p[:n], []byte{f.terminator}))) > f.given.Load() {
+			f.kill()
+			return n, fmt.Errorf("%s printed more records than it was given; it must print one for each, in the same order", f.flag)
+		}
+	}
+	return n, err
+}
+
+// finish waits for the filter to exit once its output has been read and all
+// the records given, and checks that it printed as many as it was given.
+func (f *filterProcess) finish() error {
+	if err := f.wait(); err != nil {
+		return fmt.Errorf("%s: %w", f.flag, err)
+	}
+	printed := f.printed.Load()
+	if f.lastRead != f.terminator {
+		printed++
+	}
+	if given := f.given.Load(); printed != given {
+		return fmt.Errorf("%s printed %d records for the %d it was given; it must print one for each, in the same order", f.flag, printed, given)
+	}
+	return nil
+}
+
+// wait waits for the filter to exit; it is safe to call more than once.
+func (f *filterProcess) wait() error {
+	f.waitOnce.Do(func() {
+		f.waitErr = f.cmd.Wait()
+		f.exited.Store(true)
+	})
+	return f.waitErr
+}
+
+// kill stops the filter, and whatever it started, unless it has exited.
+func (f *filterProcess) kill() {
+	if !f.exited.Load() {
+		killFilter(f.cmd)
+	}
+}
+
+// filterInput passes an opened input through the --pre-filter command. A
+// goroutine feeds the input to the filter; an error reading it is returned
+// by the reader once the filter is done. Closing the input stops the
+// filter, which is what ends it early when the run stops before the end.
+func filterInput(command string, r io.Reader, closer io.Closer, terminator byte) (io.Reader, io.Closer, error) {
+	f, err := startFilter("--pre-filter", command, terminator)
+	if err != nil {
+		return nil, nil, err
+	}
+	in := &filteredInput{filterProcess: f, source: closer, fed: make(chan error, 1)}
+	go in.feed(r)
+	return in, in, nil
+}
+
+// filteredInput is an input read through the --pre-filter.
+type filteredInput struct {
+	*filterProcess
+	source io.Closer
+	// fed gets the error reading the input, once it has all been given to
+	// the filter or the filter went away.
+	fed     chan error
+	readErr error
+	done    bool
+}
+
+// feed copies r to the filter. An error writing means the filter exited,
+// which Read reports.
+func (in *filteredInput) feed(r io.Reader) {
+	buf := make([]byte, 64<<10)
+	var err error
+	for {
+		var n int
+		n, err = r.Read(buf)
+		if n > 0 {
+			if _, werr := in.Write(buf[:n]); werr != nil {
+				err = nil
+				break
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			break
+		}
+	}
+	in.closeInput()
+	in.fed <- err
+}
+
+func (in *filteredInput) Read(p []byte) (int, error) {
+	if in.done {
+		return 0, in.readErr
+	}
+	n, err := in.filterProcess.Read(p)
+	if err != io.EOF {
+		if err != nil {
+			in.done, in.readErr = true, err
+		}
+		return n, err
+	}
+	// The filter is done printing; once it has been given everything there
+	// is, it can be checked.
+	if err = <-in.fed; err == nil {
+		if err = in.finish(); err == nil {
+			err = io.EOF
+		}
+	}
+	in.done, in.readErr = true, err
+	return n, err
+}
+
+// Close stops the filter if it is still running and closes the input.
+func (in *filteredInput) Close() error {
+	in.kill()
+	in.wait()
+	return in.source.Close()
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// filterCommand runs a filter command line through cmd.exe.
+func filterCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+// killFilter kills a started filter.
+func killFilter(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
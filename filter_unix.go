@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// filterCommand runs a filter command line through the shell. It gets a
+// process group of its own, so Ctrl-C only reaches the tool, which then
+// stops the filter in turn.
+func filterCommand(command string) *exec.Cmd {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// killFilter kills the process group of a started filter, so the commands
+// of a shell pipeline go too.
+func killFilter(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Repairs --fix makes, as bits of a mask.
+const (
+	repairPadding = 1 << iota
+	repairURLSafe
+	repairWhitespace
+	repairWrapped
+)
+
+// repairNames names the repairs in the stats, in the order of their bits.
+var repairNames = []string{"padding", "url_safe", "whitespace", "wrapped"}
+
+// wrapWidths are the line lengths old exports wrap base64 at: 76 as in
+// MIME, 64 as in PEM.
+var wrapWidths = []int{64, 76}
+
+// minFixField is the length a field needs before --fix treats it as base64,
+// so short numeric columns such as a password format or an iteration count
+// don't get padded.
+const minFixField = 12
+
+// isBase64Byte reports whether c belongs to the standard or the URL-safe
+// base64 alphabet, padding included.
+func isBase64Byte(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+		c == '+' || c == '/' || c == '-' || c == '_' || c == '='
+}
+
+// fixBase64 repairs a base64 field: it drops whitespace inside it,
+// translates the URL-safe alphabet to the standard one and sets the padding
+// right. Fields with other characters, or too short to be a salt or hash,
+// are returned as is. repairs tells what was changed.
+func fixBase64(field string) (fixed string, repairs int) {
+	for i := 0; i < len(field); i++ {
+		if c := field[i]; !isBase64Byte(c) && c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return field, 0
+		}
+	}
+	compact := strings.Join(strings.Fields(field), "")
+	if len(compact) < minFixField {
+		return field, 0
+	}
+	if compact != field {
+		field = compact
+		repairs |= repairWhitespace
+	}
+	if strings.ContainsAny(field, "-_") {
+		field = strings.NewReplacer("-", "+", "_", "/").Replace(field)
+		repairs |= repairURLSafe
+	}
+	data := strings.TrimRight(field, "=")
+	if len(data)%4 == 1 {
+		// Not valid base64 however it is padded; left to the parser.
+		return field, repairs
+	}
+	if padded := data + strings.Repeat("=", (4-len(data)%4)%4); padded != field {
+		field = padded
+		repairs |= repairPadding
+	}
+	return field, repairs
+}
+
+// fixLine applies fixBase64 to the --delimiter separated fields of a line
+// after its username. Lines without the username delimiter are returned as
+// is, for the converter to report.
+func fixLine(line string, usernamePresent bool, delimiter string, trim bool) (fixed string, repairs int) {
+	username, encoded, err := splitUsername(line, usernamePresent, delimiter, trim)
+	if err != nil {
+		return line, 0
+	}
+	fields := strings.Split(encoded, delimiter)
+	for i, field := range fields {
+		if trim {
+			field = strings.TrimSpace(field)
+		}
+		var r int
+		fields[i], r = fixBase64(field)
+		repairs |= r
+	}
+	if repairs == 0 {
+		return line, 0
+	}
+	fixed = strings.Join(fields, delimiter)
+	if usernamePresent {
+		fixed = username + delimiter + fixed
+	}
+	return fixed, repairs
+}
+
+// wrappedContinuation returns the lineReader.joinWrapped of --fix: a line
+// continues the one before if that one is exactly a wrap width long and it
+// is nothing but base64, without the delimiter.
+func wrappedContinuation(delimiter string) func(last, next []byte) bool {
+	return func(last, next []byte) bool {
+		if !slices.Contains(wrapWidths, len(last)) || len(next) == 0 || bytes.Contains(next, []byte(delimiter)) {
+			return false
+		}
+		for _, c := range next {
+			if !isBase64Byte(c) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// base64Fixes counts the lines --fix repaired, by repair, and remembers the
+// first line of each. It is safe for concurrent use.
+type base64Fixes struct {
+	counts  [4]atomic.Int64
+	example [4]atomic.Int64
+}
+
+func (f *base64Fixes) add(repairs int, lineNo int64) {
+	for i := range repairNames {
+		if repairs&(1<<i) == 0 {
+			continue
+		}
+		f.counts[i].Add(1)
+		for {
+			first := f.example[i].Load()
+			if first != 0 && first <= lineNo || f.example[i].CompareAndSwap(first, lineNo) {
+				break
+			}
+		}
+	}
+}
+
+// entries returns the repairs made, in the order of repairNames.
+func (f *base64Fixes) entries() []countEntry {
+	var entries []countEntry
+	for i, name := range repairNames {
+		if n := f.counts[i].Load(); n > 0 {
+			entries = append(entries, countEntry{Name: name, Count: n, Example: f.example[i].Load()})
+		}
+	}
+	return entries
+}
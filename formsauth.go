@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// formsAuthAlgorithm returns the algorithm of a formsauth-* --mode, or ""
+// for the other modes. The names, sha1 and md5, are those of algoSHA1 and
+// algoMD5 as well.
+func formsAuthAlgorithm(mode string) string {
+	algorithm, ok := strings.CutPrefix(mode, "formsauth-")
+	if !ok {
+		return ""
+	}
+	return algorithm
+}
+
+// isUpperHex reports whether s is hex without lowercase digits, as
+// FormsAuthentication wrote its hashes.
+func isUpperHex(s string) bool {
+	return isHex(s) && strings.ToUpper(s) == s
+}
+
+// parseFormsAuth parses a FormsAuthentication hash of the given algorithm.
+func parseFormsAuth(encoded, algorithm string) (aspnethash.FormsAuthHash, error) {
+	hash, err := aspnethash.ParseFormsAuth(encoded)
+	if err != nil {
+		return hash, err
+	}
+	if hash.Algorithm != algorithm {
+		return hash, fmt.Errorf("%w: a %s hash, not %s", aspnethash.ErrLengthMismatch, hash.Algorithm, algorithm)
+	}
+	return hash, nil
+}
+
+// convertFormsAuth turns a FormsAuthentication line into a hashcat line,
+// for mode 100 (SHA1) or 0 (MD5) unless dialect says otherwise.
+func convertFormsAuth(line string, usernamePresent bool, delimiter, outputDelimiter string, trim bool, algorithm string, normalize usernameNormalizer, dialect *hashcatDialect) (string, error) {
+	username, encoded, err := splitUsername(line, usernamePresent, delimiter, trim)
+	if err != nil {
+		return "", err
+	}
+	hash, err := parseFormsAuth(encoded, algorithm)
+	if err != nil {
+		return "", err
+	}
+	processedLine, err := hashcatLine(hashDigest{algorithm: algorithm, digest: hash.Digest}, dialect)
+	if err != nil {
+		return "", err
+	}
+	if usernamePresent {
+		return normalize.apply(username) + outputDelimiter + processedLine, nil
+	}
+	return processedLine, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"hash/maphash"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// frequency counts how often each distinct value occurs for --frequency. By
+// default it keys the counts by a 64-bit hash of the value and only keeps
+// values once they repeat, so the memory used grows with the number of
+// distinct values, not their length; values seen once are counted but can't
+// be listed. With exact set, every value is kept. It is safe for concurrent
+// use.
+type frequency struct {
+	mu    sync.Mutex
+	total int64
+
+	seed   maphash.Seed
+	counts map[uint64]int64
+	values map[uint64]string
+
+	exact map[string]int64
+}
+
+// frequencyReport is the --frequency part of the stats.
+type frequencyReport struct {
+	Total    int64 `json:"total"`
+	Distinct int64 `json:"distinct"`
+	// Repeated counts the distinct values seen more than once.
+	Repeated int64 `json:"repeated"`
+	// UniqueRatio is Distinct / Total: 1 if nothing repeats.
+	UniqueRatio float64      `json:"unique_ratio"`
+	Top         []countEntry `json:"top"`
+}
+
+func newFrequency(exact bool) *frequency {
+	if exact {
+		return &frequency{exact: map[string]int64{}}
+	}
+	return &frequency{seed: maphash.MakeSeed(), counts: map[uint64]int64{}, values: map[uint64]string{}}
+}
+
+// observe counts one occurrence of value.
+func (f *frequency) observe(value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.total++
+	if f.exact != nil {
+		if _, ok := f.exact[value]; !ok {
+			// Lines may point into a larger read buffer.
+			value = strings.Clone(value)
+		}
+		f.exact[value]++
+		return
+	}
+	key := maphash.String(f.seed, value)
+	f.counts[key]++
+	if f.counts[key] == 2 {
+		f.values[key] = strings.Clone(value)
+	}
+}
+
+// report lists the top values, most frequent first. Without exact, values
+// seen once aren't known and are left out.
+func (f *frequency) report(top int) *frequencyReport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := &frequencyReport{Total: f.total, Top: []countEntry{}}
+	if f.exact != nil {
+		r.Distinct = int64(len(f.exact))
+		for value, n := range f.exact {
+			if n > 1 {
+				r.Repeated++
+			}
+			r.Top = append(r.Top, countEntry{Name: value, Count: n})
+		}
+	} else {
+		r.Distinct = int64(len(f.counts))
+		r.Repeated = int64(len(f.values))
+		for key, value := range f.values {
+			r.Top = append(r.Top, countEntry{Name: value, Count: f.counts[key]})
+		}
+	}
+	if r.Total > 0 {
+		r.UniqueRatio = float64(r.Distinct) / float64(r.Total)
+	}
+	sortByCount(r.Top)
+	if len(r.Top) > top {
+		r.Top = r.Top[:top]
+	}
+	return r
+}
+
+// records returns the top values as <count>\t<percent of total>\t<value>
+// records.
+func (r *frequencyReport) records(recordEnd string) string {
+	var b strings.Builder
+	for _, e := range r.Top {
+		b.WriteString(strconv.FormatInt(e.Count, 10) + "\t" + fmt.Sprintf("%.2f%%", 100*float64(e.Count)/float64(r.Total)) + "\t" + e.Name + recordEnd)
+	}
+	return b.String()
+}
+
+// logSummary logs the report after the run summary.
+func (r *frequencyReport) logSummary() {
+	log.Printf("Frequency:")
+	log.Printf("  Values: %d, distinct: %d (unique ratio %.4f)", r.Total, r.Distinct, r.UniqueRatio)
+	log.Printf("  Distinct values seen more than once: %d", r.Repeated)
+}
@@ -3,9 +3,15 @@ module github.com/n0kovo/ASP.NET-hashtool
 go 1.21.0
 
 require (
+	github.com/klauspost/compress v1.17.0
 	github.com/spf13/pflag v1.0.5
 	go.uber.org/ratelimit v0.3.0
 	golang.org/x/crypto v0.13.0
+	golang.org/x/term v0.12.0
+	golang.org/x/text v0.13.0
 )
 
-require github.com/benbjohnson/clock v1.3.0 // indirect
+require (
+	github.com/benbjohnson/clock v1.3.0 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+)
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultCacheSize is the default for --cache-size.
+const defaultCacheSize = 10000
+
+// plaintextCache remembers the most recently generated plaintexts, up to
+// size of them, evicting the least recently used. With reuse set it keeps
+// their hashes too and hands them out again, for --reuse-salt-per-plaintext;
+// otherwise it only counts the repeats, to tell how much reusing would save.
+// It is safe for concurrent use.
+type plaintextCache struct {
+	mu      sync.Mutex
+	reuse   bool
+	size    int
+	order   *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	stats   plaintextCacheStats
+}
+
+// cacheEntry is a cached plaintext. done is closed once hash and err are
+// set, so workers asking for a plaintext another worker is still hashing
+// wait for its result instead of drawing a salt of their own.
+type cacheEntry struct {
+	plain string
+	hash  string
+	err   error
+	done  chan struct{}
+}
+
+// plaintextCacheStats is the --cache-size part of the stats.
+type plaintextCacheStats struct {
+	Reuse     bool  `json:"reuse"`
+	Size      int   `json:"size"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+func newPlaintextCache(size int, reuse bool) *plaintextCache {
+	return &plaintextCache{
+		reuse:   reuse,
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		stats:   plaintextCacheStats{Reuse: reuse, Size: size},
+	}
+}
+
+// hash returns the hash of plain, from the cache if reuse is set and plain
+// was hashed before, or else by calling generate.
+func (c *plaintextCache) hash(plain string, generate func() (string, error)) (string, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[plain]; ok {
+		c.stats.Hits++
+		c.order.MoveToFront(el)
+		e := el.Value.(*cacheEntry)
+		c.mu.Unlock()
+		if !c.reuse {
+			return generate()
+		}
+		<-e.done
+		if e.err != nil {
+			return generate()
+		}
+		return e.hash, nil
+	}
+	c.stats.Misses++
+	e := &cacheEntry{plain: plain, done: make(chan struct{})}
+	c.entries[plain] = c.order.PushFront(e)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).plain)
+		c.stats.Evictions++
+	}
+	c.mu.Unlock()
+	if !c.reuse {
+		return generate()
+	}
+	e.hash, e.err = generate()
+	close(e.done)
+	if e.err != nil {
+		c.mu.Lock()
+		if el, ok := c.entries[plain]; ok && el.Value == e {
+			c.order.Remove(el)
+			delete(c.entries, plain)
+		}
+		c.mu.Unlock()
+	}
+	return e.hash, e.err
+}
+
+// report returns the counts so far.
+func (c *plaintextCache) report() *plaintextCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	return &stats
+}
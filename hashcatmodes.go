@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// Algorithms of the hashes hashcat dialects can hold.
+const (
+	algoPBKDF2SHA1   = "pbkdf2-sha1"
+	algoPBKDF2SHA256 = "pbkdf2-sha256"
+	algoPBKDF2SHA512 = "pbkdf2-sha512"
+	// algoSaltedSHA1 is SHA1(salt || UTF-16LE(password)), as DNN and the
+	// SqlMembershipProvider store it.
+	algoSaltedSHA1 = "sha1-salt-utf16le"
+	// algoSaltedSHA256 is SHA256(salt || UTF-16LE(password)), as the
+	// DefaultMembershipProvider stores it.
+	algoSaltedSHA256 = "sha256-salt-utf16le"
+	algoMD5          = "md5"
+	algoSHA1         = "sha1"
+	algoSHA256       = "sha256"
+	algoSHA512       = "sha512"
+)
+
+// pbkdf2Algorithms maps PBKDF2 PRFs to their algorithm.
+var pbkdf2Algorithms = map[aspnethash.PRF]string{
+	aspnethash.PRFSHA1:   algoPBKDF2SHA1,
+	aspnethash.PRFSHA256: algoPBKDF2SHA256,
+	aspnethash.PRFSHA512: algoPBKDF2SHA512,
+}
+
+// hashDigest is what a hashcat dialect needs of a hash: the algorithm that
+// made it and its parts. pbkdf2 is set for the PBKDF2 algorithms, digest
+// and salt for the others.
+type hashDigest struct {
+	algorithm    string
+	pbkdf2       aspnethash.Hash
+	digest, salt []byte
+}
+
+func pbkdf2Digest(h aspnethash.Hash) hashDigest {
+	return hashDigest{algorithm: pbkdf2Algorithms[h.PRF], pbkdf2: h}
+}
+
+// hashcatDialect is a hashcat mode and the layout of its hash lines.
+type hashcatDialect struct {
+	mode string
+	name string
+	// algorithms are the ones the mode can hold. Unsalted digests fit the
+	// salted modes of their algorithm with an empty salt.
+	algorithms []string
+	format     func(h hashDigest) (string, error)
+}
+
+// hashcatDialects is the one list of the hashcat modes the tool knows:
+// --hashcat-mode picks from it, and for every algorithm the first mode
+// holding it is the one identify suggests and convert writes by default.
+var hashcatDialects = []hashcatDialect{
+	{"12000", "PBKDF2-HMAC-SHA1", []string{algoPBKDF2SHA1}, pbkdf2Line},
+	{"10900", "PBKDF2-HMAC-SHA256", []string{algoPBKDF2SHA256}, pbkdf2Line},
+	{"12100", "PBKDF2-HMAC-SHA512", []string{algoPBKDF2SHA512}, pbkdf2Line},
+	{"140", "sha1($salt.utf16le($pass)), with --hex-salt", []string{algoSaltedSHA1}, hexDigestHexSalt},
+	{"1440", "sha256($salt.utf16le($pass)), with --hex-salt", []string{algoSaltedSHA256}, hexDigestHexSalt},
+	{"0", "MD5", []string{algoMD5}, hexDigest},
+	{"100", "SHA1", []string{algoSHA1}, hexDigest},
+	{"1400", "SHA2-256", []string{algoSHA256}, hexDigest},
+	{"1700", "SHA2-512", []string{algoSHA512}, hexDigest},
+	{"1410", "sha256($pass.$salt)", []string{algoSHA256}, hexDigestEmptySalt},
+	{"1420", "sha256($salt.$pass)", []string{algoSHA256}, hexDigestEmptySalt},
+	{"111", "nsldaps, SSHA-1(Base64)", []string{algoSHA1}, sshaLine},
+	{"121", "SMF > v1.1", []string{algoSHA1}, hexDigestEmptySalt},
+}
+
+// unsuggestedModes are modes identify doesn't list as alternatives. 111
+// (LDAP SSHA) and 121 (SMF, salted with the username) are salted schemes:
+// an unsalted FormsAuthentication SHA1 digest only fits them with an empty
+// salt, a workaround for tools wanting their layout rather than a hash
+// either scheme would store. Use 100 for those digests.
+var unsuggestedModes = []string{"111", "121"}
+
+// pbkdf2Line writes <prf>:<iterations>:<base64 salt>:<base64 subkey>.
+func pbkdf2Line(h hashDigest) (string, error) {
+	return h.pbkdf2.Hashcat(), nil
+}
+
+func hexDigest(h hashDigest) (string, error) {
+	return hex.EncodeToString(h.digest), nil
+}
+
+// hexDigestHexSalt writes <hex digest>:<hex salt>; salts are binary, so
+// hashcat needs --hex-salt.
+func hexDigestHexSalt(h hashDigest) (string, error) {
+	return hex.EncodeToString(h.digest) + ":" + hex.EncodeToString(h.salt), nil
+}
+
+// hexDigestEmptySalt writes an unsalted digest for a salted mode.
+func hexDigestEmptySalt(h hashDigest) (string, error) {
+	return hex.EncodeToString(h.digest) + ":", nil
+}
+
+// sshaLine writes {SSHA}<base64 digest and salt>.
+func sshaLine(h hashDigest) (string, error) {
+	return "{SSHA}" + base64.StdEncoding.EncodeToString(append(append([]byte{}, h.digest...), h.salt...)), nil
+}
+
+// modeAlgorithms maps --mode to the algorithms of the hashes it reads or
+// writes. convert --mode mvc4 also takes Identity v3 hashes, of any PRF.
+var modeAlgorithms = map[string][]string{
+	"mvc4":     {algoPBKDF2SHA1, algoPBKDF2SHA256, algoPBKDF2SHA512},
+	"webforms": {algoSHA256},
+	"dnn":      {algoSaltedSHA1},
+	// FormsAuthentication hashes are unsalted digests.
+	"formsauth-sha1": {algoSHA1},
+	"formsauth-md5":  {algoMD5},
+}
+
+// hashcatModes lists the accepted values of --hashcat-mode.
+func hashcatModes() []string {
+	modes := make([]string, len(hashcatDialects))
+	for i, d := range hashcatDialects {
+		modes[i] = d.mode
+	}
+	return modes
+}
+
+// lookupDialect returns the dialect of a hashcat mode.
+func lookupDialect(mode string) (*hashcatDialect, error) {
+	for i := range hashcatDialects {
+		if hashcatDialects[i].mode == mode {
+			return &hashcatDialects[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported hashcat mode %q (want %s)", mode, strings.Join(hashcatModes(), ", "))
+}
+
+// hashcatLine writes h in dialect, or in the default dialect of its
+// algorithm if dialect is nil.
+func hashcatLine(h hashDigest, dialect *hashcatDialect) (string, error) {
+	if dialect == nil {
+		if dialect = defaultDialect(h.algorithm); dialect == nil {
+			return "", fmt.Errorf("%w: hashcat has no mode for %s hashes", aspnethash.ErrUnsupportedFormat, h.algorithm)
+		}
+	}
+	return dialect.write(h)
+}
+
+// defaultDialect returns the first dialect holding algorithm, or nil.
+func defaultDialect(algorithm string) *hashcatDialect {
+	for i := range hashcatDialects {
+		if slices.Contains(hashcatDialects[i].algorithms, algorithm) {
+			return &hashcatDialects[i]
+		}
+	}
+	return nil
+}
+
+// holds reports whether the dialect can hold hashes of algorithm.
+func (d *hashcatDialect) holds(algorithm string) bool {
+	return slices.Contains(d.algorithms, algorithm)
+}
+
+// write returns h as the dialect's hash line.
+func (d *hashcatDialect) write(h hashDigest) (string, error) {
+	if !d.holds(h.algorithm) {
+		if d == &phcDialect {
+			return "", fmt.Errorf("%w: --format phc only holds PBKDF2 hashes, not %s ones", aspnethash.ErrUnsupportedFormat, h.algorithm)
+		}
+		return "", fmt.Errorf("%w: hashcat mode %s (%s) can't hold %s hashes; valid combinations: %s", aspnethash.ErrUnsupportedFormat, d.mode, d.name, h.algorithm, dialectCombinations())
+	}
+	return d.format(h)
+}
+
+// alternativeDialects returns the modes other than the default that can
+// hold algorithm, for identify to suggest.
+func alternativeDialects(algorithm string) []string {
+	var modes []string
+	first := defaultDialect(algorithm)
+	for i := range hashcatDialects {
+		if d := &hashcatDialects[i]; d != first && d.holds(algorithm) && !slices.Contains(unsuggestedModes, d.mode) {
+			modes = append(modes, d.mode)
+		}
+	}
+	return modes
+}
+
+// dialectCombinations lists the modes each algorithm can be written in, as
+// "<algorithm>: <mode>, <mode>; ...".
+func dialectCombinations() string {
+	var algorithms []string
+	modes := map[string][]string{}
+	for _, d := range hashcatDialects {
+		for _, a := range d.algorithms {
+			if modes[a] == nil {
+				algorithms = append(algorithms, a)
+			}
+			modes[a] = append(modes[a], d.mode)
+		}
+	}
+	parts := make([]string, len(algorithms))
+	for i, a := range algorithms {
+		parts[i] = a + ": " + strings.Join(modes[a], ", ")
+	}
+	return strings.Join(parts, "; ")
+}
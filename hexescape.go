@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// decodeHexPlain decodes hashcat's $HEX[...] encoding of plaintexts.
+// Anything else is returned as is.
+func decodeHexPlain(s string) string {
+	if !strings.HasPrefix(s, "$HEX[") || !strings.HasSuffix(s, "]") {
+		return s
+	}
+	decoded, err := hex.DecodeString(s[len("$HEX[") : len(s)-1])
+	if err != nil {
+		return s
+	}
+	return string(decoded)
+}
+
+// hexEscaper applies --hex-escape: it decodes $HEX[...] plaintexts read
+// from the input and encodes usernames and plaintexts written to the output
+// the way hashcat does, so they can't be mistaken for a delimiter. The zero
+// value leaves everything alone.
+type hexEscaper struct {
+	on        bool
+	delimiter string // the output delimiter, escaped besides ":"
+}
+
+// decode decodes s if it is $HEX[...] encoded.
+func (e hexEscaper) decode(s string) string {
+	if !e.on {
+		return s
+	}
+	return decodeHexPlain(s)
+}
+
+// escape returns s as $HEX[...] if it contains the delimiter, a colon or a
+// byte outside printable ASCII, or already looks $HEX[...] encoded.
+func (e hexEscaper) escape(s string) string {
+	if !e.on || !e.needsEscape(s) {
+		return s
+	}
+	return "$HEX[" + hex.EncodeToString([]byte(s)) + "]"
+}
+
+func (e hexEscaper) needsEscape(s string) bool {
+	if strings.HasPrefix(s, "$HEX[") || strings.Contains(s, ":") || e.delimiter != "" && strings.Contains(s, e.delimiter) {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+func TestHexEscapeRoundTrip(t *testing.T) {
+	e := hexEscaper{on: true, delimiter: ","}
+	tests := []struct {
+		name, plain, escaped string
+	}{
+		{"printable", "Passw0rd!", "Passw0rd!"},
+		{"empty", "", ""},
+		{"colon", "a:b", "$HEX[613a62]"},
+		{"delimiter", "a,b", "$HEX[612c62]"},
+		{"not utf-8", "\xff\xfe\x00pw", "$HEX[fffe007077]"},
+		{"control", "pw\t\r\n", "$HEX[7077090d0a]"},
+		{"non-ascii", "Pässw0rd", "$HEX[50c3a4737377307264]"},
+		{"emoji", "😀", "$HEX[f09f9880]"},
+		// A literal $HEX[ prefix is escaped, so decoding doesn't turn it
+		// into the bytes it seems to encode.
+		{"hex prefix", "$HEX[41]", "$HEX[244845585b34315d]"},
+		{"hex prefix unterminated", "$HEX[zz", "$HEX[244845585b7a7a]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			escaped := e.escape(tt.plain)
+			if escaped != tt.escaped {
+				t.Errorf("escape(%q) = %s, want %s", tt.plain, escaped, tt.escaped)
+			}
+			if got := e.decode(escaped); got != tt.plain {
+				t.Errorf("decode(%s) = %q, want %q", escaped, got, tt.plain)
+			}
+		})
+	}
+}
+
+func TestHexEscapeOff(t *testing.T) {
+	var e hexEscaper
+	for _, s := range []string{"a:b", "\xff", "$HEX[41]"} {
+		if got := e.escape(s); got != s {
+			t.Errorf("escape(%q) = %q with --hex-escape off", s, got)
+		}
+		if got := e.decode(s); got != s {
+			t.Errorf("decode(%q) = %q with --hex-escape off", s, got)
+		}
+	}
+}
+
+func TestDecodeHexPlain(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"$HEX[70617373]", "pass"},
+		{"$HEX[3a]", ":"},
+		{"$HEX[FFFE]", "\xff\xfe"},
+		{"$HEX[]", ""},
+		// Not valid $HEX[...]: left alone.
+		{"$HEX[7]", "$HEX[7]"},
+		{"$HEX[zz]", "$HEX[zz]"},
+		{"$HEX[70617373", "$HEX[70617373"},
+		{"$hex[70617373]", "$hex[70617373]"},
+		{"x$HEX[70617373]", "x$HEX[70617373]"},
+	}
+	for _, tt := range tests {
+		if got := decodeHexPlain(tt.in); got != tt.want {
+			t.Errorf("decodeHexPlain(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestHexEscapeDecrypt checks decrypt's output splits back into the
+// username and password at the colon, whatever they hold. Encrypted
+// passwords are UTF-16, so they are valid Unicode.
+func TestHexEscapeDecrypt(t *testing.T) {
+	esc := hexEscaper{on: true, delimiter: ":"}
+	c, err := aspnethash.NewPasswordCipher("aes", make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		username, password string
+	}{
+		{"CORP:jsmith", "pw:with:colons"},
+		{"jörg", "$HEX[70617373]"},
+		{"admin", "Pässw0rd\t"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			encrypted, err := c.Encrypt(tt.password, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			line, err := decryptLine(tt.username+","+encrypted, true, ",", ":", false, c, esc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Count(line, ":") != 1 {
+				t.Fatalf("decrypt wrote %q", line)
+			}
+			username, password, _ := strings.Cut(line, ":")
+			if got := esc.decode(username); got != tt.username {
+				t.Errorf("username %q decodes to %q, want %q", username, got, tt.username)
+			}
+			if got := esc.decode(password); got != tt.password {
+				t.Errorf("password %q decodes to %q, want %q", password, got, tt.password)
+			}
+		})
+	}
+}
+
+// TestHexEscapeVerify checks a $HEX[...] plaintext is verified as the
+// bytes it encodes, not valid UTF-8 or not.
+func TestHexEscapeVerify(t *testing.T) {
+	esc := hexEscaper{on: true, delimiter: ":"}
+	for _, password := range []string{"\x00\xff\xfebinary", "pw:with:colons", "$HEX[70617373]", "Pässw0rd"} {
+		hash, err := generateHash(password, "mvc4", aspnethash.DefaultIterations, aspnethash.DefaultSubkeyLength, aspnethash.DefaultSaltSize, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		escaped := esc.escape(password)
+		if _, err := verifyLine(escaped+":"+hash, ":", false, aspnethash.DefaultIterations, "mvc4", nil, esc); err != nil {
+			t.Errorf("verify %s: %v", escaped, err)
+		}
+	}
+}
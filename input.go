@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// defaultMaxLineBytes is the default for --max-line-bytes.
+const defaultMaxLineBytes = 4 * 1024 * 1024
+
+// utf8BOM is stripped from the start of the input.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// errLineTooLong is reported for input lines longer than --max-line-bytes.
+var errLineTooLong = errors.New("line exceeds --max-line-bytes")
+
+// lineReader splits its input into lines much like bufio.Scanner with
+// ScanLines, but a line longer than limit is skipped and reported through
+// tooLong instead of ending the whole run. With a delimiter other than '\n'
+// it splits on that byte instead and leaves carriage returns alone.
+//
+// A UTF-8 byte order mark at the start of the input is always dropped, and
+// so is the \r of a CRLF line ending unless keepCR is set.
+//
+// If joinWrapped is set, lines it reports as continuing the one before
+// (hashes wrapped over several lines, see --fix) are appended to it and
+// returned as one line, and wrapped tells so.
+//
+// pos counts the bytes read, BOM and line endings included, so start is
+// where the current line begins in the input, for --with-source.
+type lineReader struct {
+	r       *bufio.Reader
+	delim   byte
+	limit   int
+	keepCR  bool
+	started bool
+	buf     []byte
+	tooLong bool
+	err     error
+	pos     int64
+	start   int64
+
+	joinWrapped func(last, next []byte) bool
+	wrapped     bool
+	// held is a line read ahead to see whether it continues the one
+	// before, and heldTooLong whether it was too long.
+	held        []byte
+	holding     bool
+	heldTooLong bool
+	heldStart   int64
+}
+
+func newLineReader(r io.Reader, delim byte, limit int) *lineReader {
+	size := 64 * 1024
+	if limit < size {
+		size = limit
+	}
+	return &lineReader{r: bufio.NewReaderSize(r, size), delim: delim, limit: limit}
+}
+
+// next advances to the next line. It returns false at the end of the input
+// or when reading fails, in which case readErr returns the error.
+func (l *lineReader) next() bool {
+	if l.joinWrapped == nil {
+		return l.read()
+	}
+	l.wrapped = false
+	if l.err != nil {
+		return false
+	}
+	if l.holding {
+		l.buf, l.held = l.held, l.buf
+		l.tooLong, l.holding, l.start = l.heldTooLong, false, l.heldStart
+	} else if !l.read() {
+		return false
+	}
+	last := l.buf
+	for !l.tooLong {
+		line, lineStart := l.buf, l.start
+		l.buf = l.held
+		more := l.read()
+		next, nextTooLong, nextStart := l.buf, l.tooLong, l.start
+		l.buf, l.tooLong, l.start = line, false, lineStart
+		if !more {
+			l.held = next
+			break
+		}
+		if nextTooLong || !l.joinWrapped(last, next) {
+			l.held, l.holding, l.heldTooLong, l.heldStart = next, true, nextTooLong, nextStart
+			break
+		}
+		start := len(l.buf)
+		l.buf = append(l.buf, next...)
+		l.held = next
+		last = l.buf[start:]
+		l.wrapped = true
+		if len(l.buf) > l.limit {
+			l.tooLong = true
+			l.buf = l.buf[:0]
+		}
+	}
+	return true
+}
+
+// read reads the next physical line into buf.
+func (l *lineReader) read() bool {
+	l.buf = l.buf[:0]
+	l.tooLong = false
+	read := false
+
+	if !l.started {
+		l.started = true
+		if prefix, _ := l.r.Peek(len(utf8BOM)); bytes.Equal(prefix, utf8BOM) {
+			l.r.Discard(len(utf8BOM))
+			l.pos += int64(len(utf8BOM))
+		}
+	}
+
+	l.start = l.pos
+	for {
+		chunk, err := l.r.ReadSlice(l.delim)
+		if len(chunk) > 0 {
+			read = true
+		}
+		l.pos += int64(len(chunk))
+		if !l.tooLong {
+			// Leave room for a CRLF terminator; trimEOL checks the exact length.
+			if len(l.buf)+len(chunk) > l.limit+2 {
+				// Keep reading to the end of the line, but drop its content.
+				l.tooLong = true
+				l.buf = l.buf[:0]
+			} else {
+				l.buf = append(l.buf, chunk...)
+			}
+		}
+
+		switch err {
+		case nil:
+			l.trimEOL()
+			return true
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			l.trimEOL()
+			return read
+		default:
+			l.err = err
+			return false
+		}
+	}
+}
+
+// trimEOL drops the line terminator, including the \r of a CRLF ending.
+func (l *lineReader) trimEOL() {
+	if n := len(l.buf); n > 0 && l.buf[n-1] == l.delim {
+		l.buf = l.buf[:n-1]
+	}
+	if n := len(l.buf); n > 0 && l.delim == '\n' && !l.keepCR && l.buf[n-1] == '\r' {
+		l.buf = l.buf[:n-1]
+	}
+	if !l.tooLong && len(l.buf) > l.limit {
+		l.tooLong = true
+		l.buf = l.buf[:0]
+	}
+}
+
+// text returns the current line. It is empty for a line that was too long.
+func (l *lineReader) text() string {
+	return string(l.buf)
+}
+
+// offset returns the byte offset of the current line in the input.
+func (l *lineReader) offset() int64 {
+	return l.start
+}
+
+// lineTooLong reports whether the current line exceeded the limit.
+func (l *lineReader) lineTooLong() bool {
+	return l.tooLong
+}
+
+// readErr returns the first read error other than io.EOF.
+func (l *lineReader) readErr() error {
+	return l.err
+}
+
+// batchLine is one input line as handed to a worker. lineNo counts over all
+// inputs; fileLine is the line in the input file, and offset the byte it
+// starts at.
+type batchLine struct {
+	lineNo   int64
+	file     int
+	fileLine int64
+	offset   int64
+	text     string
+	tooLong  bool
+	// username is the --usernames-file line paired with the line.
+	username string
+}
+
+// lineBatch is the batch of one input the producer is filling. Besides its
+// lines, it accounts for the lines dropped between and after them, which
+// are only counted once it is submitted, so a batch the run stops before
+// submitting leaves no trace in the stats.
+type lineBatch struct {
+	lines []batchLine
+	file  int
+	// first and last are the lines the batch accounts for; first is 0
+	// while there are none.
+	first, last int64
+	// duplicates counts the lines --unique and --duplicate-usernames
+	// dropped, and skipped those --sample, --shard and --blank-usernames
+	// skip left out, of which sampledOut and outOfShard count the first
+	// two.
+	duplicates, skipped, sampledOut, outOfShard int64
+}
+
+// account adds lineNo, a line of the file-th input, to the lines the batch
+// accounts for.
+func (b *lineBatch) account(lineNo int64, file int) {
+	if b.first == 0 {
+		b.first, b.file = lineNo, file
+	}
+	b.last = lineNo
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal rather
+// than a pipe or a redirected file.
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// readLines reads all lines of input with a lineReader, "<too long>"
+// standing for the lines over limit.
+func readLines(t *testing.T, input []byte, limit int, keepCR bool) []string {
+	t.Helper()
+	l := newLineReader(bytes.NewReader(input), '\n', limit)
+	l.keepCR = keepCR
+	var lines []string
+	for l.next() {
+		if l.lineTooLong() {
+			lines = append(lines, "<too long>")
+		} else {
+			lines = append(lines, l.text())
+		}
+	}
+	if err := l.readErr(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+// splitLines is what readLines should return for input without a BOM and
+// with no line over the limit.
+func splitLines(input string, keepCR bool) []string {
+	input = strings.TrimSuffix(input, "\n")
+	if input == "" {
+		return nil
+	}
+	lines := strings.Split(input, "\n")
+	if !keepCR {
+		for i, line := range lines {
+			lines[i] = strings.TrimSuffix(line, "\r")
+		}
+	}
+	return lines
+}
+
+func equalLines(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines %q, want %d %q", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("line %d is %q, want %q", i+1, got[i], want[i])
+		}
+	}
+}
+
+// TestLineReaderBufferEdges moves lines and CRLF endings across every
+// offset of the read buffer, which is as large as the limit when that is
+// smaller than 64KiB.
+func TestLineReaderBufferEdges(t *testing.T) {
+	const limit = 32
+	for pad := 0; pad <= 2*limit; pad++ {
+		for _, keepCR := range []bool{false, true} {
+			var b strings.Builder
+			b.WriteString(strings.Repeat("p", pad%limit) + "\n")
+			for n := 0; n < limit; n++ {
+				b.WriteString(strings.Repeat(string(rune('a'+n%26)), n))
+				if n%2 == 0 {
+					b.WriteString("\r\n")
+				} else {
+					b.WriteString("\n")
+				}
+			}
+			b.WriteString(strings.Repeat("z", pad%limit))
+			input := b.String()
+			equalLines(t, readLines(t, []byte(input), limit, keepCR), splitLines(input, keepCR))
+		}
+	}
+}
+
+func TestLineReaderOneByteReads(t *testing.T) {
+	input := "first\r\nsecond\n\r\n\nlast\r"
+	l := newLineReader(iotest.OneByteReader(strings.NewReader(input)), '\n', 16)
+	var got []string
+	for l.next() {
+		got = append(got, l.text())
+	}
+	// A lone \r at the end of the input is the CR of a line ending cut off.
+	equalLines(t, got, []string{"first", "second", "", "", "last"})
+}
+
+func TestLineReaderLimit(t *testing.T) {
+	const limit = 16
+	exact := strings.Repeat("x", limit)
+	tests := []struct {
+		name, input string
+		want        []string
+	}{
+		{"exact", exact + "\n" + exact + "\r\n" + exact, []string{exact, exact, exact}},
+		{"one over", exact + "y\nok\n", []string{"<too long>", "ok"}},
+		// A line over the limit spans several buffers; the lines around
+		// it are read whole.
+		{"several buffers", "a\n" + strings.Repeat("x", 5*limit+3) + "\r\nb\r\n", []string{"a", "<too long>", "b"}},
+		{"too long last", "a\n" + strings.Repeat("x", 3*limit), []string{"a", "<too long>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			equalLines(t, readLines(t, []byte(tt.input), limit, false), tt.want)
+		})
+	}
+}
+
+func TestLineReaderBOM(t *testing.T) {
+	tests := []struct {
+		name, input string
+		want        []string
+	}{
+		{"bom crlf", "\xef\xbb\xbfuser,hash\r\nuser2,hash2\r\n", []string{"user,hash", "user2,hash2"}},
+		{"bom only", "\xef\xbb\xbf", nil},
+		{"bom empty line", "\xef\xbb\xbf\r\nx\r\n", []string{"", "x"}},
+		// Only a BOM at the very start of the input is dropped.
+		{"second line", "a\r\n\xef\xbb\xbfb\r\n", []string{"a", "\xef\xbb\xbfb"}},
+		{"partial bom", "\xef\xbbx\n", []string{"\xef\xbbx"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			equalLines(t, readLines(t, []byte(tt.input), defaultMaxLineBytes, false), tt.want)
+		})
+	}
+}
+
+func TestLineReaderOtherDelimiter(t *testing.T) {
+	// With a delimiter other than \n, carriage returns and newlines are
+	// part of the lines.
+	l := newLineReader(strings.NewReader("a\r\nb\x00c\r\x00"), 0, 16)
+	var got []string
+	for l.next() {
+		got = append(got, l.text())
+	}
+	equalLines(t, got, []string{"a\r\nb", "c\r"})
+}
+
+// TestBOMCRLFFixtures runs the tool on dumps saved by Windows tools, with a
+// BOM and CRLF line endings, next to the same dumps with neither.
+func TestBOMCRLFFixtures(t *testing.T) {
+	t.Run("convert", func(t *testing.T) {
+		want := mustRunTool(t, "", "convert", "-u", "-q", "--ordered", "--input", "testdata/hashes_lf.txt")
+		if got := mustRunTool(t, "", "convert", "-u", "-q", "--ordered", "--input", "testdata/hashes_bom_crlf.txt"); got != want {
+			t.Errorf("got\n%s\nwant\n%s", got, want)
+		}
+		if !strings.HasPrefix(want, "alice:sha1:1000:") {
+			t.Errorf("unexpected output %q", want)
+		}
+	})
+
+	plain, err := os.ReadFile("testdata/plain_lf.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := splitLines(string(plain), false)
+	for _, keepCR := range []bool{false, true} {
+		name := "generate"
+		args := []string{"generate", "-u", "-q", "--ordered", "--input", "testdata/plain_bom_crlf.txt"}
+		if keepCR {
+			name += " --keep-cr"
+			args = append(args, "--keep-cr")
+		}
+		t.Run(name, func(t *testing.T) {
+			lines := splitLines(mustRunTool(t, "", args...), false)
+			if len(lines) != len(accounts) {
+				t.Fatalf("got %d lines, want %d", len(lines), len(accounts))
+			}
+			for i, account := range accounts {
+				username, password, _ := strings.Cut(account, ",")
+				gotUsername, hash, _ := strings.Cut(lines[i], ":")
+				// The BOM isn't part of the first username.
+				if gotUsername != username {
+					t.Errorf("username %q, want %q", gotUsername, username)
+				}
+				if keepCR {
+					password += "\r"
+				}
+				if ok, err := aspnethash.Verify(password, hash); err != nil || !ok {
+					t.Errorf("%s: the hash isn't of %q (%v)", username, password, err)
+				}
+			}
+		})
+	}
+}
+
+// TestLineReaderOffsets checks every line starts at its offset in the
+// input, across buffer boundaries, after a BOM and with lines joined by
+// --fix.
+func TestLineReaderOffsets(t *testing.T) {
+	const limit = 32
+	var b strings.Builder
+	b.Write(utf8BOM)
+	for n := 0; n < 3*limit; n++ {
+		b.WriteString(strings.Repeat(string(rune('a'+n%26)), n%(limit+8)))
+		if n%3 == 0 {
+			b.WriteString("\r\n")
+		} else {
+			b.WriteString("\n")
+		}
+	}
+	input := b.String()
+	l := newLineReader(strings.NewReader(input), '\n', limit)
+	want := int64(len(utf8BOM))
+	for l.next() {
+		if l.offset() != want {
+			t.Fatalf("line %q at offset %d, want %d", l.text(), l.offset(), want)
+		}
+		if !l.lineTooLong() && !strings.HasPrefix(input[want:], l.text()) {
+			t.Fatalf("line %q isn't at offset %d", l.text(), want)
+		}
+		want += int64(strings.IndexByte(input[want:], '\n') + 1)
+	}
+	if want != int64(len(input)) {
+		t.Errorf("read up to %d of %d bytes", want, len(input))
+	}
+
+	// A wrapped line is at the offset of its first part, and the line
+	// read ahead after it at its own.
+	wrapped := "x,AAAA\r\n" + strings.Repeat("A", 64) + "\r\nBBBB\r\ny,CCCC\r\n"
+	l = newLineReader(strings.NewReader(wrapped), '\n', 256)
+	l.joinWrapped = wrappedContinuation(",")
+	var offsets []int64
+	for l.next() {
+		offsets = append(offsets, l.offset())
+	}
+	if want := []int64{0, 8, 80}; !slices.Equal(offsets, want) {
+		t.Errorf("offsets %v, want %v", offsets, want)
+	}
+}
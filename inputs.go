@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// inputSource is one of the inputs a run reads, in order.
+type inputSource struct {
+	// path is the file to read; empty for stdin.
+	path string
+	// random is set instead for the plaintexts of --random.
+	random *randomPlaintexts
+	// section, if set, is the only part of the file read, for --shard.
+	section *byteRange
+}
+
+func (s inputSource) name() string {
+	if s.random != nil {
+		return "--random"
+	}
+	if s.path == "" {
+		return "stdin"
+	}
+	return s.path
+}
+
+// readsStdin reports whether stdin is one of the inputs of a run, or holds
+// the --files-from list.
+func readsStdin(inputPath string, args []string, filesFrom string, listed []string) bool {
+	if filesFrom == "-" {
+		return true
+	}
+	for _, src := range inputSources(inputPath, args, filesFrom, listed) {
+		if src.path == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// inputSources lists the inputs of a run: --input, then the positional
+// arguments, then listed, the files in the --files-from list. "-" stands
+// for stdin, which is also the only input if none is given, unless there
+// is a --files-from list, which may then turn out to be empty.
+func inputSources(inputPath string, args []string, filesFrom string, listed []string) []inputSource {
+	paths := slices.Clone(args)
+	if inputPath != "" {
+		paths = append([]string{inputPath}, paths...)
+	}
+	paths = append(paths, listed...)
+	if len(paths) == 0 && filesFrom == "" {
+		return []inputSource{{}}
+	}
+	sources := make([]inputSource, len(paths))
+	for i, p := range paths {
+		if p != "-" {
+			sources[i].path = p
+		}
+	}
+	return sources
+}
+
+// readFilesFrom reads a --files-from list, from stdin if path is "-": one
+// path or filepath.Glob pattern per line, skipping blank lines and lines
+// starting with #. Patterns expand to their matches in lexical order; the
+// ones matching nothing are returned in unmatched.
+func readFilesFrom(path string) (paths, unmatched []string, err error) {
+	var f io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+		f = file
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.ContainsAny(line, "*?[") {
+			paths = append(paths, line)
+			continue
+		}
+		matches, err := filepath.Glob(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%q: %w", line, err)
+		}
+		if len(matches) == 0 {
+			unmatched = append(unmatched, line)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, unmatched, scanner.Err()
+}
+
+// openInput opens src for reading with the --input-compression and
+// --input-charset given, and returns it with the name to show in the log.
+// The returned closer closes the file, if there is one. The bytes read from
+// a file are added to bytesRead. A limiter, if given, throttles the
+// reading of files and stdin before decompression.
+func openInput(src inputSource, compression, charset string, bytesRead *int64, limiter *byteLimiter) (r io.Reader, name string, closer io.Closer, err error) {
+	if src.random != nil {
+		return src.random, src.name(), io.NopCloser(src.random), nil
+	}
+	var f io.Reader = os.Stdin
+	closer = io.NopCloser(os.Stdin)
+	if src.path != "" {
+		file, err := os.Open(longPath(src.path))
+		if err != nil {
+			return nil, "", nil, err
+		}
+		f, closer = countingReader{file, bytesRead}, file
+		if src.section != nil {
+			f = countingReader{io.NewSectionReader(file, src.section.start, src.section.end-src.section.start), bytesRead}
+			// The file was checked not to be compressed when it was cut;
+			// the section needn't start where magic bytes would be.
+			compression = "none"
+		}
+	}
+	if limiter != nil {
+		f = limiter.reader(f)
+	}
+	decompressed, detected, err := decompressInput(f, compression)
+	if err != nil {
+		closer.Close()
+		return nil, "", nil, fmt.Errorf("%s: %w", src.name(), err)
+	}
+	name = src.name()
+	if detected != "none" {
+		name += " (" + detected + ")"
+	}
+	if r, err = decodeInput(decompressed, charset); err != nil {
+		closer.Close()
+		return nil, "", nil, err
+	}
+	return r, name, closer, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// inputShardStrategies are the accepted values of --shard-strategy.
+var inputShardStrategies = []string{"modulo", "bytes"}
+
+// inputShard is the part of the input a run processes with --shard i/n, so
+// n runs with i from 0 to n-1 process every line exactly once between them.
+// With the modulo strategy it takes the lines whose number, as without
+// --shard, is i modulo n, so shard 0 starts at line n; with bytes, the lines starting in the i-th
+// of n equal byte ranges of a single uncompressed file, read alone and
+// numbered from the start of the range.
+type inputShard struct {
+	index, count int64
+	strategy     string
+	// section is the byte range of the input read, for bytes.
+	section *byteRange
+}
+
+// byteRange is a part of an input file, from start up to end.
+type byteRange struct {
+	start, end int64
+}
+
+// parseInputShard parses the i/n of --shard.
+func parseInputShard(s, strategy string) (*inputShard, error) {
+	i, n, ok := strings.Cut(s, "/")
+	index, err1 := strconv.ParseInt(strings.TrimSpace(i), 10, 64)
+	count, err2 := strconv.ParseInt(strings.TrimSpace(n), 10, 64)
+	if !ok || err1 != nil || err2 != nil || count < 1 || index < 0 || index >= count {
+		return nil, fmt.Errorf("%q isn't i/n with 0 <= i < n", s)
+	}
+	return &inputShard{index: index, count: count, strategy: strategy}, nil
+}
+
+// owns reports whether line lineNo of the input belongs to the shard, with
+// the modulo strategy.
+func (s *inputShard) owns(lineNo int64) bool {
+	return lineNo%s.count == s.index
+}
+
+// cut finds the byte range of the shard in src, for the bytes strategy:
+// the lines starting in the i-th of n equal parts of the file. Every shard
+// cuts the file at the same line boundaries, so each line is in one range.
+func (s *inputShard) cut(src inputSource, compression, charset string, terminator byte) error {
+	if src.path == "" || src.random != nil {
+		return fmt.Errorf("it needs an input file to seek in, not %s", src.name())
+	}
+	f, err := os.Open(longPath(src.path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src.name())
+	}
+	switch compressed, utf16, err := sniffInput(f, compression, charset); {
+	case err != nil:
+		return err
+	case compressed:
+		return fmt.Errorf("%s is compressed", src.name())
+	case utf16 || charset == "utf16le" || charset == "utf16be":
+		return fmt.Errorf("%s is UTF-16", src.name())
+	}
+	size := info.Size()
+	// index*size/count without overflowing for large files.
+	at := func(i int64) int64 { return i*(size/s.count) + i*(size%s.count)/s.count }
+	start, err := lineStart(f, at(s.index), size, terminator)
+	if err != nil {
+		return err
+	}
+	end, err := lineStart(f, at(s.index+1), size, terminator)
+	if err != nil {
+		return err
+	}
+	s.section = &byteRange{start: start, end: end}
+	return nil
+}
+
+// lineStart returns the offset of the first line of f starting at or after
+// off, or size if there is none.
+func lineStart(f *os.File, off, size int64, terminator byte) (int64, error) {
+	if off <= 0 || off >= size {
+		return min(max(off, 0), size), nil
+	}
+	buf := make([]byte, 64<<10)
+	// A line starts at off if the one before ends right before it.
+	for pos := off - 1; pos < size; {
+		n, err := f.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], terminator); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		pos += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// label names the shard as the log and the stats show it.
+func (s *inputShard) label() string {
+	return fmt.Sprintf("%d/%d", s.index, s.count)
+}
+
+// describe tells what the shard takes of the input, for the log.
+func (s *inputShard) describe() string {
+	if s.section != nil {
+		return fmt.Sprintf("bytes %s to %s", groupThousands(s.section.start), groupThousands(s.section.end))
+	}
+	first := s.index
+	if first == 0 {
+		first = s.count
+	}
+	return fmt.Sprintf("lines %s, %s, %s and so on", groupThousands(first), groupThousands(first+s.count), groupThousands(first+2*s.count))
+}
+
+// inputShardReport is the --shard part of the stats.
+type inputShardReport struct {
+	Shard    string `json:"shard"`
+	Index    int64  `json:"index"`
+	Count    int64  `json:"count"`
+	Strategy string `json:"strategy"`
+	// OutOfShard counts the lines left to the other shards, with modulo.
+	OutOfShard *int64 `json:"out_of_shard_lines,omitempty"`
+	// StartByte and EndByte are the byte range read, with bytes; the lines
+	// outside it aren't read, so they aren't counted.
+	StartByte *int64 `json:"start_byte,omitempty"`
+	EndByte   *int64 `json:"end_byte,omitempty"`
+}
+
+// report sums up the shard, given the lines it left out.
+func (s *inputShard) report(outOfShard int64) *inputShardReport {
+	r := &inputShardReport{Shard: s.label(), Index: s.index, Count: s.count, Strategy: s.strategy}
+	if s.section != nil {
+		r.StartByte, r.EndByte = &s.section.start, &s.section.end
+	} else {
+		r.OutOfShard = &outOfShard
+	}
+	return r
+}
+
+// logSummary logs the report after the run summary.
+func (r *inputShardReport) logSummary() {
+	if r.StartByte != nil {
+		log.Printf("Shard %s (bytes): bytes %s to %s of the input; the lines outside them were not read", r.Shard, groupThousands(*r.StartByte), groupThousands(*r.EndByte))
+		return
+	}
+	log.Printf("Shard %s (modulo): %s lines left to the other shards", r.Shard, groupThousands(*r.OutOfShard))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// shardRun runs args on path as shard i of n, or unsharded if n is 0, and
+// returns its output lines and the lines its stats say it processed or
+// failed on.
+func shardRun(t *testing.T, args []string, path string, i, n int, strategy string) (lines []string, handled int64) {
+	t.Helper()
+	stats := filepath.Join(t.TempDir(), "stats.json")
+	args = append(args, "-q", "--ordered", "--stats-json", stats)
+	if n > 0 {
+		args = append(args, "--shard", fmt.Sprintf("%d/%d", i, n), "--shard-strategy", strategy)
+	}
+	out := mustRunTool(t, "", append(args, path)...)
+	b, err := os.ReadFile(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s runStats
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+	return splitLines(out, false), s.Processed + s.Errored
+}
+
+// TestShardUnion runs every shard of an input and checks their outputs put
+// together are the unsharded output, with every line handled by exactly
+// one shard: interleaved for modulo, in shard order for bytes.
+func TestShardUnion(t *testing.T) {
+	dir := t.TempDir()
+	hashes := strings.Split(strings.TrimSuffix(syntheticHashes(1000), "\n"), "\n")
+	var dump strings.Builder
+	for i, line := range hashes {
+		switch {
+		case i%97 == 5:
+			// A line convert fails on counts as handled too.
+			dump.WriteString("no delimiter")
+		case i%3 == 0:
+			// Lines of different lengths, so the byte ranges cut them
+			// anywhere.
+			dump.WriteString(line + strings.Repeat(" ", i%11))
+		default:
+			dump.WriteString(line)
+		}
+		if i%2 == 0 {
+			dump.WriteString("\r\n")
+		} else if i != len(hashes)-1 {
+			// The last line has no newline.
+			dump.WriteString("\n")
+		}
+	}
+	dumpPath := filepath.Join(dir, "dump.txt")
+	if err := os.WriteFile(dumpPath, []byte(dump.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var plain strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&plain, "user%d,pw%d\n", i, i%13)
+	}
+	plainPath := filepath.Join(dir, "plain.txt")
+	if err := os.WriteFile(plainPath, []byte(plain.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	shortPath := filepath.Join(dir, "short.txt")
+	if err := os.WriteFile(shortPath, []byte(strings.Join(hashes[:3], "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	convert := []string{"convert", "-u", "--trim"}
+	tests := []struct {
+		name     string
+		args     []string
+		path     string
+		strategy string
+		counts   []int
+	}{
+		{"convert modulo", convert, dumpPath, "modulo", []int{1, 3, 7}},
+		{"convert bytes", convert, dumpPath, "bytes", []int{1, 3, 7}},
+		// More shards than lines leaves some of them nothing.
+		{"convert bytes short", convert, shortPath, "bytes", []int{2, 5}},
+		{"convert modulo short", convert, shortPath, "modulo", []int{5}},
+		// Lines keep their numbers, so the salts are the same.
+		{"generate modulo", []string{"generate", "-u", "--salt-seed", "shards"}, plainPath, "modulo", []int{4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, wantHandled := shardRun(t, tt.args, tt.path, 0, 0, "")
+			for _, n := range tt.counts {
+				var handled int64
+				shards := make([][]string, n)
+				for i := range shards {
+					var h int64
+					shards[i], h = shardRun(t, tt.args, tt.path, i, n, tt.strategy)
+					handled += h
+				}
+				if handled != wantHandled {
+					t.Errorf("%d shards handled %d lines, want %d", n, handled, wantHandled)
+				}
+				if tt.strategy == "bytes" {
+					var got []string
+					for _, lines := range shards {
+						got = append(got, lines...)
+					}
+					equalLines(t, got, want)
+					continue
+				}
+				// userN is on line N+1, which is shard (N+1)%n's, so
+				// each shard writes its own of the unsharded lines, in
+				// order.
+				own := make([][]string, n)
+				for _, line := range want {
+					var user int
+					if _, err := fmt.Sscanf(line, "user%d:", &user); err != nil {
+						t.Fatalf("unexpected line %q", line)
+					}
+					own[(user+1)%n] = append(own[(user+1)%n], line)
+				}
+				for i := range shards {
+					equalLines(t, shards[i], own[i])
+				}
+			}
+		})
+	}
+}
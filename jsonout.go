@@ -0,0 +1,27 @@
+package main
+
+import "encoding/json"
+
+// convertRecord is a convert result as --json writes it, one object per
+// line. Username is left out without --username.
+type convertRecord struct {
+	Username *string `json:"username,omitempty"`
+	Hash     string  `json:"hash"`
+	*recordSource
+}
+
+// recordSource is where the input line of a record is, for --with-source.
+// Line is the line in File, and ByteOffset the byte it starts at, counting
+// the BOM and the line endings before it. For a compressed file it is the
+// offset in the uncompressed data.
+type recordSource struct {
+	File       string `json:"file"`
+	Line       int64  `json:"line"`
+	ByteOffset int64  `json:"byte_offset"`
+}
+
+// jsonRecord returns the --json object of the hash line of a convert result.
+func jsonRecord(username *string, hash string, source *recordSource) (string, error) {
+	data, err := json.Marshal(convertRecord{Username: username, Hash: hash, recordSource: source})
+	return string(data), err
+}
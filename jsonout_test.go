@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// jsonObject is a convertRecord as read back, the source fields set to
+// their zero values when left out.
+type jsonObject struct {
+	Username   *string `json:"username"`
+	Hash       string  `json:"hash"`
+	File       string  `json:"file"`
+	Line       int64   `json:"line"`
+	ByteOffset int64   `json:"byte_offset"`
+}
+
+// jsonObjects decodes the --json output of convert.
+func jsonObjects(t *testing.T, out string) []jsonObject {
+	t.Helper()
+	var records []jsonObject
+	for _, line := range splitLines(out, false) {
+		var r jsonObject
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestConvertJSON(t *testing.T) {
+	lines := splitLines(mustRunTool(t, "", "convert", "-u", "-q", "testdata/hashes_lf.txt"), false)
+	records := jsonObjects(t, mustRunTool(t, "", "convert", "-u", "-q", "--json", "testdata/hashes_lf.txt"))
+	if len(records) != len(lines) {
+		t.Fatalf("%d objects for %d lines", len(records), len(lines))
+	}
+	for i, r := range records {
+		if r.Username == nil || *r.Username+":"+r.Hash != lines[i] || r.File != "" {
+			t.Errorf("object %+v for %q", r, lines[i])
+		}
+	}
+	// Without --username there is no username field.
+	out := mustRunTool(t, "AAABAgMEBQYHCAkKCwwNDg8TEAcfPTbAR4SiWAURv/76tjH7y7DB7E7ZLpDmTdwP1g==\n", "convert", "-q", "--json")
+	if want := `{"hash":"sha1:1000:AAECAwQFBgcICQoLDA0ODw==:ExAHHz02wEeEolgFEb/++rYx+8uwwexO2S6Q5k3cD9Y="}` + "\n"; out != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
+
+// TestConvertJSONHexEscape checks the usernames in the objects are the
+// real ones, not the $HEX[...] form of the text output.
+func TestConvertJSONHexEscape(t *testing.T) {
+	input := "CORP:jörg,AAABAgMEBQYHCAkKCwwNDg8TEAcfPTbAR4SiWAURv/76tjH7y7DB7E7ZLpDmTdwP1g==\n"
+	if out := mustRunTool(t, input, "convert", "-u", "-q", "--hex-escape"); !strings.HasPrefix(out, "$HEX[") {
+		t.Fatalf("text output %q isn't escaped", out)
+	}
+	records := jsonObjects(t, mustRunTool(t, input, "convert", "-u", "-q", "--hex-escape", "--json"))
+	if len(records) != 1 || records[0].Username == nil || *records[0].Username != "CORP:jörg" ||
+		records[0].Hash != "sha1:1000:AAECAwQFBgcICQoLDA0ODw==:ExAHHz02wEeEolgFEb/++rYx+8uwwexO2S6Q5k3cD9Y=" {
+		t.Errorf("got %+v", records)
+	}
+}
+
+// checkSources checks every object's line starts at its byte_offset in
+// data, a file of username,hash lines.
+func checkSources(t *testing.T, data []byte, records []jsonObject, file string) {
+	t.Helper()
+	for _, r := range records {
+		if r.File != file {
+			t.Fatalf("object %+v, want the source in %s", r, file)
+		}
+		if r.ByteOffset < 0 || r.ByteOffset >= int64(len(data)) || !bytes.HasPrefix(data[r.ByteOffset:], []byte(*r.Username+",")) {
+			t.Errorf("%s isn't at offset %d", *r.Username, r.ByteOffset)
+		}
+	}
+}
+
+func TestConvertJSONWithSource(t *testing.T) {
+	data, err := os.ReadFile("testdata/hashes_bom_crlf.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := jsonObjects(t, mustRunTool(t, "", "convert", "-u", "-q", "--json", "--with-source", "testdata/hashes_bom_crlf.txt"))
+	if len(records) < 2 || records[0].ByteOffset != 3 || records[0].Line != 1 || records[1].Line != 2 {
+		t.Fatalf("got %+v", records)
+	}
+	checkSources(t, data, records, "testdata/hashes_bom_crlf.txt")
+
+	// A compressed file gives the offsets in the uncompressed data.
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "hashes.txt.gz")
+	if err := os.WriteFile(path, gz.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	want := mustRunTool(t, "", "convert", "-u", "-q", "--json", "--with-source", "testdata/hashes_bom_crlf.txt")
+	got := mustRunTool(t, "", "convert", "-u", "-q", "--json", "--with-source", path)
+	if got = strings.ReplaceAll(got, jsonString(path), jsonString("testdata/hashes_bom_crlf.txt")); got != want {
+		t.Errorf("got\n%s\nfrom the compressed file, want\n%s", got, want)
+	}
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// TestConvertJSONWithSourceShards checks --shard-strategy bytes gives
+// offsets in the whole file, and that the shards' objects put together
+// are those of an unsharded run but for their line numbers.
+func TestConvertJSONWithSourceShards(t *testing.T) {
+	input := syntheticHashes(300)
+	path := filepath.Join(t.TempDir(), "hashes.txt")
+	if err := os.WriteFile(path, []byte(input), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"convert", "-u", "-q", "--json", "--with-source", "--ordered"}
+	want := jsonObjects(t, mustRunTool(t, "", append(args, path)...))
+	var got []jsonObject
+	for i := 0; i < 3; i++ {
+		records := jsonObjects(t, mustRunTool(t, "", append(args, "--shard", fmt.Sprintf("%d/3", i), "--shard-strategy", "bytes", path)...))
+		checkSources(t, []byte(input), records, path)
+		got = append(got, records...)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d objects from the shards, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if *got[i].Username != *want[i].Username || got[i].Hash != want[i].Hash || got[i].ByteOffset != want[i].ByteOffset {
+			t.Fatalf("object %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestConvertJSONRefused(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		// want is in the error message.
+		want string
+	}{
+		{[]string{"convert", "-u", "--with-source"}, "give --json"},
+		{[]string{"convert", "-u", "--json", "--with-source", "--input-charset", "utf16le"}, "--input-charset"},
+		{[]string{"convert", "-u", "--json", "--with-source", "--pre-filter", "cat"}, "--pre-filter"},
+		{[]string{"convert", "-u", "--json", "--sort", "hash", "--force"}, "--sort"},
+		{[]string{"convert", "-u", "--json", "--split-by", "hash", "--split", "2", "-o", filepath.Join(t.TempDir(), "out.txt")}, "--split-by"},
+		{[]string{"convert", "--json", "-H", "AAABAgMEBQYHCAkKCwwNDg8TEAcfPTbAR4SiWAURv/76tjH7y7DB7E7ZLpDmTdwP1g=="}, "--hash"},
+		// Without a command every flag parses, so --json with -g would
+		// go unused.
+		{[]string{"-g", "--json"}, "convert mode"},
+	} {
+		run := runTool(t, "", tt.args...)
+		if run.exitCode == 0 || !strings.Contains(run.stderr, tt.want) {
+			t.Errorf("%v exited with %d: %s", tt.args, run.exitCode, run.stderr)
+		}
+	}
+}
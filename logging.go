@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Log levels. Everything is logged through the standard logger, or logWith
+// for entries with fields; logAt drops what the level set by --quiet and
+// --verbose doesn't ask for, and setupLogging points the logger at stderr
+// through a logWriter.
+const (
+	levelQuiet   = -1
+	levelDefault = 0
+	// levelVerbose (-v) adds the failed lines and periodic progress.
+	levelVerbose = 1
+	// levelDebug (-vv) adds the content of failed lines and startup and
+	// worker diagnostics.
+	levelDebug = 2
+)
+
+// verboseProgressInterval is how often -v logs the progress unless
+// --progress says otherwise.
+const verboseProgressInterval = 30 * time.Second
+
+// logLevel is the level set by setupLogging.
+var logLevel = levelDefault
+
+// logAt logs like log.Printf if the log level is at least level.
+func logAt(level int, format string, v ...any) {
+	if logLevel >= level {
+		log.Printf(format, v...)
+	}
+}
+
+const (
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiReset = "\x1b[0m"
+)
+
+// logFormats are the accepted values of --log-format.
+var logFormats = []string{"text", "json"}
+
+// logField is a value a log entry carries besides its message, such as the
+// line number of a failed line. Only the JSON format writes them; the text
+// format has them in the message already.
+type logField struct {
+	key   string
+	value any
+}
+
+// logEntry is one message of the log.
+type logEntry struct {
+	time   time.Time
+	msg    string
+	fields []logField
+}
+
+// isError reports whether the entry is an error: its message starts with
+// "Error" followed by a colon or space, or is a consistency failure.
+func (e logEntry) isError() bool {
+	return strings.HasPrefix(e.msg, "Error:") || strings.HasPrefix(e.msg, "Error ") || strings.HasPrefix(e.msg, "CONSISTENCY FAILURE")
+}
+
+// level names the entry's level in the JSON format.
+func (e logEntry) level() string {
+	switch {
+	case e.isError():
+		return "error"
+	case strings.HasPrefix(e.msg, "Warning"):
+		return "warning"
+	}
+	return "info"
+}
+
+// logFormatter writes log entries in one of the --log-format formats. An
+// entry without a message is the blank line before the summary.
+type logFormatter interface {
+	format(e logEntry) []byte
+}
+
+// textLog writes entries as the standard logger does:
+// "2006/01/02 15:04:05 <message>".
+type textLog struct{}
+
+func (textLog) format(e logEntry) []byte {
+	if e.msg == "" {
+		return []byte("\n")
+	}
+	return []byte(e.time.Format("2006/01/02 15:04:05 ") + e.msg + "\n")
+}
+
+// jsonLog writes each entry as one JSON object with ts, level and msg,
+// followed by its fields.
+type jsonLog struct{}
+
+func (jsonLog) format(e logEntry) []byte {
+	// Blank lines that set messages apart in the text format have no
+	// place here.
+	if e.msg = strings.TrimRight(e.msg, "\n"); e.msg == "" {
+		return nil
+	}
+	b := []byte(`{"ts":`)
+	b = appendJSON(b, e.time.UTC().Format(time.RFC3339Nano))
+	b = append(b, `,"level":`...)
+	b = appendJSON(b, e.level())
+	b = append(b, `,"msg":`...)
+	b = appendJSON(b, e.msg)
+	for _, f := range e.fields {
+		b = append(b, ',')
+		b = appendJSON(b, f.key)
+		b = append(b, ':')
+		b = appendJSON(b, f.value)
+	}
+	return append(b, "}\n"...)
+}
+
+// appendJSON appends v encoded as JSON, or as a string of the encoding
+// error if it has none.
+func appendJSON(b []byte, v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(err.Error())
+	}
+	return append(b, data...)
+}
+
+// logWriter is the output of the standard logger, which it hands every
+// message to without a prefix. With quiet set, it drops everything but
+// errors; with color set, it colors errors red and the run summary green.
+type logWriter struct {
+	w         io.Writer
+	formatter logFormatter
+	quiet     bool
+	color     bool
+	summary   atomic.Bool
+	mu        sync.Mutex
+}
+
+// stderrLog is the logWriter setupLogging installs.
+var stderrLog *logWriter
+
+// setupLogging sets the log level from --quiet and --verbose and sends the
+// log to stderr in the --log-format, colored if it is text, stderr is a
+// terminal and neither --no-color nor NO_COLOR turns it off.
+func setupLogging(quiet bool, verbose int, noColor bool, format string) {
+	logLevel = min(verbose, levelDebug)
+	if quiet {
+		logLevel = levelQuiet
+	}
+	stderrLog = &logWriter{
+		w:         os.Stderr,
+		formatter: textLog{},
+		quiet:     quiet,
+		color:     !noColor && os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(os.Stderr.Fd())),
+	}
+	if format == "json" {
+		stderrLog.formatter, stderrLog.color = jsonLog{}, false
+	}
+	log.SetFlags(0)
+	log.SetOutput(stderrLog)
+}
+
+// jsonLogging reports whether the log is in the JSON format.
+func jsonLogging() bool {
+	if stderrLog == nil {
+		return false
+	}
+	_, ok := stderrLog.formatter.(jsonLog)
+	return ok
+}
+
+// logWith logs like logAt, with fields for the JSON format.
+func logWith(level int, fields []logField, format string, v ...any) {
+	if logLevel < level {
+		return
+	}
+	if stderrLog == nil {
+		log.Printf(format, v...)
+		return
+	}
+	stderrLog.write(logEntry{time: time.Now(), msg: fmt.Sprintf(format, v...), fields: fields})
+}
+
+// Write takes a message of the standard logger.
+func (l *logWriter) Write(p []byte) (int, error) {
+	if err := l.write(logEntry{time: time.Now(), msg: strings.TrimSuffix(string(p), "\n")}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (l *logWriter) write(e logEntry) error {
+	isError := e.isError()
+	if l.quiet && !isError {
+		return nil
+	}
+	p := l.formatter.format(e)
+	color := ""
+	switch {
+	case !l.color || len(bytes.TrimSpace(p)) == 0:
+	case isError:
+		color = ansiRed
+	case l.summary.Load():
+		color = ansiGreen
+	}
+	if color != "" {
+		line := bytes.TrimSuffix(p, []byte("\n"))
+		colored := make([]byte, 0, len(p)+len(color)+len(ansiReset))
+		colored = append(colored, color...)
+		colored = append(colored, line...)
+		colored = append(colored, ansiReset...)
+		p = append(colored, p[len(line):]...)
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(p)
+	return err
+}
+
+// beginSummary marks the entries logged until the returned function is
+// called as the run summary.
+func beginSummary() (end func()) {
+	if stderrLog == nil {
+		return func() {}
+	}
+	stderrLog.summary.Store(true)
+	return func() { stderrLog.summary.Store(false) }
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// longPath returns path unchanged: only Windows limits the length of paths
+// this way.
+func longPath(path string) string {
+	return path
+}
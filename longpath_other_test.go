@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	long := strings.Repeat("d/", 200) + "out.txt"
+	for _, path := range []string{"", "out.txt", long, "/" + long} {
+		if got := longPath(path); got != path {
+			t.Errorf("longPath(%q) = %q, want it unchanged", path, got)
+		}
+	}
+}
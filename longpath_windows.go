@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is MAX_PATH; directories are limited to 12 characters less, to
+// leave room for an 8.3 file name.
+const maxPath = 260
+
+// longPath returns path in its \\?\ extended-length form if it is too long
+// for the Win32 APIs, which evidence stores with deep layouts easily are.
+// The os package already does this for absolute paths, but not for
+// relative ones, which only exceed the limit once made absolute.
+func longPath(path string) string {
+	if path == "" || strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil || len(abs) < maxPath-12 {
+		return path
+	}
+	return extendedLengthPath(abs)
+}
+
+// extendedLengthPath prefixes the clean, absolute path abs with \\?\, or a
+// UNC path \\server\share\... with \\?\UNC\.
+func extendedLengthPath(abs string) string {
+	if rest, ok := strings.CutPrefix(abs, `\\`); ok {
+		return `\\?\UNC\` + rest
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,43 @@
+//go:build windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtendedLengthPath(t *testing.T) {
+	tests := []struct {
+		abs, want string
+	}{
+		{`C:\evidence\case\out.txt`, `\\?\C:\evidence\case\out.txt`},
+		{`\\server\share\case\out.txt`, `\\?\UNC\server\share\case\out.txt`},
+	}
+	for _, tt := range tests {
+		if got := extendedLengthPath(tt.abs); got != tt.want {
+			t.Errorf("extendedLengthPath(%q) = %q, want %q", tt.abs, got, tt.want)
+		}
+	}
+}
+
+func TestLongPath(t *testing.T) {
+	for _, path := range []string{"", "out.txt", `C:\out.txt`, `\\?\C:\` + strings.Repeat("d", 300), `\\.\pipe\out`} {
+		if got := longPath(path); got != path {
+			t.Errorf("longPath(%q) = %q, want it unchanged", path, got)
+		}
+	}
+	// A relative path only too long once made absolute.
+	long := filepath.Join(strings.Repeat("d", 100), strings.Repeat("e", 100), "out.txt")
+	abs, err := filepath.Abs(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abs) < maxPath-12 {
+		t.Skipf("%s is short enough", abs)
+	}
+	if got := longPath(long); got != extendedLengthPath(abs) {
+		t.Errorf("longPath(%q) = %q, want %q", long, got, extendedLengthPath(abs))
+	}
+}
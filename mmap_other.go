@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapSupported reports whether --reader mmap can map files here.
+const mmapSupported = false
+
+// mapFile is not implemented on this platform.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	return nil, nil, errors.New("memory mapping is not supported on this platform")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sinkDialTimeout bounds each attempt to connect to an --output-addr.
+const sinkDialTimeout = 10 * time.Second
+
+// sinkRetryDelay and sinkMaxRetryDelay bound the wait between reconnects,
+// which doubles with every failed attempt.
+const (
+	sinkRetryDelay    = 100 * time.Millisecond
+	sinkMaxRetryDelay = 5 * time.Second
+)
+
+// parseOutputAddr splits an --output-addr, unix:///path/to.sock or
+// tcp://host:port, into the network and address to dial.
+func parseOutputAddr(addr string) (network, address string, err error) {
+	network, address, ok := strings.Cut(addr, "://")
+	if !ok || address == "" || (network != "unix" && network != "tcp") {
+		return "", "", fmt.Errorf("invalid --output-addr %q (valid: unix:///path/to.sock or tcp://host:port)", addr)
+	}
+	if network == "tcp" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("invalid --output-addr %q: %w", addr, err)
+		}
+	}
+	return network, address, nil
+}
+
+// netSink streams the output to a Unix socket or TCP endpoint for
+// --output-addr. Writes are queued and sent by a goroutine of their own, so
+// a dropped connection holds up to backlog lines while it reconnects before
+// the writes block and, through the output lock, the workers. Everything
+// below the queue (the byte counts and the checksum) runs on that goroutine
+// and only sees what was sent.
+type netSink struct {
+	addr             string
+	network, address string
+	// retries is the number of reconnects tried in a row before giving up.
+	retries    int
+	backlog    int64
+	terminator byte
+	// w is what the queued bytes are written to; its bottom is send.
+	w io.Writer
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  [][]byte
+	queued int64 // lines in queue
+	closed bool
+	// err is set once the sink gave up; the writes fail from then on.
+	err  error
+	done chan struct{}
+
+	// Only used by the sending goroutine until done is closed.
+	conn       net.Conn
+	sent       int64 // lines sent
+	reconnects int64
+	// partial is what was sent of a record not sent in full yet.
+	partial []byte
+}
+
+// newNetSink connects to addr, retrying as for a dropped connection, so a
+// run whose endpoint is down fails before reading any input.
+func newNetSink(addr string, retries int, backlog int64, terminator byte) (*netSink, error) {
+	network, address, err := parseOutputAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &netSink{addr: addr, network: network, address: address, retries: retries, backlog: backlog, terminator: terminator, done: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	s.reconnects = 0
+	return s, nil
+}
+
+// sender is the bottom of the writer chain the queue is sent through.
+func (s *netSink) sender() io.Writer {
+	return sinkSender{s}
+}
+
+type sinkSender struct{ s *netSink }
+
+func (w sinkSender) Write(p []byte) (int, error) {
+	return w.s.send(p)
+}
+
+// start starts sending what is written through w.
+func (s *netSink) start(w io.Writer) {
+	s.w = w
+	go s.run()
+}
+
+// Write queues p, blocking while the backlog is full.
+func (s *netSink) Write(p []byte) (int, error) {
+	lines := int64(bytes.Count(p, []byte{s.terminator}))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.err == nil && s.queued > 0 && s.queued+lines > s.backlog {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.queue = append(s.queue, bytes.Clone(p))
+	s.queued += lines
+	s.cond.Broadcast()
+	return len(p), nil
+}
+
+// run sends the queue until the sink is closed and drained, or gives up.
+func (s *netSink) run() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		p := s.queue[0]
+		s.mu.Unlock()
+
+		_, err := s.w.Write(p)
+
+		s.mu.Lock()
+		if err != nil {
+			s.err = err
+		} else {
+			s.queue = s.queue[1:]
+			s.queued -= int64(bytes.Count(p, []byte{s.terminator}))
+		}
+		s.cond.Broadcast()
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send writes p to the connection. If the connection drops, it reconnects
+// and carries on from the start of the record the old connection cut off,
+// so the endpoint never gets half a record as a whole one. What the old
+// connection took counts as sent, whether or not the endpoint read it.
+func (s *netSink) send(p []byte) (int, error) {
+	rest, out := p, p
+	for {
+		n, err := s.conn.Write(out)
+		s.record(out[:n])
+		if err == nil {
+			return len(p), nil
+		}
+		rest = rest[max(0, n-(len(out)-len(rest))):]
+		s.conn.Close()
+		logAt(levelVerbose, "Lost the connection to %s: %v; reconnecting", s.addr, err)
+		if err := s.connect(); err != nil {
+			return len(p) - len(rest), err
+		}
+		out = append(s.partial, rest...)
+		s.partial = nil
+	}
+}
+
+// record counts the records in b, which was just sent, and keeps what
+// follows the last one.
+func (s *netSink) record(b []byte) {
+	s.sent += int64(bytes.Count(b, []byte{s.terminator}))
+	if i := bytes.LastIndexByte(b, s.terminator); i >= 0 {
+		b = b[i+1:]
+		s.partial = s.partial[:0]
+	}
+	s.partial = append(s.partial, b...)
+}
+
+// connect dials the endpoint, trying up to retries more times with a
+// growing delay.
+func (s *netSink) connect() error {
+	delay := sinkRetryDelay
+	var err error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay = min(2*delay, sinkMaxRetryDelay)
+		}
+		if s.conn, err = net.DialTimeout(s.network, s.address, sinkDialTimeout); err == nil {
+			s.reconnects++
+			return nil
+		}
+		logAt(levelVerbose, "Connecting to %s: %v", s.addr, err)
+	}
+	return fmt.Errorf("%s: giving up after %d retries: %w", s.addr, s.retries, err)
+}
+
+// close waits for the queue to be sent and closes the connection.
+func (s *netSink) close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	<-s.done
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	return s.err
+}
+
+// sinkReport is the --output-addr part of the stats.
+type sinkReport struct {
+	Address string `json:"address"`
+	// Sent counts the lines the endpoint took; Undelivered the ones the
+	// run produced but couldn't send once the sink gave up.
+	Sent        int64 `json:"sent"`
+	Undelivered int64 `json:"undelivered"`
+	Reconnects  int64 `json:"reconnects"`
+}
+
+// report sums up the sink once it is closed, for a run that produced lines
+// lines of output.
+func (s *netSink) report(lines int64) *sinkReport {
+	return &sinkReport{Address: s.addr, Sent: s.sent, Undelivered: max(0, lines-s.sent), Reconnects: s.reconnects}
+}
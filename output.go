@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// outputCompressions lists the accepted values of --output-compression.
+var outputCompressions = []string{"none", "gzip", "zstd"}
+
+// lineEndings lists the accepted values of --output-line-ending.
+var lineEndings = []string{"lf", "crlf", "native"}
+
+// lineEnding returns the line ending an --output-line-ending stands for.
+func lineEnding(name string) string {
+	if name == "crlf" || name == "native" && runtime.GOOS == "windows" {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// outputCompressionFor infers the compression from the output file
+// extension when --output-compression isn't given.
+func outputCompressionFor(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz":
+		return "gzip"
+	case ".zst", ".zstd":
+		return "zstd"
+	}
+	return "none"
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// errOutputLocked is returned by lockFile if another run holds the lock on
+// the output file.
+var errOutputLocked = errors.New("output is locked by another run")
+
+// defaultWriteBuffer is the default size of the output buffer.
+const defaultWriteBuffer = 1 << 20
+
+// outputWriter serializes result records from concurrent workers onto the
+// output, optionally compressing them. Output is buffered; write errors are
+// kept and reported by close.
+type outputWriter struct {
+	mu         sync.Mutex
+	w          *bufio.Writer
+	raw        *countingWriter // uncompressed bytes
+	written    *countingWriter // bytes that reached the file or stdout
+	compressor io.WriteCloser
+	file       *os.File
+	err        error
+	// path is the output file. If it is written atomically, tmpPath is
+	// the file written to until commit moves it to path.
+	path    string
+	tmpPath string
+	// base is the size of the output file before this run: the
+	// checkpointed size when resuming, the existing size when appending.
+	base       int64
+	terminator byte
+	lines      int64
+	// sum hashes the output for --output-checksum; checksumOf says which
+	// side of the compressor it sits on.
+	sum        *checksumWriter
+	checksumOf string
+	compressed bool
+	// committed and discarded tell what became of an atomic output.
+	committed, discarded bool
+	// unique holds the records written so far for --unique-output, which
+	// leaves out the ones written before; duplicates counts those.
+	unique     seenSet
+	duplicates int64
+	crlf       bool
+	tee        *teeWriter
+	failure    *writeFailure
+	temp       *aspnethash.RunDir
+	// sink is the --output-addr the output is sent to instead of stdout;
+	// lost counts the lines refused after a write error, which never
+	// reached it.
+	sink *netSink
+	lost int64
+	// filter is the --post-filter the records go through; filterDone gets
+	// the outcome of copying its output on.
+	filter     *filterProcess
+	filterDone chan error
+}
+
+// writeFailure is told about the first error writing any output of a run,
+// so the run can stop reading input it has nowhere to write: after a full
+// disk or a closed pipe, every line fails the same way. It is safe for
+// concurrent use; a nil writeFailure ignores the errors.
+type writeFailure struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func newWriteFailure() *writeFailure {
+	return &writeFailure{done: make(chan struct{})}
+}
+
+// fail records err if it is the first, and closes done.
+func (f *writeFailure) fail(err error) {
+	if f == nil {
+		return
+	}
+	f.once.Do(func() {
+		f.err = err
+		close(f.done)
+	})
+}
+
+// error returns the first write error, if there was one.
+func (f *writeFailure) error() error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// tempOutputPath is the file an atomic output is written to before it is
+// renamed into place.
+func tempOutputPath(path string) string {
+	return path + ".tmp"
+}
+
+// writeAtomically reports whether the output at path should go through a
+// temporary file. Existing special files like FIFOs and devices are written
+// to directly.
+func writeAtomically(path string) bool {
+	info, err := os.Stat(longPath(path))
+	return os.IsNotExist(err) || (err == nil && info.Mode().IsRegular())
+}
+
+// outputOptions control how newOutputWriter opens the output.
+type outputOptions struct {
+	// resumeAt, if not negative, keeps an existing file, truncates it to
+	// resumeAt bytes and appends to it.
+	resumeAt    int64
+	compression string
+	bufferSize  int
+	// viaTemp writes to tempOutputPath(path) until commit.
+	viaTemp bool
+	// appendTo adds to an existing file instead of replacing it, after
+	// ending its last record with terminator if needed.
+	appendTo   bool
+	terminator byte
+	// lock takes an advisory lock on the file and fails if another run
+	// holds it.
+	lock bool
+	// checksum, if set, hashes the output: "written" or "raw" as in
+	// --checksum-of.
+	checksum string
+	// unique, if set, leaves out records it has seen, for --unique-output.
+	unique seenSet
+	// crlf ends the lines with \r\n rather than the \n terminator.
+	crlf bool
+	// tee, if set, mirrors the records to stdout, for --tee and --head.
+	tee *teeWriter
+	// failure, if set, is told about the first write error.
+	failure *writeFailure
+	// sink, if set, takes the place of stdout.
+	sink *netSink
+	// postFilter, if set, is a command the records are passed through.
+	postFilter string
+	// temp, if set, removes the temporary file of an atomic output if the
+	// run ends before it is committed or discarded.
+	temp *aspnethash.RunDir
+	// ioTime, if set, adds up the nanoseconds spent writing to the file or
+	// stdout, for --timings.
+	ioTime *int64
+}
+
+// newOutputWriter opens path (stdout, or opts.sink, if empty) as set by
+// opts.
+func newOutputWriter(path string, opts outputOptions) (*outputWriter, error) {
+	o := &outputWriter{path: path}
+
+	var dst io.Writer = os.Stdout
+	if opts.sink != nil {
+		dst = opts.sink.sender()
+	}
+	if path != "" {
+		if opts.viaTemp {
+			o.tmpPath = tempOutputPath(path)
+			path = o.tmpPath
+			if o.temp = opts.temp; o.temp != nil {
+				o.temp.Track(longPath(o.tmpPath))
+			}
+		}
+		f, err := openOutputFile(path, opts.resumeAt, opts.appendTo, opts.lock)
+		if err != nil {
+			return nil, err
+		}
+		o.file = f
+		dst = f
+		switch {
+		case opts.resumeAt > 0:
+			o.base = opts.resumeAt
+		case opts.appendTo:
+			// Compressed outputs are appended as a new gzip member or zstd
+			// frame, which can't end in the middle of a record.
+			if o.base, err = endRecord(f, opts.terminator, opts.crlf, opts.compression == "none"); err != nil {
+				o.closeFile()
+				return nil, err
+			}
+		}
+	}
+	if opts.ioTime != nil {
+		dst = &timedWriter{w: dst, ns: opts.ioTime}
+	}
+	o.written = &countingWriter{w: dst}
+
+	// Without compression the two checksums are of the same bytes.
+	o.checksumOf, o.compressed = opts.checksum, opts.compression != "none"
+	if !o.compressed && o.checksumOf == "raw" {
+		o.checksumOf = "written"
+	}
+	var compressed io.Writer = o.written
+	if o.checksumOf == "written" {
+		o.sum = newChecksumWriter(o.written)
+		compressed = o.sum
+	}
+	switch opts.compression {
+	case "none":
+		o.raw = o.written
+		if o.sum != nil {
+			o.raw = &countingWriter{w: o.sum}
+		}
+	case "gzip":
+		o.compressor = gzip.NewWriter(compressed)
+	case "zstd":
+		zw, err := zstd.NewWriter(compressed)
+		if err != nil {
+			o.closeFile()
+			return nil, err
+		}
+		o.compressor = zw
+	default:
+		o.closeFile()
+		return nil, fmt.Errorf("unknown compression %q (valid: %v)", opts.compression, outputCompressions)
+	}
+	if o.compressor != nil {
+		if o.checksumOf == "raw" {
+			o.sum = newChecksumWriter(o.compressor)
+			o.raw = &countingWriter{w: o.sum}
+		} else {
+			o.raw = &countingWriter{w: o.compressor}
+		}
+	}
+	if o.sum != nil && o.base > 0 {
+		if err := o.sum.prehash(path, o.base, opts.compression, o.checksumOf == "raw", opts.terminator); err != nil {
+			o.closeFile()
+			return nil, fmt.Errorf("checksumming the existing output: %w", err)
+		}
+	}
+	o.terminator = opts.terminator
+	o.unique = opts.unique
+	o.crlf = opts.crlf
+	o.tee = opts.tee
+	o.failure = opts.failure
+	var front io.Writer = o.raw
+	if opts.sink != nil {
+		// The sink queues the records ahead of everything else, so the
+		// counts and the checksum only see what was sent.
+		o.sink = opts.sink
+		o.sink.start(o.raw)
+		front = o.sink
+	}
+	if opts.postFilter != "" {
+		f, err := startFilter("--post-filter", opts.postFilter, opts.terminator)
+		if err != nil {
+			o.closeFile()
+			return nil, err
+		}
+		o.filter, o.filterDone = f, make(chan error, 1)
+		go o.copyFiltered(front)
+		front = f
+	}
+	o.w = bufio.NewWriterSize(front, opts.bufferSize)
+	return o, nil
+}
+
+// copyFiltered passes on what the --post-filter prints to dst. If that
+// fails, the filter is stopped so the writes to it fail too.
+func (o *outputWriter) copyFiltered(dst io.Writer) {
+	_, err := io.Copy(dst, o.filter)
+	if err == nil {
+		err = o.filter.finish()
+	} else {
+		o.filter.kill()
+	}
+	if err != nil {
+		o.failure.fail(err)
+	}
+	o.filterDone <- err
+}
+
+// openOutputFile creates path, opens it for appending, or when resuming
+// opens it and cuts off anything written after the last checkpoint. With
+// lock, the file is only truncated once the lock is taken, so a run that
+// fails to get it leaves the file alone.
+func openOutputFile(path string, resumeAt int64, appendTo, lock bool) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case appendTo && resumeAt < 0:
+		flags = os.O_RDWR | os.O_APPEND | os.O_CREATE
+	case resumeAt < 0 && !lock:
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(longPath(path), flags, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	if lock {
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if flags&(os.O_APPEND|os.O_TRUNC) != 0 {
+		return f, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if resumeAt < 0 {
+		if !info.Mode().IsRegular() {
+			return f, nil
+		}
+		resumeAt = 0
+	} else if info.Size() < resumeAt {
+		// Truncating would pad it with zeros.
+		f.Close()
+		return nil, fmt.Errorf("%s is shorter than the checkpoint says (%d < %d bytes); was the run already finished?", path, info.Size(), resumeAt)
+	}
+	if err := f.Truncate(resumeAt); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(resumeAt, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// endRecord makes sure a file opened for appending ends with terminator,
+// so the first record appended isn't glued to the last one already there,
+// and returns the resulting size. With crlf the line break added is \r\n.
+// check false skips the check.
+func endRecord(f *os.File, terminator byte, crlf, check bool) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if size == 0 || !check || !info.Mode().IsRegular() {
+		return size, nil
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, size-1); err != nil {
+		return 0, err
+	}
+	if last[0] == terminator {
+		return size, nil
+	}
+	end := []byte{terminator}
+	if crlf {
+		end = []byte("\r\n")
+	}
+	if _, err := f.Write(end); err != nil {
+		return 0, err
+	}
+	return size + int64(len(end)), nil
+}
+
+// write writes records that already end in the record terminator.
+func (o *outputWriter) write(records string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.err != nil {
+		o.lost += int64(strings.Count(records, string(o.terminator)))
+		return
+	}
+	if o.unique != nil {
+		var dropped int64
+		records, dropped = dropSeen(records, o.unique, o.terminator)
+		o.duplicates += dropped
+	}
+	if o.crlf {
+		records = strings.ReplaceAll(records, "\n", "\r\n")
+	}
+	if o.tee != nil {
+		o.tee.write(records)
+	}
+	if _, o.err = io.WriteString(o.w, records); o.err != nil {
+		o.failure.fail(o.err)
+	}
+	o.lines += int64(strings.Count(records, string(o.terminator)))
+}
+
+// sync makes everything written so far durable and returns the output
+// offset it corresponds to.
+func (o *outputWriter) sync() (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.err != nil {
+		return 0, o.err
+	}
+	if o.err = o.w.Flush(); o.err != nil {
+		o.failure.fail(o.err)
+		return 0, o.err
+	}
+	if o.file != nil {
+		if err := o.file.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	return o.base + o.written.n, nil
+}
+
+// close flushes the buffer and the compressor and closes the output file. It
+// is safe to call more than once.
+func (o *outputWriter) close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.err == nil {
+		o.err = o.w.Flush()
+	}
+	if o.filter != nil {
+		o.filter.closeInput()
+		// What went wrong with the filter explains a failed write to it.
+		if err := <-o.filterDone; err != nil {
+			o.err = err
+		}
+		o.filter = nil
+	}
+	if o.sink != nil {
+		if err := o.sink.close(); err != nil && o.err == nil {
+			o.err = err
+		}
+	}
+	if o.compressor != nil {
+		if err := o.compressor.Close(); err != nil && o.err == nil {
+			o.err = err
+		}
+		o.compressor = nil
+	}
+	if err := o.closeFile(); err != nil && o.err == nil {
+		o.err = err
+	}
+	return o.err
+}
+
+// commit moves an atomically written output into place once it has been
+// closed successfully.
+func (o *outputWriter) commit() error {
+	if o.tmpPath == "" {
+		return nil
+	}
+	if err := os.Rename(longPath(o.tmpPath), longPath(o.path)); err != nil {
+		return err
+	}
+	if o.temp != nil {
+		o.temp.Untrack(longPath(o.tmpPath))
+	}
+	o.committed = true
+	return nil
+}
+
+// discard removes the temporary file of an atomically written output,
+// leaving whatever was at its path before untouched.
+func (o *outputWriter) discard() error {
+	if o.tmpPath == "" {
+		return nil
+	}
+	o.close()
+	o.discarded = true
+	if o.temp != nil {
+		o.temp.Untrack(longPath(o.tmpPath))
+	}
+	return os.Remove(longPath(o.tmpPath))
+}
+
+func (o *outputWriter) closeFile() error {
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+// bytesWritten returns the number of uncompressed bytes written and the
+// number of bytes that actually reached the output in this run.
+func (o *outputWriter) bytesWritten() (raw, written int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.raw.n, o.written.n
+}
+
+// sinkReport sums up the --output-addr once the output is closed.
+func (o *outputWriter) sinkReport() *sinkReport {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.sink.report(o.lines + o.lost)
+}
+
+// duplicatesDropped returns the number of records --unique-output left out.
+func (o *outputWriter) duplicatesDropped() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.duplicates
+}
+
+// linesWritten returns the number of records written in this run and the
+// size of the output file before it.
+func (o *outputWriter) linesWritten() (lines, preexisting int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.lines, o.base
+}
+
+// checksums returns the --output-checksum of the output, once it is closed,
+// or nothing without one.
+func (o *outputWriter) checksums() []checksumReport {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.sum == nil {
+		return nil
+	}
+	file := o.path
+	switch {
+	case o.sink != nil:
+		file = o.sink.addr
+	case file == "":
+		file = "-"
+	case o.tmpPath != "" && !o.committed:
+		file = o.tmpPath
+	}
+	return []checksumReport{{
+		File:   file,
+		Of:     o.checksumOf,
+		SHA256: hex.EncodeToString(o.sum.h.Sum(nil)),
+		Bytes:  o.sum.n,
+		Lines:  o.sum.preexisting + o.lines,
+	}}
+}
+
+// writeChecksumFile writes the checksum next to the output in sha256sum
+// format. Only outputs at their final path get one: an atomic output that
+// wasn't committed is either gone or left as a temporary file to resume.
+func (o *outputWriter) writeChecksumFile() error {
+	if o.sum == nil || o.path == "" || o.discarded || o.tmpPath != "" && !o.committed {
+		return nil
+	}
+	report := o.checksums()[0]
+	return os.WriteFile(longPath(checksumFilePath(o.path)), []byte(report.sidecar(o.compressed)), 0o666)
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile is not implemented on this platform.
+func lockFile(f *os.File) error {
+	return errors.New("--lock-output is not supported on this platform")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// BenchmarkConvertWriteBuffer converts a synthetic dump into a file with
+// the output unbuffered, near enough, and with the default --write-buffer.
+// Batches of one line make every line a write of its own.
+func BenchmarkConvertWriteBuffer(b *testing.B) {
+	const benchLines = 100000
+	input := syntheticHashes(benchLines)
+	out := filepath.Join(b.TempDir(), "out.txt")
+	for _, size := range []int{1, defaultWriteBuffer} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				mustRunTool(b, input, "convert", "-u", "-q", "--batch-size", "1", "--write-buffer", fmt.Sprint(size), "-o", out)
+			}
+			b.ReportMetric(float64(benchLines*b.N)/b.Elapsed().Seconds(), "lines/s")
+		})
+	}
+}
+
+func TestLineEnding(t *testing.T) {
+	native := "\n"
+	if runtime.GOOS == "windows" {
+		native = "\r\n"
+	}
+	for name, want := range map[string]string{"lf": "\n", "crlf": "\r\n", "native": native} {
+		if got := lineEnding(name); got != want {
+			t.Errorf("lineEnding(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestOutputLineEnding converts to stdout and to a file with each
+// --output-line-ending, and checks every line ends the same way.
+func TestOutputLineEnding(t *testing.T) {
+	input := syntheticHashes(20)
+	for _, ending := range lineEndings {
+		want := lineEnding(ending)
+		t.Run(ending, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.txt")
+			stdout := mustRunTool(t, input, "convert", "-u", "-q", "--output-line-ending", ending)
+			mustRunTool(t, input, "convert", "-u", "-q", "--output-line-ending", ending, "-o", path)
+			file, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, out := range []string{stdout, string(file)} {
+				if !strings.HasSuffix(out, want) {
+					t.Fatalf("output %q doesn't end with %q", out, want)
+				}
+				lines := strings.SplitAfter(out, "\n")
+				lines = lines[:len(lines)-1]
+				if len(lines) != 20 {
+					t.Fatalf("%d lines, want 20", len(lines))
+				}
+				for _, line := range lines {
+					if !strings.HasSuffix(line, want) || strings.Count(line, "\r") != strings.Count(want, "\r") {
+						t.Fatalf("line %q doesn't end with just %q", line, want)
+					}
+				}
+			}
+		})
+	}
+	run := runTool(t, input, "convert", "-u", "--output-line-ending", "cr")
+	if run.exitCode == 0 || !strings.Contains(run.stderr, "invalid --output-line-ending") {
+		t.Errorf("--output-line-ending cr exited with %d: %s", run.exitCode, run.stderr)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// outputFormats are the accepted values of --format.
+var outputFormats = []string{"hashcat", "phc"}
+
+// phcIdentifiers are the passlib identifiers of the PBKDF2 algorithms, and
+// the checksum sizes passlib requires of them: the digest size of the PRF.
+var phcIdentifiers = map[string]struct {
+	ident        string
+	checksumSize int
+}{
+	algoPBKDF2SHA1:   {"pbkdf2", 20},
+	algoPBKDF2SHA256: {"pbkdf2-sha256", 32},
+	algoPBKDF2SHA512: {"pbkdf2-sha512", 64},
+}
+
+// phcDialect is --format phc: the modular crypt strings of passlib's
+// pbkdf2_sha1, pbkdf2_sha256 and pbkdf2_sha512. It takes the place of a
+// hashcat dialect in convert.
+var phcDialect = hashcatDialect{
+	mode:       "phc",
+	name:       "passlib pbkdf2",
+	algorithms: []string{algoPBKDF2SHA1, algoPBKDF2SHA256, algoPBKDF2SHA512},
+	format:     phcLine,
+}
+
+// ab64 is passlib's "adapted base64": standard base64 with '.' for '+' and
+// without padding.
+var ab64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+// phcLine writes $<ident>$<iterations>$<ab64 salt>$<ab64 subkey>. passlib
+// takes a derived key exactly as long as the PRF's digest; a longer subkey
+// is cut to it, which PBKDF2 allows as its first bytes don't depend on the
+// length asked for. Shorter subkeys, such as the 32 bytes of a SHA512
+// Identity v3 hash, can't be written.
+func phcLine(h hashDigest) (string, error) {
+	id := phcIdentifiers[h.algorithm]
+	subkey := h.pbkdf2.Subkey
+	if len(subkey) < id.checksumSize {
+		return "", fmt.Errorf("%w: passlib needs a %d byte %s subkey, the hash has %d", aspnethash.ErrUnsupportedFormat, id.checksumSize, h.algorithm, len(subkey))
+	}
+	return strings.Join([]string{"", id.ident, strconv.Itoa(h.pbkdf2.Iterations), ab64.EncodeToString(h.pbkdf2.Salt), ab64.EncodeToString(subkey[:id.checksumSize])}, "$"), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// phcTests are hashes of testVectorPlaintext and the strings --format phc
+// writes for them. The strings were checked with passlib's algorithm: the
+// PBKDF2 of the UTF-8 password over the ab64-decoded salt, as long as the
+// checksum, gives the checksum back.
+var phcTests = []struct {
+	name, hash, phc string
+}{
+	// The 32-byte MVC4 subkey is cut to passlib's 20 bytes.
+	{"mvc4", "AAABAgMEBQYHCAkKCwwNDg8TEAcfPTbAR4SiWAURv/76tjH7y7DB7E7ZLpDmTdwP1g==",
+		"$pbkdf2$1000$AAECAwQFBgcICQoLDA0ODw$ExAHHz02wEeEolgFEb/..rYx.8s"},
+	{"identity-v3-sha256", "AQAAAAEAACcQAAAAEAABAgMEBQYHCAkKCwwNDg+C+4kIIpPWEkm+WRQWPvycsw5up6fUf784Y2BOE8Kz3w==",
+		"$pbkdf2-sha256$10000$AAECAwQFBgcICQoLDA0ODw$gvuJCCKT1hJJvlkUFj78nLMObqen1H./OGNgThPCs98"},
+	{"identity-v3-sha512", "AQAAAAIAAYagAAAAEAABAgMEBQYHCAkKCwwNDg9d6VNYYvt2aqPeCZRsG+wh5LNpVmJDKBd+qaXhb6QwvuFWOQ2+yAda6NyJwEW4K+YSyhzptDFjiMrS3cuL6y3t",
+		"$pbkdf2-sha512$100000$AAECAwQFBgcICQoLDA0ODw$XelTWGL7dmqj3gmUbBvsIeSzaVZiQygXfqml4W.kML7hVjkNvsgHWujcicBFuCvmEsoc6bQxY4jK0t3Li.st7Q"},
+	// An 8-byte salt with the bytes ab64 writes as '.' and '/'.
+	{"identity-v3-sha1", "AQAAAAAAAHFIAAAACPvv/z4BAgMEfzWP8I3NksmDyZHUUk86QqkooXw=",
+		"$pbkdf2$29000$..//PgECAwQ$fzWP8I3NksmDyZHUUk86QqkooXw"},
+}
+
+func TestPHCFormat(t *testing.T) {
+	parse := newHashParser(aspnethash.DefaultSaltSize, aspnethash.DefaultSubkeyLength, false)
+	for _, tt := range phcTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertHash(tt.hash, false, "", "", false, aspnethash.DefaultIterations, nil, parse, &phcDialect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.phc {
+				t.Errorf("got %s, want %s", got, tt.phc)
+			}
+			if !passlibVerify(t, testVectorPlaintext, got) {
+				t.Errorf("%s doesn't verify", got)
+			}
+			if passlibVerify(t, "Passw0rd!", got) {
+				t.Errorf("%s verifies another password", got)
+			}
+		})
+	}
+}
+
+// passlibVerify checks password against a pbkdf2 string the way passlib's
+// pbkdf2 handlers do, strict about the checksum size and the encoding.
+func passlibVerify(t *testing.T, password, phc string) bool {
+	t.Helper()
+	handlers := map[string]struct {
+		newHash func() hash.Hash
+		size    int
+	}{
+		"pbkdf2":        {sha1.New, 20},
+		"pbkdf2-sha256": {sha256.New, 32},
+		"pbkdf2-sha512": {sha512.New, 64},
+	}
+	fields := strings.Split(phc, "$")
+	if len(fields) != 5 || fields[0] != "" {
+		t.Fatalf("%s isn't $<ident>$<rounds>$<salt>$<checksum>", phc)
+	}
+	handler, ok := handlers[fields[1]]
+	if !ok {
+		t.Fatalf("%s: passlib has no handler %s", phc, fields[1])
+	}
+	rounds, err := strconv.Atoi(fields[2])
+	if err != nil || rounds < 1 || strings.HasPrefix(fields[2], "0") {
+		t.Fatalf("%s: bad rounds %q", phc, fields[2])
+	}
+	salt, err1 := ab64.Strict().DecodeString(fields[3])
+	checksum, err2 := ab64.Strict().DecodeString(fields[4])
+	if err1 != nil || err2 != nil {
+		t.Fatalf("%s: not adapted base64: %v %v", phc, err1, err2)
+	}
+	if len(checksum) != handler.size {
+		t.Fatalf("%s: %d byte checksum, passlib wants %d", phc, len(checksum), handler.size)
+	}
+	derived := pbkdf2.Key([]byte(password), salt, rounds, handler.size, handler.newHash)
+	return subtle.ConstantTimeCompare(derived, checksum) == 1
+}
+
+func TestPHCShortSubkey(t *testing.T) {
+	// A SHA512 Identity v3 hash with the default 32-byte subkey is shorter
+	// than the 64 bytes passlib wants.
+	parse := newHashParser(aspnethash.DefaultSaltSize, aspnethash.DefaultSubkeyLength, false)
+	encoded := "AQAAAAIAAYagAAAAEAABAgMEBQYHCAkKCwwNDg9d6VNYYvt2aqPeCZRsG+wh5LNpVmJDKBd+qaXhb6Qwvg=="
+	if got, err := convertHash(encoded, false, "", "", false, aspnethash.DefaultIterations, nil, parse, &phcDialect); !errors.Is(err, aspnethash.ErrUnsupportedFormat) {
+		t.Errorf("got %q, %v; want %v", got, err, aspnethash.ErrUnsupportedFormat)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+// ignoreBrokenPipe does nothing: without SIGPIPE, a closed pipe already is
+// a write error.
+func ignoreBrokenPipe() {}
+
+// isBrokenPipe reports false: a closed pipe is an ordinary write error here.
+func isBrokenPipe(err error) bool {
+	return false
+}
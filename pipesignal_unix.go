@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os/signal"
+	"syscall"
+)
+
+// ignoreBrokenPipe makes writes to a closed pipe fail with EPIPE instead of
+// killing the process with SIGPIPE, so the run can stop and report.
+func ignoreBrokenPipe() {
+	signal.Ignore(syscall.SIGPIPE)
+}
+
+// isBrokenPipe reports whether err comes from writing to a pipe or socket
+// whose reader went away, like head after its last line.
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
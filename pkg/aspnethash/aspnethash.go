@@ -0,0 +1,242 @@
+// Package aspnethash creates, parses and verifies ASP.NET membership password
+// hashes:
+//
+//   - SimpleMembershipProvider (MVC4, ASP.NET Identity v2): PBKDF2 with
+//     HMAC-SHA1, stored as base64(0x00 || salt || subkey).
+//   - DefaultMembershipProvider (Web Forms): SHA256, stored as a base64
+//     hash and a separate base64 salt, or an HMAC keyed with the machineKey
+//     validationKey.
+//   - DotNetNuke: SqlMembershipProvider's SHA1 over the salt and the UTF-16LE
+//     password, stored as a base64 hash and a separate base64 salt.
+//   - FormsAuthentication.HashPasswordForStoringInConfigFile (ASP.NET 1.x
+//     and 2.0): unsalted SHA1 or MD5, stored as uppercase hex.
+//   - Umbraco legacy encoding: HMAC-SHA256 of the UTF-16LE password keyed
+//     with itself, unsalted.
+//   - Encrypted membership passwords: decrypted with the machineKey
+//     decryptionKey rather than hashed.
+//   - ASP.NET Core Identity v3: PBKDF2 with HMAC-SHA1/256/512 and the
+//     parameters stored in the hash.
+//
+// Processor runs a stream of lines through them on a pool of workers.
+package aspnethash
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Defaults used by ASP.NET MVC4.
+const (
+	DefaultIterations   = 1000
+	DefaultSaltSize     = 16
+	DefaultSubkeyLength = 32
+)
+
+// Errors returned when parsing a hash. They are wrapped, so use errors.Is.
+var (
+	ErrInvalidBase64     = errors.New("invalid base64")
+	ErrTooShort          = errors.New("decoded bytes too short")
+	ErrVersionByte       = errors.New("unexpected version byte")
+	ErrLengthMismatch    = errors.New("length mismatch")
+	ErrUnsupportedFormat = errors.New("unsupported format")
+)
+
+// kindError attaches one of the errors above to an error without changing
+// its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// Options control hash generation. Zero values select the MVC4 defaults.
+type Options struct {
+	Iterations   int
+	SaltSize     int
+	SubkeyLength int
+	// Rand is the source of salts, crypto/rand.Reader if nil.
+	Rand io.Reader
+	// Salt, if set, is used instead of a random salt of SaltSize bytes.
+	// Reusing a salt defeats its purpose; this is meant for reproducible
+	// examples and tests.
+	Salt []byte
+}
+
+func (o Options) withDefaults() Options {
+	if o.Iterations == 0 {
+		o.Iterations = DefaultIterations
+	}
+	if o.SaltSize == 0 {
+		o.SaltSize = DefaultSaltSize
+	}
+	if o.SubkeyLength == 0 {
+		o.SubkeyLength = DefaultSubkeyLength
+	}
+	if o.Rand == nil {
+		o.Rand = rand.Reader
+	}
+	return o
+}
+
+// salt returns opts.Salt, or a random salt read into s.salt.
+func (o Options) salt(s *scratch) ([]byte, error) {
+	if o.Salt != nil {
+		return o.Salt, nil
+	}
+	s.salt = sized(s.salt, o.SaltSize)
+	if _, err := io.ReadFull(o.Rand, s.salt); err != nil {
+		return nil, err
+	}
+	return s.salt, nil
+}
+
+// HashMVC4 hashes plain with a fresh salt and returns the base64 encoded
+// SimpleMembershipProvider hash.
+func HashMVC4(plain []byte, opts Options) (string, error) {
+	opts = opts.withDefaults()
+	s := scratchPool.Get().(*scratch)
+	defer scratchPool.Put(s)
+
+	salt, err := opts.salt(s)
+	if err != nil {
+		return "", err
+	}
+	subkey := pbkdf2.Key(plain, salt, opts.Iterations, opts.SubkeyLength, sha1.New)
+	s.raw = sized(s.raw, 1+len(salt)+len(subkey))
+	s.raw[0] = 0
+	copy(s.raw[1:], salt)
+	copy(s.raw[1+len(salt):], subkey)
+	return s.encode(s.raw), nil
+}
+
+// HashWebForms hashes plain with a fresh salt and returns the base64 encoded
+// hash and salt.
+func HashWebForms(plain []byte, opts Options) (hash, salt string, err error) {
+	opts = opts.withDefaults()
+	s := scratchPool.Get().(*scratch)
+	defer scratchPool.Put(s)
+
+	rawSalt, err := opts.salt(s)
+	if err != nil {
+		return "", "", err
+	}
+	return webFormsHash(s, plain, rawSalt), s.encode(rawSalt), nil
+}
+
+func webFormsHash(s *scratch, plain, salt []byte) string {
+	digest := sha256.Sum256(plain)
+	s.raw = sized(s.raw, len(salt)+len(digest))
+	copy(s.raw, salt)
+	copy(s.raw[len(salt):], digest[:])
+	return s.encode(s.raw)
+}
+
+// Hash is a parsed PBKDF2 hash.
+type Hash struct {
+	Version byte
+	// PRF is always PRFSHA1 for MVC4 hashes.
+	PRF    PRF
+	Salt   []byte
+	Subkey []byte
+	// Iterations isn't stored in MVC4 hashes; ParseMVC4 assumes
+	// DefaultIterations.
+	Iterations int
+}
+
+// ParseMVC4 decodes a base64 encoded SimpleMembershipProvider hash. The
+// version byte is skipped, the next 16 bytes are the salt and the rest is the
+// subkey.
+func ParseMVC4(encoded string) (Hash, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Hash{}, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64: %w", err)}
+	}
+	if len(decoded) < 1+DefaultSaltSize {
+		return Hash{}, ErrTooShort
+	}
+	return Hash{
+		Version:    decoded[0],
+		Salt:       decoded[1 : 1+DefaultSaltSize],
+		Subkey:     decoded[1+DefaultSaltSize:],
+		Iterations: DefaultIterations,
+	}, nil
+}
+
+// ParseMVC4Sized is a strict ParseMVC4 for hashes with a salt of saltSize
+// bytes and a subkey of subkeyLength bytes: the version byte must be 0x00 and
+// the decoded length exactly 1+saltSize+subkeyLength.
+func ParseMVC4Sized(encoded string, saltSize, subkeyLength int) (Hash, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Hash{}, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64: %w", err)}
+	}
+	if len(decoded) == 0 {
+		return Hash{}, ErrTooShort
+	}
+	if decoded[0] != 0x00 {
+		return Hash{}, fmt.Errorf("%w: 0x%02x, expected 0x00", ErrVersionByte, decoded[0])
+	}
+	if want := 1 + saltSize + subkeyLength; len(decoded) != want {
+		return Hash{}, fmt.Errorf("%w: length %d, expected %d", ErrLengthMismatch, len(decoded), want)
+	}
+	return Hash{
+		Salt:       decoded[1 : 1+saltSize],
+		Subkey:     decoded[1+saltSize:],
+		Iterations: DefaultIterations,
+	}, nil
+}
+
+// Hashcat returns the hash in hashcat's format,
+// <prf>:<iterations>:<base64 salt>:<base64 subkey>, which is mode 12000 for
+// MVC4 hashes.
+func (h Hash) Hashcat() string {
+	return h.PRF.String() + ":" + strconv.Itoa(h.Iterations) + ":" +
+		base64.StdEncoding.EncodeToString(h.Salt) + ":" + base64.StdEncoding.EncodeToString(h.Subkey)
+}
+
+// Verify reports whether plain matches encoded, which is either an MVC4 hash
+// or a Web Forms "hash,salt" pair as produced by this package.
+func Verify(plain, encoded string) (bool, error) {
+	if hash, salt, ok := strings.Cut(encoded, ","); ok {
+		rawSalt, err := base64.StdEncoding.DecodeString(salt)
+		if err != nil {
+			return false, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64 salt: %w", err)}
+		}
+		s := scratchPool.Get().(*scratch)
+		defer scratchPool.Put(s)
+		expected := webFormsHash(s, []byte(plain), rawSalt)
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1, nil
+	}
+
+	h, err := ParseMVC4(encoded)
+	if err != nil {
+		return false, err
+	}
+	if len(h.Subkey) == 0 {
+		return false, ErrTooShort
+	}
+	return h.Verify([]byte(plain)), nil
+}
+
+// Verify reports whether plain matches the hash, using h.Iterations.
+func (h Hash) Verify(plain []byte) bool {
+	return subtle.ConstantTimeCompare(h.Derive(plain), h.Subkey) == 1
+}
+
+// Derive returns the subkey plain derives with the hash's PRF, salt,
+// iterations and subkey length.
+func (h Hash) Derive(plain []byte) []byte {
+	return pbkdf2.Key(plain, h.Salt, h.Iterations, len(h.Subkey), h.PRF.hash())
+}
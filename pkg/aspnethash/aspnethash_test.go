@@ -0,0 +1,318 @@
+package aspnethash
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// The inputs of the known answers below, the same as the testvectors
+// command's: a non-ASCII plaintext, to pin the UTF-8 and UTF-16LE
+// encodings, and the salt 00 01 .. 0f.
+const testPlain = "Pässw0rd!"
+
+var testSalt = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+const (
+	testMVC4       = "AAABAgMEBQYHCAkKCwwNDg8TEAcfPTbAR4SiWAURv/76tjH7y7DB7E7ZLpDmTdwP1g=="
+	testV3SHA1     = "AQAAAAAAAAPoAAAAEAABAgMEBQYHCAkKCwwNDg8TEAcfPTbAR4SiWAURv/76tjH7y7DB7E7ZLpDmTdwP1g=="
+	testV3SHA256   = "AQAAAAEAACcQAAAAEAABAgMEBQYHCAkKCwwNDg+C+4kIIpPWEkm+WRQWPvycsw5up6fUf784Y2BOE8Kz3w=="
+	testV3SHA512   = "AQAAAAIAAYagAAAAEAABAgMEBQYHCAkKCwwNDg9d6VNYYvt2aqPeCZRsG+wh5LNpVmJDKBd+qaXhb6Qwvg=="
+	testWebForms   = "AAECAwQFBgcICQoLDA0OD+yvhkJ96NoSe3DxTdAAd83YcXGWUavSzIlnOfH3Js8z"
+	testSaltBase64 = "AAECAwQFBgcICQoLDA0ODw=="
+)
+
+func TestHashKnownAnswers(t *testing.T) {
+	tests := []struct {
+		name string
+		hash func() (string, error)
+		want string
+	}{
+		{"mvc4", func() (string, error) {
+			return HashMVC4([]byte(testPlain), Options{Salt: testSalt})
+		}, testMVC4},
+		{"identity-v3-sha1", func() (string, error) {
+			return HashIdentityV3([]byte(testPlain), PRFSHA1, Options{Salt: testSalt})
+		}, testV3SHA1},
+		{"identity-v3-sha256", func() (string, error) {
+			return HashIdentityV3([]byte(testPlain), PRFSHA256, Options{Iterations: 10000, Salt: testSalt})
+		}, testV3SHA256},
+		{"identity-v3-sha512", func() (string, error) {
+			return HashIdentityV3([]byte(testPlain), PRFSHA512, Options{Iterations: 100000, Salt: testSalt})
+		}, testV3SHA512},
+		{"webforms", func() (string, error) {
+			hash, salt, err := HashWebForms([]byte(testPlain), Options{Salt: testSalt})
+			return hash + "," + salt, err
+		}, testWebForms + "," + testSaltBase64},
+		{"webforms-rand", func() (string, error) {
+			hash, salt, err := HashWebForms([]byte(testPlain), Options{Rand: bytes.NewReader(testSalt)})
+			return hash + "," + salt, err
+		}, testWebForms + "," + testSaltBase64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.hash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDotNetVectors checks the hashes of ASP.NET Core Identity's own
+// tests, the Version 2 and Version 3 payloads of
+// src/Identity/test/Identity.Test/PasswordHasherTest.cs, for the password
+// "my password". HashMVC4 and HashIdentityV3 must reproduce them from their
+// salts byte for byte.
+func TestDotNetVectors(t *testing.T) {
+	const plain = "my password"
+	tests := []struct {
+		name string
+		hash func() (string, error)
+		want string
+	}{
+		// SHA1, 1000 iterations, 128-bit salt, 256-bit subkey.
+		{"v2", func() (string, error) {
+			return HashMVC4([]byte(plain), Options{Salt: testSalt})
+		}, "AAABAgMEBQYHCAkKCwwNDg+ukCEMDf0yyQ29NYubggHIVY0sdEUfdyeM+E1LtH1uJg=="},
+		// SHA1, 250 iterations, 128-bit salt, 128-bit subkey.
+		{"v3-sha1", func() (string, error) {
+			salt := []byte{0x08, 0x5f, 0xb4, 0xcc, 0x9f, 0x4c, 0x9c, 0xa5, 0x3a, 0x56, 0x53, 0xf9, 0xe1, 0x28, 0xb4, 0x55}
+			return HashIdentityV3([]byte(plain), PRFSHA1, Options{Iterations: 250, SubkeyLength: 16, Salt: salt})
+		}, "AQAAAAAAAAD6AAAAEAhftMyfTJylOlZT+eEotFXd1elee8ih5WsjXaR3PA9M"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.hash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if ok, err := parsed(plain, tt.want); err != nil || !ok {
+				t.Errorf("the plaintext doesn't verify (%v)", err)
+			}
+		})
+	}
+}
+
+func TestHashRandomSalt(t *testing.T) {
+	a, err := HashMVC4([]byte(testPlain), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := HashMVC4([]byte(testPlain), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Errorf("two hashes share a salt: %s", a)
+	}
+	if _, err := HashMVC4([]byte(testPlain), Options{Rand: bytes.NewReader(testSalt[:4])}); err == nil {
+		t.Error("HashMVC4 with a short rand source succeeded")
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, encoded string
+		prf           PRF
+		version       byte
+		iterations    int
+		hashcat       string
+	}{
+		{"mvc4", testMVC4, PRFSHA1, 0, 1000, "sha1:1000:" + testSaltBase64 + ":ExAHHz02wEeEolgFEb/++rYx+8uwwexO2S6Q5k3cD9Y="},
+		{"identity-v3-sha1", testV3SHA1, PRFSHA1, 1, 1000, "sha1:1000:" + testSaltBase64 + ":ExAHHz02wEeEolgFEb/++rYx+8uwwexO2S6Q5k3cD9Y="},
+		{"identity-v3-sha256", testV3SHA256, PRFSHA256, 1, 10000, "sha256:10000:" + testSaltBase64 + ":gvuJCCKT1hJJvlkUFj78nLMObqen1H+/OGNgThPCs98="},
+		{"identity-v3-sha512", testV3SHA512, PRFSHA512, 1, 100000, "sha512:100000:" + testSaltBase64 + ":XelTWGL7dmqj3gmUbBvsIeSzaVZiQygXfqml4W+kML4="},
+		{"hashcat", "sha256:10000:" + testSaltBase64 + ":gvuJCCKT1hJJvlkUFj78nLMObqen1H+/OGNgThPCs98=", PRFSHA256, 0, 10000, "sha256:10000:" + testSaltBase64 + ":gvuJCCKT1hJJvlkUFj78nLMObqen1H+/OGNgThPCs98="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := Parse(tt.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.PRF != tt.prf || h.Version != tt.version || h.Iterations != tt.iterations {
+				t.Errorf("got PRF %v, version %d, %d iterations; want %v, %d, %d", h.PRF, h.Version, h.Iterations, tt.prf, tt.version, tt.iterations)
+			}
+			if !bytes.Equal(h.Salt, testSalt) {
+				t.Errorf("salt %x, want %x", h.Salt, testSalt)
+			}
+			if got := h.Hashcat(); got != tt.hashcat {
+				t.Errorf("Hashcat() = %s, want %s", got, tt.hashcat)
+			}
+			if !h.Verify([]byte(testPlain)) {
+				t.Error("the plaintext doesn't verify")
+			}
+			if h.Verify([]byte("Passw0rd!")) {
+				t.Error("another plaintext verifies")
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString
+	v3Header := []byte{1, 0, 0, 0, 1, 0, 0, 0x27, 0x10, 0, 0, 0, 16}
+	tests := []struct {
+		name  string
+		parse func(string) (Hash, error)
+		input string
+		want  error
+	}{
+		{"mvc4 base64", ParseMVC4, "not base64!", ErrInvalidBase64},
+		{"mvc4 short", ParseMVC4, b64(make([]byte, 16)), ErrTooShort},
+		{"sized base64", sized16x32, "not base64!", ErrInvalidBase64},
+		{"sized empty", sized16x32, "", ErrTooShort},
+		{"sized version", sized16x32, b64(append([]byte{1}, make([]byte, 48)...)), ErrVersionByte},
+		{"sized length", sized16x32, b64(make([]byte, 48)), ErrLengthMismatch},
+		{"v3 base64", ParseIdentityV3, "not base64!", ErrInvalidBase64},
+		{"v3 short", ParseIdentityV3, b64(v3Header[:12]), ErrTooShort},
+		{"v3 version", ParseIdentityV3, b64(append([]byte{0}, make([]byte, 60)...)), ErrVersionByte},
+		{"v3 prf", ParseIdentityV3, b64(append([]byte{1, 0, 0, 0, 3}, make([]byte, 60)...)), ErrUnsupportedFormat},
+		{"v3 salt length", ParseIdentityV3, b64(append(v3Header, make([]byte, 16)...)), ErrLengthMismatch},
+		{"hashcat fields", ParseHashcat, "sha1:1000:" + testSaltBase64, ErrUnsupportedFormat},
+		{"hashcat prf", ParseHashcat, "md5:1000:" + testSaltBase64 + ":AAAA", ErrUnsupportedFormat},
+		{"hashcat iterations", ParseHashcat, "sha1:0:" + testSaltBase64 + ":AAAA", ErrUnsupportedFormat},
+		{"hashcat salt", ParseHashcat, "sha1:1000:!:AAAA", ErrInvalidBase64},
+		{"hashcat subkey", ParseHashcat, "sha1:1000:" + testSaltBase64 + ":!", ErrInvalidBase64},
+		{"hashcat empty subkey", ParseHashcat, "sha1:1000:" + testSaltBase64 + ":", ErrTooShort},
+		{"parse base64", Parse, "not base64!", ErrInvalidBase64},
+		{"parse sized length", parseSized16x32, b64(make([]byte, 40)), ErrLengthMismatch},
+		{"parse sized version", parseSized16x32, b64(append([]byte{2}, make([]byte, 48)...)), ErrVersionByte},
+		{"parse sized v3", parseSized16x32, b64(append(v3Header, make([]byte, 16)...)), ErrLengthMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.parse(tt.input)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func sized16x32(encoded string) (Hash, error) {
+	return ParseMVC4Sized(encoded, DefaultSaltSize, DefaultSubkeyLength)
+}
+
+func parseSized16x32(encoded string) (Hash, error) {
+	return ParseSized(encoded, DefaultSaltSize, DefaultSubkeyLength)
+}
+
+func TestParseMVC4Sized(t *testing.T) {
+	h, err := ParseMVC4Sized(testMVC4, DefaultSaltSize, DefaultSubkeyLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h.Salt, testSalt) || len(h.Subkey) != DefaultSubkeyLength || !h.Verify([]byte(testPlain)) {
+		t.Errorf("got %+v", h)
+	}
+	// The lenient ParseMVC4 takes a bad version byte, the strict one not.
+	raw, _ := base64.StdEncoding.DecodeString(testMVC4)
+	raw[0] = 2
+	if _, err := ParseMVC4(base64.StdEncoding.EncodeToString(raw)); err != nil {
+		t.Errorf("ParseMVC4: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name, plain, encoded string
+		want                 bool
+		err                  error
+	}{
+		{"mvc4", testPlain, testMVC4, true, nil},
+		{"mvc4 mismatch", "Passw0rd!", testMVC4, false, nil},
+		{"webforms", testPlain, testWebForms + "," + testSaltBase64, true, nil},
+		{"webforms mismatch", "Passw0rd!", testWebForms + "," + testSaltBase64, false, nil},
+		// The digest after the salt is hashcat's example hash for mode 1400,
+		// SHA256 of "hashcat".
+		{"webforms hashcat", "hashcat", "AAECAwQFBgcICQoLDA0ODxJ+b7/iSnUOcpMMIgqOE4J1ZWuOXY9IqYw8kt8sq6k1," + testSaltBase64, true, nil},
+		{"webforms salt", testPlain, testWebForms + ",!", false, ErrInvalidBase64},
+		{"mvc4 no subkey", testPlain, base64.StdEncoding.EncodeToString(make([]byte, 17)), false, ErrTooShort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Verify(tt.plain, tt.encoded)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFamilies round-trips a hash of every family through its parser or
+// verifier: the right plaintext matches, another doesn't.
+func TestFamilies(t *testing.T) {
+	opts := Options{Salt: testSalt}
+	keyed, err := NewKeyedHasher("hmacsha256", make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher, err := NewPasswordCipher("aes", make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		hash   func(plain string) (string, error)
+		verify func(plain, encoded string) (bool, error)
+	}{
+		{"mvc4", func(p string) (string, error) { return HashMVC4([]byte(p), opts) }, Verify},
+		{"identity-v3", func(p string) (string, error) { return HashIdentityV3([]byte(p), PRFSHA256, opts) }, parsed},
+		{"webforms", func(p string) (string, error) {
+			hash, salt, err := HashWebForms([]byte(p), opts)
+			return hash + "," + salt, err
+		}, Verify},
+		{"webforms-hmac", func(p string) (string, error) {
+			hash, salt, err := keyed.Hash([]byte(p), opts)
+			return hash + "," + salt, err
+		}, keyed.Verify},
+		{"dnn", func(p string) (string, error) {
+			hash, salt, err := HashDNN([]byte(p), opts)
+			return hash + "," + salt, err
+		}, func(p, encoded string) (bool, error) {
+			hash, salt, _ := bytes.Cut([]byte(encoded), []byte(","))
+			h, err := ParseDNN(string(hash), string(salt))
+			return err == nil && h.Verify([]byte(p)), err
+		}},
+		{"formsauth", func(p string) (string, error) { return HashFormsAuth([]byte(p), "md5") }, func(p, encoded string) (bool, error) {
+			h, err := ParseFormsAuth(encoded)
+			return err == nil && h.Verify([]byte(p)), err
+		}},
+		{"umbraco-legacy", func(p string) (string, error) { return HashUmbracoLegacy([]byte(p)), nil }, VerifyUmbracoLegacy},
+		{"encrypted", func(p string) (string, error) { return cipher.Encrypt(p, nil) }, func(p, encoded string) (bool, error) {
+			plain, err := cipher.Decrypt(encoded)
+			return plain == p, err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hash(testPlain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := tt.verify(testPlain, encoded); err != nil || !ok {
+				t.Errorf("%s doesn't verify (%v)", encoded, err)
+			}
+			if ok, err := tt.verify("Passw0rd!", encoded); err != nil || ok {
+				t.Errorf("%s verifies another plaintext (%v)", encoded, err)
+			}
+		})
+	}
+}
+
+func parsed(plain, encoded string) (bool, error) {
+	h, err := Parse(encoded)
+	return err == nil && h.Verify([]byte(plain)), err
+}
@@ -0,0 +1,80 @@
+package aspnethash
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf16"
+)
+
+// DotNetNuke (DNN) sites use SqlMembershipProvider with hashed passwords:
+// SHA1 over the salt followed by the UTF-16LE encoded password, with the hash
+// and the salt stored base64 encoded in separate columns.
+
+// DNNHash is a parsed DNN hash.
+type DNNHash struct {
+	Digest []byte
+	Salt   []byte
+}
+
+// HashDNN hashes plain with a fresh salt of opts.SaltSize bytes and returns
+// the base64 encoded hash and salt.
+func HashDNN(plain []byte, opts Options) (hash, salt string, err error) {
+	opts = opts.withDefaults()
+	s := scratchPool.Get().(*scratch)
+	defer scratchPool.Put(s)
+
+	rawSalt, err := opts.salt(s)
+	if err != nil {
+		return "", "", err
+	}
+	digest := dnnDigest(plain, rawSalt)
+	return s.encode(digest), s.encode(rawSalt), nil
+}
+
+// dnnDigest returns SHA1(salt || UTF-16LE(plain)).
+func dnnDigest(plain, salt []byte) []byte {
+	h := sha1.New()
+	h.Write(salt)
+	h.Write(utf16LE(plain))
+	return h.Sum(nil)
+}
+
+// utf16LE encodes plain like .NET's Encoding.Unicode.
+func utf16LE(plain []byte) []byte {
+	units := utf16.Encode([]rune(string(plain)))
+	b := make([]byte, 2*len(units))
+	for i, u := range units {
+		b[2*i], b[2*i+1] = byte(u), byte(u>>8)
+	}
+	return b
+}
+
+// ParseDNN decodes the base64 hash and salt columns of a DNN hash.
+func ParseDNN(hash, salt string) (DNNHash, error) {
+	digest, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil {
+		return DNNHash{}, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64: %w", err)}
+	}
+	rawSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return DNNHash{}, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64 salt: %w", err)}
+	}
+	if len(digest) != sha1.Size {
+		return DNNHash{}, fmt.Errorf("%w: length %d, expected %d", ErrLengthMismatch, len(digest), sha1.Size)
+	}
+	return DNNHash{Digest: digest, Salt: rawSalt}, nil
+}
+
+// Hashcat returns the hash as <hex digest>:<hex salt> for hashcat mode 140,
+// sha1($salt.utf16le($pass)), which needs --hex-salt for the binary salt.
+func (h DNNHash) Hashcat() string {
+	return hex.EncodeToString(h.Digest) + ":" + hex.EncodeToString(h.Salt)
+}
+
+// Verify reports whether plain matches the hash.
+func (h DNNHash) Verify(plain []byte) bool {
+	return subtle.ConstantTimeCompare(dnnDigest(plain, h.Salt), h.Digest) == 1
+}
@@ -0,0 +1,75 @@
+package aspnethash
+
+import (
+	"errors"
+	"testing"
+)
+
+// reversedSalt is ff fe .. f0, a salt whose byte order shows in the hash.
+var reversedSalt = []byte{0xff, 0xfe, 0xfd, 0xfc, 0xfb, 0xfa, 0xf9, 0xf8, 0xf7, 0xf6, 0xf5, 0xf4, 0xf3, 0xf2, 0xf1, 0xf0}
+
+const reversedSaltBase64 = "//79/Pv6+fj39vX08/Lx8A=="
+
+// The DNN known answers are SHA1(salt || UTF-16LE(password)), the salt
+// first; a surrogate pair checks the UTF-16 encoding past the BMP. The
+// first is hashcat's example hash for mode 141, EPiServer 6.x < .NET 4,
+// $episerver$*0*bEtiVGhPNlZpcUN4a3ExTg==*utkfN0EOgljbv5FoZ6+AcZD5iLk with
+// the password "hashcat": EPiServer keeps SqlMembershipProvider SHA1 hashes
+// as DNN does, so it was computed apart from this code. The others were
+// computed with Python's hashlib from the layout above.
+func TestDNNKnownAnswers(t *testing.T) {
+	tests := []struct {
+		plain string
+		salt  []byte
+		hash  string
+		hex   string
+	}{
+		{"hashcat", []byte("lKbThO6ViqCxkq1N"), "utkfN0EOgljbv5FoZ6+AcZD5iLk=", "bad91f37410e8258dbbf916867af807190f988b9:6c4b6254684f3656697143786b71314e"},
+		{testPlain, testSalt, "0DDRUuUD3dxCsVujIBvkHL6piMw=", "d030d152e503dddc42b15ba3201be41cbea988cc:000102030405060708090a0b0c0d0e0f"},
+		{"password", reversedSalt, "aJ9KNWltyZhmFCd71VNvdTSQWQQ=", "689f4a35696dc9986614277bd5536f7534905904:fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0"},
+		{"", reversedSalt, "kqbhq+8WwWB0LUWjZyyC3NlcYAM=", "92a6e1abef16c160742d45a3672c82dcd95c6003:fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0"},
+		{"密码😀", reversedSalt, "Pw0kdTdpGYBhBW5J9lYCB657sB0=", "3f0d24753769198061056e49f6560207ae7bb01d:fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.plain, func(t *testing.T) {
+			hash, salt, err := HashDNN([]byte(tt.plain), Options{Salt: tt.salt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != tt.hash {
+				t.Errorf("HashDNN = %s, want %s", hash, tt.hash)
+			}
+			h, err := ParseDNN(hash, salt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := h.Hashcat(); got != tt.hex {
+				t.Errorf("Hashcat() = %s, want %s", got, tt.hex)
+			}
+			if !h.Verify([]byte(tt.plain)) {
+				t.Error("the plaintext doesn't verify")
+			}
+			if h.Verify([]byte(tt.plain + " ")) {
+				t.Error("another plaintext verifies")
+			}
+		})
+	}
+}
+
+func TestParseDNNErrors(t *testing.T) {
+	tests := []struct {
+		name, hash, salt string
+		want             error
+	}{
+		{"hash base64", "not base64!", reversedSaltBase64, ErrInvalidBase64},
+		{"salt base64", "aJ9KNWltyZhmFCd71VNvdTSQWQQ=", "!", ErrInvalidBase64},
+		{"hash length", reversedSaltBase64, reversedSaltBase64, ErrLengthMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseDNN(tt.hash, tt.salt); !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
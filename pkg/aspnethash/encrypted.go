@@ -0,0 +1,115 @@
+package aspnethash
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// Membership providers with passwordFormat="Encrypted" (PasswordFormat 2 in
+// aspnet_Membership) store passwords encrypted with the machineKey
+// decryptionKey, laid out like System.Web's MachineKeySection does in the
+// Framework20SP1 compatibility mode: a block of random bytes, the 16-byte
+// salt and the UTF-16LE password, encrypted in CBC mode with PKCS#7 padding
+// and base64 encoded. The random block stands in for the IV, so decryption
+// doesn't need to know the one used.
+
+// ErrDecrypt is returned for an encrypted password that doesn't decrypt
+// with the key, which almost always means the key is wrong.
+var ErrDecrypt = errors.New("decryption failed (wrong key or algorithm?)")
+
+// encryptedSaltSize is the size of the salt in front of the password.
+const encryptedSaltSize = 16
+
+// DecryptionAlgorithms lists the machineKey decryption algorithms supported,
+// as accepted by NewPasswordCipher.
+var DecryptionAlgorithms = []string{"aes", "3des"}
+
+// PasswordCipher encrypts and decrypts membership passwords with a
+// machineKey decryptionKey. It is safe for concurrent use.
+type PasswordCipher struct {
+	block cipher.Block
+}
+
+// NewPasswordCipher returns a cipher for the algorithm ("aes" or "3des") and
+// the decoded decryptionKey key.
+func NewPasswordCipher(algorithm string, key []byte) (*PasswordCipher, error) {
+	var block cipher.Block
+	var err error
+	switch algorithm {
+	case "aes":
+		if n := len(key); n != 16 && n != 24 && n != 32 {
+			return nil, fmt.Errorf("AES keys are 16, 24 or 32 bytes, not %d", n)
+		}
+		block, err = aes.NewCipher(key)
+	case "3des":
+		switch len(key) {
+		case 16:
+			// Two-key 3DES: the first key is used again as the third.
+			key = append(key[:16:16], key[:8]...)
+		case 24:
+		default:
+			return nil, fmt.Errorf("3DES keys are 16 or 24 bytes, not %d", len(key))
+		}
+		block, err = des.NewTripleDESCipher(key)
+	default:
+		return nil, fmt.Errorf("%w: decryption algorithm %s", ErrUnsupportedFormat, algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PasswordCipher{block: block}, nil
+}
+
+// Decrypt returns the password of a base64 encoded encrypted password.
+func (c *PasswordCipher) Decrypt(encoded string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64: %w", err)}
+	}
+	size := c.block.BlockSize()
+	if len(data) < 2*size || len(data)%size != 0 {
+		return "", fmt.Errorf("%w: length %d is not a multiple of the %d-byte block", ErrLengthMismatch, len(data), size)
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(c.block, make([]byte, size)).CryptBlocks(plain, data)
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > size || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return "", ErrDecrypt
+	}
+	plain = plain[size : len(plain)-pad]
+	if len(plain) < encryptedSaltSize || (len(plain)-encryptedSaltSize)%2 != 0 {
+		return "", ErrDecrypt
+	}
+	plain = plain[encryptedSaltSize:]
+	units := make([]uint16, len(plain)/2)
+	for i := range units {
+		units[i] = uint16(plain[2*i]) | uint16(plain[2*i+1])<<8
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// Encrypt encrypts password with a fresh salt and random block, for
+// fixtures. rnd is crypto/rand.Reader if nil.
+func (c *PasswordCipher) Encrypt(password string, rnd io.Reader) (string, error) {
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+	size := c.block.BlockSize()
+	data := make([]byte, size+encryptedSaltSize)
+	if _, err := io.ReadFull(rnd, data); err != nil {
+		return "", err
+	}
+	data = append(data, utf16LE([]byte(password))...)
+	pad := size - len(data)%size
+	data = append(data, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(c.block, make([]byte, size)).CryptBlocks(data, data)
+	return base64.StdEncoding.EncodeToString(data), nil
+}
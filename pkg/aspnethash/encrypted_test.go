@@ -0,0 +1,108 @@
+package aspnethash
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// The encrypted known answers are the MachineKeySection layout encrypted
+// with a zero IV: a block of 0xa5 bytes, the salt 00 01 .. 0f and the
+// UTF-16LE password, with PKCS#7 padding. They were encrypted apart from
+// this code with openssl enc -aes-128-cbc, -aes-256-cbc and -des-ede3-cbc
+// (the 2-key key as k1 k2 k1), not taken from a real install.
+var encryptedTests = []struct {
+	name, algorithm string
+	key             []byte
+	encrypted       string
+}{
+	{"aes-128", "aes", seq(16), "KCgRwxMd9C858mxDRJE/oGu2txHYuPF+g7facgGUiW+z602Q4qPN5j6skn8wL9oL1i/W9CjpY/r4t6mAmIxbhQ=="},
+	{"aes-256", "aes", seq(32), "8OdMxYcGq6AiSD517c3HSTvzQjJowqV8RIE4FAvJ2VXP6bCPX0UoQOY0Bhajlo4nnzyM/RBLh5LJoc6xCWmjCg=="},
+	{"3des", "3des", seq(24), "fubOqDWSi7m/Zgu4hdP2s+y0lptTtBkIRXgqwOpUfDIBvE8VcXdDhb2zjjCvoZpn"},
+	// A two-key 3DES key is used as k1 k2 k1.
+	{"3des-2key", "3des", seq(16), "61LvNmKMvl8URzkTKSFDgtzzIAPEZthVaapVACPMX0hDw+cGMpForLTcbqGhkOGg"},
+}
+
+// seq returns the n bytes 00 01 02 ...
+func seq(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func TestEncryptedKnownAnswers(t *testing.T) {
+	for _, tt := range encryptedTests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewPasswordCipher(tt.algorithm, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := c.Decrypt(tt.encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain != testPlain {
+				t.Errorf("Decrypt = %q, want %q", plain, testPlain)
+			}
+			block := bytes.Repeat([]byte{0xa5}, c.block.BlockSize())
+			encrypted, err := c.Encrypt(testPlain, bytes.NewReader(append(block, testSalt...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted != tt.encrypted {
+				t.Errorf("Encrypt = %s, want %s", encrypted, tt.encrypted)
+			}
+		})
+	}
+}
+
+func TestEncryptedWrongKey(t *testing.T) {
+	for _, tt := range encryptedTests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := append([]byte{}, tt.key...)
+			key[0] ^= 0x80
+			c, err := NewPasswordCipher(tt.algorithm, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain, err := c.Decrypt(tt.encrypted); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Decrypt with the wrong key = %q, %v; want %v", plain, err, ErrDecrypt)
+			}
+		})
+	}
+}
+
+func TestEncryptedErrors(t *testing.T) {
+	for _, tt := range []struct {
+		algorithm string
+		key       []byte
+	}{
+		{"aes", seq(20)},
+		{"3des", seq(8)},
+		{"des", seq(8)},
+	} {
+		if _, err := NewPasswordCipher(tt.algorithm, tt.key); err == nil {
+			t.Errorf("NewPasswordCipher(%s, %d bytes) succeeded", tt.algorithm, len(tt.key))
+		}
+	}
+	c, err := NewPasswordCipher("aes", seq(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		encrypted string
+		want      error
+	}{
+		{"not base64!", ErrInvalidBase64},
+		// One block: the random block, without salt or password.
+		{"KCgRwxMd9C858mxDRJE/oA==", ErrLengthMismatch},
+		// Not a whole number of blocks.
+		{"KCgRwxMd9C858mxDRJE/oGu2txHYuPF+", ErrLengthMismatch},
+	} {
+		if _, err := c.Decrypt(tt.encrypted); !errors.Is(err, tt.want) {
+			t.Errorf("Decrypt(%s): got error %v, want %v", tt.encrypted, err, tt.want)
+		}
+	}
+}
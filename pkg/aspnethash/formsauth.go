@@ -0,0 +1,74 @@
+package aspnethash
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// ASP.NET 1.x and 2.0 sites often stored the result of
+// FormsAuthentication.HashPasswordForStoringInConfigFile: the unsalted SHA1
+// or MD5 of the UTF-8 password as uppercase hex. hashcat takes them as
+// modes 100 and 0.
+
+// FormsAuthHash is a parsed FormsAuthentication hash.
+type FormsAuthHash struct {
+	// Algorithm is "sha1" or "md5".
+	Algorithm string
+	Digest    []byte
+}
+
+// formsAuthAlgorithms are the algorithms HashPasswordForStoringInConfigFile
+// offered.
+var formsAuthAlgorithms = map[string]func() hash.Hash{
+	"sha1": sha1.New,
+	"md5":  md5.New,
+}
+
+// HashFormsAuth returns the FormsAuthentication hash of plain with
+// algorithm, "sha1" or "md5", in uppercase hex as .NET wrote it.
+func HashFormsAuth(plain []byte, algorithm string) (string, error) {
+	newHash, ok := formsAuthAlgorithms[algorithm]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, algorithm)
+	}
+	h := newHash()
+	h.Write(plain)
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// ParseFormsAuth decodes a FormsAuthentication hash of either case. The
+// algorithm is told by the length: 40 hex digits for SHA1, 32 for MD5.
+func ParseFormsAuth(encoded string) (FormsAuthHash, error) {
+	digest, err := hex.DecodeString(encoded)
+	if err != nil {
+		return FormsAuthHash{}, fmt.Errorf("%w: not hex: %v", ErrUnsupportedFormat, err)
+	}
+	switch len(digest) {
+	case sha1.Size:
+		return FormsAuthHash{Algorithm: "sha1", Digest: digest}, nil
+	case md5.Size:
+		return FormsAuthHash{Algorithm: "md5", Digest: digest}, nil
+	}
+	return FormsAuthHash{}, fmt.Errorf("%w: length %d, expected %d (SHA1) or %d (MD5)", ErrLengthMismatch, len(digest), sha1.Size, md5.Size)
+}
+
+// Hashcat returns the hash as the lowercase hex digest hashcat writes.
+func (h FormsAuthHash) Hashcat() string {
+	return hex.EncodeToString(h.Digest)
+}
+
+// Verify reports whether plain matches the hash.
+func (h FormsAuthHash) Verify(plain []byte) bool {
+	newHash, ok := formsAuthAlgorithms[h.Algorithm]
+	if !ok {
+		return false
+	}
+	d := newHash()
+	d.Write(plain)
+	return subtle.ConstantTimeCompare(d.Sum(nil), h.Digest) == 1
+}
@@ -0,0 +1,72 @@
+package aspnethash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// The FormsAuthentication known answers are the SHA1 and MD5 of the UTF-8
+// password in uppercase hex. The first are hashcat's example hashes for
+// modes 100 and 0, whose password is "hashcat".
+func TestFormsAuthKnownAnswers(t *testing.T) {
+	tests := []struct {
+		plain, sha1, md5 string
+	}{
+		{"hashcat", "B89EAAC7E61417341B710B727768294D0E6A277B", "8743B52063CD84097A65D1633F5C74F5"},
+		{testPlain, "2F41B907E78757343EA3716625C84480397138C6", "E29042A326D6983A777EE737385F911F"},
+		{"password", "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8", "5F4DCC3B5AA765D61D8327DEB882CF99"},
+		{"", "DA39A3EE5E6B4B0D3255BFEF95601890AFD80709", "D41D8CD98F00B204E9800998ECF8427E"},
+		{"密码😀", "8C49109C2514EC3D8632C2216283131F33452A84", "560442BBB79A92CD7A08F70277C889C9"},
+	}
+	for _, tt := range tests {
+		for algorithm, want := range map[string]string{"sha1": tt.sha1, "md5": tt.md5} {
+			t.Run(algorithm+"/"+tt.plain, func(t *testing.T) {
+				got, err := HashFormsAuth([]byte(tt.plain), algorithm)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("HashFormsAuth = %s, want %s", got, want)
+				}
+				// Hashes are read in either case.
+				h, err := ParseFormsAuth(strings.ToLower(got))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if h.Algorithm != algorithm {
+					t.Errorf("Algorithm = %s, want %s", h.Algorithm, algorithm)
+				}
+				if h.Hashcat() != strings.ToLower(want) {
+					t.Errorf("Hashcat() = %s, want %s", h.Hashcat(), strings.ToLower(want))
+				}
+				if !h.Verify([]byte(tt.plain)) {
+					t.Error("the plaintext doesn't verify")
+				}
+				if h.Verify([]byte(tt.plain + " ")) {
+					t.Error("another plaintext verifies")
+				}
+			})
+		}
+	}
+}
+
+func TestFormsAuthErrors(t *testing.T) {
+	if _, err := HashFormsAuth([]byte(testPlain), "sha256"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("HashFormsAuth(sha256): got error %v, want %v", err, ErrUnsupportedFormat)
+	}
+	tests := []struct {
+		encoded string
+		want    error
+	}{
+		{"not hex", ErrUnsupportedFormat},
+		{"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD", ErrUnsupportedFormat},
+		// A SHA256 digest is hex, but not one of the lengths.
+		{"ECAF86427DE8DA127B70F14DD00077CDD871719651ABD2CC896739F1F726CF33", ErrLengthMismatch},
+	}
+	for _, tt := range tests {
+		if _, err := ParseFormsAuth(tt.encoded); !errors.Is(err, tt.want) {
+			t.Errorf("ParseFormsAuth(%s): got error %v, want %v", tt.encoded, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package aspnethash
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PRF is the HMAC PBKDF2 is run with, numbered like ASP.NET Core Identity's
+// KeyDerivationPrf. The zero value is the HMAC-SHA1 of MVC4 hashes.
+type PRF uint32
+
+const (
+	PRFSHA1 PRF = iota
+	PRFSHA256
+	PRFSHA512
+)
+
+func (p PRF) String() string {
+	switch p {
+	case PRFSHA1:
+		return "sha1"
+	case PRFSHA256:
+		return "sha256"
+	case PRFSHA512:
+		return "sha512"
+	}
+	return "prf" + strconv.Itoa(int(p))
+}
+
+func (p PRF) hash() func() hash.Hash {
+	switch p {
+	case PRFSHA256:
+		return sha256.New
+	case PRFSHA512:
+		return sha512.New
+	}
+	return sha1.New
+}
+
+func parsePRF(name string) (PRF, bool) {
+	for p := PRFSHA1; p <= PRFSHA512; p++ {
+		if p.String() == name {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// identityV3Header is the size of the fields before the salt of an Identity
+// v3 hash: version byte, PRF, iteration count and salt length.
+const identityV3Header = 1 + 4 + 4 + 4
+
+// ParseIdentityV3 decodes a base64 encoded ASP.NET Core Identity v3 hash:
+// 0x01, then PRF, iteration count and salt length as big-endian uint32,
+// then the salt and the subkey.
+func ParseIdentityV3(encoded string) (Hash, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return Hash{}, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64: %w", err)}
+	}
+	if len(decoded) < identityV3Header {
+		return Hash{}, ErrTooShort
+	}
+	if decoded[0] != 0x01 {
+		return Hash{}, fmt.Errorf("%w: 0x%02x, expected 0x01", ErrVersionByte, decoded[0])
+	}
+	prf := PRF(binary.BigEndian.Uint32(decoded[1:5]))
+	if prf > PRFSHA512 {
+		return Hash{}, fmt.Errorf("%w: PRF %d", ErrUnsupportedFormat, prf)
+	}
+	iterations := binary.BigEndian.Uint32(decoded[5:9])
+	saltLen := binary.BigEndian.Uint32(decoded[9:13])
+	rest := decoded[identityV3Header:]
+	if uint64(saltLen) >= uint64(len(rest)) {
+		return Hash{}, fmt.Errorf("%w: salt length %d with %d bytes left", ErrLengthMismatch, saltLen, len(rest))
+	}
+	return Hash{
+		Version:    decoded[0],
+		PRF:        prf,
+		Salt:       rest[:saltLen],
+		Subkey:     rest[saltLen:],
+		Iterations: int(iterations),
+	}, nil
+}
+
+// HashIdentityV3 hashes plain with a fresh salt and returns the base64
+// encoded ASP.NET Core Identity v3 hash. Zero options select the MVC4
+// defaults; Identity itself uses 10000 iterations with PRFSHA256, or 100000
+// with PRFSHA512 since .NET 7.
+func HashIdentityV3(plain []byte, prf PRF, opts Options) (string, error) {
+	if prf > PRFSHA512 {
+		return "", fmt.Errorf("%w: PRF %d", ErrUnsupportedFormat, prf)
+	}
+	opts = opts.withDefaults()
+	s := scratchPool.Get().(*scratch)
+	defer scratchPool.Put(s)
+
+	salt, err := opts.salt(s)
+	if err != nil {
+		return "", err
+	}
+	subkey := pbkdf2.Key(plain, salt, opts.Iterations, opts.SubkeyLength, prf.hash())
+	s.raw = sized(s.raw, identityV3Header+len(salt)+len(subkey))
+	s.raw[0] = 0x01
+	binary.BigEndian.PutUint32(s.raw[1:5], uint32(prf))
+	binary.BigEndian.PutUint32(s.raw[5:9], uint32(opts.Iterations))
+	binary.BigEndian.PutUint32(s.raw[9:13], uint32(len(salt)))
+	copy(s.raw[identityV3Header:], salt)
+	copy(s.raw[identityV3Header+len(salt):], subkey)
+	return s.encode(s.raw), nil
+}
+
+// ParseHashcat parses a hash in hashcat's PBKDF2 format,
+// <prf>:<iterations>:<base64 salt>:<base64 subkey>, as used by modes 12000
+// (sha1), 10900 (sha256) and 12100 (sha512).
+func ParseHashcat(line string) (Hash, error) {
+	parts := strings.Split(line, ":")
+	if len(parts) != 4 {
+		return Hash{}, fmt.Errorf("%w: expected <prf>:<iterations>:<salt>:<subkey>", ErrUnsupportedFormat)
+	}
+	prf, ok := parsePRF(parts[0])
+	if !ok {
+		return Hash{}, fmt.Errorf("%w: %s", ErrUnsupportedFormat, parts[0])
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return Hash{}, fmt.Errorf("%w: iterations %q", ErrUnsupportedFormat, parts[1])
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Hash{}, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64 salt: %w", err)}
+	}
+	subkey, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return Hash{}, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64 subkey: %w", err)}
+	}
+	if len(subkey) == 0 {
+		return Hash{}, ErrTooShort
+	}
+	return Hash{PRF: prf, Salt: salt, Subkey: subkey, Iterations: iterations}, nil
+}
+
+// Parse parses an MVC4 or Identity v3 hash, told apart by the version byte,
+// or a hash in hashcat's format. MVC4 hashes get DefaultIterations.
+func Parse(encoded string) (Hash, error) {
+	if strings.Contains(encoded, ":") {
+		return ParseHashcat(encoded)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(decoded) > 0 && decoded[0] == 0x01 {
+		return ParseIdentityV3(encoded)
+	}
+	return ParseMVC4(encoded)
+}
+
+// ParseSized is the strict counterpart of Parse for stored hashes: an MVC4
+// hash as ParseMVC4Sized reads it, or, if its version byte isn't 0x00, an
+// Identity v3 hash. The error is that of the MVC4 hash unless it is a v3
+// hash.
+func ParseSized(encoded string, saltSize, subkeyLength int) (Hash, error) {
+	hash, err := ParseMVC4Sized(encoded, saltSize, subkeyLength)
+	if errors.Is(err, ErrVersionByte) {
+		if v3, v3Err := ParseIdentityV3(encoded); !errors.Is(v3Err, ErrVersionByte) {
+			return v3, v3Err
+		}
+	}
+	return hash, err
+}
@@ -0,0 +1,93 @@
+package aspnethash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// With hashAlgorithmType="HMACSHA256" or "HMACSHA512", SqlMembershipProvider
+// keys the HMAC with the machineKey validationKey and hashes the salt
+// followed by the UTF-16LE password. The base64 hash and salt are stored in
+// separate columns, like other Web Forms hashes.
+
+// ErrAutoGenerateKey is returned for a machineKey set to AutoGenerate: the
+// key only exists on the server that generated it.
+var ErrAutoGenerateKey = errors.New("the key is AutoGenerate, so it was generated on the server and isn't in web.config; hashes using it can't be computed without the server's key")
+
+// KeyedAlgorithms lists the hashAlgorithmType values KeyedHasher supports,
+// lower-cased, with the shortest validationKey each accepts in bytes.
+var KeyedAlgorithms = map[string]int{
+	"hmacsha256": 32,
+	"hmacsha512": 64,
+}
+
+// KeyedHasher hashes Web Forms passwords with an HMAC keyed with the
+// validationKey. It is safe for concurrent use.
+type KeyedHasher struct {
+	newHash func() hash.Hash
+	key     []byte
+}
+
+// NewKeyedHasher returns a hasher for the algorithm ("hmacsha256" or
+// "hmacsha512") and the decoded validationKey key, which must be at least
+// as long as ASP.NET requires for the algorithm.
+func NewKeyedHasher(algorithm string, key []byte) (*KeyedHasher, error) {
+	algorithm = strings.ToLower(algorithm)
+	minLen, ok := KeyedAlgorithms[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w: hash algorithm %s", ErrUnsupportedFormat, algorithm)
+	}
+	if len(key) < minLen {
+		return nil, fmt.Errorf("%w: a %s validationKey needs at least %d bytes (%d hex digits), this one has %d", ErrLengthMismatch, strings.ToUpper(algorithm), minLen, 2*minLen, len(key))
+	}
+	h := &KeyedHasher{newHash: sha256.New, key: key}
+	if algorithm == "hmacsha512" {
+		h.newHash = sha512.New
+	}
+	return h, nil
+}
+
+func (h *KeyedHasher) digest(plain, salt []byte) []byte {
+	mac := hmac.New(h.newHash, h.key)
+	mac.Write(salt)
+	mac.Write(utf16LE(plain))
+	return mac.Sum(nil)
+}
+
+// Hash hashes plain with a fresh salt and returns the base64 encoded hash
+// and salt.
+func (h *KeyedHasher) Hash(plain []byte, opts Options) (hash, salt string, err error) {
+	opts = opts.withDefaults()
+	s := scratchPool.Get().(*scratch)
+	defer scratchPool.Put(s)
+
+	rawSalt, err := opts.salt(s)
+	if err != nil {
+		return "", "", err
+	}
+	return s.encode(h.digest(plain, rawSalt)), s.encode(rawSalt), nil
+}
+
+// Verify reports whether plain matches encoded, a "hash,salt" pair.
+func (h *KeyedHasher) Verify(plain, encoded string) (bool, error) {
+	hash, salt, ok := strings.Cut(encoded, ",")
+	if !ok {
+		return false, fmt.Errorf("%w: expected <hash>,<salt>", ErrUnsupportedFormat)
+	}
+	digest, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil {
+		return false, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64: %w", err)}
+	}
+	rawSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return false, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64 salt: %w", err)}
+	}
+	return subtle.ConstantTimeCompare(h.digest([]byte(plain), rawSalt), digest) == 1, nil
+}
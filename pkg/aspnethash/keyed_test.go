@@ -0,0 +1,89 @@
+package aspnethash
+
+import (
+	"errors"
+	"testing"
+)
+
+// The keyed known answers are HMAC(validationKey, salt || UTF-16LE(password))
+// with the keys 00 01 .. 1f for HMACSHA256 and 00 01 .. 3f for HMACSHA512,
+// computed apart from this code with Python's hmac. They aren't from a real
+// site: no hashes with their validationKey were at hand.
+func TestKeyedKnownAnswers(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		key       []byte
+		plain     string
+		salt      []byte
+		hash      string
+	}{
+		{"hmacsha256", seq(32), testPlain, testSalt, "i3wG3zdCuEn0TQAQ/OzbXFfOiOtO7AOogRVWcnhuj3g="},
+		{"hmacsha256", seq(32), "password", reversedSalt, "XTaLc0GkQ1EkJ61Z067ApFg7koZWsD44dXvNugxKxLM="},
+		{"hmacsha256", seq(32), "", reversedSalt, "FXDkFMQ7yP2tEJi6Czpq7BoQfScf5q9mXHNwMssKUVs="},
+		{"hmacsha256", seq(32), "密码😀", reversedSalt, "/ceP32ry45GW4I7dsZGI4beL+0G+R0hM+QdiCZvL0Tw="},
+		{"HMACSHA512", seq(64), "password", reversedSalt, "OHpBHhUF8akjOWWyvaSkc4htecGkFCtQX3u7u5n5ugkPta+7u1mn7eZQaCghKKpOAko+qMzDlBtTSh7Ony37ww=="},
+		{"hmacsha512", seq(64), "密码😀", reversedSalt, "LDWDjfsuVKrpi0vjSM3d0RDPr+nn9pGuZphxPBacCtZiFGOi4mJqE/pj9UUFfTo7juIjHN4pC/Trfuc4nyGQew=="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm+"/"+tt.plain, func(t *testing.T) {
+			h, err := NewKeyedHasher(tt.algorithm, tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash, salt, err := h.Hash([]byte(tt.plain), Options{Salt: tt.salt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != tt.hash {
+				t.Errorf("Hash = %s, want %s", hash, tt.hash)
+			}
+			if ok, err := h.Verify(tt.plain, hash+","+salt); err != nil || !ok {
+				t.Errorf("the plaintext doesn't verify (%v)", err)
+			}
+			if ok, err := h.Verify(tt.plain+" ", hash+","+salt); err != nil || ok {
+				t.Errorf("another plaintext verifies (%v)", err)
+			}
+		})
+	}
+}
+
+func TestNewKeyedHasherErrors(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		key       []byte
+		want      error
+	}{
+		{"hmacsha256", seq(31), ErrLengthMismatch},
+		{"hmacsha512", seq(32), ErrLengthMismatch},
+		{"hmacmd5", seq(64), ErrUnsupportedFormat},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyedHasher(tt.algorithm, tt.key); !errors.Is(err, tt.want) {
+			t.Errorf("NewKeyedHasher(%s, %d bytes): got error %v, want %v", tt.algorithm, len(tt.key), err, tt.want)
+		}
+	}
+	// A longer key than required is fine.
+	if _, err := NewKeyedHasher("hmacsha256", seq(64)); err != nil {
+		t.Errorf("NewKeyedHasher(hmacsha256, 64 bytes): %v", err)
+	}
+}
+
+func TestKeyedVerifyErrors(t *testing.T) {
+	h, err := NewKeyedHasher("hmacsha256", seq(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		encoded string
+		want    error
+	}{
+		{"i3wG3zdCuEn0TQAQ/OzbXFfOiOtO7AOogRVWcnhuj3g=", ErrUnsupportedFormat},
+		{"not base64!," + testSaltBase64, ErrInvalidBase64},
+		{"i3wG3zdCuEn0TQAQ/OzbXFfOiOtO7AOogRVWcnhuj3g=,!", ErrInvalidBase64},
+	}
+	for _, tt := range tests {
+		if _, err := h.Verify(testPlain, tt.encoded); !errors.Is(err, tt.want) {
+			t.Errorf("Verify(%s): got error %v, want %v", tt.encoded, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package aspnethash
+
+import (
+	"context"
+	"sync"
+)
+
+// Pipeline runs batches of consecutive input lines on a pool of workers and
+// delivers their results one batch at a time, in line order if it is
+// ordered. Processor.Run reads its input into one; programs with readers of
+// their own, such as the aspnethashtool command, submit batches directly.
+type Pipeline struct {
+	slots   chan struct{}
+	ordered bool
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	next    int64 // the first line not released yet, if ordered
+	pending map[int64]pendingBatch
+}
+
+// pendingBatch is a batch done before the lines ahead of it.
+type pendingBatch struct {
+	lines   int64
+	deliver func()
+}
+
+// NewPipeline returns a Pipeline whose batches hold a value in slots while
+// they run, so cap(slots) of them run at once; with nil slots they aren't
+// bounded. If ordered, results are delivered in line order, from first.
+func NewPipeline(slots chan struct{}, ordered bool, first int64) *Pipeline {
+	return &Pipeline{slots: slots, ordered: ordered, next: first, pending: make(map[int64]pendingBatch)}
+}
+
+// Submit runs work on a worker once a slot is free, for the batch of n
+// lines starting at first, which may account for lines the caller dropped
+// as well as those it holds. work returns the function that delivers the
+// batch's results, or nil if there are none. Deliveries are made one at a
+// time, and if the Pipeline is ordered, after those of every earlier line.
+// If ctx is done before a slot is free, the batch is dropped and Submit
+// returns false.
+func (p *Pipeline) Submit(ctx context.Context, first, n int64, work func() (deliver func())) bool {
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		deliver := work()
+		if p.slots != nil {
+			<-p.slots
+		}
+		p.done(first, n, deliver)
+	}()
+	return true
+}
+
+// Skip accounts for n lines starting at first that no batch holds, so the
+// lines after them can be delivered.
+func (p *Pipeline) Skip(first, n int64) {
+	if p.ordered {
+		p.done(first, n, nil)
+	}
+}
+
+// done delivers the results of a batch, and if it was next in line, those
+// of the batches waiting on it.
+func (p *Pipeline) done(first, n int64, deliver func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.ordered {
+		if deliver != nil {
+			deliver()
+		}
+		return
+	}
+	if first != p.next {
+		p.pending[first] = pendingBatch{lines: n, deliver: deliver}
+		return
+	}
+	for {
+		if deliver != nil {
+			deliver()
+		}
+		p.next += n
+		b, found := p.pending[p.next]
+		if !found {
+			return
+		}
+		delete(p.pending, p.next)
+		n, deliver = b.lines, b.deliver
+	}
+}
+
+// Released calls fn with the last line delivered in order. Nothing is
+// delivered while fn runs, so it sees the output in a consistent state.
+func (p *Pipeline) Released(fn func(lastLine int64) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fn(p.next - 1)
+}
+
+// Wait waits until every batch submitted has run. Batches still waiting on
+// lines that were never submitted or skipped aren't delivered.
+func (p *Pipeline) Wait() {
+	p.wg.Wait()
+}
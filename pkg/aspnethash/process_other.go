@@ -0,0 +1,16 @@
+//go:build !unix
+
+package aspnethash
+
+import "os"
+
+// processRunning reports whether a process with the ID pid runs. Where
+// finding a process doesn't check that it exists, it assumes it does.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package aspnethash
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processRunning reports whether a process with the ID pid runs, as far as
+// signalling it tells.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,233 @@
+package aspnethash
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Mode is what a Processor does to each line.
+type Mode string
+
+// Modes of a Processor. The hashing modes take a plaintext per line and
+// write its hash, with the salt after a comma for the modes that store it
+// separately; the conversion modes take a hash and write its hashcat line.
+const (
+	ModeMVC4          Mode = "mvc4"
+	ModeWebForms      Mode = "webforms"
+	ModeDNN           Mode = "dnn"
+	ModeUmbracoLegacy Mode = "umbraco-legacy"
+	// ModeHashcat converts MVC4 hashes of the salt size and subkey length
+	// of the Options, and Identity v3 hashes.
+	ModeHashcat Mode = "hashcat"
+	// ModeHashcatDNN converts DNN hashes given as <hash>,<salt>.
+	ModeHashcatDNN Mode = "hashcat-dnn"
+)
+
+// processorBatchSize is the number of lines a worker takes at once.
+const processorBatchSize = 256
+
+// maxProcessorLine is the longest input line a Processor reads.
+const maxProcessorLine = 1 << 20
+
+// Processor streams lines through one of the package's hash functions on a
+// pool of workers, for programs that embed the whole pipeline rather than
+// hashing one value at a time. The aspnethashtool command doesn't use it:
+// its reader has input options a Processor lacks, and only the Pipeline
+// and the parsers are shared.
+type Processor struct {
+	Mode Mode
+	// Options are passed to the hash functions of the hashing modes. In
+	// ModeHashcat, they give the iterations and sizes of the MVC4 hashes.
+	Options Options
+	// Keyed, if set, replaces SHA256 in ModeWebForms.
+	Keyed *KeyedHasher
+	// Workers is the number of lines processed at once, GOMAXPROCS if zero.
+	Workers int
+	// Ordered writes the output in input order rather than as lines are
+	// done.
+	Ordered bool
+	// OnError, if set, is called for every line that fails, which is left
+	// out of the output. lineNo counts from 1. Calls are made from one
+	// goroutine at a time, in input order if Ordered is set.
+	OnError func(lineNo int, line string, err error)
+}
+
+// Stats are the counts of a Processor run. Every line read is counted as
+// exactly one of processed, errored or skipped.
+type Stats struct {
+	Read      int64
+	Processed int64
+	Errored   int64
+	// Skipped counts the empty lines.
+	Skipped  int64
+	Duration time.Duration
+}
+
+// Run reads lines from in until EOF and writes one line to out for each
+// line processed. A trailing \r is dropped from every line. Failed lines
+// go to OnError and don't stop the run; the error returned is one reading
+// in or writing out, or ctx's error if it was cancelled. Cancelling stops
+// the reading, though not a Read of in that is already blocked, and the
+// lines already read are finished and written before Run returns, so the
+// stats add up either way.
+func (p *Processor) Run(ctx context.Context, in io.Reader, out io.Writer) (Stats, error) {
+	started := time.Now()
+	process, err := p.lineFunc()
+	if err != nil {
+		return Stats{}, err
+	}
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	run, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// stats and w are only touched by the deliveries, which the pipeline
+	// makes one at a time.
+	var stats Stats
+	w := bufio.NewWriter(out)
+	var writeErr error
+	pipe := NewPipeline(make(chan struct{}, workers), p.Ordered, 1)
+	read, readErr := readBatches(run, in, func(first int64, lines []string) bool {
+		return pipe.Submit(run, first, int64(len(lines)), func() func() {
+			results, errs := processLines(lines, process)
+			return func() {
+				for i, line := range lines {
+					switch {
+					case errs[i] != nil:
+						stats.Errored++
+						if p.OnError != nil {
+							p.OnError(int(first)+i, line, errs[i])
+						}
+					case line == "":
+						stats.Skipped++
+					default:
+						stats.Processed++
+						if writeErr == nil {
+							if _, writeErr = w.WriteString(results[i] + "\n"); writeErr != nil {
+								cancel()
+							}
+						}
+					}
+				}
+			}
+		})
+	})
+	pipe.Wait()
+	if writeErr == nil {
+		writeErr = w.Flush()
+	}
+	stats.Read = read
+	stats.Duration = time.Since(started)
+	switch {
+	case writeErr != nil:
+		return stats, writeErr
+	case readErr != nil:
+		return stats, readErr
+	}
+	return stats, ctx.Err()
+}
+
+// lineFunc returns the function of the Processor's mode.
+func (p *Processor) lineFunc() (func(line string) (string, error), error) {
+	opts := p.Options
+	joined := func(hash func([]byte, Options) (string, string, error)) func(string) (string, error) {
+		return func(line string) (string, error) {
+			hash, salt, err := hash([]byte(line), opts)
+			if err != nil {
+				return "", err
+			}
+			return hash + "," + salt, nil
+		}
+	}
+	switch p.Mode {
+	case ModeMVC4:
+		return func(line string) (string, error) {
+			return HashMVC4([]byte(line), opts)
+		}, nil
+	case ModeWebForms:
+		if p.Keyed != nil {
+			return joined(p.Keyed.Hash), nil
+		}
+		return joined(HashWebForms), nil
+	case ModeDNN:
+		return joined(HashDNN), nil
+	case ModeUmbracoLegacy:
+		return func(line string) (string, error) {
+			return HashUmbracoLegacy([]byte(line)), nil
+		}, nil
+	case ModeHashcat:
+		opts = opts.withDefaults()
+		return func(line string) (string, error) {
+			h, err := ParseSized(line, opts.SaltSize, opts.SubkeyLength)
+			if err != nil {
+				return "", err
+			}
+			if h.Version == 0 {
+				h.Iterations = opts.Iterations
+			}
+			return h.Hashcat(), nil
+		}, nil
+	case ModeHashcatDNN:
+		return func(line string) (string, error) {
+			hash, salt, ok := strings.Cut(line, ",")
+			if !ok {
+				return "", fmt.Errorf("%w: expected <hash>,<salt>", ErrUnsupportedFormat)
+			}
+			h, err := ParseDNN(hash, salt)
+			if err != nil {
+				return "", err
+			}
+			return h.Hashcat(), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: mode %q", ErrUnsupportedFormat, p.Mode)
+}
+
+// readBatches reads the lines of in in batches and submits them until EOF
+// or until ctx is done, and returns the number of lines submitted. first
+// is the number of the first line of a batch, counting from 1.
+func readBatches(ctx context.Context, in io.Reader, submit func(first int64, lines []string) bool) (int64, error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxProcessorLine)
+	var read int64
+	lines := make([]string, 0, processorBatchSize)
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			// Neither are the lines of the pending batch.
+			return read - int64(len(lines)), nil
+		}
+		lines = append(lines, strings.TrimSuffix(scanner.Text(), "\r"))
+		read++
+		if len(lines) == processorBatchSize {
+			if !submit(read-int64(len(lines))+1, lines) {
+				// The lines of the batch were read but will never be
+				// processed.
+				return read - int64(len(lines)), nil
+			}
+			lines = make([]string, 0, processorBatchSize)
+		}
+	}
+	if len(lines) > 0 && !submit(read-int64(len(lines))+1, lines) {
+		return read - int64(len(lines)), nil
+	}
+	return read, scanner.Err()
+}
+
+// processLines runs every line but the empty ones through process.
+func processLines(lines []string, process func(string) (string, error)) ([]string, []error) {
+	results := make([]string, len(lines))
+	errs := make([]error, len(lines))
+	for i, line := range lines {
+		if line != "" {
+			results[i], errs[i] = process(line)
+		}
+	}
+	return results, errs
+}
@@ -0,0 +1,130 @@
+package aspnethash
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestPipelineOrdered finishes the batches in reverse, with lines skipped
+// between them, and checks they are delivered in line order.
+func TestPipelineOrdered(t *testing.T) {
+	pipe := NewPipeline(nil, true, 11)
+	release := make([]chan struct{}, 5)
+	var got []string
+	for i := range release {
+		release[i] = make(chan struct{})
+		// Batches of three lines from 11, each followed by a skipped line.
+		first := int64(11 + 4*i)
+		wait := release[i]
+		pipe.Submit(context.Background(), first, 3, func() func() {
+			<-wait
+			return func() { got = append(got, fmt.Sprint(first)) }
+		})
+		pipe.Skip(first+3, 1)
+	}
+	for i := len(release) - 1; i >= 0; i-- {
+		close(release[i])
+		time.Sleep(5 * time.Millisecond)
+	}
+	pipe.Wait()
+	if want := "[11 15 19 23 27]"; fmt.Sprint(got) != want {
+		t.Errorf("delivered %v, want %s", got, want)
+	}
+	var last int64
+	pipe.Released(func(lastLine int64) error {
+		last = lastLine
+		return nil
+	})
+	if last != 30 {
+		t.Errorf("released up to line %d, want 30", last)
+	}
+}
+
+func TestPipelineSlots(t *testing.T) {
+	pipe := NewPipeline(make(chan struct{}, 2), false, 1)
+	var running, most atomic.Int32
+	for i := int64(0); i < 20; i++ {
+		pipe.Submit(context.Background(), i+1, 1, func() func() {
+			n := running.Add(1)
+			for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			return nil
+		})
+	}
+	pipe.Wait()
+	if most.Load() != 2 {
+		t.Errorf("%d batches ran at once, want 2", most.Load())
+	}
+
+	// A batch waiting for a slot is dropped when ctx is done.
+	block := make(chan struct{})
+	pipe = NewPipeline(make(chan struct{}, 1), false, 1)
+	pipe.Submit(context.Background(), 1, 1, func() func() {
+		<-block
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if pipe.Submit(ctx, 2, 1, func() func() { t.Error("ran a dropped batch"); return nil }) {
+		t.Error("Submit took a batch without a free slot")
+	}
+	close(block)
+	pipe.Wait()
+}
+
+func TestProcessorHashcat(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(testMVC4)
+	short := base64.StdEncoding.EncodeToString(raw[:40])
+	var errs []string
+	p := &Processor{
+		Mode:    ModeHashcat,
+		Options: Options{Iterations: 5000},
+		Ordered: true,
+		OnError: func(lineNo int, line string, err error) {
+			errs = append(errs, fmt.Sprintf("%d %v", lineNo, errors.Is(err, ErrLengthMismatch)))
+		},
+	}
+	var out strings.Builder
+	stats, err := p.Run(context.Background(), strings.NewReader(testMVC4+"\r\n"+testV3SHA256+"\n\n"+short+"\n"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The MVC4 hash gets the iterations of the Options, the v3 hash its own.
+	want := "sha1:5000:" + testSaltBase64 + ":ExAHHz02wEeEolgFEb/++rYx+8uwwexO2S6Q5k3cD9Y=\n" +
+		"sha256:10000:" + testSaltBase64 + ":gvuJCCKT1hJJvlkUFj78nLMObqen1H+/OGNgThPCs98=\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+	// A hash cut short is refused, though Parse would take it.
+	if fmt.Sprint(errs) != "[4 true]" {
+		t.Errorf("errors %v", errs)
+	}
+	if stats.Read != 4 || stats.Processed != 2 || stats.Skipped != 1 || stats.Errored != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestProcessorCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Processor{
+		Mode:    ModeHashcat,
+		Workers: 1,
+		OnError: func(int, string, error) { cancel() },
+	}
+	input := strings.Repeat(testMVC4+"\n", 3*processorBatchSize) + "bad\n" + strings.Repeat(testMVC4+"\n", 50*processorBatchSize)
+	stats, err := p.Run(ctx, strings.NewReader(input), new(strings.Builder))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v", err)
+	}
+	if stats.Read == 0 || stats.Read >= 53*processorBatchSize+1 || stats.Read != stats.Processed+stats.Errored+stats.Skipped {
+		t.Errorf("stats %+v", stats)
+	}
+}
@@ -0,0 +1,175 @@
+package aspnethash
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runDirPrefix starts the name of every RunDir directory, which is how
+// CleanStale tells them from anything else in the base directory.
+const runDirPrefix = "aspnethash-run-"
+
+// runOwnerFile, in a RunDir directory, holds the process ID and host name
+// of the run that made it.
+const runOwnerFile = "owner"
+
+// RunDir keeps track of the temporary files of one run, so they can all be
+// removed when it ends, however it ends. Files made with CreateTemp live in
+// a directory of the run's own under a base directory, made on first use;
+// other paths, like a temporary file that must sit next to its final name
+// to be renamed into place, are added with Track. Cleanup removes them all.
+//
+// A run that is killed before it can clean up leaves its directory behind,
+// with a file naming the process that made it; CleanStale removes such
+// directories once that process is gone. Tracked paths outside the directory
+// can't be swept that way.
+//
+// A RunDir is safe for concurrent use. Cleanup on a nil RunDir does
+// nothing, so it can be deferred before the run decides whether it needs
+// one.
+type RunDir struct {
+	base string
+
+	mu      sync.Mutex
+	dir     string
+	tracked map[string]bool
+}
+
+// NewRunDir returns a RunDir for a run whose temporary directory is made in
+// base, or os.TempDir() if base is empty. Nothing is created until a file
+// is.
+func NewRunDir(base string) *RunDir {
+	if base == "" {
+		base = os.TempDir()
+	}
+	return &RunDir{base: base, tracked: map[string]bool{}}
+}
+
+// Dir returns the directory of the run, making it if needed.
+func (d *RunDir) Dir() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.makeDir()
+}
+
+func (d *RunDir) makeDir() (string, error) {
+	if d.dir != "" {
+		return d.dir, nil
+	}
+	dir, err := os.MkdirTemp(d.base, runDirPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("creating the temporary directory: %w", err)
+	}
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%d %s\n", os.Getpid(), host)
+	if err := os.WriteFile(filepath.Join(dir, runOwnerFile), []byte(owner), 0o644); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("creating the temporary directory: %w", err)
+	}
+	d.dir = dir
+	return dir, nil
+}
+
+// CreateTemp creates a new file in the run's directory, named as by
+// os.CreateTemp from pattern, and opens it for reading and writing. It is
+// removed by Cleanup if the caller hasn't removed it by then.
+func (d *RunDir) CreateTemp(pattern string) (*os.File, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dir, err := d.makeDir()
+	if err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, pattern)
+}
+
+// Track adds path to the files Cleanup removes.
+func (d *RunDir) Track(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tracked[path] = true
+}
+
+// Untrack takes path off the files Cleanup removes, once it has been
+// renamed into place or is meant to outlive the run.
+func (d *RunDir) Untrack(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.tracked, path)
+}
+
+// Cleanup removes the tracked files and the run's directory with all that
+// is in it. Files already gone are not an error. The RunDir can be used
+// again afterwards, with a new directory.
+func (d *RunDir) Cleanup() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var errs []error
+	for path := range d.tracked {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	clear(d.tracked)
+	if d.dir != "" {
+		if err := os.RemoveAll(d.dir); err != nil {
+			errs = append(errs, err)
+		}
+		d.dir = ""
+	}
+	return errors.Join(errs...)
+}
+
+// CleanStale removes the RunDir directories in base (os.TempDir() if
+// empty) that haven't changed for olderThan and whose run is gone: the
+// process that made them no longer runs on this host. Directories made on
+// another host sharing base are left alone, as their process can't be
+// checked. It returns the directories removed; an error for one directory
+// doesn't stop the others from being swept.
+func CleanStale(base string, olderThan time.Duration) (removed []string, err error) {
+	if base == "" {
+		base = os.TempDir()
+	}
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	cutoff := time.Now().Add(-olderThan)
+	var errs []error
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), runDirPrefix) {
+			continue
+		}
+		dir := filepath.Join(base, e.Name())
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if owner, err := os.ReadFile(filepath.Join(dir, runOwnerFile)); err == nil {
+			pid, ownerHost, _ := strings.Cut(strings.TrimSpace(string(owner)), " ")
+			n, err := strconv.Atoi(pid)
+			if err != nil || ownerHost != host || processRunning(n) {
+				continue
+			}
+		}
+		// A directory without an owner file lost its run before it could
+		// write one.
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, dir)
+	}
+	return removed, errors.Join(errs...)
+}
@@ -0,0 +1,221 @@
+package aspnethash
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// entries returns the names in dir.
+func entries(t *testing.T, dir string) []string {
+	t.Helper()
+	list, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range list {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestRunDirCleanup(t *testing.T) {
+	base, outside := t.TempDir(), t.TempDir()
+	d := NewRunDir(base)
+	if names := entries(t, base); len(names) != 0 {
+		t.Fatalf("NewRunDir made %v", names)
+	}
+	for i := 0; i < 3; i++ {
+		f, err := d.CreateTemp("spill-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	dir, err := d.Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(dir) != base || !strings.HasPrefix(filepath.Base(dir), runDirPrefix) {
+		t.Fatalf("run directory %s", dir)
+	}
+	tracked := filepath.Join(outside, "out.txt.tmp")
+	if err := os.WriteFile(tracked, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d.Track(tracked)
+	kept := filepath.Join(outside, "out.txt")
+	if err := os.WriteFile(kept, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d.Track(kept)
+	d.Untrack(kept)
+	// A tracked file the run removed itself.
+	d.Track(filepath.Join(outside, "gone"))
+
+	if err := d.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if names := entries(t, base); len(names) != 0 {
+		t.Errorf("%v left in the base directory", names)
+	}
+	if names := entries(t, outside); len(names) != 1 || names[0] != "out.txt" {
+		t.Errorf("%v left next to the output, want just out.txt", names)
+	}
+
+	// The RunDir can be used again, with a new directory.
+	again, err := d.Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == dir {
+		t.Error("the directory was reused after Cleanup")
+	}
+	if err := d.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if names := entries(t, base); len(names) != 0 {
+		t.Errorf("%v left in the base directory", names)
+	}
+}
+
+func TestRunDirNil(t *testing.T) {
+	var d *RunDir
+	if err := d.Cleanup(); err != nil {
+		t.Error(err)
+	}
+}
+
+// spillAndFail stands for a run that made temporary files and then failed,
+// by returning an error or by panicking, with Cleanup deferred.
+func spillAndFail(d *RunDir, panics bool) (err error) {
+	defer d.Cleanup()
+	for i := 0; i < 3; i++ {
+		f, err := d.CreateTemp("spill-*")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(f, "records %d\n", i)
+		defer f.Close()
+	}
+	if panics {
+		panic("failed")
+	}
+	return errors.New("failed")
+}
+
+func TestRunDirCleanupOnFailure(t *testing.T) {
+	for _, panics := range []bool{false, true} {
+		base := t.TempDir()
+		func() {
+			defer func() { recover() }()
+			if err := spillAndFail(NewRunDir(base), panics); err == nil {
+				t.Error("spillAndFail succeeded")
+			}
+		}()
+		if names := entries(t, base); len(names) != 0 {
+			t.Errorf("panics=%v: %v left in the base directory", panics, names)
+		}
+	}
+}
+
+func TestRunDirCreateFails(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "missing")
+	d := NewRunDir(base)
+	if _, err := d.CreateTemp("spill-*"); err == nil {
+		t.Fatal("CreateTemp succeeded in a missing directory")
+	}
+	if err := d.Cleanup(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunDirCleanupError(t *testing.T) {
+	base, outside := t.TempDir(), t.TempDir()
+	d := NewRunDir(base)
+	if _, err := d.Dir(); err != nil {
+		t.Fatal(err)
+	}
+	// A tracked path that can't be removed: a directory with a file in it.
+	stuck := filepath.Join(outside, "stuck")
+	if err := os.MkdirAll(filepath.Join(stuck, "file"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	d.Track(stuck)
+	if err := d.Cleanup(); err == nil {
+		t.Error("Cleanup didn't report the path it couldn't remove")
+	}
+	// The rest is removed all the same.
+	if names := entries(t, base); len(names) != 0 {
+		t.Errorf("%v left in the base directory", names)
+	}
+}
+
+// deadPID returns the ID of a process that has exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("can't run a process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestCleanStale(t *testing.T) {
+	base := t.TempDir()
+	host, _ := os.Hostname()
+	dead := deadPID(t)
+	if processRunning(dead) {
+		t.Skip("can't tell a process has exited here")
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	tests := []struct {
+		name, owner string
+		recent      bool
+		removed     bool
+	}{
+		{runDirPrefix + "dead", fmt.Sprintf("%d %s\n", dead, host), false, true},
+		{runDirPrefix + "running", fmt.Sprintf("%d %s\n", os.Getpid(), host), false, false},
+		{runDirPrefix + "other-host", fmt.Sprintf("%d %s-other\n", dead, host), false, false},
+		{runDirPrefix + "recent", fmt.Sprintf("%d %s\n", dead, host), true, false},
+		{runDirPrefix + "bad-owner", "not a pid\n", false, false},
+		{runDirPrefix + "no-owner", "", false, true},
+		{"not-a-run-dir", "", false, false},
+	}
+	for _, tt := range tests {
+		dir := filepath.Join(base, tt.name)
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if tt.owner != "" {
+			if err := os.WriteFile(filepath.Join(dir, runOwnerFile), []byte(tt.owner), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !tt.recent {
+			if err := os.Chtimes(dir, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	removed, err := CleanStale(base, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		dir := filepath.Join(base, tt.name)
+		_, statErr := os.Stat(dir)
+		if gone := errors.Is(statErr, os.ErrNotExist); gone != tt.removed {
+			t.Errorf("%s: removed %v, want %v", tt.name, gone, tt.removed)
+		}
+	}
+	if len(removed) != 2 {
+		t.Errorf("CleanStale returned %v, want the 2 directories removed", removed)
+	}
+}
@@ -0,0 +1,34 @@
+package aspnethash
+
+import (
+	"encoding/base64"
+	"sync"
+)
+
+// scratch holds the buffers one hash is built in. A scratch is taken from
+// scratchPool at the start of HashMVC4 or HashWebForms and put back before
+// it returns; results are copied out into fresh strings first, so nothing
+// handed to the caller aliases a pooled buffer and the pool may drop or
+// reuse buffers at any time.
+type scratch struct {
+	salt    []byte // random salt
+	raw     []byte // binary hash before base64 encoding
+	encoded []byte // base64 encoding of raw or salt
+}
+
+var scratchPool = sync.Pool{New: func() any { return new(scratch) }}
+
+// sized returns b resized to n bytes, reusing its storage if large enough.
+func sized(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
+
+// encode returns the base64 encoding of src, built in s.encoded.
+func (s *scratch) encode(src []byte) string {
+	s.encoded = sized(s.encoded, base64.StdEncoding.EncodedLen(len(src)))
+	base64.StdEncoding.Encode(s.encoded, src)
+	return string(s.encoded)
+}
@@ -0,0 +1,59 @@
+package aspnethash
+
+import "testing"
+
+// benchmarkPooled runs hash with the scratch pool as it is, and with the
+// pool drained before every hash, so each one starts from a fresh scratch
+// as it did before the pool.
+func benchmarkPooled(b *testing.B, hash func() error) {
+	for _, pooled := range []bool{true, false} {
+		name := "pooled"
+		if !pooled {
+			name = "unpooled"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !pooled {
+					scratchPool.Get()
+				}
+				if err := hash(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkHashMVC4 uses a single iteration, so the allocations aren't
+// lost in the time PBKDF2 takes; their number doesn't depend on it.
+func BenchmarkHashMVC4(b *testing.B) {
+	benchmarkPooled(b, func() error {
+		_, err := HashMVC4([]byte(testPlain), Options{Iterations: 1})
+		return err
+	})
+}
+
+func BenchmarkHashWebForms(b *testing.B) {
+	benchmarkPooled(b, func() error {
+		_, _, err := HashWebForms([]byte(testPlain), Options{})
+		return err
+	})
+}
+
+func TestScratchPoolBitIdentical(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		// A scratch used for a longer salt and subkey first must not
+		// leave anything behind in the next hash.
+		if _, err := HashMVC4([]byte("other"), Options{SaltSize: 64, SubkeyLength: 64, Iterations: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := HashMVC4([]byte(testPlain), Options{Salt: testSalt}); err != nil || got != testMVC4 {
+			t.Errorf("HashMVC4 = %s, %v, want %s", got, err, testMVC4)
+		}
+		hash, salt, err := HashWebForms([]byte(testPlain), Options{Salt: testSalt})
+		if err != nil || hash != testWebForms || salt != testSaltBase64 {
+			t.Errorf("HashWebForms = %s, %s, %v, want %s, %s", hash, salt, err, testWebForms, testSaltBase64)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package aspnethash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+)
+
+// Umbraco before 7.6 with useLegacyEncoding stores unsalted hashes: the
+// UTF-16LE password run through HMAC-SHA256 keyed with itself, base64
+// encoded. hashcat has no mode for it. Its mode 24800, Umbraco HMAC-SHA1,
+// is the same construction with HMAC-SHA1, whose 28 character hashes this
+// doesn't read.
+
+// umbracoLegacyLength is the length of a base64 encoded legacy hash.
+const umbracoLegacyLength = 44
+
+// HashUmbracoLegacy returns the legacy Umbraco hash of plain.
+func HashUmbracoLegacy(plain []byte) string {
+	return base64.StdEncoding.EncodeToString(umbracoLegacyDigest(plain))
+}
+
+func umbracoLegacyDigest(plain []byte) []byte {
+	return selfKeyedMAC(sha256.New, plain)
+}
+
+// selfKeyedMAC returns the HMAC of the UTF-16LE plain keyed with itself.
+func selfKeyedMAC(newHash func() hash.Hash, plain []byte) []byte {
+	key := utf16LE(plain)
+	mac := hmac.New(newHash, key)
+	mac.Write(key)
+	return mac.Sum(nil)
+}
+
+// VerifyUmbracoLegacy reports whether plain matches the legacy Umbraco hash
+// encoded.
+func VerifyUmbracoLegacy(plain, encoded string) (bool, error) {
+	digest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false, &kindError{ErrInvalidBase64, fmt.Errorf("error decoding Base64: %w", err)}
+	}
+	if len(digest) != sha256.Size {
+		return false, fmt.Errorf("%w: length %d, expected %d", ErrLengthMismatch, len(digest), sha256.Size)
+	}
+	return subtle.ConstantTimeCompare(umbracoLegacyDigest([]byte(plain)), digest) == 1, nil
+}
+
+// IsUmbracoLegacy reports whether encoded has the shape of a legacy Umbraco
+// hash: 44 base64 characters decoding to 32 bytes. Any unsalted base64
+// SHA-256 digest looks the same.
+func IsUmbracoLegacy(encoded string) bool {
+	if len(encoded) != umbracoLegacyLength {
+		return false
+	}
+	digest, err := base64.StdEncoding.DecodeString(encoded)
+	return err == nil && len(digest) == sha256.Size
+}
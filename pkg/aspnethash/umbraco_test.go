@@ -0,0 +1,71 @@
+package aspnethash
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// The Umbraco known answers are HMAC-SHA256 keyed with the UTF-16LE
+// password, over the same bytes, computed with Python's hmac. No legacy
+// install's hashes were at hand to take them from; TestSelfKeyedMAC checks
+// the construction against one computed apart from this code.
+func TestUmbracoLegacyKnownAnswers(t *testing.T) {
+	tests := []struct {
+		plain, hash string
+	}{
+		{testPlain, "8Rp/8PhaVg3OUTZFYl89QGS2aGR2tz3huekQLzBGAvg="},
+		{"password", "vVpEqQxqRuU2tq0Ha4tYtFGFzZ05ZcHc1ZA1Yn5LRCM="},
+		{"", "thNnmggU2ex3L5XXeMNfxf8Wl8STcVZTxscSFEKSxa0="},
+		{"密码😀", "Tf8FpBxETBsNyuieMmQEvbI9dF8M+/TeN3iSNCfk0Lc="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.plain, func(t *testing.T) {
+			if got := HashUmbracoLegacy([]byte(tt.plain)); got != tt.hash {
+				t.Errorf("HashUmbracoLegacy = %s, want %s", got, tt.hash)
+			}
+			if !IsUmbracoLegacy(tt.hash) {
+				t.Error("IsUmbracoLegacy = false")
+			}
+			if ok, err := VerifyUmbracoLegacy(tt.plain, tt.hash); err != nil || !ok {
+				t.Errorf("the plaintext doesn't verify (%v)", err)
+			}
+			if ok, err := VerifyUmbracoLegacy(tt.plain+" ", tt.hash); err != nil || ok {
+				t.Errorf("another plaintext verifies (%v)", err)
+			}
+		})
+	}
+}
+
+// TestSelfKeyedMAC checks the construction against hashcat's example hash
+// for mode 24800, Umbraco HMAC-SHA1, whose password is "hashcat".
+func TestSelfKeyedMAC(t *testing.T) {
+	if got := base64.StdEncoding.EncodeToString(selfKeyedMAC(sha1.New, []byte("hashcat"))); got != "8uigXlGMNI7BzwLCJlDbcKR2FP4=" {
+		t.Errorf("got %s, want 8uigXlGMNI7BzwLCJlDbcKR2FP4=", got)
+	}
+}
+
+func TestUmbracoLegacyShape(t *testing.T) {
+	tests := []struct {
+		encoded string
+		legacy  bool
+		err     error
+	}{
+		// A DNN SHA1 hash is too short, an MVC4 hash too long.
+		{"0DDRUuUD3dxCsVujIBvkHL6piMw=", false, ErrLengthMismatch},
+		{testMVC4, false, ErrLengthMismatch},
+		// 44 characters, but not base64.
+		{"8Rp/8PhaVg3OUTZFYl89QGS2aGR2tz3huekQLzBGAv!=", false, ErrInvalidBase64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoded, func(t *testing.T) {
+			if got := IsUmbracoLegacy(tt.encoded); got != tt.legacy {
+				t.Errorf("IsUmbracoLegacy = %v, want %v", got, tt.legacy)
+			}
+			if _, err := VerifyUmbracoLegacy(testPlain, tt.encoded); !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// errPolicy is returned for plaintexts that break the --policy, with
+// --policy-enforce.
+var errPolicy = errors.New("password policy violation")
+
+// Rules of a --policy, as bits of a mask.
+const (
+	policyMinLength = 1 << iota
+	policyMaxLength
+	policyMinNonAlnum
+)
+
+// policyRules names the rules in --policy and the stats, in the order of
+// their bits.
+var policyRules = []string{"minlen", "maxlen", "minnonalnum"}
+
+// passwordPolicy is the part of a membership provider's password rules
+// --policy checks. Zero limits aren't checked.
+type passwordPolicy struct {
+	minLength, maxLength, minNonAlnum int
+}
+
+// parsePasswordPolicy parses a comma-separated list of rule=value pairs,
+// such as "minlen=8,minnonalnum=1,maxlen=128".
+func parsePasswordPolicy(s string) (passwordPolicy, error) {
+	var p passwordPolicy
+	for _, rule := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok {
+			return p, fmt.Errorf("%q isn't a rule=value pair", rule)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("%s takes a number of characters, not %q", name, value)
+		}
+		switch strings.ToLower(name) {
+		case "minlen":
+			p.minLength = n
+		case "maxlen":
+			p.maxLength = n
+		case "minnonalnum":
+			p.minNonAlnum = n
+		default:
+			return p, fmt.Errorf("unknown rule %q (want %s)", name, strings.Join(policyRules, ", "))
+		}
+	}
+	if p.maxLength > 0 && p.minLength > p.maxLength {
+		return p, fmt.Errorf("minlen %d is more than maxlen %d", p.minLength, p.maxLength)
+	}
+	return p, nil
+}
+
+// check returns the rules plain breaks. Characters are counted as .NET
+// counts them: in UTF-16 code units, so characters outside the BMP count
+// twice, and as neither letters nor digits since char.IsLetterOrDigit sees
+// their surrogates.
+func (p passwordPolicy) check(plain string) (broken int) {
+	var length, nonAlnum int
+	for _, r := range plain {
+		// Invalid UTF-8 decodes to one U+FFFD, as with .NET's decoder.
+		n := 1
+		if r > 0xFFFF {
+			n = 2
+		}
+		length += n
+		if n > 1 || !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			nonAlnum += n
+		}
+	}
+	if length < p.minLength {
+		broken |= policyMinLength
+	}
+	if p.maxLength > 0 && length > p.maxLength {
+		broken |= policyMaxLength
+	}
+	if nonAlnum < p.minNonAlnum {
+		broken |= policyMinNonAlnum
+	}
+	return broken
+}
+
+// brokenRules names the rules of a check result.
+func brokenRules(broken int) []string {
+	var names []string
+	for i, name := range policyRules {
+		if broken&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// policyReport is the --policy part of the stats.
+type policyReport struct {
+	// Violations counts the plaintexts breaking any rule.
+	Violations int64        `json:"violations"`
+	Enforced   bool         `json:"enforced"`
+	Rules      []countEntry `json:"rules"`
+}
+
+// policyViolations counts the plaintexts breaking each rule and remembers
+// the first line of each. It is safe for concurrent use.
+type policyViolations struct {
+	lines   atomic.Int64
+	counts  [3]atomic.Int64
+	example [3]atomic.Int64
+}
+
+func (v *policyViolations) add(broken int, lineNo int64) {
+	v.lines.Add(1)
+	for i := range policyRules {
+		if broken&(1<<i) == 0 {
+			continue
+		}
+		v.counts[i].Add(1)
+		for {
+			first := v.example[i].Load()
+			if first != 0 && first <= lineNo || v.example[i].CompareAndSwap(first, lineNo) {
+				break
+			}
+		}
+	}
+}
+
+// entries returns the rules broken, in the order of policyRules.
+func (v *policyViolations) entries() []countEntry {
+	var entries []countEntry
+	for i, name := range policyRules {
+		if n := v.counts[i].Load(); n > 0 {
+			entries = append(entries, countEntry{Name: name, Count: n, Example: v.example[i].Load()})
+		}
+	}
+	return entries
+}
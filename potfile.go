@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// uncrackedMarker stands in for the plaintext of accounts show --uncracked
+// prints although they weren't cracked.
+const uncrackedMarker = "[uncracked]"
+
+// hashKey identifies a hash by its salt and subkey, which is what a dump
+// line, a converted line and a potfile entry for it have in common.
+func hashKey(h aspnethash.Hash) string {
+	return strconv.Itoa(len(h.Salt)) + ":" + string(h.Salt) + string(h.Subkey)
+}
+
+// parsePotLine splits a potfile entry, <prf>:<iter>:<salt>:<subkey>:<plain>
+// or <base64 hash>:<plain>, into the key of its hash and the plaintext.
+func parsePotLine(line string) (key, plain string, err error) {
+	var encoded string
+	if prf, _, _ := strings.Cut(line, ":"); prf == "sha1" || prf == "sha256" || prf == "sha512" {
+		parts := strings.SplitN(line, ":", 5)
+		if len(parts) < 5 {
+			return "", "", fmt.Errorf("invalid potfile line: %w", errMissingDelimiter)
+		}
+		encoded, plain = strings.Join(parts[:4], ":"), parts[4]
+	} else {
+		var ok bool
+		if encoded, plain, ok = strings.Cut(line, ":"); !ok {
+			return "", "", fmt.Errorf("invalid potfile line: %w", errMissingDelimiter)
+		}
+	}
+	hash, err := aspnethash.Parse(encoded)
+	if err != nil {
+		return "", "", err
+	}
+	return hashKey(hash), decodeHexPlain(plain), nil
+}
+
+// potfile holds the plaintexts of a hashcat potfile by hash key.
+type potfile struct {
+	plains map[string]string
+	// malformed counts the lines that couldn't be parsed and were skipped.
+	malformed int
+}
+
+// loadPotfile reads the potfile at path.
+func loadPotfile(path string) (*potfile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pot := &potfile{plains: map[string]string{}}
+	lines := newLineReader(f, '\n', defaultMaxLineBytes)
+	for lines.next() {
+		if lines.lineTooLong() {
+			pot.malformed++
+			continue
+		}
+		line := lines.text()
+		if line == "" {
+			continue
+		}
+		key, plain, err := parsePotLine(line)
+		if err != nil {
+			pot.malformed++
+			continue
+		}
+		pot.plains[key] = plain
+	}
+	return pot, lines.readErr()
+}
+
+// lookup returns the plaintext of encoded, if it was cracked.
+func (p *potfile) lookup(encoded string) (plain string, cracked bool, err error) {
+	hash, err := aspnethash.Parse(encoded)
+	if err != nil {
+		return "", false, err
+	}
+	plain, cracked = p.plains[hashKey(hash)]
+	return plain, cracked, nil
+}
+
+// showLine looks up the hash of a dump line, or of a --map-file line when
+// fromMap is set, and returns <username><outputDelimiter><plaintext>. Map
+// lines are <hash>:<username>, or <hashcat line>\t<username> as written with
+// --strip-usernames. Lines
+// without a username are shown as <hash><outputDelimiter><plaintext>.
+// Uncracked accounts give an empty result unless uncracked is set. Usernames
+// and plaintexts are escaped with esc.
+func showLine(line string, fromMap, usernamePresent bool, delimiter, outputDelimiter string, trim, uncracked bool, pot *potfile, esc hexEscaper) (result string, cracked bool, err error) {
+	var username, encoded string
+	if fromMap {
+		// Hashcat lines have colons of their own, so a tab comes first.
+		var ok bool
+		if encoded, username, ok = strings.Cut(line, "\t"); !ok {
+			if encoded, username, ok = strings.Cut(line, ":"); !ok {
+				return "", false, fmt.Errorf("invalid map line: %w", errMissingDelimiter)
+			}
+		}
+		// Usernames in the map are escaped already.
+		username = esc.decode(username)
+	} else if username, encoded, err = splitUsername(line, usernamePresent, delimiter, trim); err != nil {
+		return "", false, err
+	} else if !usernamePresent {
+		username = encoded
+	}
+	username = esc.escape(username)
+
+	plain, cracked, err := pot.lookup(encoded)
+	if err != nil {
+		return "", false, err
+	}
+	switch {
+	case cracked:
+		return username + outputDelimiter + esc.escape(plain), true, nil
+	case uncracked:
+		return username + outputDelimiter + uncrackedMarker, false, nil
+	}
+	return "", false, nil
+}
+
+// removeLine returns line unless its hash, after the username if
+// usernamePresent is set, was cracked, for the left list of the remove
+// command. cracked tells whether the line was removed.
+func removeLine(line string, usernamePresent bool, delimiter string, trim bool, pot *potfile) (result string, cracked bool, err error) {
+	_, encoded, err := splitUsername(line, usernamePresent, delimiter, trim)
+	if err != nil {
+		return "", false, err
+	}
+	if _, cracked, err = pot.lookup(encoded); err != nil || cracked {
+		return "", cracked, err
+	}
+	return line, false, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// profileVersion is bumped whenever the profile layout changes in a way older
+// versions of the tool can't read.
+const profileVersion = 1
+
+// secretAnnotation marks flags whose values must never be written to a profile
+// inline. Only references to where to read them (@path, fd:N or env:NAME) are
+// saved for them.
+const secretAnnotation = "secret"
+
+// redactedFlagValue is flagValue with the values of secret flags hidden,
+// unless they only name where to read them.
+func redactedFlagValue(flag *pflag.Flag) string {
+	value := flagValue(flag)
+	if _, secret := flag.Annotations[secretAnnotation]; secret && !isSecretReference(value) {
+		return "<redacted>"
+	}
+	return value
+}
+
+// profileExcluded lists flags that control profiles, config files, help
+// output, benchmarks or single-value runs and so don't belong in a saved
+// profile or a config file.
+var profileExcluded = map[string]bool{
+	"help":                true,
+	"advanced-help":       true,
+	"profile":             true,
+	"save-profile":        true,
+	"list-profiles":       true,
+	"profile-description": true,
+	"profiles-dir":        true,
+	"config":              true,
+	"print-config":        true,
+	"version":             true,
+	"bench":               true,
+	// One-off values, and a password has no place in a file anyway.
+	"password": true,
+	"prompt":   true,
+	"hash":     true,
+}
+
+// profile is the on-disk format of a saved run configuration.
+type profile struct {
+	Version     int               `json:"version"`
+	Description string            `json:"description,omitempty"`
+	Flags       map[string]string `json:"flags"`
+}
+
+// defaultProfilesDir returns the directory profiles are kept in when
+// --profiles-dir isn't given.
+func defaultProfilesDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "profiles"
+	}
+	return filepath.Join(dir, "aspnethashtool", "profiles")
+}
+
+func profilePath(dir, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+func flagValue(flag *pflag.Flag) string {
+	if sv, ok := flag.Value.(pflag.SliceValue); ok {
+		return strings.Join(sv.GetSlice(), ",")
+	}
+	return flag.Value.String()
+}
+
+func setFlagValue(flag *pflag.Flag, value string) error {
+	if sv, ok := flag.Value.(pflag.SliceValue); ok {
+		var values []string
+		if value != "" {
+			values = strings.Split(value, ",")
+		}
+		if err := sv.Replace(values); err != nil {
+			return err
+		}
+		flag.Changed = true
+		return nil
+	}
+	return pflag.Set(flag.Name, value)
+}
+
+// saveProfile writes every flag that differs from its default to the named
+// profile.
+func saveProfile(dir, name, description string) (string, error) {
+	path, err := profilePath(dir, name)
+	if err != nil {
+		return "", err
+	}
+
+	p := profile{Version: profileVersion, Description: description, Flags: map[string]string{}}
+	var saveErr error
+	pflag.VisitAll(func(flag *pflag.Flag) {
+		if !flag.Changed || profileExcluded[flag.Name] {
+			return
+		}
+		value := flagValue(flag)
+		if _, secret := flag.Annotations[secretAnnotation]; secret && !isSecretReference(value) {
+			saveErr = fmt.Errorf("--%s holds a secret; pass it as @keyfile, fd:N or env:NAME to save it in a profile", flag.Name)
+			return
+		}
+		p.Flags[flag.Name] = value
+	})
+	if saveErr != nil {
+		return "", saveErr
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// loadProfile applies the named profile to every flag that wasn't given
+// explicitly on the command line. Unknown keys are skipped. It returns the
+// names of the flags it set, and warnings to log once the profile's own
+// logging flags are in effect.
+func loadProfile(dir, name string) (set map[string]bool, warnings []string, err error) {
+	path, err := profilePath(dir, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var p profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, nil, fmt.Errorf("parsing profile %s: %w", path, err)
+	}
+	if p.Version > profileVersion {
+		warnings = append(warnings, fmt.Sprintf("profile %q was written by a newer version (format %d)", name, p.Version))
+	}
+
+	names := make([]string, 0, len(p.Flags))
+	for key := range p.Flags {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	set = map[string]bool{}
+	for _, key := range names {
+		flag := pflag.Lookup(key)
+		if flag == nil || profileExcluded[key] {
+			warnings = append(warnings, fmt.Sprintf("ignoring unknown option %q in profile %q", key, name))
+			continue
+		}
+		if flag.Changed {
+			continue
+		}
+		if err := setFlagValue(flag, p.Flags[key]); err != nil {
+			return nil, nil, fmt.Errorf("profile %q: invalid value for --%s: %w", name, key, err)
+		}
+		set[key] = true
+	}
+	return set, warnings, nil
+}
+
+// listProfiles prints the saved profiles and their descriptions.
+func listProfiles(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		var p profile
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &p)
+		}
+		if err != nil {
+			fmt.Printf("%-20s (unreadable: %v)\n", name, err)
+			continue
+		}
+		fmt.Printf("%-20s %s\n", name, p.Description)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testValidationKey is a 32-byte HMACSHA256 validationKey.
+const testValidationKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// readProfile reads the named profile from dir.
+func readProfile(t *testing.T, dir, name string) profile {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// writeProfile writes p as the named profile in dir.
+func writeProfile(t *testing.T, dir, name string, p profile) {
+	t.Helper()
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".json"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestProfileRoundTrip saves a profile, loads it to save another, and
+// checks both files are the same.
+func TestProfileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	mustRunTool(t, "", "convert", "-q", "--profiles-dir", dir, "--save-profile", "first", "--profile-description", "dumps from CORP",
+		"-u", "--delimiter", ";", "--unique", "--normalize-username", "lower,strip-domain", "--format", "phc")
+	mustRunTool(t, "", "convert", "-q", "--profiles-dir", dir, "--profile", "first", "--save-profile", "second", "--profile-description", "dumps from CORP")
+	first, err := os.ReadFile(filepath.Join(dir, "first.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.ReadFile(filepath.Join(dir, "second.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != string(second) {
+		t.Errorf("saved again as\n%s\nfrom\n%s", second, first)
+	}
+	p := readProfile(t, dir, "first")
+	want := map[string]string{"quiet": "true", "username": "true", "delimiter": ";", "unique": "true", "normalize-username": "lower,strip-domain", "format": "phc"}
+	if p.Version != profileVersion || p.Description != "dumps from CORP" || len(p.Flags) != len(want) {
+		t.Fatalf("saved %+v", p)
+	}
+	for name, value := range want {
+		if p.Flags[name] != value {
+			t.Errorf("--%s saved as %q, want %q", name, p.Flags[name], value)
+		}
+	}
+}
+
+// TestProfileWarnings loads profiles with unknown keys and a newer format,
+// which are warned about and otherwise skipped.
+func TestProfileWarnings(t *testing.T) {
+	dir := t.TempDir()
+	input := "CORP\\JSmith;AAABAgMEBQYHCAkKCwwNDg8TEAcfPTbAR4SiWAURv/76tjH7y7DB7E7ZLpDmTdwP1g==\n"
+	want := "jsmith:sha1:1000:AAECAwQFBgcICQoLDA0ODw==:ExAHHz02wEeEolgFEb/++rYx+8uwwexO2S6Q5k3cD9Y=\n"
+	flags := map[string]string{"username": "true", "delimiter": ";", "normalize-username": "lower,strip-domain"}
+	withFlags := func(extra map[string]string) map[string]string {
+		all := map[string]string{}
+		for name, value := range flags {
+			all[name] = value
+		}
+		for name, value := range extra {
+			all[name] = value
+		}
+		return all
+	}
+	tests := []struct {
+		name     string
+		profile  profile
+		warnings []string
+	}{
+		{"current", profile{Version: profileVersion, Flags: flags}, nil},
+		{"unknown", profile{Version: profileVersion, Flags: withFlags(map[string]string{"no-such-flag": "1", "save-profile": "other"})},
+			[]string{`ignoring unknown option "no-such-flag" in profile "unknown"`, `ignoring unknown option "save-profile" in profile "unknown"`}},
+		{"newer", profile{Version: profileVersion + 1, Flags: flags},
+			[]string{fmt.Sprintf(`profile "newer" was written by a newer version (format %d)`, profileVersion+1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeProfile(t, dir, tt.name, tt.profile)
+			run := runTool(t, input, "convert", "--profiles-dir", dir, "--profile", tt.name)
+			if run.exitCode != 0 || run.stdout != want {
+				t.Fatalf("exited with %d and wrote %q: %s", run.exitCode, run.stdout, run.stderr)
+			}
+			if got := strings.Count(run.stderr, "Warning:"); got != len(tt.warnings) {
+				t.Errorf("%d warnings, want %d: %s", got, len(tt.warnings), run.stderr)
+			}
+			for _, w := range tt.warnings {
+				if !strings.Contains(run.stderr, "Warning: "+w) {
+					t.Errorf("no warning %q: %s", w, run.stderr)
+				}
+			}
+		})
+	}
+
+	// The warnings are logged as the profile's --log-format says.
+	writeProfile(t, dir, "json", profile{Version: profileVersion + 1, Flags: withFlags(map[string]string{"log-format": "json"})})
+	run := runTool(t, input, "convert", "--profiles-dir", dir, "--profile", "json")
+	first, _, _ := strings.Cut(run.stderr, "\n")
+	var entry struct {
+		Level, Msg string
+	}
+	if err := json.Unmarshal([]byte(first), &entry); err != nil || entry.Level != "warning" || !strings.Contains(entry.Msg, "newer version") {
+		t.Errorf("first log line %q (%v)", first, err)
+	}
+}
+
+// TestProfileSecrets checks a secret flag is only saved as a reference to
+// where to read it, and that the reference works when loaded.
+func TestProfileSecrets(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "validation.key")
+	if err := os.WriteFile(keyPath, []byte(testValidationKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	args := func(extra ...string) []string {
+		return append([]string{"generate", "-q", "--mode", "webforms", "--hash-algorithm", "hmacsha256", "--salt-seed", "profile", "--profiles-dir", dir}, extra...)
+	}
+	want := mustRunTool(t, "password\n", args("--validation-key", testValidationKey)...)
+
+	run := runTool(t, "", args("--validation-key", testValidationKey, "--save-profile", "inline")...)
+	if run.exitCode == 0 || !strings.Contains(run.stderr, "--validation-key holds a secret") {
+		t.Errorf("an inline key exited with %d: %s", run.exitCode, run.stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "inline.json")); !os.IsNotExist(err) {
+		t.Errorf("the profile was saved anyway (%v)", err)
+	}
+
+	for _, ref := range []string{"@" + keyPath, "env:TEST_VALIDATION_KEY"} {
+		cmd := toolCommand(t, args("--validation-key", ref, "--save-profile", "ref")...)
+		cmd.Env = append(cmd.Env, "TEST_VALIDATION_KEY="+testValidationKey)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("saving %s: %v: %s", ref, err, out)
+		}
+		if got := readProfile(t, dir, "ref").Flags["validation-key"]; got != ref {
+			t.Errorf("--validation-key %s saved as %q", ref, got)
+		}
+		cmd = toolCommand(t, "generate", "-q", "--profiles-dir", dir, "--profile", "ref")
+		cmd.Env = append(cmd.Env, "TEST_VALIDATION_KEY="+testValidationKey)
+		cmd.Stdin = strings.NewReader("password\n")
+		if out, err := cmd.Output(); err != nil || string(out) != want {
+			t.Errorf("with the profile saving %s got %q (%v), want %q", ref, out, err, want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	_ "net/http/pprof" // registers the /debug/pprof handlers for --pprof-http
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startCPUProfile starts writing a CPU profile to path. The returned
+// function stops the profile and closes the file.
+func startCPUProfile(path string) (stop func() error, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() error {
+		pprof.StopCPUProfile()
+		return f.Close()
+	}, nil
+}
+
+// writeHeapProfile writes a heap profile to path, after a garbage collection
+// so it reflects live memory only.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// servePprof serves net/http/pprof on addr in the background for live
+// inspection of long runs.
+func servePprof(addr string) {
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("Error serving --pprof-http: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// countReadSize is the size of the reads the --count-first pass counts
+// record terminators in.
+const countReadSize = 1 << 20
+
+// countInputLines counts the records of all sources for --count-first. If
+// one of them can't be read twice (stdin or a pipe) or its records can't be
+// counted without decoding it (compressed or UTF-16), it returns the reason
+// instead of a count.
+func countInputLines(sources []inputSource, compression, charset string, recordEnd byte) (total int64, skipReason string, err error) {
+	for _, src := range sources {
+		n, reason, err := countSourceLines(src, compression, charset, recordEnd)
+		if err != nil || reason != "" {
+			return 0, reason, err
+		}
+		total += n
+	}
+	return total, "", nil
+}
+
+func countSourceLines(src inputSource, compression, charset string, recordEnd byte) (n int64, skipReason string, err error) {
+	if src.random != nil {
+		return src.random.count, "", nil
+	}
+	if src.path == "" {
+		return 0, "stdin can't be read twice", nil
+	}
+	if charset == "utf16le" || charset == "utf16be" {
+		return 0, "UTF-16 input", nil
+	}
+	f, err := os.Open(longPath(src.path))
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return 0, "", err
+	} else if !info.Mode().IsRegular() {
+		return 0, src.name() + " is not a regular file", nil
+	}
+	compressed, utf16, err := sniffInput(f, compression, charset)
+	switch {
+	case err != nil:
+		return 0, "", err
+	case compressed:
+		return 0, src.name() + " is compressed", nil
+	case utf16:
+		return 0, src.name() + " is UTF-16", nil
+	}
+
+	var r io.Reader = f
+	if src.section != nil {
+		r = io.NewSectionReader(f, src.section.start, src.section.end-src.section.start)
+	}
+	buf := make([]byte, countReadSize)
+	var last byte = recordEnd
+	for {
+		read, err := r.Read(buf)
+		if read > 0 {
+			n += int64(bytes.Count(buf[:read], []byte{recordEnd}))
+			last = buf[read-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, "", err
+		}
+	}
+	if last != recordEnd {
+		// The last record has no terminator.
+		n++
+	}
+	return n, "", nil
+}
+
+// sniffInput tells from the first bytes of f whether it is compressed or,
+// with --input-charset auto, UTF-16, and rewinds f.
+func sniffInput(f *os.File, compression, charset string) (compressed, utf16 bool, err error) {
+	if compression == "gzip" || compression == "zstd" {
+		return true, false, nil
+	}
+	magic := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, false, err
+	}
+	magic = magic[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, false, err
+	}
+	compressed = compression == "auto" && (bytes.HasPrefix(magic, gzipMagic) || bytes.HasPrefix(magic, zstdMagic))
+	utf16 = charset == "auto" && (bytes.HasPrefix(magic, []byte{0xff, 0xfe}) || bytes.HasPrefix(magic, []byte{0xfe, 0xff}))
+	return compressed, utf16, nil
+}
+
+// inputBytes is the total size of the sources, if they are all regular
+// files, for progress by byte offset.
+func inputBytes(sources []inputSource) (total int64, ok bool) {
+	for _, src := range sources {
+		if src.path == "" {
+			return 0, false
+		}
+		info, err := os.Stat(longPath(src.path))
+		if err != nil || !info.Mode().IsRegular() {
+			return 0, false
+		}
+		if src.section != nil {
+			total += src.section.end - src.section.start
+			continue
+		}
+		total += info.Size()
+	}
+	return total, true
+}
+
+// countingReader counts the bytes read through it in n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// progress tells how far the run is, by lines if their total is known and
+// by the bytes read from the input files otherwise.
+type progress struct {
+	started time.Time
+	// done is the number of lines finished so far.
+	done       func() int64
+	totalLines int64
+	totalBytes int64
+	bytesRead  *int64
+	// throttled counts the bytes read through --rate-limit-bytes, if set,
+	// for the input rate to be shown next to it.
+	throttled *atomic.Int64
+}
+
+// byteRate is the rate the throttled bytes were read at, in bytes per
+// second.
+func (p *progress) byteRate() float64 {
+	elapsed := time.Since(p.started).Seconds()
+	if p.throttled == nil || elapsed <= 0 {
+		return 0
+	}
+	return float64(p.throttled.Load()) / elapsed
+}
+
+// fraction is the share of the input done: of the lines if their total is
+// known, of the input bytes read otherwise, or 0 if neither is.
+func (p *progress) fraction(done int64) float64 {
+	switch {
+	case p.totalLines > 0:
+		return float64(done) / float64(p.totalLines)
+	case p.totalBytes > 0:
+		return float64(atomic.LoadInt64(p.bytesRead)) / float64(p.totalBytes)
+	}
+	return 0
+}
+
+// String formats the progress as "1,234 / 9,876 (12.5%) ETA 00:41:12", or
+// with the share of the input bytes read if the total lines aren't known.
+func (p *progress) String() string {
+	done := p.done()
+	fraction := p.fraction(done)
+	var s string
+	switch {
+	case p.totalLines > 0:
+		s = fmt.Sprintf("%s / %s (%.1f%%)", groupThousands(done), groupThousands(p.totalLines), 100*fraction)
+	case p.totalBytes > 0:
+		s = fmt.Sprintf("%s lines (%.1f%% of the input bytes)", groupThousands(done), 100*fraction)
+	default:
+		s = groupThousands(done) + " lines"
+	}
+	if fraction > 0 && fraction < 1 {
+		elapsed := time.Since(p.started)
+		s += " ETA " + formatETA(time.Duration(float64(elapsed)*(1-fraction)/fraction))
+	}
+	if p.throttled != nil {
+		s += fmt.Sprintf(", input %.2f MB/s", p.byteRate()/(1<<20))
+	}
+	return s
+}
+
+// fields are the progress as numbers, for the JSON log: the lines
+// finished, the lines per second, and the total lines if known.
+func (p *progress) fields() []logField {
+	done := p.done()
+	fields := []logField{{"processed", done}}
+	if elapsed := time.Since(p.started).Seconds(); elapsed > 0 {
+		fields = append(fields, logField{"rate", float64(done) / elapsed})
+	}
+	if p.totalLines > 0 {
+		fields = append(fields, logField{"total_lines", p.totalLines})
+	}
+	if p.throttled != nil {
+		fields = append(fields, logField{"input_bytes_per_second", p.byteRate()})
+	}
+	return fields
+}
+
+// run logs the progress every interval until ctx is done.
+func (p *progress) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			logWith(levelDefault, p.fields(), "Progress: %s", p)
+		}
+	}
+}
+
+// groupThousands formats n with commas between groups of three digits.
+func groupThousands(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	start := 0
+	if n < 0 {
+		start = 1
+	}
+	for i := len(s) - 3; i > start; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// formatETA formats d as HH:MM:SS.
+func formatETA(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
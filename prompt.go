@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// promptPassword reads a password from the terminal on stdin with echo
+// disabled. It asks twice and fails if the entries differ.
+func promptPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("--prompt needs a terminal on stdin")
+	}
+	read := func(prompt string) ([]byte, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		defer fmt.Fprintln(os.Stderr)
+		return term.ReadPassword(fd)
+	}
+	first, err := read("Password: ")
+	if err != nil {
+		return "", err
+	}
+	second, err := read("Repeat password: ")
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(first, second) {
+		return "", errors.New("passwords don't match")
+	}
+	return string(first), nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultRandomLength and defaultRandomCharset shape the --random
+// plaintexts unless --random-length, --random-charset or --random-mask say
+// otherwise.
+const (
+	defaultRandomLength  = 16
+	defaultRandomCharset = "?l?u?d"
+)
+
+// maskCharsets are the placeholders of --random-mask and --random-charset,
+// as in hashcat.
+var maskCharsets = map[byte]string{
+	'l': "abcdefghijklmnopqrstuvwxyz",
+	'u': "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	'd': "0123456789",
+	's': " !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~",
+}
+
+func init() {
+	maskCharsets['a'] = maskCharsets['l'] + maskCharsets['u'] + maskCharsets['d'] + maskCharsets['s']
+}
+
+// parseMask splits a mask into the characters each position is drawn from:
+// ?l, ?u, ?d, ?s and ?a stand for their charset, ?? for a question mark,
+// and any other character for itself.
+func parseMask(mask string) ([][]rune, error) {
+	var positions [][]rune
+	for i := 0; i < len(mask); i++ {
+		if mask[i] != '?' {
+			r, size := utf8.DecodeRuneInString(mask[i:])
+			positions = append(positions, []rune{r})
+			i += size - 1
+			continue
+		}
+		if i+1 == len(mask) {
+			return nil, fmt.Errorf("%q ends in a lone ?", mask)
+		}
+		i++
+		switch charset, ok := maskCharsets[mask[i]]; {
+		case ok:
+			positions = append(positions, []rune(charset))
+		case mask[i] == '?':
+			positions = append(positions, []rune{'?'})
+		default:
+			return nil, fmt.Errorf("unknown placeholder ?%c (want ?l, ?u, ?d, ?s, ?a or ??)", mask[i])
+		}
+	}
+	if len(positions) == 0 {
+		return nil, fmt.Errorf("the mask is empty")
+	}
+	for _, chars := range positions {
+		if strings.ContainsAny(string(chars), "\x00\r\n") {
+			return nil, fmt.Errorf("plaintexts can't contain line breaks or NUL")
+		}
+	}
+	return positions, nil
+}
+
+// parseRandomCharset returns a charset written like a mask, placeholders
+// and literal characters together, without repeats.
+func parseRandomCharset(charset string) ([]rune, error) {
+	positions, err := parseMask(charset)
+	if err != nil {
+		return nil, err
+	}
+	var chars []rune
+	seen := map[rune]bool{}
+	for _, p := range positions {
+		for _, r := range p {
+			if !seen[r] {
+				seen[r] = true
+				chars = append(chars, r)
+			}
+		}
+	}
+	return chars, nil
+}
+
+// randomPlaintexts is the input of --random: count lines of plaintexts,
+// each position drawn uniformly from its characters with crypto/rand.
+type randomPlaintexts struct {
+	positions [][]rune
+	count     int64
+	end       byte
+	rand      *bufio.Reader
+	written   int64
+	pending   []byte
+}
+
+func newRandomPlaintexts(positions [][]rune, count int64, end byte) *randomPlaintexts {
+	return &randomPlaintexts{positions: positions, count: count, end: end, rand: bufio.NewReader(rand.Reader)}
+}
+
+// Read fills p with the next plaintext lines.
+func (g *randomPlaintexts) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(g.pending) == 0 {
+			if g.written == g.count {
+				break
+			}
+			line, err := g.plaintext()
+			if err != nil {
+				return n, err
+			}
+			g.pending = append(line, g.end)
+			g.written++
+		}
+		c := copy(p[n:], g.pending)
+		g.pending = g.pending[c:]
+		n += c
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (g *randomPlaintexts) plaintext() ([]byte, error) {
+	var line []byte
+	for _, chars := range g.positions {
+		i, err := g.index(len(chars))
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, string(chars[i])...)
+	}
+	return line, nil
+}
+
+// index draws a number below n without modulo bias, by rejecting the draws
+// from the incomplete last stretch of the uint32 range.
+func (g *randomPlaintexts) index(n int) (int, error) {
+	if n == 1 {
+		return 0, nil
+	}
+	limit := (1 << 32) - (1<<32)%uint64(n)
+	var b [4]byte
+	for {
+		if _, err := io.ReadFull(g.rand, b[:]); err != nil {
+			return 0, err
+		}
+		if v := uint64(binary.LittleEndian.Uint32(b[:])); v < limit {
+			return int(v % uint64(n)), nil
+		}
+	}
+}
+
+// syntheticUsername is the username --random gives line lineNo without a
+// --usernames-file.
+func syntheticUsername(lineNo int64) string {
+	return fmt.Sprintf("user%06d", lineNo)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/ratelimit"
+)
+
+// defaultRateBurst is the slack go.uber.org/ratelimit allows by default.
+const defaultRateBurst = 10
+
+// newRateLimiter returns a limiter for rate lines per second, which may be
+// fractional (0.5 = one line every two seconds). burst is how many lines
+// may be let through at once to catch up after a stall; 0 keeps the spacing
+// strict. A rate of 0 means no limit.
+func newRateLimiter(rate float64, burst int) ratelimit.Limiter {
+	if rate <= 0 {
+		return ratelimit.NewUnlimited()
+	}
+	slack := ratelimit.WithoutSlack
+	if burst > 0 {
+		slack = ratelimit.WithSlack(burst)
+	}
+	if rate == float64(int(rate)) {
+		return ratelimit.New(int(rate), slack)
+	}
+	return ratelimit.New(1, ratelimit.Per(time.Duration(float64(time.Second)/rate)), slack)
+}
+
+// takeRate waits for limiter like Take, but gives up when ctx is done, so a
+// slow rate doesn't hold up shutdown. It reports whether it got a slot.
+func takeRate(ctx context.Context, limiter ratelimit.Limiter) bool {
+	done := make(chan struct{})
+	go func() {
+		limiter.Take()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// byteLimiter is the token bucket of --rate-limit-bytes, shared by all the
+// inputs of a run. Readers pay for the bytes they read afterwards and wait
+// until the bucket is out of debt, so the sustained rate is exact and the
+// wait is never longer than the last read took to earn.
+type byteLimiter struct {
+	rate     float64 // bytes per second
+	mu       sync.Mutex
+	debt     float64 // bytes read but not yet earned
+	last     time.Time
+	stopped  chan struct{}
+	stopOnce sync.Once
+	// consumed counts the bytes read through the limiter, for --progress.
+	consumed atomic.Int64
+}
+
+func newByteLimiter(rate int64) *byteLimiter {
+	return &byteLimiter{rate: float64(rate), last: time.Now(), stopped: make(chan struct{})}
+}
+
+// stop ends all waits, so a slow rate doesn't hold up shutdown.
+func (l *byteLimiter) stop() {
+	l.stopOnce.Do(func() { close(l.stopped) })
+}
+
+// maxRead is the most a single read may take, a tenth of a second's worth,
+// so a large buffer doesn't make for one long stall.
+func (l *byteLimiter) maxRead() int {
+	return max(int(l.rate/10), 512)
+}
+
+// pay adds n bytes to the debt and waits until they are earned.
+func (l *byteLimiter) pay(n int) {
+	l.consumed.Add(int64(n))
+	l.mu.Lock()
+	now := time.Now()
+	l.debt = max(l.debt-now.Sub(l.last).Seconds()*l.rate, 0) + float64(n)
+	l.last = now
+	wait := time.Duration(l.debt / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-l.stopped:
+	}
+}
+
+// reader limits the bytes read from r.
+func (l *byteLimiter) reader(r io.Reader) io.Reader {
+	return limitedReader{r, l}
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *byteLimiter
+}
+
+func (r limitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.maxRead() {
+		p = p[:r.limiter.maxRead()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.limiter.pay(n)
+	}
+	return n, err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// withinTolerance checks elapsed is no more than 10% under want, and no
+// more than 50% over it, as a loaded machine only ever makes it slower.
+func withinTolerance(t *testing.T, elapsed, want time.Duration) {
+	t.Helper()
+	if elapsed < want*9/10 || elapsed > want*3/2 {
+		t.Errorf("took %v, want %v", elapsed, want)
+	}
+}
+
+func TestRateLimiterThroughput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes seconds")
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		takes int
+	}{
+		{"200/s", 200, 101},
+		{"40.5/s", 40.5, 21},
+		{"2.5/s", 2.5, 3},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			limiter := newRateLimiter(tt.rate, 0)
+			limiter.Take()
+			start := time.Now()
+			for i := 1; i < tt.takes; i++ {
+				limiter.Take()
+			}
+			withinTolerance(t, time.Since(start), time.Duration(float64(tt.takes-1)/tt.rate*float64(time.Second)))
+		})
+	}
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	limiter := newRateLimiter(20, 5)
+	limiter.Take()
+	// After a stall, the burst is let through at once.
+	time.Sleep(400 * time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		limiter.Take()
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Millisecond {
+		t.Errorf("the burst took %v", elapsed)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := newRateLimiter(0, 0)
+	start := time.Now()
+	for i := 0; i < 100000; i++ {
+		limiter.Take()
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("100000 unlimited takes took %v", elapsed)
+	}
+}
+
+func TestTakeRateCancelled(t *testing.T) {
+	limiter := newRateLimiter(0.1, 0)
+	limiter.Take()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if takeRate(ctx, limiter) {
+		t.Error("takeRate got a slot ten seconds early")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("takeRate gave up after %v", elapsed)
+	}
+}
+
+// TestRateLimitRun checks convert keeps to --rate-limit, and that the lines
+// --skip passes over don't wait for it.
+func TestRateLimitRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes a second")
+	}
+	var input strings.Builder
+	for i := 0; i < 41; i++ {
+		hash, err := aspnethash.HashMVC4([]byte("pw"), aspnethash.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		input.WriteString(hash + "\n")
+	}
+	start := time.Now()
+	out := mustRunTool(t, input.String(), "convert", "-q", "--rate-limit", "40", "--rate-burst", "0", "--skip", "20")
+	elapsed := time.Since(start)
+	if n := strings.Count(out, "\n"); n != 21 {
+		t.Fatalf("converted %d lines, want 21", n)
+	}
+	// 21 lines at 40 a second, the first one at once.
+	withinTolerance(t, elapsed, 500*time.Millisecond)
+}
+
+func TestByteLimiterThroughput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes a second")
+	}
+	const rate, size = 200 << 10, 100 << 10
+	l := newByteLimiter(rate)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, l.reader(bytes.NewReader(make([]byte, size))))
+	if err != nil || n != size {
+		t.Fatalf("read %d bytes, %v", n, err)
+	}
+	withinTolerance(t, time.Since(start), time.Second*size/rate)
+	if got := l.consumed.Load(); got != size {
+		t.Errorf("consumed %d bytes, want %d", got, size)
+	}
+}
+
+func TestByteLimiterReadSize(t *testing.T) {
+	l := newByteLimiter(100 << 20)
+	r := l.reader(bytes.NewReader(make([]byte, 64<<20)))
+	buf := make([]byte, 32<<20)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A tenth of a second's worth at most.
+	if n != 10<<20 {
+		t.Errorf("read %d bytes at once, want %d", n, 10<<20)
+	}
+}
+
+func TestByteLimiterStop(t *testing.T) {
+	l := newByteLimiter(1000)
+	done := make(chan struct{})
+	go func() {
+		// Ten seconds' worth of debt.
+		l.pay(10000)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	l.stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stop didn't end the wait")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// rehashTargets lists the accepted values of --target-mode: Identity v3
+// hashes as ASP.NET Core Identity writes them before .NET 7, with
+// HMAC-SHA256 and 10000 iterations, and since, with HMAC-SHA512 and 100000.
+var rehashTargets = []string{"identityv3", "identityv3-sha512"}
+
+// rehashParams returns the PRF and default iteration count of a target.
+func rehashParams(target string) (aspnethash.PRF, int) {
+	if target == "identityv3-sha512" {
+		return aspnethash.PRFSHA512, 100000
+	}
+	return aspnethash.PRFSHA256, 10000
+}
+
+// errDuplicateAccount is returned for a dump row of an account rehash has
+// seen on an earlier row; one account can only get one new hash.
+var errDuplicateAccount = errors.New("duplicate account")
+
+// rehasher re-hashes the accounts of a dump whose plaintexts were cracked in
+// a potfile, for the rehash command.
+type rehasher struct {
+	pot  *potfile
+	prf  aspnethash.PRF
+	opts aspnethash.Options
+
+	mu sync.Mutex
+	// rows maps each account to the line it was first seen on.
+	rows map[string]int64
+	// looked counts the accounts looked up, rehashed the ones that got a
+	// new hash; the others are listed in the --unrecovered-file.
+	looked, rehashed int64
+}
+
+func newRehasher(pot *potfile, prf aspnethash.PRF, opts aspnethash.Options) *rehasher {
+	return &rehasher{pot: pot, prf: prf, opts: opts, rows: map[string]int64{}}
+}
+
+// claim records that account is on line lineNo, or returns the line it was
+// seen on before.
+func (r *rehasher) claim(account string, lineNo int64) (first int64, dup bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if first, dup = r.rows[account]; dup {
+		return first, true
+	}
+	r.rows[account] = lineNo
+	return lineNo, false
+}
+
+// line rehashes the dump line on line lineNo and returns
+// <username><outputDelimiter><new hash>, or <old hash><outputDelimiter><new
+// hash> without usernamePresent. A line whose hash isn't in the potfile
+// gives no result but itself as unrecovered. The plaintext is checked
+// against the old hash first, so a wrong potfile entry can't lock the
+// account out.
+func (r *rehasher) line(lineNo int64, line string, usernamePresent bool, delimiter, outputDelimiter string, trim bool, esc hexEscaper) (result, unrecovered string, err error) {
+	username, encoded, err := splitUsername(line, usernamePresent, delimiter, trim)
+	if err != nil {
+		return "", "", err
+	}
+	hash, err := aspnethash.Parse(encoded)
+	if err != nil {
+		return "", "", err
+	}
+	account := encoded
+	if usernamePresent {
+		account = esc.decode(username)
+	}
+	if first, dup := r.claim(account, lineNo); dup {
+		return "", "", fmt.Errorf("%w: already on line %d", errDuplicateAccount, first)
+	}
+	atomic.AddInt64(&r.looked, 1)
+	plain, cracked := r.pot.plains[hashKey(hash)]
+	if !cracked {
+		return "", line, nil
+	}
+	if !hash.Verify([]byte(plain)) {
+		return "", "", fmt.Errorf("potfile plaintext: %w", errMismatch)
+	}
+	rehashed, err := aspnethash.HashIdentityV3([]byte(plain), r.prf, r.opts)
+	if err != nil {
+		return "", "", err
+	}
+	atomic.AddInt64(&r.rehashed, 1)
+	if !usernamePresent {
+		return encoded + outputDelimiter + rehashed, "", nil
+	}
+	return esc.escape(account) + outputDelimiter + rehashed, "", nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/base64"
+	"hash/maphash"
+	"log"
+	"strings"
+	"sync"
+)
+
+// saltReportTop is the number of most reused salts --analyze-salts lists.
+const saltReportTop = 10
+
+// saltUse is what --analyze-salts knows of one salt.
+type saltUse struct {
+	count int64
+	// firstLine is the line the salt was first seen on.
+	firstLine int64
+	// accounts, with --analyze-salts-exact, are the ones using the salt.
+	accounts []string
+}
+
+// saltAnalysis looks for weak salts among the hashes convert reads, for
+// --analyze-salts. Like frequency, it keys the salts by a 64-bit hash and
+// only keeps a salt once it repeats, so the memory used grows with the
+// number of distinct salts, not their length. With exact set, every salt is
+// kept with the accounts using it. It is safe for concurrent use.
+type saltAnalysis struct {
+	mu    sync.Mutex
+	total int64
+	// zero and ascii count the hashes with an all-zero salt and with a salt
+	// of printable ASCII only, which random salts practically never are.
+	zero, ascii int64
+
+	seed   maphash.Seed
+	uses   map[uint64]*saltUse
+	values map[uint64]string
+
+	exact map[string]*saltUse
+}
+
+func newSaltAnalysis(exact bool) *saltAnalysis {
+	if exact {
+		return &saltAnalysis{exact: map[string]*saltUse{}}
+	}
+	return &saltAnalysis{seed: maphash.MakeSeed(), uses: map[uint64]*saltUse{}, values: map[uint64]string{}}
+}
+
+// isZeroSalt reports whether every byte of salt is zero.
+func isZeroSalt(salt []byte) bool {
+	for _, b := range salt {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isASCIISalt reports whether every byte of salt is printable ASCII.
+func isASCIISalt(salt []byte) bool {
+	for _, b := range salt {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// observe records the salt of account, on line lineNo. Reuses are logged
+// at -v.
+func (a *saltAnalysis) observe(lineNo int64, account string, salt []byte) {
+	if len(salt) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.total++
+	switch {
+	case isZeroSalt(salt):
+		a.zero++
+	case isASCIISalt(salt):
+		a.ascii++
+	}
+	var use *saltUse
+	if a.exact != nil {
+		if use = a.exact[string(salt)]; use == nil {
+			use = &saltUse{firstLine: lineNo}
+			a.exact[string(salt)] = use
+		}
+		use.accounts = append(use.accounts, account)
+	} else {
+		key := maphash.Bytes(a.seed, salt)
+		if use = a.uses[key]; use == nil {
+			use = &saltUse{firstLine: lineNo}
+			a.uses[key] = use
+		}
+		if use.count == 1 {
+			a.values[key] = string(salt)
+		}
+	}
+	use.count++
+	if use.count > 1 {
+		logAt(levelVerbose, "Salt %s of %s (line %d) was first used on line %d", base64.StdEncoding.EncodeToString(salt), account, lineNo, use.firstLine)
+	}
+}
+
+// lineSalt returns the username and the salt of a line convert converted
+// in mode, for --analyze-salts. The salt is nil for unsalted hashes.
+func lineSalt(line, mode string, usernamePresent bool, delimiter string, trim bool, iterations int, parse hashParser) (username string, salt []byte) {
+	if mode == "dnn" {
+		username, hash, _ := parseDNNLine(line, usernamePresent, delimiter, trim)
+		return username, hash.Salt
+	}
+	username, encoded, _ := splitUsername(line, usernamePresent, delimiter, trim)
+	if mode == "auto" {
+		d := detectHash(encoded, delimiter, iterations)
+		if d.digest.pbkdf2.Salt != nil {
+			return username, d.digest.pbkdf2.Salt
+		}
+		return username, d.digest.salt
+	}
+	hash, _ := parse(encoded, iterations)
+	return username, hash.Salt
+}
+
+// reusedSalt is a salt used by more than one account.
+type reusedSalt struct {
+	Salt  string `json:"salt"` // base64
+	Count int64  `json:"count"`
+	// Accounts are listed with --analyze-salts-exact.
+	Accounts []string `json:"accounts,omitempty"`
+}
+
+// saltReport is the --analyze-salts part of the stats.
+type saltReport struct {
+	// Salted counts the hashes with a salt, Distinct their distinct salts
+	// and Reused the distinct salts used more than once.
+	Salted   int64 `json:"salted"`
+	Distinct int64 `json:"distinct"`
+	Reused   int64 `json:"reused"`
+	MaxReuse int64 `json:"max_reuse"`
+	// Suspicious counts the hashes with an all-zero (AllZero) or ASCII
+	// looking (ASCII) salt.
+	Suspicious int64        `json:"suspicious"`
+	AllZero    int64        `json:"all_zero"`
+	ASCII      int64        `json:"ascii"`
+	Top        []reusedSalt `json:"top"`
+}
+
+// report sums up the salts seen, with the most reused first.
+func (a *saltAnalysis) report() *saltReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := &saltReport{Salted: a.total, AllZero: a.zero, ASCII: a.ascii, Suspicious: a.zero + a.ascii, Top: []reusedSalt{}}
+	var entries []countEntry
+	accounts := map[string][]string{}
+	if a.exact != nil {
+		r.Distinct = int64(len(a.exact))
+		for salt, use := range a.exact {
+			if use.count > 1 {
+				entries = append(entries, countEntry{Name: salt, Count: use.count})
+				accounts[salt] = use.accounts
+			}
+		}
+	} else {
+		r.Distinct = int64(len(a.uses))
+		for key, salt := range a.values {
+			entries = append(entries, countEntry{Name: salt, Count: a.uses[key].count})
+		}
+	}
+	r.Reused = int64(len(entries))
+	sortByCount(entries)
+	if len(entries) > 0 {
+		r.MaxReuse = entries[0].Count
+	}
+	for i, e := range entries {
+		if i == saltReportTop {
+			break
+		}
+		r.Top = append(r.Top, reusedSalt{Salt: base64.StdEncoding.EncodeToString([]byte(e.Name)), Count: e.Count, Accounts: accounts[e.Name]})
+	}
+	return r
+}
+
+// logSummary logs the report after the run summary, with the accounts of
+// the reused salts at -v.
+func (r *saltReport) logSummary() {
+	log.Printf("Salts:")
+	log.Printf("  Salted hashes: %d, distinct salts: %d, used more than once: %d (at most by %d hashes)", r.Salted, r.Distinct, r.Reused, r.MaxReuse)
+	log.Printf("  Suspicious salts: %d (all zero: %d, ASCII: %d)", r.Suspicious, r.AllZero, r.ASCII)
+	for _, s := range r.Top {
+		log.Printf("  %s: %d hashes", s.Salt, s.Count)
+		if len(s.Accounts) > 0 {
+			logAt(levelVerbose, "    %s", strings.Join(s.Accounts, ", "))
+		}
+	}
+}
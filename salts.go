@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+)
+
+// uniqueSalts draws random salts for --unique-salts and draws again whenever
+// a salt was already handed out in this run.
+type uniqueSalts struct {
+	size    int
+	mu      sync.Mutex
+	seen    map[string]struct{}
+	redraws int64
+}
+
+func newUniqueSalts(size int) *uniqueSalts {
+	return &uniqueSalts{size: size, seen: map[string]struct{}{}}
+}
+
+// next returns a salt no earlier call returned.
+func (u *uniqueSalts) next() ([]byte, error) {
+	for {
+		salt := make([]byte, u.size)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		u.mu.Lock()
+		_, dup := u.seen[string(salt)]
+		if !dup {
+			u.seen[string(salt)] = struct{}{}
+		}
+		u.mu.Unlock()
+		if !dup {
+			return salt, nil
+		}
+		atomic.AddInt64(&u.redraws, 1)
+	}
+}
+
+// saltSequence derives the salt of each line from a seed and the line number
+// for --salt-sequence, so a fixture can be generated again byte for byte.
+// The line number is encrypted with AES under a key derived from the seed;
+// since a block cipher is a permutation, salts of at least one block (16
+// bytes) never repeat.
+type saltSequence struct {
+	block cipher.Block
+	size  int
+}
+
+func newSaltSequence(seed string, size int) (*saltSequence, error) {
+	key := sha256.Sum256([]byte(seed))
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		return nil, err
+	}
+	return &saltSequence{block: block, size: size}, nil
+}
+
+// salt returns the salt of input line lineNo. Salts longer than a block are
+// continued with the encryptions of lineNo and a block counter.
+func (s *saltSequence) salt(lineNo int64) []byte {
+	salt := make([]byte, 0, s.size+aes.BlockSize)
+	var in, out [aes.BlockSize]byte
+	binary.BigEndian.PutUint64(in[:8], uint64(lineNo))
+	for i := uint64(0); len(salt) < s.size; i++ {
+		binary.BigEndian.PutUint64(in[8:], i)
+		s.block.Encrypt(out[:], in[:])
+		salt = append(salt, out[:]...)
+	}
+	return salt[:s.size]
+}
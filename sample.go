@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	mathrand "math/rand"
+)
+
+// sampler picks the input lines --sample and --sample-n process. Lines are
+// numbered from 1 after --skip.
+type sampler struct {
+	// rate is the share of the lines kept; with --sample each line is kept
+	// with that probability.
+	rate float64
+	rand *mathrand.Rand
+	seed int64
+	// every, with --sample-n, keeps one line out of each run of every
+	// lines, up to max lines, which spreads the sample evenly over an
+	// input of known size without holding lines back. The line is picked
+	// at random within its run, at offset, so a pattern that repeats in
+	// the input can't line up with the sample.
+	every, offset, max int64
+	run, kept          int64
+}
+
+// newSampleSeed draws a seed for a run without --sample-seed; it is
+// reported in the stats so the sample can be drawn again.
+func newSampleSeed() int64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return int64(binary.LittleEndian.Uint64(b[:]) >> 1)
+}
+
+// newFractionSampler keeps each line with probability fraction.
+func newFractionSampler(fraction float64, seed int64) *sampler {
+	return &sampler{rate: fraction, rand: mathrand.New(mathrand.NewSource(seed)), seed: seed}
+}
+
+// newCountSampler keeps n lines spread evenly over population lines.
+func newCountSampler(n, population, seed int64) *sampler {
+	return &sampler{
+		rate:  math.Min(1, float64(n)/float64(max(1, population))),
+		rand:  mathrand.New(mathrand.NewSource(seed)),
+		seed:  seed,
+		every: max(1, population/n),
+		run:   -1,
+		max:   n,
+	}
+}
+
+// keep reports whether line n is part of the sample. It must be called for
+// every line, in order.
+func (s *sampler) keep(n int64) bool {
+	if s.every == 0 {
+		return s.rand.Float64() < s.rate
+	}
+	if run := (n - 1) / s.every; run != s.run {
+		s.run, s.offset = run, s.rand.Int63n(s.every)
+	}
+	if s.kept == s.max || (n-1)%s.every != s.offset {
+		return false
+	}
+	s.kept++
+	return true
+}
+
+// method names how the sample is drawn, for the stats.
+func (s *sampler) method() string {
+	if s.every == 0 {
+		return "fraction"
+	}
+	return "every-nth"
+}
+
+// sampleReport is the --sample part of the stats: how the sample was drawn
+// and what its counts come to for the whole input.
+type sampleReport struct {
+	Method string  `json:"method"`
+	Rate   float64 `json:"rate"`
+	Seed   int64   `json:"seed"`
+	// Population is the number of lines the sample was drawn from, and
+	// Sampled the number in the sample.
+	Population int64 `json:"population"`
+	Sampled    int64 `json:"sampled"`
+	// The estimates scale the counts of the sample up to the population.
+	EstimatedProcessed  int64   `json:"estimated_processed"`
+	EstimatedErrored    int64   `json:"estimated_errored"`
+	EstimatedDuplicates int64   `json:"estimated_duplicates,omitempty"`
+	ErrorRate           float64 `json:"error_rate"`
+	// ErrorRateMargin is the half width of the 95% confidence interval
+	// of ErrorRate.
+	ErrorRateMargin float64 `json:"error_rate_margin"`
+}
+
+// report extrapolates the counts of a sample of sampled out of population
+// lines.
+func (s *sampler) report(population, sampled, processed, errored, duplicates int64) *sampleReport {
+	r := &sampleReport{Method: s.method(), Rate: s.rate, Seed: s.seed, Population: population, Sampled: sampled}
+	if sampled == 0 {
+		return r
+	}
+	scale := float64(population) / float64(sampled)
+	r.EstimatedProcessed = int64(math.Round(float64(processed) * scale))
+	r.EstimatedErrored = int64(math.Round(float64(errored) * scale))
+	r.EstimatedDuplicates = int64(math.Round(float64(duplicates) * scale))
+	p := float64(errored) / float64(sampled)
+	r.ErrorRate = p
+	r.ErrorRateMargin = 1.96 * math.Sqrt(p*(1-p)/float64(sampled))
+	return r
+}
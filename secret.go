@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// isSecretReference reports whether the value of a secret flag names where
+// to read the secret rather than holding it, so it can be shown and saved.
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, "@") || strings.HasPrefix(value, "fd:") || strings.HasPrefix(value, "env:")
+}
+
+// secretFromStdin reports whether the value of a secret flag reads it from
+// stdin, which leaves nothing of it for the input.
+func secretFromStdin(value string) bool {
+	return value == "@-" || value == "fd:0"
+}
+
+// readSecret returns the value of secret flag given as arg: the value
+// itself, or a reference to where to read it, so it stays out of the shell
+// history, the process list and profiles:
+//
+//	@path    the file at path (@- for stdin)
+//	fd:N     the inherited file descriptor N (fd:0 for stdin)
+//	env:NAME the environment variable NAME
+//
+// A trailing newline is dropped. A file, or descriptor of one, that anyone
+// may read is refused unless insecurePerms is set.
+func readSecret(flag, arg string, insecurePerms bool) (string, error) {
+	var data []byte
+	switch {
+	case secretFromStdin(arg):
+		var err error
+		if data, err = io.ReadAll(os.Stdin); err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(arg, "@"):
+		f, err := os.Open(arg[1:])
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if data, err = readSecretFile(flag, f, insecurePerms); err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(arg, "fd:"):
+		fd, err := strconv.ParseUint(arg[len("fd:"):], 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("%q isn't a file descriptor number", arg)
+		}
+		f := os.NewFile(uintptr(fd), arg)
+		if f == nil {
+			return "", fmt.Errorf("%s isn't open", arg)
+		}
+		defer f.Close()
+		if data, err = readSecretFile(flag, f, insecurePerms); err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(arg, "env:"):
+		name := arg[len("env:"):]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("the environment variable %s isn't set", name)
+		}
+		return value, nil
+	default:
+		return arg, nil
+	}
+	s := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
+
+// readSecretFile reads the secret in f, after checking who may read it.
+func readSecretFile(flag string, f *os.File, insecurePerms bool) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !insecurePerms && info.Mode().IsRegular() && worldReadable(info) {
+		return nil, fmt.Errorf("%s: anyone may read it (mode %v); chmod o-r it, or give --insecure-secret-perms to read --%s from it anyway", f.Name(), info.Mode().Perm(), flag)
+	}
+	return io.ReadAll(f)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// testDecryptionKey is the AES key the secret tests decrypt with.
+const testDecryptionKey = "000102030405060708090a0b0c0d0e0f"
+
+func TestReadSecret(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"lf": "s3cret\n", "crlf": "s3cret\r\n", "none": "s3cret", "two": "s3cret\n\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("ASPNETHASHTOOL_TEST_SECRET", "from env\n")
+	tests := []struct {
+		arg, want string
+	}{
+		{"s3cret", "s3cret"},
+		// A literal keeps its newline; only what is read is trimmed.
+		{"s3cret\n", "s3cret\n"},
+		{"@" + filepath.Join(dir, "lf"), "s3cret"},
+		{"@" + filepath.Join(dir, "crlf"), "s3cret"},
+		{"@" + filepath.Join(dir, "none"), "s3cret"},
+		// Only one line ending is dropped.
+		{"@" + filepath.Join(dir, "two"), "s3cret\n"},
+		// A variable is taken as it is.
+		{"env:ASPNETHASHTOOL_TEST_SECRET", "from env\n"},
+	}
+	for _, tt := range tests {
+		got, err := readSecret("validation-key", tt.arg, false)
+		if err != nil {
+			t.Errorf("readSecret(%q): %v", tt.arg, err)
+		} else if got != tt.want {
+			t.Errorf("readSecret(%q) = %q, want %q", tt.arg, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"@" + filepath.Join(dir, "missing"), "env:ASPNETHASHTOOL_TEST_UNSET", "fd:x", "fd:-1", "fd:99999999999"} {
+		if _, err := readSecret("validation-key", bad, false); err == nil {
+			t.Errorf("readSecret(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestIsSecretReference(t *testing.T) {
+	for arg, want := range map[string]bool{"@key.txt": true, "@-": true, "fd:3": true, "env:KEY": true, "0a1b": false, "": false, "file:key": false} {
+		if got := isSecretReference(arg); got != want {
+			t.Errorf("isSecretReference(%q) = %v", arg, got)
+		}
+	}
+	for arg, want := range map[string]bool{"@-": true, "fd:0": true, "fd:3": false, "@key": false} {
+		if got := secretFromStdin(arg); got != want {
+			t.Errorf("secretFromStdin(%q) = %v", arg, got)
+		}
+	}
+}
+
+// encryptedInput returns username,encrypted password lines for the
+// decrypt tests, and the lines decrypt should write for them.
+func encryptedInput(t *testing.T) (input, want string) {
+	t.Helper()
+	key, err := readKeyArg("decryption-key", testDecryptionKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := aspnethash.NewPasswordCipher("aes", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range []string{"alice,Passw0rd!", "bob,hunter2"} {
+		username, password, _ := strings.Cut(account, ",")
+		encrypted, err := c.Encrypt(password, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		input += username + "," + encrypted + "\n"
+		want += username + ":" + password + "\n"
+	}
+	return input, want
+}
+
+// TestDecryptionKeyEnv decrypts with the key in an environment variable.
+func TestDecryptionKeyEnv(t *testing.T) {
+	input, want := encryptedInput(t)
+	cmd := toolCommand(t, "decrypt", "-u", "-q", "--decryption-key", "env:TEST_DECRYPTION_KEY")
+	cmd.Env = append(cmd.Env, "TEST_DECRYPTION_KEY="+testDecryptionKey)
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
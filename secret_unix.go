@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import "io/fs"
+
+// worldReadable reports whether the permissions of a secret file let anyone
+// read it.
+func worldReadable(info fs.FileInfo) bool {
+	return info.Mode().Perm()&0o004 != 0
+}
@@ -0,0 +1,131 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// secretFD returns a descriptor of its own for f, for readSecret to close.
+func secretFD(t *testing.T, f *os.File) string {
+	t.Helper()
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("fd:%d", fd)
+}
+
+func TestReadSecretFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := w.WriteString("s3cret\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	got, err := readSecret("validation-key", secretFD(t, r), false)
+	if err != nil || got != "s3cret" {
+		t.Errorf("readSecret of a pipe = %q, %v", got, err)
+	}
+}
+
+// TestReadSecretWorldReadable checks a secret file anyone may read is
+// refused, by path or descriptor, unless insecurePerms is set.
+func TestReadSecretWorldReadable(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The umask doesn't apply to Chmod.
+	for _, mode := range []os.FileMode{0o600, 0o640, 0o644, 0o604} {
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refused := mode&0o004 != 0
+		for _, arg := range []string{"@" + path, secretFD(t, f)} {
+			_, err := readSecret("validation-key", arg, false)
+			if refused && (err == nil || !strings.Contains(err.Error(), "--insecure-secret-perms")) {
+				t.Errorf("%v %s: got %v, want it refused", mode, arg, err)
+			}
+			if !refused && err != nil {
+				t.Errorf("%v %s: %v", mode, arg, err)
+			}
+		}
+		if got, err := readSecret("validation-key", "@"+path, true); err != nil || got != "s3cret" {
+			t.Errorf("%v with insecurePerms: %q, %v", mode, got, err)
+		}
+		f.Close()
+	}
+}
+
+// TestDecryptionKeyFD decrypts with the key read from an inherited
+// descriptor, and refuses a key file anyone may read.
+func TestDecryptionKeyFD(t *testing.T) {
+	input, want := encryptedInput(t)
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(testDecryptionKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cmd := toolCommand(t, "decrypt", "-u", "-q", "--decryption-key", "fd:3")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run := runTool(t, input, "decrypt", "-u", "-q", "--decryption-key", "@"+path)
+	if run.exitCode == 0 || !strings.Contains(run.stderr, "anyone may read it") {
+		t.Errorf("a world-readable key file exited with %d: %s", run.exitCode, run.stderr)
+	}
+	if got := mustRunTool(t, input, "decrypt", "-u", "-q", "--decryption-key", "@"+path, "--insecure-secret-perms"); got != want {
+		t.Errorf("with --insecure-secret-perms got %q, want %q", got, want)
+	}
+}
+
+// TestProfileSecretFD saves --validation-key fd:3 in a profile, as the
+// reference, not the key read from it.
+func TestProfileSecretFD(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "validation.key")
+	if err := os.WriteFile(path, []byte(testValidationKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cmd := toolCommand(t, "generate", "-q", "--mode", "webforms", "--hash-algorithm", "hmacsha256", "--validation-key", "fd:3", "--profiles-dir", dir, "--save-profile", "fd")
+	cmd.ExtraFiles = []*os.File{f}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if got := readProfile(t, dir, "fd").Flags["validation-key"]; got != "fd:3" {
+		t.Errorf("--validation-key fd:3 saved as %q", got)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// shardModes lists the accepted values of --split-by.
+var shardModes = []string{"round-robin", "hash"}
+
+// recordWriter is where the results of a run go: a single outputWriter, or
+// a shardedWriter for --split.
+type recordWriter interface {
+	write(records string)
+	sync() (int64, error)
+	close() error
+	commit() error
+	discard() error
+	bytesWritten() (raw, written int64)
+	checksums() []checksumReport
+	writeChecksumFile() error
+	duplicatesDropped() int64
+}
+
+// shardPath names shard i of n for the output path, keeping the extension
+// (including a compression extension) at the end: hashes.txt.gz becomes
+// hashes_000.txt.gz.
+func shardPath(path string, i, n int) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	if outputCompressionFor(path) != "none" {
+		inner := filepath.Ext(base)
+		base, ext = strings.TrimSuffix(base, inner), inner+ext
+	}
+	width := max(3, len(fmt.Sprint(n-1)))
+	return fmt.Sprintf("%s_%0*d%s", base, width, i, ext)
+}
+
+// shardedWriter spreads records over several output files for --split,
+// either round-robin or by a hash of the record so identical hashes end up
+// in the same shard. Records keep their relative order within a shard.
+type shardedWriter struct {
+	shards []*outputWriter
+	paths  []string
+	lines  []int64
+	next   int64
+	byHash bool
+	// stripUsername removes the username from records before hashing them.
+	stripUsername   bool
+	outputDelimiter string
+	terminator      byte
+	// unique is the set of --unique-output when it spans all shards;
+	// duplicates counts the records it left out.
+	unique     *lockedSet
+	duplicates atomic.Int64
+}
+
+// newShardedWriter creates n shards of path, each opened with opts as far
+// as they apply to a new file. newUnique, if not nil, makes the
+// --unique-output set of each shard, or with global the one set of all of
+// them.
+func newShardedWriter(path string, n int, byHash bool, opts outputOptions, newUnique func() seenSet, global bool) (*shardedWriter, error) {
+	s := &shardedWriter{byHash: byHash, lines: make([]int64, n), terminator: opts.terminator}
+	// Identical records hash to the same shard, so sets of their own are
+	// as good as a global one there, without the lock.
+	if newUnique != nil && global && !byHash {
+		s.unique = &lockedSet{set: newUnique()}
+		newUnique = nil
+	}
+	for i := 0; i < n; i++ {
+		p := shardPath(path, i, n)
+		shardOpts := opts
+		shardOpts.resumeAt = -1
+		shardOpts.viaTemp = opts.viaTemp && writeAtomically(p)
+		if newUnique != nil {
+			shardOpts.unique = newUnique()
+		}
+		w, err := newOutputWriter(p, shardOpts)
+		if err != nil {
+			s.discard()
+			return nil, err
+		}
+		s.shards = append(s.shards, w)
+		s.paths = append(s.paths, p)
+	}
+	return s, nil
+}
+
+// shardFor picks the shard of one record.
+func (s *shardedWriter) shardFor(record string) int {
+	if !s.byHash {
+		return int((atomic.AddInt64(&s.next, 1) - 1) % int64(len(s.shards)))
+	}
+	if s.stripUsername {
+		if _, rest, ok := strings.Cut(record, s.outputDelimiter); ok {
+			record = rest
+		}
+	}
+	h := fnv.New64a()
+	h.Write([]byte(record))
+	return int(h.Sum64() % uint64(len(s.shards)))
+}
+
+// write routes each of the records to its shard.
+func (s *shardedWriter) write(records string) {
+	if s.unique != nil {
+		var dropped int64
+		records, dropped = dropSeen(records, s.unique, s.terminator)
+		s.duplicates.Add(dropped)
+	}
+	parts := make([]strings.Builder, len(s.shards))
+	for records != "" {
+		end := strings.IndexByte(records, s.terminator)
+		if end < 0 {
+			end = len(records) - 1
+		}
+		i := s.shardFor(records[:end])
+		parts[i].WriteString(records[:end+1])
+		atomic.AddInt64(&s.lines[i], 1)
+		records = records[end+1:]
+	}
+	for i := range parts {
+		if parts[i].Len() > 0 {
+			s.shards[i].write(parts[i].String())
+		}
+	}
+}
+
+// sync flushes every shard. The offset it returns is the total over all
+// shards, which can't be resumed from; --split and --checkpoint don't mix.
+func (s *shardedWriter) sync() (int64, error) {
+	var total int64
+	for _, w := range s.shards {
+		n, err := w.sync()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// close closes every shard and returns the first error.
+func (s *shardedWriter) close() error {
+	var first error
+	for _, w := range s.shards {
+		if err := w.close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// commit moves every atomically written shard into place.
+func (s *shardedWriter) commit() error {
+	var first error
+	for _, w := range s.shards {
+		if err := w.commit(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// discard removes the temporary files of every shard.
+func (s *shardedWriter) discard() error {
+	var first error
+	for _, w := range s.shards {
+		if err := w.discard(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (s *shardedWriter) bytesWritten() (raw, written int64) {
+	for _, w := range s.shards {
+		r, n := w.bytesWritten()
+		raw += r
+		written += n
+	}
+	return raw, written
+}
+
+// checksums returns the checksum of every shard, in shard order.
+func (s *shardedWriter) checksums() []checksumReport {
+	var reports []checksumReport
+	for _, w := range s.shards {
+		reports = append(reports, w.checksums()...)
+	}
+	return reports
+}
+
+// writeChecksumFile writes a checksum file next to every shard.
+func (s *shardedWriter) writeChecksumFile() error {
+	for _, w := range s.shards {
+		if err := w.writeChecksumFile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shardedWriter) duplicatesDropped() int64 {
+	n := s.duplicates.Load()
+	for _, w := range s.shards {
+		n += w.duplicatesDropped()
+	}
+	return n
+}
+
+// breakdown returns the number of records written to each shard, in shard
+// order. lines counts the records routed to a shard, some of which its own
+// --unique-output set may have left out.
+func (s *shardedWriter) breakdown() []countEntry {
+	entries := make([]countEntry, len(s.shards))
+	for i, p := range s.paths {
+		entries[i] = countEntry{Name: p, Count: atomic.LoadInt64(&s.lines[i]) - s.shards[i].duplicatesDropped()}
+	}
+	return entries
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// splitRun runs args with --split n into a directory of its own and
+// returns the lines of each file and the stats.
+func splitRun(t *testing.T, input string, n int, args ...string) ([][]string, runStats) {
+	t.Helper()
+	dir := t.TempDir()
+	stats := filepath.Join(dir, "stats.json")
+	out := filepath.Join(dir, "hashes.txt")
+	mustRunTool(t, input, append(args, "-q", "--split", fmt.Sprint(n), "-o", out, "--stats-json", stats)...)
+	files := make([][]string, n)
+	for i := range files {
+		b, err := os.ReadFile(shardPath(out, i, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i] = splitLines(string(b), false)
+	}
+	b, err := os.ReadFile(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s runStats
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Shards) != n {
+		t.Fatalf("%d shards in the stats, want %d", len(s.Shards), n)
+	}
+	for i, shard := range s.Shards {
+		if shard.Name != shardPath(out, i, n) || shard.Count != int64(len(files[i])) {
+			t.Errorf("the stats count %d lines in %s, the file %s has %d", shard.Count, shard.Name, shardPath(out, i, n), len(files[i]))
+		}
+	}
+	return files, s
+}
+
+// repeatedHashes returns n username,hash lines using only distinct blobs,
+// user i having blob i%distinct, so --split-by hash has identical hashes to
+// keep together.
+func repeatedHashes(n, distinct int) string {
+	blobs := strings.Split(syntheticHashes(distinct), "\n")
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		_, blob, _ := strings.Cut(blobs[i%distinct], ",")
+		fmt.Fprintf(&b, "user%d,%s\n", i, blob)
+	}
+	return b.String()
+}
+
+// distinct counts the different lines of lines.
+func distinct(lines []string) int {
+	seen := map[string]bool{}
+	for _, line := range lines {
+		seen[line] = true
+	}
+	return len(seen)
+}
+
+// TestSplitRoundRobin checks --split deals the lines out in turn, which
+// with --ordered puts line j of the unsplit output in file j%n, in order.
+func TestSplitRoundRobin(t *testing.T) {
+	input := syntheticHashes(100)
+	// Workers racing over one-line batches, so only --ordered keeps the
+	// order.
+	args := []string{"convert", "-u", "--max-workers", "4", "--batch-size", "1"}
+	want := splitLines(mustRunTool(t, input, append(args, "-q", "--ordered")...), false)
+
+	files, _ := splitRun(t, input, 3, append(args, "--ordered")...)
+	for i, got := range files {
+		var own []string
+		for j := i; j < len(want); j += 3 {
+			own = append(own, want[j])
+		}
+		equalLines(t, got, own)
+	}
+
+	// Unordered, every line still lands once, and the files take turns.
+	files, _ = splitRun(t, input, 3, args...)
+	var all []string
+	for i, got := range files {
+		if len(got) != 34 && len(got) != 33 {
+			t.Errorf("file %d has %d lines, want 33 or 34", i, len(got))
+		}
+		all = append(all, got...)
+	}
+	slices.Sort(all)
+	slices.Sort(want)
+	equalLines(t, all, want)
+}
+
+// TestSplitByHash checks --split-by hash writes every line of a hash to the
+// same file, keeping the order of the lines within each with --ordered.
+func TestSplitByHash(t *testing.T) {
+	input := repeatedHashes(200, 7)
+	args := []string{"convert", "-u", "--max-workers", "4", "--batch-size", "1", "--ordered"}
+	want := splitLines(mustRunTool(t, input, append(args, "-q")...), false)
+
+	files, _ := splitRun(t, input, 4, append(args, "--split-by", "hash")...)
+	fileOf := map[string]int{}
+	for i, lines := range files {
+		for _, line := range lines {
+			_, hash, _ := strings.Cut(line, ":")
+			if f, seen := fileOf[hash]; seen && f != i {
+				t.Fatalf("%s is in files %d and %d", hash, f, i)
+			}
+			fileOf[hash] = i
+		}
+	}
+	if len(fileOf) != 7 {
+		t.Errorf("%d distinct hashes written, want 7", len(fileOf))
+	}
+	own := make([][]string, len(files))
+	for _, line := range want {
+		_, hash, _ := strings.Cut(line, ":")
+		own[fileOf[hash]] = append(own[fileOf[hash]], line)
+	}
+	for i := range files {
+		equalLines(t, files[i], own[i])
+	}
+}
+
+// TestSplitUniqueOutputScope checks --unique-output leaves out a line
+// written to any file with the global scope, and only one written to the
+// same file with shard.
+func TestSplitUniqueOutputScope(t *testing.T) {
+	// Each of 10 hashes three times in a row, without usernames, so the
+	// output lines repeat; round-robin puts a hash's lines in both files.
+	blob := make([]byte, 1+aspnethash.DefaultSaltSize+aspnethash.DefaultSubkeyLength)
+	var input strings.Builder
+	for i := 0; i < 10; i++ {
+		blob[1] = byte(i)
+		for j := 0; j < 3; j++ {
+			input.WriteString(base64.StdEncoding.EncodeToString(blob) + "\n")
+		}
+	}
+	args := []string{"convert", "--ordered", "--unique-output", "--split-by", "round-robin"}
+
+	files, stats := splitRun(t, input.String(), 2, append(args, "--unique-output-scope", "global")...)
+	if all := append(files[0], files[1]...); len(all) != 10 || distinct(all) != 10 {
+		t.Errorf("global: %d lines, %d distinct, want 10 distinct", len(all), distinct(all))
+	}
+	if stats.OutputDuplicates == nil || *stats.OutputDuplicates != 20 {
+		t.Errorf("global: %v duplicates counted, want 20", stats.OutputDuplicates)
+	}
+
+	files, stats = splitRun(t, input.String(), 2, append(args, "--unique-output-scope", "shard")...)
+	for i, lines := range files {
+		if len(lines) != 10 || distinct(lines) != 10 {
+			t.Errorf("shard: file %d has %d lines, %d distinct, want 10 distinct", i, len(lines), distinct(lines))
+		}
+	}
+	if stats.OutputDuplicates == nil || *stats.OutputDuplicates != 10 {
+		t.Errorf("shard: %v duplicates counted, want 10", stats.OutputDuplicates)
+	}
+}
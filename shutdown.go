@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The causes a run is stopped for before it has read all its input.
+var (
+	errInterrupted = errors.New("interrupted")
+	errTimedOut    = errors.New("--timeout reached")
+)
+
+// timeoutGrace is how long the workers still busy at the --timeout get to
+// finish before the run ends without their results.
+const timeoutGrace = 5 * time.Second
+
+// shutdownContext returns the context of a run, which is cancelled with
+// errInterrupted by SIGINT or SIGTERM, or with errTimedOut once timeout (if
+// not zero) has passed. Either way the signals are released, so a second
+// signal kills the process. stop must be called when the run is over.
+func shutdownContext(timeout time.Duration) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigCtx.Done():
+			cancel(errInterrupted)
+		case <-ctx.Done():
+		}
+		stopSignals()
+	}()
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { cancel(errTimedOut) })
+	}
+	return ctx, func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel(nil)
+	}
+}
+
+// waitWorkers waits for the dispatched workers with wait. After a --timeout
+// they only get timeoutGrace; it reports whether they all finished.
+func waitWorkers(ctx context.Context, wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+	}
+	if context.Cause(ctx) != errTimedOut {
+		<-done
+		return true
+	}
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeoutGrace):
+		return false
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// sortKeys lists the accepted values of --sort.
+var sortKeys = []string{"username", "hash"}
+
+// defaultSortMem is the default for --sort-mem.
+const defaultSortMem = "512M"
+
+// sortRecordOverhead approximates the memory a buffered record takes on top
+// of its bytes.
+const sortRecordOverhead = 16
+
+// outputSorter collects output records for --sort and emits them sorted at
+// the end. Once the records held exceed memLimit bytes, they are sorted and
+// spilled to a temporary file, and the files are merged at the end. It is
+// safe for concurrent use.
+type outputSorter struct {
+	mu         sync.Mutex
+	key        func(record string) string
+	terminator byte
+	memLimit   int64
+	temp       *aspnethash.RunDir
+
+	records []string
+	bytes   int64
+	peak    int64
+	spills  []*os.File
+	spilled int
+	err     error
+}
+
+// sortStats is the --sort part of the stats.
+type sortStats struct {
+	Key        string `json:"key"`
+	SpillFiles int    `json:"spill_files"`
+	PeakBytes  int64  `json:"peak_bytes"`
+}
+
+// newOutputSorter sorts records by the username in front of delimiter, or
+// by the rest of the record for the "hash" key. An empty delimiter means the
+// records have no username, so the "hash" key is the whole record. The
+// spill files are made in temp.
+func newOutputSorter(key, delimiter string, terminator byte, memLimit int64, temp *aspnethash.RunDir) *outputSorter {
+	s := &outputSorter{terminator: terminator, memLimit: memLimit, temp: temp}
+	switch {
+	case key == "username":
+		s.key = func(record string) string {
+			username, _, _ := strings.Cut(record, delimiter)
+			return username
+		}
+	case delimiter == "":
+		s.key = func(record string) string { return record }
+	default:
+		s.key = func(record string) string {
+			_, hash, ok := strings.Cut(record, delimiter)
+			if !ok {
+				return record
+			}
+			return hash
+		}
+	}
+	return s
+}
+
+// write takes terminated records, like recordWriter.write.
+func (s *outputSorter) write(records string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	for records != "" {
+		record, rest, _ := strings.Cut(records, string(s.terminator))
+		s.records = append(s.records, record)
+		s.bytes += int64(len(record)) + sortRecordOverhead
+		records = rest
+	}
+	s.peak = max(s.peak, s.bytes)
+	if s.bytes > s.memLimit {
+		s.err = s.spill()
+	}
+}
+
+func (s *outputSorter) less(a, b string) int {
+	if c := strings.Compare(s.key(a), s.key(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// spill writes the records held, sorted, to a new temporary file.
+func (s *outputSorter) spill() error {
+	slices.SortFunc(s.records, s.less)
+	f, err := s.temp.CreateTemp("sort-*")
+	if err != nil {
+		return fmt.Errorf("creating --sort spill file: %w", err)
+	}
+	s.spills = append(s.spills, f)
+	s.spilled++
+	w := bufio.NewWriterSize(f, 1<<20)
+	for _, record := range s.records {
+		w.WriteString(record)
+		w.WriteByte(s.terminator)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing --sort spill file: %w", err)
+	}
+	s.records, s.bytes = nil, 0
+	return nil
+}
+
+// finish emits all records in order, in blocks of terminated records, and
+// removes the spill files.
+func (s *outputSorter) finish(emit func(records string)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.removeSpills()
+	if s.err != nil {
+		return s.err
+	}
+	var b strings.Builder
+	flush := func(force bool) {
+		if b.Len() >= 1<<20 || force && b.Len() > 0 {
+			emit(b.String())
+			b.Reset()
+		}
+	}
+	if len(s.spills) == 0 {
+		slices.SortFunc(s.records, s.less)
+		for _, record := range s.records {
+			b.WriteString(record)
+			b.WriteByte(s.terminator)
+			flush(false)
+		}
+		flush(true)
+		s.records = nil
+		return nil
+	}
+	if len(s.records) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	h := &spillHeap{less: s.less}
+	for _, f := range s.spills {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r := &spillReader{r: bufio.NewReaderSize(f, 256*1024), terminator: s.terminator}
+		if ok, err := r.next(); err != nil {
+			return err
+		} else if ok {
+			h.readers = append(h.readers, r)
+		}
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		r := h.readers[0]
+		b.WriteString(r.record)
+		b.WriteByte(s.terminator)
+		flush(false)
+		ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	flush(true)
+	return nil
+}
+
+func (s *outputSorter) removeSpills() {
+	for _, f := range s.spills {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	s.spills = nil
+}
+
+// stats reports the spill files and the most memory the records took.
+func (s *outputSorter) stats(key string) *sortStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &sortStats{Key: key, SpillFiles: s.spilled, PeakBytes: s.peak}
+}
+
+// spillReader reads the records of a spill file one at a time.
+type spillReader struct {
+	r          *bufio.Reader
+	terminator byte
+	record     string
+}
+
+func (r *spillReader) next() (bool, error) {
+	record, err := r.r.ReadString(r.terminator)
+	if errors.Is(err, io.EOF) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading --sort spill file: %w", err)
+	}
+	r.record = record[:len(record)-1]
+	return true, nil
+}
+
+// spillHeap orders spill readers by their current record, for the merge.
+type spillHeap struct {
+	readers []*spillReader
+	less    func(a, b string) int
+}
+
+func (h *spillHeap) Len() int           { return len(h.readers) }
+func (h *spillHeap) Less(i, j int) bool { return h.less(h.readers[i].record, h.readers[j].record) < 0 }
+func (h *spillHeap) Swap(i, j int)      { h.readers[i], h.readers[j] = h.readers[j], h.readers[i] }
+func (h *spillHeap) Push(x any)         { h.readers = append(h.readers, x.(*spillReader)) }
+func (h *spillHeap) Pop() any {
+	r := h.readers[len(h.readers)-1]
+	h.readers = h.readers[:len(h.readers)-1]
+	return r
+}
+
+// parseByteSize parses a size such as 512M or 2G: bytes, or a number with
+// a K, M or G suffix (powers of 1024, optionally followed by "B" or "iB").
+func parseByteSize(arg string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(arg))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	shift := 0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)>>shift {
+		return 0, fmt.Errorf("invalid size %q", arg)
+	}
+	return n << shift, nil
+}
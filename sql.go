@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// outputFormatsGenerate are the accepted values of --output-format.
+var outputFormatsGenerate = []string{"lines", "sql"}
+
+// sqlSchema is a table --output-format sql resets passwords in. Tables
+// without a username column find the user's rows by UserId in usersTable.
+type sqlSchema struct {
+	name string
+	// hashModes are the --mode values of the hashes the schema stores, the
+	// default first.
+	hashModes      []string
+	table          string
+	usersTable     string // "" if table has the username column itself
+	usernameColumn string
+	// set returns the assignments of the SET clause for a generated hash.
+	set func(hash string) ([]sqlAssignment, error)
+}
+
+type sqlAssignment struct {
+	column, value string // value is a T-SQL expression
+}
+
+// sqlSchemas are the accepted values of --schema.
+var sqlSchemas = []sqlSchema{
+	{
+		// SimpleMembership keeps the salt inside the MVC4 hash and leaves
+		// PasswordSalt empty.
+		name: "simplemembership", hashModes: []string{"mvc4"},
+		table: "webpages_Membership", usersTable: "UserProfile", usernameColumn: "UserName",
+		set: func(hash string) ([]sqlAssignment, error) {
+			return []sqlAssignment{{"Password", sqlString(hash)}, {"PasswordSalt", sqlString("")}}, nil
+		},
+	},
+	{
+		// SqlMembershipProvider hashes as DNN does, which uses it; sites
+		// configured for SHA256 or a keyed HMAC take --mode webforms.
+		// PasswordFormat 1 is MembershipPasswordFormat.Hashed.
+		name: "membership", hashModes: []string{"dnn", "webforms"},
+		table: "aspnet_Membership", usersTable: "aspnet_Users", usernameColumn: "UserName",
+		set: func(hash string) ([]sqlAssignment, error) {
+			hash, salt, ok := strings.Cut(hash, ",")
+			if !ok {
+				return nil, fmt.Errorf("unexpected salted hash %q", hash)
+			}
+			return []sqlAssignment{{"Password", sqlString(hash)}, {"PasswordSalt", sqlString(salt)}, {"PasswordFormat", "1"}}, nil
+		},
+	},
+	{
+		// A new SecurityStamp signs out the user's existing sessions, as
+		// Identity does when it changes a password.
+		name: "identity", hashModes: []string{"mvc4"},
+		table: "AspNetUsers", usernameColumn: "UserName",
+		set: func(hash string) ([]sqlAssignment, error) {
+			stamp, err := newGUID()
+			if err != nil {
+				return nil, err
+			}
+			return []sqlAssignment{{"PasswordHash", sqlString(hash)}, {"SecurityStamp", sqlString(stamp)}}, nil
+		},
+	},
+}
+
+// sqlSchemaNames lists the accepted values of --schema.
+func sqlSchemaNames() []string {
+	names := make([]string, len(sqlSchemas))
+	for i, s := range sqlSchemas {
+		names[i] = s.name
+	}
+	return names
+}
+
+// lookupSQLSchema returns a copy of the named schema, for the flags to
+// override its table and column names.
+func lookupSQLSchema(name string) (sqlSchema, error) {
+	for _, s := range sqlSchemas {
+		if s.name == strings.ToLower(name) {
+			return s, nil
+		}
+	}
+	return sqlSchema{}, fmt.Errorf("unknown schema %q (want %s)", name, strings.Join(sqlSchemaNames(), ", "))
+}
+
+// sqlIdent quotes a T-SQL identifier.
+func sqlIdent(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// sqlString quotes a T-SQL Unicode string literal.
+func sqlString(s string) string {
+	return "N'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// newGUID returns a random (version 4) GUID, formatted as .NET's
+// Guid.ToString does.
+func newGUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// sqlHeader starts the script: one transaction, aborted by any error, and
+// a count of the rows updated.
+const sqlHeader = "SET XACT_ABORT ON;\nBEGIN TRANSACTION;\nDECLARE @updated int = 0;\n"
+
+// statement is the UPDATE of one user's password, followed by adding the
+// rows it touched to @updated.
+func (s sqlSchema) statement(username, hash string) (string, error) {
+	assignments, err := s.set(hash)
+	if err != nil {
+		return "", err
+	}
+	sets := make([]string, len(assignments))
+	for i, a := range assignments {
+		sets[i] = sqlIdent(a.column) + " = " + a.value
+	}
+	where := sqlIdent(s.usernameColumn) + " = " + sqlString(username)
+	if s.usersTable != "" {
+		where = "[UserId] IN (SELECT [UserId] FROM " + sqlIdent(s.usersTable) + " WHERE " + where + ")"
+	}
+	return "UPDATE " + sqlIdent(s.table) + " SET " + strings.Join(sets, ", ") + " WHERE " + where + ";\n" +
+		"SET @updated += @@ROWCOUNT;", nil
+}
+
+// sqlFooter ends the script: it shows the rows updated against the
+// statements written, and commits only if the two match.
+func sqlFooter(statements int64) string {
+	n := strconv.FormatInt(statements, 10)
+	return "SELECT @updated AS updated_rows, " + n + " AS expected_rows;\n" +
+		"IF @updated = " + n + " COMMIT TRANSACTION; ELSE ROLLBACK TRANSACTION;"
+}
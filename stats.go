@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"time"
+	"unicode"
+)
+
+// countEntry is a single named counter in a stats breakdown. Breakdowns are
+// always kept as slices, never maps, so that the human and JSON stats come
+// out in the same order on every run and can be diffed.
+type countEntry struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+	// Example is the number of an input line counted here, if any.
+	Example int64 `json:"example_line,omitempty"`
+}
+
+// sortByCount orders a breakdown by descending count, then by name. Use it
+// for breakdowns without a natural order (e.g. error kinds); breakdowns with
+// one (formats in registry order, files in input order) should be built in
+// that order directly.
+func sortByCount(entries []countEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Name < entries[j].Name
+	})
+}
+
+// runTiming holds every stats value that depends on the clock. Keeping them
+// together means two runs over the same input produce identical stats once
+// this field is ignored.
+type runTiming struct {
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	PerSecond       float64   `json:"per_second"`
+	// Pipeline breaks the run time down by stage, with --timings.
+	Pipeline *pipelineReport `json:"pipeline,omitempty"`
+}
+
+// runStats is the end-of-run summary, printed to the log and optionally
+// written as JSON with --stats-json.
+type runStats struct {
+	Version  string `json:"version"`
+	Command  string `json:"command"`
+	HashMode string `json:"hash_mode,omitempty"`
+	WorkType string `json:"work_type"`
+	Read     int64  `json:"read"`
+	// TotalLines is the number of input lines --count-first counted.
+	TotalLines int64 `json:"total_lines,omitempty"`
+	Processed  int64 `json:"processed"`
+	Errored    int64 `json:"errored"`
+	Skipped    int64 `json:"skipped"`
+	// Duplicates counts the lines --unique dropped, and the later rows of an
+	// account --duplicate-usernames first dropped.
+	Duplicates int64 `json:"duplicates,omitempty"`
+	// Abandoned counts the lines still being worked on when the run ended
+	// without them after a --timeout, and those left out of a batch once
+	// --strict stopped the run.
+	Abandoned int64 `json:"abandoned,omitempty"`
+	// ErrorKinds breaks Errored down by kind, see sortByCount.
+	ErrorKinds []countEntry `json:"error_kinds"`
+	ErrorFile  string       `json:"error_file,omitempty"`
+	// Targets and Cracked count the --hashes of the crack command and how
+	// many of them were found, or the accounts rehash looked up and how
+	// many of them it rehashed.
+	Targets int64 `json:"targets,omitempty"`
+	Cracked int64 `json:"cracked,omitempty"`
+	// Removed and Kept count the lines the remove command left out as
+	// cracked and the ones it wrote.
+	Removed *int64 `json:"removed,omitempty"`
+	Kept    *int64 `json:"kept,omitempty"`
+	// IterFallbacks counts the --iter-col rows without a valid iteration
+	// count, which were converted with --iter instead.
+	IterFallbacks int64 `json:"iter_fallbacks,omitempty"`
+	// InvalidUTF8 counts the lines --invalid-utf8 replaced bytes in or
+	// errored, unless it passes them on.
+	InvalidUTF8 *int64 `json:"invalid_utf8,omitempty"`
+	// Repairs counts the lines --fix repaired, by repair, in a fixed order.
+	Repairs []countEntry `json:"repairs,omitempty"`
+	// Policy counts the plaintexts breaking --policy, by rule.
+	Policy *policyReport `json:"policy,omitempty"`
+	// RepeatedHashes counts the hashes --unique left out of the output with
+	// --strip-usernames. Their accounts are still in the --map-file.
+	RepeatedHashes int64 `json:"repeated_hashes,omitempty"`
+	// PlaintextCache counts the repeated plaintexts generate saw, and with
+	// --reuse-salt-per-plaintext the hashes it reused.
+	PlaintextCache *plaintextCacheStats `json:"plaintext_cache,omitempty"`
+	// SaltRedraws counts the salts --unique-salts drew again because they
+	// were used before.
+	SaltRedraws *int64 `json:"salt_redraws,omitempty"`
+	// UsernameCollisions counts the rows --username-collisions reported.
+	UsernameCollisions *int64 `json:"username_collisions,omitempty"`
+	// DuplicateUsernames counts the accounts with more than one row, with
+	// --duplicate-usernames.
+	DuplicateUsernames *duplicateUsernamesReport `json:"duplicate_usernames,omitempty"`
+	// Validation is what --validate found out about the input.
+	Validation *validationReport `json:"validation,omitempty"`
+	// Frequency is what --frequency counted.
+	Frequency *frequencyReport `json:"frequency,omitempty"`
+	// Salts is what --analyze-salts found out about the salts.
+	Salts *saltReport `json:"salts,omitempty"`
+	// Audit is how the hashes measured up to the --audit-policy.
+	Audit *auditReport `json:"audit,omitempty"`
+	// Sample is set if the run only processed a --sample of the input,
+	// and so did its output.
+	Sample *sampleReport `json:"sample,omitempty"`
+	// Shard is set if the run only processed a --shard of the input.
+	Shard *inputShardReport `json:"shard,omitempty"`
+	// Sort tells how --sort held the output back.
+	Sort *sortStats `json:"sort,omitempty"`
+	// Files breaks the counts down by input file, when there are several.
+	Files []fileStats `json:"files,omitempty"`
+	// Workers is the range of the worker limit, if --max-workers is set.
+	Workers *workerRange `json:"workers,omitempty"`
+	// Threads is the GOMAXPROCS the run had, set by --threads.
+	Threads int    `json:"threads"`
+	Output  string `json:"output,omitempty"`
+	// LinesWritten and OutputPreexistingBytes tell the lines added by this
+	// run from the size --output had before, with --output-append.
+	LinesWritten           int64 `json:"lines_written,omitempty"`
+	OutputPreexistingBytes int64 `json:"output_preexisting_bytes,omitempty"`
+	// Shards counts the lines written to each --split file, in file order.
+	Shards []countEntry `json:"shards,omitempty"`
+	// BytesWritten counts output bytes before compression, CompressedBytes
+	// what actually reached the output when --output-compression is used.
+	BytesWritten      int64  `json:"bytes_written"`
+	OutputCompression string `json:"output_compression,omitempty"`
+	CompressedBytes   int64  `json:"compressed_bytes,omitempty"`
+	// OutputDuplicates counts the lines --unique-output left out.
+	OutputDuplicates *int64 `json:"output_duplicates,omitempty"`
+	// OutputChecksums are the --output-checksum of the output, one per
+	// --split file.
+	OutputChecksums []checksumReport `json:"output_checksums,omitempty"`
+	// Sink is where the output was sent with --output-addr.
+	Sink *sinkReport `json:"sink,omitempty"`
+	// StopReason is set when the run ended before all input was read.
+	StopReason string `json:"stop_reason,omitempty"`
+	// Unaccounted is the number of records read that no stage counted as
+	// an outcome. Anything but zero is a bug; see reconcile.
+	Unaccounted int64     `json:"unaccounted"`
+	Consistent  bool      `json:"consistent"`
+	Timing      runTiming `json:"timing"`
+}
+
+// fileStats counts the lines of one input file. Error is set if the file
+// couldn't be read to the end.
+type fileStats struct {
+	Name      string `json:"name"`
+	Read      int64  `json:"read"`
+	Processed int64  `json:"processed"`
+	Errored   int64  `json:"errored"`
+	Error     string `json:"error,omitempty"`
+}
+
+// finish records the end time and derives the clock-dependent values.
+func (s *runStats) finish(end time.Time) {
+	s.Timing.FinishedAt = end
+	s.Timing.DurationSeconds = end.Sub(s.Timing.StartedAt).Seconds()
+	if s.Timing.DurationSeconds > 0 {
+		s.Timing.PerSecond = float64(s.Processed) / s.Timing.DurationSeconds
+	}
+}
+
+// reconcile checks the invariant that every record read was counted as
+// exactly one outcome. A stage that consumes records without passing them on
+// must add its counter to the sum here.
+func (s *runStats) reconcile() bool {
+	accounted := s.Processed + s.Errored + s.Skipped + s.Duplicates + s.Abandoned
+	s.Unaccounted = s.Read - accounted
+	s.Consistent = s.Unaccounted == 0
+	return s.Consistent
+}
+
+// logSummary prints the human readable stats.
+// With --log-format json, it is one entry holding the stats as --stats-json
+// writes them.
+func (s *runStats) logSummary() {
+	defer beginSummary()()
+	if jsonLogging() {
+		logWith(levelDefault, []logField{{"stats", s}}, "Done! Total Run Time: %f seconds", s.Timing.DurationSeconds)
+		return
+	}
+	log.Printf("Done! Total Run Time: %f seconds", s.Timing.DurationSeconds)
+	if s.TotalLines > 0 {
+		log.Printf("Read %s / %s lines (%.1f%%)", groupThousands(s.Read), groupThousands(s.TotalLines), 100*float64(s.Read)/float64(s.TotalLines))
+	}
+	log.Printf("Processed %d %s", s.Processed, s.WorkType)
+	log.Printf("Errored %s: %d", s.WorkType, s.Errored)
+	for i, kind := range s.ErrorKinds {
+		if i == 3 {
+			log.Printf("  ... and %d more error kinds", len(s.ErrorKinds)-3)
+			break
+		}
+		log.Printf("  %s: %d (e.g. line %d)", kind.Name, kind.Count, kind.Example)
+	}
+	if s.Validation != nil {
+		s.Validation.logSummary()
+	}
+	if s.Frequency != nil {
+		s.Frequency.logSummary()
+	}
+	if s.Salts != nil {
+		s.Salts.logSummary()
+	}
+	if s.Audit != nil {
+		s.Audit.logSummary()
+	}
+	if s.Shard != nil {
+		s.Shard.logSummary()
+	}
+	if r := s.Sample; r != nil {
+		log.Printf("Sampled %s of %s lines (%s, seed %d); the output holds only the sample", groupThousands(r.Sampled), groupThousands(r.Population), r.Method, r.Seed)
+		log.Printf("  Estimated for the whole input: %s processed, %s errored (error rate %.2f%% ± %.2f%%)", groupThousands(r.EstimatedProcessed), groupThousands(r.EstimatedErrored), 100*r.ErrorRate, 100*r.ErrorRateMargin)
+		if r.EstimatedDuplicates > 0 {
+			log.Printf("  Estimated duplicates: %s", groupThousands(r.EstimatedDuplicates))
+		}
+	}
+	if s.Sort != nil {
+		log.Printf("Sorted by %s: %d spill files, peak %d bytes held", s.Sort.Key, s.Sort.SpillFiles, s.Sort.PeakBytes)
+	}
+	if s.Targets > 0 && s.Command == "rehash" {
+		log.Printf("Rehashed %d of %d accounts", s.Cracked, s.Targets)
+	} else if s.Targets > 0 {
+		log.Printf("Cracked %d of %d hashes", s.Cracked, s.Targets)
+	}
+	if s.Removed != nil {
+		log.Printf("Removed %d cracked %s, kept %d", *s.Removed, s.WorkType, *s.Kept)
+	}
+	if s.IterFallbacks > 0 {
+		log.Printf("Rows without a valid --iter-col value: %d (converted with --iter)", s.IterFallbacks)
+	}
+	if s.InvalidUTF8 != nil && *s.InvalidUTF8 > 0 {
+		log.Printf("Lines with invalid UTF-8: %d", *s.InvalidUTF8)
+	}
+	if len(s.Repairs) > 0 {
+		log.Printf("Lines repaired by --fix:")
+		for _, r := range s.Repairs {
+			log.Printf("  %s: %d (e.g. line %d)", r.Name, r.Count, r.Example)
+		}
+	}
+	if p := s.Policy; p != nil && p.Violations > 0 {
+		action := "hashed anyway"
+		if p.Enforced {
+			action = "errored"
+		}
+		log.Printf("Plaintexts breaking --policy: %d (%s)", p.Violations, action)
+		for _, r := range p.Rules {
+			log.Printf("  %s: %d (e.g. line %d)", r.Name, r.Count, r.Example)
+		}
+	}
+	if s.RepeatedHashes > 0 {
+		log.Printf("Repeated hashes written once (every account is in the --map-file): %d", s.RepeatedHashes)
+	}
+	if c := s.PlaintextCache; c != nil && c.Hits > 0 {
+		share := 100 * float64(c.Hits) / float64(c.Hits+c.Misses)
+		if c.Reuse {
+			log.Printf("Hashes reused for repeated plaintexts: %d (%.1f%%), %d plaintexts evicted from the cache", c.Hits, share, c.Evictions)
+		} else {
+			log.Printf("Repeated plaintexts: %d (%.1f%%); --reuse-salt-per-plaintext would hash them only once", c.Hits, share)
+		}
+	}
+	if s.SaltRedraws != nil {
+		log.Printf("Salts drawn again to keep them unique: %d", *s.SaltRedraws)
+	}
+	if s.UsernameCollisions != nil {
+		log.Printf("Usernames colliding after normalization: %d", *s.UsernameCollisions)
+	}
+	if s.DuplicateUsernames != nil {
+		s.DuplicateUsernames.logSummary()
+	}
+	if len(s.Files) > 0 {
+		log.Printf("Per input file:")
+		for _, f := range s.Files {
+			if f.Error != "" {
+				log.Printf("  %s: read %d, processed %d, errored %d, failed: %s", f.Name, f.Read, f.Processed, f.Errored, f.Error)
+			} else {
+				log.Printf("  %s: read %d, processed %d, errored %d", f.Name, f.Read, f.Processed, f.Errored)
+			}
+		}
+	}
+	if s.Workers != nil && s.Workers.Min != s.Workers.Max {
+		log.Printf("Workers: %d-%d", s.Workers.Min, s.Workers.Max)
+	}
+	if s.Skipped > 0 {
+		log.Printf("Skipped %s: %d", s.WorkType, s.Skipped)
+	}
+	if s.Duplicates > 0 {
+		log.Printf("Duplicate %s: %d", s.WorkType, s.Duplicates)
+	}
+	if s.Abandoned > 0 {
+		log.Printf("Abandoned %s: %d", s.WorkType, s.Abandoned)
+	}
+	if !s.Consistent {
+		log.Printf("CONSISTENCY FAILURE: read %d %s but %d are unaccounted for", s.Read, s.WorkType, s.Unaccounted)
+	}
+	if s.OutputCompression != "" {
+		log.Printf("Wrote %d bytes (%d bytes %s compressed)", s.BytesWritten, s.CompressedBytes, s.OutputCompression)
+	}
+	if s.Sink != nil {
+		log.Printf("Sent %d lines to %s (%d reconnects)", s.Sink.Sent, s.Sink.Address, s.Sink.Reconnects)
+		if s.Sink.Undelivered > 0 {
+			log.Printf("  Not delivered: %d lines", s.Sink.Undelivered)
+		}
+	}
+	if s.LinesWritten > 0 || s.OutputPreexistingBytes > 0 {
+		log.Printf("Appended %d lines to %s (%d bytes before this run)", s.LinesWritten, s.Output, s.OutputPreexistingBytes)
+	}
+	if len(s.Shards) > 0 {
+		log.Printf("Lines per --split file:")
+		for _, shard := range s.Shards {
+			log.Printf("  %s: %d", shard.Name, shard.Count)
+		}
+	}
+	if s.OutputDuplicates != nil && *s.OutputDuplicates > 0 {
+		log.Printf("Duplicate output lines not written: %d", *s.OutputDuplicates)
+	}
+	for _, c := range s.OutputChecksums {
+		log.Printf("SHA-256 of %s (%s): %s (%d bytes, %d lines)", c.File, c.Of, c.SHA256, c.Bytes, c.Lines)
+	}
+	if s.ErrorFile != "" {
+		log.Printf("Failed lines written to %s", s.ErrorFile)
+	}
+	if s.Timing.DurationSeconds > 0 {
+		r := []rune(s.WorkType)
+		r[0] = unicode.ToUpper(r[0])
+		log.Printf("%s per second: %f", string(r), s.Timing.PerSecond)
+	}
+	if p := s.Timing.Pipeline; p != nil {
+		p.logSummary()
+	}
+}
+
+// writeJSON writes the stats to path as indented JSON.
+func (s *runStats) writeJSON(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// timingField matches the timing object of --stats-json, which holds every
+// value that depends on the clock.
+var timingField = regexp.MustCompile(`"timing": \{[^{}]*\}`)
+
+// TestStatsDeterministic converts the same files twice with several workers
+// racing over lines with different errors, and checks the two stats files
+// are the same but for their timing.
+func TestStatsDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	valid := strings.Split(syntheticHashes(500), "\n")
+	var files []string
+	for f := 0; f < 2; f++ {
+		var b strings.Builder
+		for i := 0; i < 500; i++ {
+			switch i % 10 {
+			case 0, 1, 2, 3:
+				b.WriteString(valid[i])
+			case 4, 5, 6:
+				fmt.Fprintf(&b, "user%d", i)
+			case 7, 8:
+				fmt.Fprintf(&b, "user%d,not base64!", i)
+			default:
+				blob := make([]byte, 20)
+				if i%20 == 9 {
+					blob = make([]byte, 1+aspnethash.DefaultSaltSize+aspnethash.DefaultSubkeyLength)
+					blob[0] = 7
+				}
+				fmt.Fprintf(&b, "user%d,%s", i, base64.StdEncoding.EncodeToString(blob))
+			}
+			b.WriteString("\n")
+		}
+		path := filepath.Join(dir, fmt.Sprintf("dump%d.txt", f+1))
+		if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+
+	var stats [2]string
+	for i := range stats {
+		path := filepath.Join(dir, fmt.Sprintf("stats%d.json", i+1))
+		args := append([]string{"convert", "-u", "-q", "--threads", "4", "--max-workers", "8", "--batch-size", "1", "--stats-json", path}, files...)
+		mustRunTool(t, "", args...)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(timingField.FindAll(b, -1)); n != 1 {
+			t.Fatalf("%d timing fields in %s", n, b)
+		}
+		stats[i] = timingField.ReplaceAllString(string(b), `"timing": {}`)
+	}
+	if stats[0] != stats[1] {
+		t.Errorf("the stats of two runs differ:\n%s\n%s", stats[0], stats[1])
+	}
+	// Errors by descending count then name, files in input order.
+	for _, want := range []string{`"missing_delimiter"`, `"invalid_base64"`, `"length_mismatch"`, `"version_byte"`, "dump1.txt", "dump2.txt"} {
+		i := strings.Index(stats[0], want)
+		if i < 0 {
+			t.Fatalf("no %s in the stats:\n%s", want, stats[0])
+		}
+		stats[0] = stats[0][i:]
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statusWindow is how far back the throughput of --status-addr looks.
+const statusWindow = time.Minute
+
+// statusCounters point at the live counters of the run. The status server
+// only ever loads them atomically, so it never holds up the workers.
+type statusCounters struct {
+	processed, errored, skipped, duplicates *int64
+}
+
+// statusReport is the JSON served by --status-addr.
+type statusReport struct {
+	Command       string            `json:"command"`
+	HashMode      string            `json:"hash_mode,omitempty"`
+	UptimeSeconds float64           `json:"uptime_seconds"`
+	Processed     int64             `json:"processed"`
+	Errored       int64             `json:"errored"`
+	Skipped       int64             `json:"skipped"`
+	Duplicates    int64             `json:"duplicates"`
+	PerSecond     float64           `json:"per_second_last_minute"`
+	Flags         map[string]string `json:"flags"`
+}
+
+type statusSample struct {
+	at   time.Time
+	done int64
+}
+
+// statusServer serves the progress of the run on --status-addr: the report
+// on / and /status, and /healthz, which answers 200 while the run is active.
+type statusServer struct {
+	srv      *http.Server
+	counters statusCounters
+	report   statusReport // the fields that don't change
+	started  time.Time
+	active   atomic.Bool
+
+	mu      sync.Mutex
+	samples []statusSample
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// startStatusServer listens on addr and serves in the background until
+// close is called.
+func startStatusServer(addr string, counters statusCounters, command, hashMode string, flags map[string]string) (*statusServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &statusServer{
+		counters: counters,
+		report:   statusReport{Command: command, HashMode: hashMode, Flags: flags},
+		started:  time.Now(),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	s.active.Store(true)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.serveStatus)
+	mux.HandleFunc("/healthz", s.serveHealth)
+	s.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go s.srv.Serve(ln)
+	go s.sample()
+	return s, nil
+}
+
+// done is the number of lines finished so far.
+func (s *statusServer) done() int64 {
+	return atomic.LoadInt64(s.counters.processed) + atomic.LoadInt64(s.counters.errored)
+}
+
+// sample records the progress once a second for the throughput, keeping
+// statusWindow worth of samples.
+func (s *statusServer) sample() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	s.add(statusSample{at: s.started})
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.add(statusSample{at: now, done: s.done()})
+		}
+	}
+}
+
+func (s *statusServer) add(sample statusSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, sample)
+	for len(s.samples) > 1 && sample.at.Sub(s.samples[0].at) > statusWindow {
+		s.samples = s.samples[1:]
+	}
+}
+
+// perSecond is the throughput since the oldest sample.
+func (s *statusServer) perSecond(now time.Time, done int64) float64 {
+	s.mu.Lock()
+	oldest := s.samples[0]
+	s.mu.Unlock()
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(done-oldest.done) / elapsed
+}
+
+func (s *statusServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "/status" {
+		http.NotFound(w, r)
+		return
+	}
+	now := time.Now()
+	report := s.report
+	report.UptimeSeconds = now.Sub(s.started).Seconds()
+	report.Processed = atomic.LoadInt64(s.counters.processed)
+	report.Errored = atomic.LoadInt64(s.counters.errored)
+	report.Skipped = atomic.LoadInt64(s.counters.skipped)
+	report.Duplicates = atomic.LoadInt64(s.counters.duplicates)
+	report.PerSecond = s.perSecond(now, report.Processed+report.Errored)
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
+
+func (s *statusServer) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if !s.active.Load() {
+		http.Error(w, "finished", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// close marks the run as finished and shuts the server down, giving open
+// requests a moment to complete.
+func (s *statusServer) close() error {
+	s.active.Store(false)
+	close(s.stop)
+	<-s.stopped
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"time"
+)
+
+// reportOnSignal logs the status of the run each time one of the
+// statusSignals arrives, from the time it returns until ctx is done. The
+// reports only load the counters and go through the logger, so they can't
+// get in the way of the workers or the output. The current rate is
+// measured over the last second or two.
+func reportOnSignal(ctx context.Context, p *progress, counters statusCounters) {
+	if len(statusSignals) == 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, statusSignals...)
+	go reportStatus(ctx, p, counters, signals)
+}
+
+func reportStatus(ctx context.Context, p *progress, counters statusCounters, signals chan os.Signal) {
+	defer signal.Stop(signals)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	// previous and last are the two latest samples of the lines done.
+	previous := statusSample{at: p.started}
+	last := previous
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			previous, last = last, statusSample{at: now, done: p.done()}
+		case <-signals:
+			now := time.Now()
+			processed := atomic.LoadInt64(counters.processed)
+			errored := atomic.LoadInt64(counters.errored)
+			skipped := atomic.LoadInt64(counters.skipped)
+			done := p.done()
+			elapsed := now.Sub(p.started)
+			var current, average float64
+			if d := now.Sub(previous.at).Seconds(); d > 0 {
+				current = float64(done-previous.done) / d
+			}
+			if elapsed > 0 {
+				average = float64(done) / elapsed.Seconds()
+			}
+			fields := []logField{
+				{"processed", processed}, {"errored", errored}, {"skipped", skipped},
+				{"elapsed_seconds", elapsed.Seconds()}, {"rate", current}, {"average_rate", average},
+			}
+			msg := fmt.Sprintf("Status: processed %s, errored %s, skipped %s in %s; %.1f lines/s now, %.1f on average",
+				groupThousands(processed), groupThousands(errored), groupThousands(skipped), formatETA(elapsed), current, average)
+			if fraction := p.fraction(done); fraction > 0 {
+				fields = append(fields, logField{"percent", 100 * fraction})
+				msg += fmt.Sprintf("; %.1f%% done", 100*fraction)
+			}
+			logWith(levelDefault, fields, "%s", msg)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// statusSignals make the run log its status, see reportOnSignal. SIGINFO
+// is what Ctrl-T sends.
+var statusSignals = []os.Signal{syscall.SIGUSR1, syscall.SIGINFO}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// statusSignals is empty: there is no SIGUSR1 to ask for the status with.
+var statusSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix && !(darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// statusSignals make the run log its status, see reportOnSignal.
+var statusSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// teeWriter mirrors the records written to --output on stdout, for --tee:
+// all of them, or with --head only the first limit lines. It sees the
+// records as they are written to the file, after --unique-output and before
+// compression. The shards of --split share one, so the lines show up in the
+// order they were written to any file. It is safe for concurrent use.
+type teeWriter struct {
+	mu         sync.Mutex
+	w          io.Writer
+	terminator byte
+	// limit is the number of lines to mirror, 0 for all; lines counts the
+	// ones mirrored so far.
+	limit, lines int64
+	// err is the first error writing to stdout, after which the mirroring
+	// stops but the output carries on.
+	err error
+}
+
+func newTeeWriter(w io.Writer, terminator byte, limit int64) *teeWriter {
+	return &teeWriter{w: w, terminator: terminator, limit: limit}
+}
+
+// write mirrors records, which end in the record terminator.
+func (t *teeWriter) write(records string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil || (t.limit > 0 && t.lines >= t.limit) {
+		return
+	}
+	n := int64(strings.Count(records, string(t.terminator)))
+	if t.limit > 0 && t.lines+n > t.limit {
+		records = firstRecords(records, t.terminator, t.limit-t.lines)
+		n = t.limit - t.lines
+	}
+	t.lines += n
+	_, t.err = io.WriteString(t.w, records)
+}
+
+// firstRecords returns the first n records of records.
+func firstRecords(records string, terminator byte, n int64) string {
+	end := 0
+	for ; n > 0; n-- {
+		i := strings.IndexByte(records[end:], terminator)
+		if i < 0 {
+			return records
+		}
+		end += i + 1
+	}
+	return records[:end]
+}
+
+// error returns the error that stopped the mirroring, if any.
+func (t *teeWriter) error() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// runTemp holds the temporary files of a run, the --sort spill files in a
+// directory of the run's own under --tmp-dir and the atomic outputs not
+// moved into place yet, so none outlive it. It is nil until the run starts.
+var runTemp *aspnethash.RunDir
+
+// fatalf logs like log.Fatalf once the run's temporary files are removed,
+// for the errors that end a run after it created them.
+func fatalf(format string, v ...any) {
+	removeRunTemp()
+	log.Fatalf(format, v...)
+}
+
+// removeRunTemp removes the temporary files of the run.
+func removeRunTemp() {
+	if err := runTemp.Cleanup(); err != nil {
+		log.Printf("Error removing temporary files: %v", err)
+	}
+}
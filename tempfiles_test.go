@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sortSpillArgs converts with --sort spilling to files in tmp, into the
+// atomic output out.
+func sortSpillArgs(tmp, out string) []string {
+	return []string{"convert", "-u", "-q", "--sort", "username", "--sort-mem", "1K", "--tmp-dir", tmp, "-o", out}
+}
+
+// TestRunTempCleanup checks the --sort spill files and the temporary
+// output are gone after a run that succeeds and after one --strict stops.
+func TestRunTempCleanup(t *testing.T) {
+	input := syntheticHashes(2000)
+	t.Run("success", func(t *testing.T) {
+		tmp, outDir := t.TempDir(), t.TempDir()
+		stats := filepath.Join(t.TempDir(), "stats.json")
+		mustRunTool(t, input, append(sortSpillArgs(tmp, filepath.Join(outDir, "out.txt")), "--stats-json", stats)...)
+		b, err := os.ReadFile(stats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), `"spill_files": 0`) || !strings.Contains(string(b), `"spill_files"`) {
+			t.Fatalf("no spill files in %s", b)
+		}
+		noEntries(t, tmp)
+		if names := dirNames(t, outDir); len(names) != 1 || names[0] != "out.txt" {
+			t.Errorf("%v in the output directory, want just out.txt", names)
+		}
+	})
+	t.Run("strict", func(t *testing.T) {
+		tmp, outDir := t.TempDir(), t.TempDir()
+		run := runTool(t, input+"bad\n", append(sortSpillArgs(tmp, filepath.Join(outDir, "out.txt")), "--strict")...)
+		if run.exitCode == 0 {
+			t.Fatal("--strict run succeeded")
+		}
+		noEntries(t, tmp)
+		noEntries(t, outDir)
+	})
+}
+
+func TestCleanStaleFlag(t *testing.T) {
+	tmp := t.TempDir()
+	// A run directory whose owner file was never written.
+	dir := filepath.Join(tmp, "aspnethash-run-killed")
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	mustRunTool(t, "", "convert", "-q", "--clean-stale", "--stale-age", "0s", "--tmp-dir", tmp)
+	noEntries(t, tmp)
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func noEntries(t *testing.T, dir string) {
+	t.Helper()
+	if names := dirNames(t, dir); len(names) != 0 {
+		t.Errorf("%v left in %s", names, dir)
+	}
+}
@@ -0,0 +1,60 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRunTempCleanupInterrupt interrupts a run once it has spilled to a
+// file, and checks it removes its temporary files on the way out. The
+// --rate-limit keeps the run going for 20 seconds otherwise.
+func TestRunTempCleanupInterrupt(t *testing.T) {
+	tmp, outDir := t.TempDir(), t.TempDir()
+	input := filepath.Join(t.TempDir(), "in.txt")
+	if err := os.WriteFile(input, []byte(syntheticHashes(2000)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := toolCommand(t, append(sortSpillArgs(tmp, filepath.Join(outDir, "out.txt")), "--rate-limit", "100", input)...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	deadline := time.After(10 * time.Second)
+	for !spilled(t, tmp) {
+		select {
+		case err := <-done:
+			t.Fatalf("the run ended before spilling to a file: %v", err)
+		case <-deadline:
+			cmd.Process.Kill()
+			t.Fatal("the run didn't spill to a file")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("the run didn't stop when interrupted")
+	}
+	noEntries(t, tmp)
+	noEntries(t, outDir)
+}
+
+// spilled reports whether the run directory in tmp holds a spill file next
+// to its owner file.
+func spilled(t *testing.T, tmp string) bool {
+	for _, dir := range dirNames(t, tmp) {
+		if len(dirNames(t, filepath.Join(tmp, dir))) > 1 {
+			return true
+		}
+	}
+	return false
+}
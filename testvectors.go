@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// The fixed inputs of the test vectors. The plaintext isn't ASCII, so the
+// vectors also pin the UTF-8 and UTF-16LE encodings the modes hash.
+const (
+	testVectorPlaintext = "Pässw0rd!"
+	testVectorSalt      = "000102030405060708090a0b0c0d0e0f"
+	// testVectorValidationKey keys the HMAC of the hmacsha256 vector.
+	testVectorValidationKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+)
+
+// testVector is one entry printed by testvectors.
+type testVector struct {
+	Name       string         `json:"name"`
+	Describes  string         `json:"describes"`
+	Plaintext  string         `json:"plaintext"`
+	SaltHex    string         `json:"salt_hex,omitempty"`
+	Parameters map[string]any `json:"parameters"`
+	Hash       string         `json:"hash"`
+	// HashcatMode and Hashcat are empty for modes hashcat can't crack.
+	HashcatMode string `json:"hashcat_mode,omitempty"`
+	Hashcat     string `json:"hashcat,omitempty"`
+}
+
+// testVectorSpec makes a test vector through the code paths of generate and
+// convert, and checks it through those of verify and crack.
+type testVectorSpec struct {
+	name, describes string
+	salted          bool
+	algorithm       string // the hashcat dialect algorithm of the hash
+	parameters      map[string]any
+	hash            func(plain string, salt []byte) (string, error)
+	hashcat         func(encoded string) (string, error) // nil if hashcat has no mode
+	verify          func(plain, encoded string) (bool, error)
+}
+
+func testVectorSpecs() ([]testVectorSpec, error) {
+	key, err := hex.DecodeString(testVectorValidationKey)
+	if err != nil {
+		return nil, err
+	}
+	keyed, err := aspnethash.NewKeyedHasher("hmacsha256", key)
+	if err != nil {
+		return nil, err
+	}
+	const (
+		iterations   = aspnethash.DefaultIterations
+		subkeyLength = aspnethash.DefaultSubkeyLength
+		saltSize     = aspnethash.DefaultSaltSize
+	)
+	generated := func(mode string, keyed *aspnethash.KeyedHasher) func(string, []byte) (string, error) {
+		return func(plain string, salt []byte) (string, error) {
+			return generateHash(plain, mode, iterations, subkeyLength, saltSize, salt, keyed)
+		}
+	}
+	verified := func(mode string, keyed *aspnethash.KeyedHasher) func(string, string) (bool, error) {
+		return func(plain, encoded string) (bool, error) {
+			_, err := verifyLine(plain+":"+encoded, ":", false, iterations, mode, keyed, hexEscaper{})
+			if errors.Is(err, errMismatch) {
+				return false, nil
+			}
+			return err == nil, err
+		}
+	}
+	identityV3 := func(prf aspnethash.PRF, iterations int) testVectorSpec {
+		return testVectorSpec{
+			name:       "identity-v3-" + prf.String(),
+			algorithm:  pbkdf2Algorithms[prf],
+			describes:  "ASP.NET Core Identity v3",
+			salted:     true,
+			parameters: map[string]any{"algorithm": "PBKDF2-HMAC-" + prfName(prf), "iterations": iterations, "salt_size": saltSize, "subkey_length": subkeyLength},
+			hash: func(plain string, salt []byte) (string, error) {
+				return aspnethash.HashIdentityV3([]byte(plain), prf, aspnethash.Options{Iterations: iterations, SubkeyLength: subkeyLength, Salt: salt})
+			},
+			hashcat: func(encoded string) (string, error) {
+				return convertHash(encoded, false, "", "", false, aspnethash.DefaultIterations, nil, newHashParser(saltSize, subkeyLength, false), nil)
+			},
+			verify: func(plain, encoded string) (bool, error) {
+				hash, err := aspnethash.Parse(encoded)
+				if err != nil {
+					return false, err
+				}
+				return hash.Verify([]byte(plain)), nil
+			},
+		}
+	}
+	formsAuth := func(digest, algorithm string) testVectorSpec {
+		mode := "formsauth-" + digest
+		return testVectorSpec{
+			name:       mode,
+			algorithm:  algorithm,
+			describes:  "FormsAuthentication.HashPasswordForStoringInConfigFile, unsalted",
+			parameters: map[string]any{"algorithm": strings.ToUpper(digest) + "(UTF-8(password)), uppercase hex"},
+			hash: func(plain string, _ []byte) (string, error) {
+				return generateHash(plain, mode, iterations, subkeyLength, saltSize, nil, nil)
+			},
+			hashcat: func(encoded string) (string, error) {
+				return convertFormsAuth(encoded, false, ":", "", false, digest, nil, nil)
+			},
+			verify: verified(mode, nil),
+		}
+	}
+	return []testVectorSpec{
+		{
+			name:       "mvc4",
+			algorithm:  algoPBKDF2SHA1,
+			describes:  "SimpleMembershipProvider, ASP.NET Identity v2",
+			salted:     true,
+			parameters: map[string]any{"algorithm": "PBKDF2-HMAC-SHA1", "iterations": iterations, "salt_size": saltSize, "subkey_length": subkeyLength},
+			hash:       generated("mvc4", nil),
+			hashcat: func(encoded string) (string, error) {
+				return generatedHashcat(encoded, "mvc4", iterations, subkeyLength, nil)
+			},
+			verify: verified("mvc4", nil),
+		},
+		// Identity v3 defaulted to HMAC-SHA256 and 10000 iterations until
+		// .NET 7 moved to HMAC-SHA512 and 100000.
+		identityV3(aspnethash.PRFSHA256, 10000),
+		identityV3(aspnethash.PRFSHA512, 100000),
+		{
+			name:       "webforms-sha256",
+			algorithm:  algoSHA256,
+			describes:  "DefaultMembershipProvider, <hash>,<salt>",
+			salted:     true,
+			parameters: map[string]any{"algorithm": "SHA256", "salt_size": saltSize},
+			hash:       generated("webforms", nil),
+			hashcat: func(encoded string) (string, error) {
+				return generatedHashcat(encoded, "webforms", iterations, subkeyLength, nil)
+			},
+			verify: verified("mvc4", nil),
+		},
+		{
+			name:       "webforms-hmacsha256",
+			describes:  "SqlMembershipProvider with hashAlgorithmType=\"HMACSHA256\", <hash>,<salt>",
+			salted:     true,
+			parameters: map[string]any{"algorithm": "HMAC-SHA256(salt || UTF-16LE(password))", "salt_size": saltSize, "validation_key": testVectorValidationKey},
+			hash:       generated("webforms", keyed),
+			verify:     verified("mvc4", keyed),
+		},
+		{
+			// SqlMembershipProvider's default SHA1 is the same hash.
+			name:       "dnn",
+			algorithm:  algoSaltedSHA1,
+			describes:  "DotNetNuke and SqlMembershipProvider SHA1, <hash>,<salt>",
+			salted:     true,
+			parameters: map[string]any{"algorithm": "SHA1(salt || UTF-16LE(password))", "salt_size": saltSize},
+			hash:       generated("dnn", nil),
+			hashcat: func(encoded string) (string, error) {
+				return convertDNN(encoded, false, ",", "", false, nil, nil)
+			},
+			verify: func(plain, encoded string) (bool, error) {
+				hash, salt, _ := strings.Cut(encoded, ",")
+				h, err := aspnethash.ParseDNN(hash, salt)
+				if err != nil {
+					return false, err
+				}
+				return h.Verify([]byte(plain)), nil
+			},
+		},
+		formsAuth("sha1", algoSHA1),
+		formsAuth("md5", algoMD5),
+		{
+			name:       "umbraco-legacy",
+			describes:  "Umbraco legacy encoding, unsalted",
+			parameters: map[string]any{"algorithm": "HMAC-SHA256(key = UTF-16LE(password), UTF-16LE(password))"},
+			hash: func(plain string, _ []byte) (string, error) {
+				return generateHash(plain, "umbraco-legacy", iterations, subkeyLength, saltSize, nil, nil)
+			},
+			verify: verified("umbraco-legacy", nil),
+		},
+	}, nil
+}
+
+// prfName is the upper-case name of a PRF, as in "PBKDF2-HMAC-SHA256".
+func prfName(prf aspnethash.PRF) string {
+	switch prf {
+	case aspnethash.PRFSHA256:
+		return "SHA256"
+	case aspnethash.PRFSHA512:
+		return "SHA512"
+	}
+	return "SHA1"
+}
+
+// testVectors computes the test vectors, and fails if one doesn't verify or
+// convert: the command doubles as a check of the hashing code.
+func testVectors() ([]testVector, error) {
+	specs, err := testVectorSpecs()
+	if err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(testVectorSalt)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([]testVector, len(specs))
+	for i, spec := range specs {
+		v := testVector{Name: spec.name, Describes: spec.describes, Plaintext: testVectorPlaintext, Parameters: spec.parameters}
+		if spec.salted {
+			v.SaltHex = testVectorSalt
+		}
+		if v.Hash, err = spec.hash(testVectorPlaintext, salt); err != nil {
+			return nil, fmt.Errorf("%s: %w", spec.name, err)
+		}
+		if ok, err := spec.verify(testVectorPlaintext, v.Hash); err != nil || !ok {
+			return nil, fmt.Errorf("%s: %s doesn't verify against its own plaintext (%v)", spec.name, v.Hash, err)
+		}
+		if spec.hashcat != nil {
+			if v.Hashcat, err = spec.hashcat(v.Hash); err != nil {
+				return nil, fmt.Errorf("%s: %w", spec.name, err)
+			}
+			v.HashcatMode = defaultDialect(spec.algorithm).mode
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+// writeTestVectors prints the test vectors, as a JSON array or as one block
+// of aligned fields each.
+func writeTestVectors(w io.Writer, asJSON bool) error {
+	vectors, err := testVectors()
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(vectors)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, v := range vectors {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s (%s)\n", v.Name, v.Describes)
+		fmt.Fprintf(tw, "  plaintext:\t%s\n", v.Plaintext)
+		if v.SaltHex != "" {
+			fmt.Fprintf(tw, "  salt (hex):\t%s\n", v.SaltHex)
+		}
+		for _, name := range []string{"algorithm", "iterations", "salt_size", "subkey_length", "validation_key"} {
+			if value, ok := v.Parameters[name]; ok {
+				fmt.Fprintf(tw, "  %s:\t%v\n", name, value)
+			}
+		}
+		fmt.Fprintf(tw, "  hash:\t%s\n", v.Hash)
+		if v.Hashcat != "" {
+			fmt.Fprintf(tw, "  hashcat -m %s:\t%s\n", v.HashcatMode, v.Hashcat)
+		} else {
+			fmt.Fprintf(tw, "  hashcat:\tno mode\n")
+		}
+	}
+	return tw.Flush()
+}
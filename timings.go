@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// pipelineTimings adds up where the run spent its time for --timings. Every
+// field is a nanosecond total, added to atomically by whichever goroutine
+// measured it. Without --timings it is nil and nothing is measured.
+type pipelineTimings struct {
+	// started and finished bound the pipeline, from before the first line
+	// is read until the workers are done.
+	started, finished time.Time
+	// reading is the producer's time from the first line to the last,
+	// dispatchWait the part of it spent blocked waiting for a worker.
+	reading      time.Duration
+	dispatchWait int64
+	// busy is the workers' time on their batches, processing the part of
+	// it spent on the lines rather than passing the results on.
+	busy, processing int64
+	// writing is the workers' time handing records to the output,
+	// including waiting for its lock; outputIO is the time spent in writes
+	// to the output file or stdout, by the workers or the final flush.
+	writing, outputIO int64
+	// batchLines counts the lines the workers were handed.
+	batchLines int64
+}
+
+// start marks the start of the pipeline, as the producer reads the first
+// line.
+func (t *pipelineTimings) start() {
+	t.started = time.Now()
+}
+
+// doneReading marks the producer's last line.
+func (t *pipelineTimings) doneReading() {
+	t.reading = time.Since(t.started)
+}
+
+// stop marks the end of the pipeline, once the workers are done.
+func (t *pipelineTimings) stop() {
+	t.finished = time.Now()
+}
+
+// addSince adds the time since start to the total at ns.
+func addSince(ns *int64, start time.Time) {
+	atomic.AddInt64(ns, int64(time.Since(start)))
+}
+
+// timedWrite wraps write to add its time to t.writing.
+func (t *pipelineTimings) timedWrite(write func(string)) func(string) {
+	return func(records string) {
+		start := time.Now()
+		write(records)
+		addSince(&t.writing, start)
+	}
+}
+
+// timedWriter adds the time of every Write to ns.
+type timedWriter struct {
+	w  io.Writer
+	ns *int64
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	addSince(t.ns, start)
+	return n, err
+}
+
+// pipelineReport is the --timings part of the stats, in seconds.
+type pipelineReport struct {
+	WallSeconds float64 `json:"wall_seconds"`
+	// ReadSeconds is the producer's time reading and batching lines, not
+	// counting DispatchWaitSeconds, when it was blocked because every
+	// worker was busy.
+	ReadSeconds         float64 `json:"read_seconds"`
+	DispatchWaitSeconds float64 `json:"dispatch_wait_seconds"`
+	// WorkerBusySeconds adds up the time of all workers, ProcessSeconds
+	// the part spent on the lines and WriteSeconds the part spent passing
+	// their results to the output.
+	WorkerBusySeconds float64 `json:"worker_busy_seconds"`
+	// WorkerIdleSeconds is the time the worker limit allowed but no worker
+	// used. It is only known with a fixed --max-workers.
+	WorkerIdleSeconds *float64 `json:"worker_idle_seconds,omitempty"`
+	AverageBusy       float64  `json:"average_busy_workers"`
+	ProcessSeconds    float64  `json:"process_seconds"`
+	WriteSeconds      float64  `json:"write_seconds"`
+	// OutputIOSeconds is the time spent in writes to the output file or
+	// stdout, mostly while writing and the rest in the final flush.
+	OutputIOSeconds float64 `json:"output_io_seconds"`
+	// The per-line latencies divide the time of a stage by the lines that
+	// went through it, in microseconds.
+	ReadPerLineMicros    float64 `json:"read_per_line_us"`
+	ProcessPerLineMicros float64 `json:"process_per_line_us"`
+	WritePerLineMicros   float64 `json:"write_per_line_us"`
+}
+
+// report sums up the timings of a run that read lines lines and wrote the
+// records of written ones, with at most workers at once if it is not 0.
+func (t *pipelineTimings) report(lines, written int64, workers int) *pipelineReport {
+	seconds := func(ns int64) float64 { return time.Duration(ns).Seconds() }
+	perLine := func(ns, n int64) float64 {
+		if n == 0 {
+			return 0
+		}
+		return float64(ns) / float64(n) / 1e3
+	}
+	wall := t.finished.Sub(t.started)
+	r := &pipelineReport{
+		WallSeconds:          wall.Seconds(),
+		ReadSeconds:          seconds(int64(t.reading) - t.dispatchWait),
+		DispatchWaitSeconds:  seconds(t.dispatchWait),
+		WorkerBusySeconds:    seconds(t.busy),
+		ProcessSeconds:       seconds(t.processing),
+		WriteSeconds:         seconds(t.writing),
+		OutputIOSeconds:      seconds(t.outputIO),
+		ReadPerLineMicros:    perLine(int64(t.reading)-t.dispatchWait, lines),
+		ProcessPerLineMicros: perLine(t.processing, t.batchLines),
+		WritePerLineMicros:   perLine(t.writing, written),
+	}
+	if wall > 0 {
+		r.AverageBusy = float64(t.busy) / float64(wall)
+	}
+	if workers > 0 {
+		idle := max(0, float64(workers)*wall.Seconds()-r.WorkerBusySeconds)
+		r.WorkerIdleSeconds = &idle
+	}
+	return r
+}
+
+// logSummary prints the report for the summary.
+func (r *pipelineReport) logSummary() {
+	log.Printf("Pipeline timings over %.3fs:", r.WallSeconds)
+	log.Printf("  Reading: %.3fs (%.2f µs/line), blocked waiting for a worker %.3fs", r.ReadSeconds, r.ReadPerLineMicros, r.DispatchWaitSeconds)
+	idle := ""
+	if r.WorkerIdleSeconds != nil {
+		idle = fmt.Sprintf(", idle %.3fs", *r.WorkerIdleSeconds)
+	}
+	log.Printf("  Workers: busy %.3fs (%.1f at a time on average)%s", r.WorkerBusySeconds, r.AverageBusy, idle)
+	log.Printf("  Processing: %.3fs (%.2f µs/line)", r.ProcessSeconds, r.ProcessPerLineMicros)
+	log.Printf("  Writing: %.3fs (%.2f µs/line), output I/O %.3fs", r.WriteSeconds, r.WritePerLineMicros, r.OutputIOSeconds)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"hash/maphash"
+	"math"
+	"strings"
+	"sync"
+)
+
+// uniqueOutputScopes lists the accepted values of --unique-output-scope.
+var uniqueOutputScopes = []string{"global", "shard"}
+
+// uniqueFalsePositiveRate is the share of distinct lines --unique-approx may
+// wrongly drop as duplicates once it holds as many lines as it was sized for.
+const uniqueFalsePositiveRate = 1e-6
+
+// seenSet remembers the keys of the lines --unique has let through.
+type seenSet interface {
+	// seen adds key to the set and reports whether it was already there.
+	seen(key string) bool
+}
+
+// exactSet is a seenSet that keeps every key, so it never drops a line by
+// mistake but grows with the number of distinct lines.
+type exactSet map[string]struct{}
+
+func (s exactSet) seen(key string) bool {
+	if _, ok := s[key]; ok {
+		return true
+	}
+	// key may be a slice of a much larger batch of records.
+	s[strings.Clone(key)] = struct{}{}
+	return false
+}
+
+// bloomSet is a seenSet of fixed size. It may report a key as seen that
+// wasn't, at a rate that grows beyond uniqueFalsePositiveRate once it holds
+// more keys than it was sized for.
+type bloomSet struct {
+	bits  []uint64
+	m     uint64 // number of bits
+	k     uint64 // number of hash functions
+	seed1 maphash.Seed
+	seed2 maphash.Seed
+}
+
+// newBloomSet sizes a bloomSet for n keys at uniqueFalsePositiveRate.
+func newBloomSet(n int) *bloomSet {
+	m := uint64(math.Ceil(-float64(n) * math.Log(uniqueFalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	m = max(64, (m+63)/64*64)
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	return &bloomSet{
+		bits:  make([]uint64, m/64),
+		m:     m,
+		k:     max(1, k),
+		seed1: maphash.MakeSeed(),
+		seed2: maphash.MakeSeed(),
+	}
+}
+
+// seen derives the k bit positions from two independent hashes
+// (Kirsch-Mitzenmacher) and sets them.
+func (b *bloomSet) seen(key string) bool {
+	h1, h2 := maphash.String(b.seed1, key), maphash.String(b.seed2, key)|1
+	present := true
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % b.m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if b.bits[word]&mask == 0 {
+			present = false
+			b.bits[word] |= mask
+		}
+	}
+	return present
+}
+
+// lockedSet makes a seenSet safe for concurrent use, for sets the workers
+// share rather than the reader alone.
+type lockedSet struct {
+	mu  sync.Mutex
+	set seenSet
+}
+
+func (s *lockedSet) seen(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set.seen(key)
+}
+
+// dropSeen removes the records set has seen before from records, which end
+// in terminator, adds the others to the set and returns the records kept and
+// the number dropped.
+func dropSeen(records string, set seenSet, terminator byte) (string, int64) {
+	var kept strings.Builder
+	var dropped int64
+	for rest := records; rest != ""; {
+		end := strings.IndexByte(rest, terminator) + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		record := rest[:end]
+		rest = rest[end:]
+		if set.seen(strings.TrimSuffix(record, string(terminator))) {
+			dropped++
+			continue
+		}
+		kept.WriteString(record)
+	}
+	if dropped == 0 {
+		return records, 0
+	}
+	return kept.String(), dropped
+}
+
+// uniqueKey is the part of a line --unique compares: the hash after the
+// username if usernamePresent is set, otherwise the whole line. Lines
+// without a delimiter are compared whole; they fail later anyway.
+func uniqueKey(line string, usernamePresent bool, delimiter string, trim bool) string {
+	if !usernamePresent {
+		return line
+	}
+	if _, encoded, err := splitUsername(line, true, delimiter, trim); err == nil {
+		return encoded
+	}
+	return line
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// usernameTransforms are the accepted values of --normalize-username.
+var usernameTransforms = map[string]func(string) string{
+	"lower":        strings.ToLower,
+	"strip-domain": stripDomain,
+	"trim":         strings.TrimSpace,
+}
+
+// usernameNormalizer applies the --normalize-username transforms in the
+// order given. The zero value leaves usernames alone.
+type usernameNormalizer []func(string) string
+
+// parseUsernameNormalizer parses a comma-separated list of transforms.
+func parseUsernameNormalizer(s string) (usernameNormalizer, error) {
+	var n usernameNormalizer
+	if s == "" {
+		return n, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		transform, ok := usernameTransforms[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q (want lower, strip-domain or trim)", name)
+		}
+		n = append(n, transform)
+	}
+	return n, nil
+}
+
+func (n usernameNormalizer) apply(username string) string {
+	for _, transform := range n {
+		username = transform(username)
+	}
+	return username
+}
+
+// stripDomain removes a DOMAIN\ prefix and an @domain suffix. Only the
+// part after the last backslash and before the last @ is kept, and a
+// username that would end up empty (e.g. "CORP\" or "@corp") is left alone.
+func stripDomain(username string) string {
+	stripped := username
+	if i := strings.LastIndexByte(stripped, '\\'); i >= 0 {
+		stripped = stripped[i+1:]
+	}
+	if i := strings.LastIndexByte(stripped, '@'); i >= 0 {
+		stripped = stripped[:i]
+	}
+	if stripped == "" {
+		return username
+	}
+	return stripped
+}
+
+// usernameCollisions reports different usernames that normalize to the same
+// one, for --username-collisions.
+type usernameCollisions struct {
+	mu    sync.Mutex
+	first map[string]string // normalized -> first original seen
+	count int64
+}
+
+func newUsernameCollisions() *usernameCollisions {
+	return &usernameCollisions{first: map[string]string{}}
+}
+
+// check records that original on line lineNo normalized to normalized.
+func (c *usernameCollisions) check(lineNo int64, original, normalized string) {
+	c.mu.Lock()
+	first, seen := c.first[normalized]
+	if !seen {
+		c.first[normalized] = original
+	}
+	c.mu.Unlock()
+	if seen && first != original {
+		atomic.AddInt64(&c.count, 1)
+		log.Printf("Line %d: username %q normalizes to %q, like %q before it", lineNo, original, normalized, first)
+	}
+}
+
+// duplicateUsernamePolicies are the accepted values of --duplicate-usernames.
+var duplicateUsernamePolicies = []string{"keep-all", "first", "last", "error"}
+
+// duplicateExamples is the number of repeated accounts the stats list, and
+// of lines listed for each.
+const duplicateExamples = 5
+
+// duplicateUsernames tracks the accounts of the rows convert reads, after
+// --normalize-username, for --duplicate-usernames. The reader calls observe
+// in input order, which is what tells the first row of an account. For
+// "last", the workers hold the output of each account in hold until the
+// input is done, as its last row may come at any point.
+type duplicateUsernames struct {
+	policy    string
+	delimiter string
+	trim      bool
+	normalize usernameNormalizer
+
+	// Only used by the reader until the workers are done.
+	// first maps each account to the line of its first row, negated once
+	// the account repeats.
+	first    map[string]int64
+	rows     int64
+	accounts int64
+	examples []duplicateExample
+
+	mu   sync.Mutex
+	held map[string]heldRow
+}
+
+// heldRow is the output of the last row of an account so far, for "last".
+type heldRow struct {
+	lineNo       int64
+	record, side string
+}
+
+// duplicateExample is an account with more than one row.
+type duplicateExample struct {
+	Username string  `json:"username"`
+	Lines    []int64 `json:"lines"`
+}
+
+func newDuplicateUsernames(policy, delimiter string, trim bool, normalize usernameNormalizer) *duplicateUsernames {
+	d := &duplicateUsernames{policy: policy, delimiter: delimiter, trim: trim, normalize: normalize, first: map[string]int64{}}
+	if policy == "last" {
+		d.held = map[string]heldRow{}
+	}
+	return d
+}
+
+// observe records the account of line, line lineNo of the input, and
+// reports whether an earlier row had it. Lines without a username are left
+// to fail in conversion.
+func (d *duplicateUsernames) observe(lineNo int64, line string) (repeated bool) {
+	username, _, err := splitUsername(line, true, d.delimiter, d.trim)
+	if err != nil {
+		return false
+	}
+	account := d.normalize.apply(username)
+	first, repeated := d.first[account]
+	if !repeated {
+		// Lines may point into a larger read buffer.
+		d.first[strings.Clone(account)] = lineNo
+		return false
+	}
+	d.rows++
+	if first > 0 {
+		// The first repeat of the account, which is marked as repeated.
+		d.first[account] = -first
+		d.accounts++
+		if len(d.examples) < duplicateExamples {
+			d.examples = append(d.examples, duplicateExample{Username: strings.Clone(account), Lines: []int64{first, lineNo}})
+		}
+		return true
+	}
+	for i := range d.examples {
+		if e := &d.examples[i]; e.Username == account && len(e.Lines) < duplicateExamples {
+			e.Lines = append(e.Lines, lineNo)
+		}
+	}
+	return true
+}
+
+// hold keeps record, and side for the side output, as the output of
+// account unless a later row of it was held already.
+func (d *duplicateUsernames) hold(lineNo int64, account, record, side string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if h, ok := d.held[account]; !ok || h.lineNo < lineNo {
+		d.held[account] = heldRow{lineNo: lineNo, record: record, side: side}
+	}
+}
+
+// release writes the held rows in input order, each record ended with
+// terminator, and their side records to side if it is set.
+func (d *duplicateUsernames) release(write, side func(string), terminator byte) {
+	rows := make([]heldRow, 0, len(d.held))
+	for _, h := range d.held {
+		rows = append(rows, h)
+	}
+	slices.SortFunc(rows, func(a, b heldRow) int { return cmp.Compare(a.lineNo, b.lineNo) })
+	for _, h := range rows {
+		if h.record != "" {
+			write(h.record + string(terminator))
+		}
+		if side != nil && h.side != "" {
+			side(h.side)
+		}
+	}
+	clear(d.held)
+}
+
+// failed reports whether the "error" policy fails the run.
+func (d *duplicateUsernames) failed() bool {
+	return d.policy == "error" && d.rows > 0
+}
+
+// lines lists the lines of the example.
+func (e duplicateExample) lines() string {
+	lines := make([]string, len(e.Lines))
+	for i, n := range e.Lines {
+		lines[i] = strconv.FormatInt(n, 10)
+	}
+	return strings.Join(lines, ", ")
+}
+
+// exampleList names the example accounts with their lines.
+func (d *duplicateUsernames) exampleList() string {
+	var parts []string
+	for _, e := range d.examples {
+		parts = append(parts, fmt.Sprintf("%s (lines %s)", e.Username, e.lines()))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// duplicateUsernamesReport is the --duplicate-usernames part of the stats.
+type duplicateUsernamesReport struct {
+	Policy string `json:"policy"`
+	// Accounts counts the accounts with more than one row, Rows the rows
+	// repeating an earlier one.
+	Accounts int64 `json:"accounts"`
+	Rows     int64 `json:"rows"`
+	// Action is what became of the repeats: kept, dropped (the later rows
+	// for "first", the earlier ones for "last") or failed the run.
+	Action   string             `json:"action"`
+	Examples []duplicateExample `json:"examples"`
+}
+
+// report sums up the repeats and what the policy did with them.
+func (d *duplicateUsernames) report() *duplicateUsernamesReport {
+	r := &duplicateUsernamesReport{Policy: d.policy, Accounts: d.accounts, Rows: d.rows, Action: "kept", Examples: d.examples}
+	switch {
+	case d.rows == 0:
+		r.Action = "none"
+	case d.policy == "first" || d.policy == "last":
+		r.Action = "dropped"
+	case d.policy == "error":
+		r.Action = "failed"
+	}
+	if r.Examples == nil {
+		r.Examples = []duplicateExample{}
+	}
+	return r
+}
+
+// logSummary logs the report after the run summary.
+func (r *duplicateUsernamesReport) logSummary() {
+	log.Printf("Duplicate usernames: %d rows repeat %d accounts (--duplicate-usernames %s: %s)", r.Rows, r.Accounts, r.Policy, r.Action)
+	for _, e := range r.Examples {
+		logAt(levelVerbose, "  %s: lines %s", e.Username, e.lines())
+	}
+}
+
+// errBlankUsername is returned for input lines whose --usernames-file line
+// is blank, with --blank-usernames error.
+var errBlankUsername = errors.New("blank username")
+
+// blankUsernamePolicies are the accepted values of --blank-usernames.
+var blankUsernamePolicies = []string{"error", "skip"}
+
+// pairedUsernames reads --usernames-file in lockstep with the input: its
+// line n is the username of input line n, whether that line is processed or
+// skipped. lines counts the lines read so far.
+type pairedUsernames struct {
+	*lineReader
+	closer io.Closer
+	lines  int64
+}
+
+func openPairedUsernames(path, charset string, recordEnd byte, maxLineBytes int) (*pairedUsernames, error) {
+	var bytesRead int64
+	r, _, closer, err := openInput(inputSource{path: path}, "auto", charset, &bytesRead, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &pairedUsernames{lineReader: newLineReader(r, recordEnd, maxLineBytes), closer: closer}, nil
+}
+
+func (p *pairedUsernames) next() bool {
+	if !p.lineReader.next() {
+		return false
+	}
+	p.lines++
+	return true
+}
+
+// count reads the rest of the file and returns its number of lines.
+func (p *pairedUsernames) count() (int64, error) {
+	for p.next() {
+	}
+	return p.lines, p.readErr()
+}
+
+func (p *pairedUsernames) Close() error {
+	return p.closer.Close()
+}
+
+// inputLinesFrom tells how many lines the input has, for the error of a
+// --usernames-file that ran out at line lineNo: that line, the rest of
+// reader and the inputs after it. Inputs that can't be counted make it "at
+// least" the lines counted.
+func inputLinesFrom(lineNo int64, reader *lineReader, rest []inputSource, compression, charset string, recordEnd byte) string {
+	for reader.next() {
+		lineNo++
+	}
+	n, skipReason, err := countInputLines(rest, compression, charset, recordEnd)
+	if reader.readErr() != nil || err != nil || skipReason != "" {
+		return fmt.Sprintf("at least %d", lineNo)
+	}
+	return strconv.FormatInt(lineNo+n, 10)
+}
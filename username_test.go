@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestStripDomain(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`CORP\JSmith`, "JSmith"},
+		{"JSmith@corp.local", "JSmith"},
+		{`CORP\JSmith@corp.local`, "JSmith"},
+		{"jsmith", "jsmith"},
+		{"", ""},
+		// Only the part after the last backslash is kept.
+		{`FOREST\CORP\JSmith`, "JSmith"},
+		// A trailing backslash leaves nothing after it, so the username
+		// is left alone.
+		{`CORP\`, `CORP\`},
+		{`\`, `\`},
+		{`\JSmith`, "JSmith"},
+		// Only the part before the last @ is kept.
+		{"j@smith@corp.local", "j@smith"},
+		{"JSmith@", "JSmith"},
+		{"@@corp", "@"},
+		// An empty local part leaves nothing, so the username is left
+		// alone.
+		{"@corp.local", "@corp.local"},
+		{`CORP\@corp.local`, `CORP\@corp.local`},
+		{"@", "@"},
+		// An @ before the backslash is part of the domain.
+		{`user@CORP\JSmith`, "JSmith"},
+	}
+	for _, tt := range tests {
+		if got := stripDomain(tt.in); got != tt.want {
+			t.Errorf("stripDomain(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUsernameNormalizer(t *testing.T) {
+	tests := []struct {
+		transforms, in, want string
+	}{
+		{"", ` CORP\JSmith `, ` CORP\JSmith `},
+		{"lower", `CORP\JSmith`, `corp\jsmith`},
+		{"trim,strip-domain,lower", ` CORP\JSmith@Corp `, "jsmith"},
+		// In the order given: stripping first leaves the spaces the
+		// domain was cut from.
+		{"strip-domain,trim", ` CORP\ JSmith @corp`, "JSmith"},
+		{"lower, trim", " JSmith ", "jsmith"},
+	}
+	for _, tt := range tests {
+		n, err := parseUsernameNormalizer(tt.transforms)
+		if err != nil {
+			t.Fatalf("parseUsernameNormalizer(%q): %v", tt.transforms, err)
+		}
+		if got := n.apply(tt.in); got != tt.want {
+			t.Errorf("%q applied to %q = %q, want %q", tt.transforms, tt.in, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"upper", "lower,,trim", "lower,"} {
+		if _, err := parseUsernameNormalizer(bad); err == nil {
+			t.Errorf("parseUsernameNormalizer(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestUsernameCollisions(t *testing.T) {
+	c := newUsernameCollisions()
+	n, err := parseUsernameNormalizer("strip-domain,lower")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, username := range []string{`CORP\JSmith`, "jsmith@corp.local", `CORP\JSmith`, "JSMITH", "other"} {
+		c.check(int64(i+1), username, n.apply(username))
+	}
+	// The same original again isn't a collision.
+	if c.count != 2 {
+		t.Errorf("%d collisions, want 2", c.count)
+	}
+}
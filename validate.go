@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/n0kovo/ASP.NET-hashtool/pkg/aspnethash"
+)
+
+// validation collects what --validate reports about the input: the formats
+// seen, the range of decoded lengths and the salt sizes they imply. It is
+// safe for concurrent use.
+type validation struct {
+	mu             sync.Mutex
+	formats        map[string]int64
+	saltSizes      map[int]int64
+	minLen, maxLen int
+	lengths        int64
+}
+
+// validationReport is the --validate part of the stats. For generate, the
+// lengths are those of the plaintexts; for convert, of the decoded hashes.
+type validationReport struct {
+	Formats   []countEntry `json:"formats"`
+	MinLength int          `json:"min_length"`
+	MaxLength int          `json:"max_length"`
+	SaltSizes []countEntry `json:"salt_sizes,omitempty"`
+}
+
+func newValidation() *validation {
+	return &validation{formats: map[string]int64{}, saltSizes: map[int]int64{}}
+}
+
+// observe records a line that would have been processed. saltSize is -1 if
+// the line doesn't tell.
+func (v *validation) observe(format string, length, saltSize int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.formats[format]++
+	if v.lengths == 0 || length < v.minLen {
+		v.minLen = length
+	}
+	if length > v.maxLen {
+		v.maxLen = length
+	}
+	v.lengths++
+	if saltSize >= 0 {
+		v.saltSizes[saltSize]++
+	}
+}
+
+// observeHash records a hash convert accepted. The salt size of an MVC4 blob
+// is what is left after the version byte and a subkey of subkeyLength bytes.
+func (v *validation) observeHash(encoded string, subkeyLength int) {
+	format := detectHash(encoded, "", aspnethash.DefaultIterations).format
+	if formsAuthAlgorithm(format) != "" {
+		v.observe(format, len(encoded)/2, -1)
+		return
+	}
+	if hash, salt, ok := strings.Cut(encoded, ","); ok {
+		decoded, _ := base64.StdEncoding.DecodeString(hash)
+		rawSalt, _ := base64.StdEncoding.DecodeString(salt)
+		v.observe(format, len(decoded), len(rawSalt))
+		return
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(encoded)
+	saltSize := -1
+	if len(decoded) > 1+subkeyLength && decoded[0] == 0 {
+		saltSize = len(decoded) - 1 - subkeyLength
+	}
+	v.observe(format, len(decoded), saltSize)
+}
+
+func (v *validation) report() *validationReport {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	r := &validationReport{Formats: []countEntry{}, MinLength: v.minLen, MaxLength: v.maxLen}
+	for format, n := range v.formats {
+		r.Formats = append(r.Formats, countEntry{Name: format, Count: n})
+	}
+	sortByCount(r.Formats)
+	sizes := make([]int, 0, len(v.saltSizes))
+	for size := range v.saltSizes {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	for _, size := range sizes {
+		r.SaltSizes = append(r.SaltSizes, countEntry{Name: fmt.Sprint(size), Count: v.saltSizes[size]})
+	}
+	return r
+}
+
+// logSummary logs the report after the run summary.
+func (r *validationReport) logSummary() {
+	log.Printf("Validation:")
+	for _, f := range r.Formats {
+		log.Printf("  %s: %d", f.Name, f.Count)
+	}
+	if len(r.Formats) > 0 {
+		log.Printf("  Lengths: %d-%d bytes", r.MinLength, r.MaxLength)
+	}
+	for _, s := range r.SaltSizes {
+		log.Printf("  Salt size %s bytes: %d", s.Name, s.Count)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set at release time with
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Values left empty are taken from the module build info where possible.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// buildInfo returns the version, commit and build date of this binary,
+// falling back to what `go install` and `go build` embed, then to "dev" and
+// "unknown".
+func buildInfo() (ver, rev, date string) {
+	ver, rev, date = version, commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if ver == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			ver = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if rev == "" {
+					rev = s.Value
+					if len(rev) > 12 {
+						rev = rev[:12]
+					}
+				}
+			case "vcs.time":
+				if date == "" {
+					date = s.Value
+				}
+			case "vcs.modified":
+				if s.Value == "true" && commit == "" && rev != "" {
+					rev += "-dirty"
+				}
+			}
+		}
+	}
+	if ver == "" {
+		ver = "dev"
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return ver, rev, date
+}
+
+// versionString is the one-line version used in the log and the stats.
+func versionString() string {
+	ver, rev, _ := buildInfo()
+	return fmt.Sprintf("%s (%s)", ver, rev)
+}
+
+// printVersion prints the full build information for --version.
+func printVersion(prog string) {
+	ver, rev, date := buildInfo()
+	fmt.Printf("%s %s\n", prog, ver)
+	fmt.Printf("commit:     %s\n", rev)
+	fmt.Printf("built:      %s\n", date)
+	fmt.Printf("go version: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// webConfig holds the settings --web-config reads from a site's web.config.
+type webConfig struct {
+	validationKey, decryptionKey string
+	validation, decryption       string
+	// hashAlgorithmType is set on <membership>; without it, ASP.NET uses
+	// the machineKey validation algorithm.
+	hashAlgorithmType string
+	defaultProvider   string
+	// providers maps membership provider names to their settings.
+	providers     map[string]membershipProvider
+	providerOrder []string
+	iterations    string
+}
+
+// membershipProvider is what --web-config reads of a membership provider.
+type membershipProvider struct {
+	passwordFormat string
+	// minLength and minNonAlnum are minRequiredPasswordLength and
+	// minRequiredNonalphanumericCharacters, empty if not set.
+	minLength, minNonAlnum string
+}
+
+// readWebConfig parses path. Elements and attributes are matched by their
+// local name, ignoring case, so namespaced elements and the xdt: attributes
+// of config transforms don't get in the way.
+func readWebConfig(path string) (*webConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &webConfig{providers: map[string]membershipProvider{}}
+	dec := xml.NewDecoder(f)
+	dec.Strict = false
+	var stack []string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			attr := func(name string) string {
+				for _, a := range t.Attr {
+					if strings.EqualFold(a.Name.Local, name) {
+						return strings.TrimSpace(a.Value)
+					}
+				}
+				return ""
+			}
+			parent := ""
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			switch {
+			case name == "machinekey":
+				c.validationKey, c.decryptionKey = attr("validationKey"), attr("decryptionKey")
+				c.validation, c.decryption = attr("validation"), attr("decryption")
+			case name == "membership":
+				c.hashAlgorithmType, c.defaultProvider = attr("hashAlgorithmType"), attr("defaultProvider")
+			case name == "add" && parent == "providers" && slices.Contains(stack, "membership"):
+				provider := attr("name")
+				if _, seen := c.providers[provider]; !seen {
+					c.providerOrder = append(c.providerOrder, provider)
+				}
+				c.providers[provider] = membershipProvider{
+					passwordFormat: attr("passwordFormat"),
+					minLength:      attr("minRequiredPasswordLength"),
+					minNonAlnum:    attr("minRequiredNonalphanumericCharacters"),
+				}
+			case name == "add" && parent == "appsettings":
+				key := strings.ToLower(attr("key"))
+				if strings.HasSuffix(key, "iterations") || strings.HasSuffix(key, "iterationcount") {
+					c.iterations = attr("value")
+				}
+			}
+			stack = append(stack, name)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return c, nil
+}
+
+// provider returns the default membership provider, or the first one if
+// none is named.
+func (c *webConfig) provider() membershipProvider {
+	if p, ok := c.providers[c.defaultProvider]; ok {
+		return p
+	}
+	if len(c.providerOrder) > 0 {
+		return c.providers[c.providerOrder[0]]
+	}
+	return membershipProvider{}
+}
+
+func (c *webConfig) passwordFormat() string {
+	return c.provider().passwordFormat
+}
+
+// isAutoGenerate reports whether a machineKey key is left to the server.
+func isAutoGenerate(key string) bool {
+	return strings.HasPrefix(strings.ToLower(key), "autogenerate")
+}
+
+// options translates the settings to flag values, with warnings about the
+// ones that can't be used.
+func (c *webConfig) options() (opts map[string]string, warnings []string) {
+	opts = map[string]string{}
+	source, algorithm := "hashAlgorithmType", c.hashAlgorithmType
+	if algorithm == "" {
+		source, algorithm = "machineKey validation", c.validation
+	}
+	algorithm = strings.ToLower(algorithm)
+	if isAutoGenerate(c.validationKey) {
+		warnings = append(warnings, "validationKey is AutoGenerate, so HMAC hashing is unavailable")
+	}
+	switch {
+	case algorithm == "":
+	case !slices.Contains(hashAlgorithms, algorithm):
+		warnings = append(warnings, fmt.Sprintf("%s %s isn't supported", source, algorithm))
+	case algorithm == "sha256":
+		opts["hash-algorithm"] = algorithm
+	case c.validationKey != "" && !isAutoGenerate(c.validationKey):
+		opts["hash-algorithm"], opts["validation-key"] = algorithm, c.validationKey
+	}
+
+	switch decryption := strings.ToLower(c.decryption); {
+	case c.decryptionKey == "":
+	case isAutoGenerate(c.decryptionKey):
+		warnings = append(warnings, "decryptionKey is AutoGenerate, so decryption is unavailable")
+	case decryption == "" || decryption == "auto" || decryption == "aes":
+		opts["decryption-key"], opts["decryption-algo"] = c.decryptionKey, "aes"
+	case decryption == "3des":
+		opts["decryption-key"], opts["decryption-algo"] = c.decryptionKey, "3des"
+	default:
+		warnings = append(warnings, fmt.Sprintf("decryption algorithm %s isn't supported", c.decryption))
+	}
+
+	var policy []string
+	for _, rule := range []struct{ name, value string }{{"minlen", c.provider().minLength}, {"minnonalnum", c.provider().minNonAlnum}} {
+		if rule.value == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(rule.value); err == nil && n >= 0 {
+			policy = append(policy, rule.name+"="+rule.value)
+		} else {
+			warnings = append(warnings, fmt.Sprintf("password rule %s %q isn't a number", rule.name, rule.value))
+		}
+	}
+	if len(policy) > 0 {
+		opts["policy"] = strings.Join(policy, ",")
+	}
+
+	if c.iterations != "" {
+		if n, err := strconv.Atoi(c.iterations); err == nil && n > 0 {
+			opts["iter"] = c.iterations
+		} else {
+			warnings = append(warnings, fmt.Sprintf("iteration count %q isn't a positive number", c.iterations))
+		}
+	}
+	return opts, warnings
+}
+
+// summary describes what was read, giving only the length of keys.
+func (c *webConfig) summary() []string {
+	key := func(k string) string {
+		if k == "" || isAutoGenerate(k) {
+			return strconv.Quote(k)
+		}
+		return fmt.Sprintf("%d hex digits", len(k))
+	}
+	lines := []string{
+		fmt.Sprintf("machineKey: validation %q, validationKey %s, decryption %q, decryptionKey %s", c.validation, key(c.validationKey), c.decryption, key(c.decryptionKey)),
+		fmt.Sprintf("membership: hashAlgorithmType %q, passwordFormat %q, minRequiredPasswordLength %q, minRequiredNonalphanumericCharacters %q", c.hashAlgorithmType, c.passwordFormat(), c.provider().minLength, c.provider().minNonAlnum),
+	}
+	if c.iterations != "" {
+		lines = append(lines, fmt.Sprintf("iterations: %s", c.iterations))
+	}
+	return lines
+}
+
+// applyWebConfig sets the flags the settings of path translate to, unless
+// they were given on the command line (or by a profile) or don't apply to
+// the command. Flags in overridable, the ones a config file set, are
+// replaced all the same. It returns the names of the flags it set.
+func applyWebConfig(path string, verbose bool, overridable map[string]bool) (map[string]bool, error) {
+	c, err := readWebConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		for _, line := range c.summary() {
+			log.Printf("web.config %s", line)
+		}
+	}
+	opts, warnings := c.options()
+	for _, w := range warnings {
+		log.Printf("Warning: web.config: %s", w)
+	}
+	if strings.EqualFold(c.passwordFormat(), "Encrypted") && pflag.Lookup("decryption-key") == nil {
+		log.Printf("Warning: web.config: passwords are stored encrypted; use the decrypt command")
+	}
+	set := map[string]bool{}
+	for name, value := range opts {
+		flag := pflag.Lookup(name)
+		if flag == nil || flag.Changed && !overridable[name] {
+			continue
+		}
+		if err := setFlagValue(flag, value); err != nil {
+			return nil, fmt.Errorf("invalid value for --%s: %w", name, err)
+		}
+		set[name] = true
+	}
+	return set, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// workerScaleInterval is how often --max-workers auto reconsiders the
+// number of workers.
+const workerScaleInterval = 2 * time.Second
+
+// workerScaleCooldown is the number of intervals to hold the worker count
+// after a step that didn't pay off, so it doesn't flap.
+const workerScaleCooldown = 5
+
+// parseMaxWorkers interprets --max-workers, which is a number or "auto".
+func parseMaxWorkers(s string) (n int, auto bool, err error) {
+	if s == "auto" {
+		return 0, true, nil
+	}
+	n, err = strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, false, fmt.Errorf("%q is neither a number of workers nor \"auto\"", s)
+	}
+	return n, false, nil
+}
+
+// workerRange is the smallest and largest worker limit used in a run.
+type workerRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// workerScaler implements --max-workers auto on top of the worker
+// semaphore. The semaphore is sized for the cap; the scaler occupies the
+// tokens that workers may not use, and hands them out or takes them back to
+// grow or shrink the pool.
+type workerScaler struct {
+	sem     chan struct{}
+	verbose bool
+	done    func() int64 // lines finished so far
+
+	mu      sync.Mutex
+	workers int
+	used    workerRange
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// newWorkerScaler starts with start workers out of the cap(sem) allowed. It
+// must be created before any worker takes a token.
+func newWorkerScaler(sem chan struct{}, start int, done func() int64, verbose bool) *workerScaler {
+	if start > cap(sem) {
+		start = cap(sem)
+	}
+	for i := start; i < cap(sem); i++ {
+		sem <- struct{}{}
+	}
+	return &workerScaler{
+		sem:     sem,
+		verbose: verbose,
+		done:    done,
+		workers: start,
+		used:    workerRange{Min: start, Max: start},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// run adjusts the pool until ctx is done or close is called. It grows the
+// pool while that improves throughput and the CPUs aren't saturated, and
+// takes back a step that didn't help.
+func (s *workerScaler) run(ctx context.Context) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(workerScaleInterval)
+	defer ticker.Stop()
+
+	last, lastCPU, lastAt := s.done(), cpuBusySeconds(), time.Now()
+	var prevRate float64
+	var lastStep, cooldown int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			lines, busy := s.done(), cpuBusySeconds()
+			elapsed := now.Sub(lastAt).Seconds()
+			rate := float64(lines-last) / elapsed
+			cpu := (busy - lastCPU) / (elapsed * float64(runtime.GOMAXPROCS(0)))
+			last, lastCPU, lastAt = lines, busy, now
+
+			step := 0
+			switch {
+			case cooldown > 0:
+				cooldown--
+			case lastStep > 0 && rate < prevRate*1.05:
+				// The last step up didn't help; take it back.
+				step = -lastStep
+				cooldown = workerScaleCooldown
+			case cpu < 0.9:
+				step = max(1, s.current()/4)
+			}
+			prevRate = rate
+			lastStep = s.resize(ctx, step)
+			if s.verbose && lastStep != 0 {
+				log.Printf("Workers: %d -> %d (%.1f lines/s, CPU %.0f%%)", s.current()-lastStep, s.current(), rate, cpu*100)
+			}
+		}
+	}
+}
+
+// resize grows or shrinks the pool by up to step workers and returns the
+// change actually made.
+func (s *workerScaler) resize(ctx context.Context, step int) int {
+	changed := 0
+	for ; step > 0 && s.current() < cap(s.sem); step-- {
+		<-s.sem
+		s.add(1)
+		changed++
+	}
+	for ; step < 0 && s.current() > 1; step++ {
+		select {
+		case s.sem <- struct{}{}:
+		case <-ctx.Done():
+			return changed
+		}
+		s.add(-1)
+		changed--
+	}
+	return changed
+}
+
+func (s *workerScaler) current() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.workers
+}
+
+func (s *workerScaler) add(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workers += n
+	s.used.Min = min(s.used.Min, s.workers)
+	s.used.Max = max(s.used.Max, s.workers)
+}
+
+// close stops the scaler and returns the range of worker counts used.
+func (s *workerScaler) close() workerRange {
+	close(s.stop)
+	<-s.stopped
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+// cpuBusySeconds is the CPU time the Go program has spent so far, as
+// estimated by the runtime.
+func cpuBusySeconds() float64 {
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/user:cpu-seconds"},
+		{Name: "/cpu/classes/gc/total:cpu-seconds"},
+	}
+	metrics.Read(samples)
+	var total float64
+	for _, sample := range samples {
+		if sample.Value.Kind() == metrics.KindFloat64 {
+			total += sample.Value.Float64()
+		}
+	}
+	return total
+}